@@ -0,0 +1,238 @@
+package acl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const DefaultUser = "default"
+
+type Thinker interface {
+	// default 用户的密码，为空时无需认证
+	RequirePass() string
+	// 保存用户的文件，为空时不使用
+	ACLFile() string
+	// ACL LOG 保留的最大条数
+	ACLLogMaxLen() int
+}
+
+// 校验 ACL SETUSER 中的指令名与类别
+type Validator interface {
+	ValidCmd(name string) bool
+	ValidCategory(category string) bool
+}
+
+var (
+	errNoACLFile = errors.New("ERR This Redis instance is not configured to use an ACL file. " +
+		"You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE " +
+		"(assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+	errDelDefault = errors.New("ERR The 'default' user cannot be removed")
+)
+
+// ACL 用户表
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+
+	file      string
+	validator Validator
+	log       *denialLog
+}
+
+func New(thinker Thinker, validator Validator) (*ACL, error) {
+	a := ACL{
+		users:     map[string]*User{DefaultUser: newDefaultUser()},
+		validator: validator,
+		log:       newDenialLog(defaultLogMaxLen),
+	}
+	if thinker == nil {
+		return &a, nil
+	}
+
+	if thinker.ACLLogMaxLen() > 0 {
+		a.log = newDenialLog(thinker.ACLLogMaxLen())
+	}
+	if pass := thinker.RequirePass(); pass != "" {
+		user := a.users[DefaultUser]
+		_ = user.applyRule("resetpass", nil)
+		_ = user.applyRule(">"+pass, nil)
+	}
+
+	// aclfile 中的用户覆盖 requirepass 的设置，文件不存在时视为空文件
+	if a.file = thinker.ACLFile(); a.file != "" {
+		if _, err := a.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return &a, nil
+}
+
+// 与 redis 一致，默认用户无需密码并拥有全部权限
+func newDefaultUser() *User {
+	user := newUser(DefaultUser)
+	for _, rule := range []string{"on", "nopass", "allkeys", "allchannels", "allcommands"} {
+		_ = user.applyRule(rule, nil)
+	}
+	return user
+}
+
+func (a *ACL) User(name string) (*User, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user, ok := a.users[name]
+	return user, ok
+}
+
+// 按用户名排序
+func (a *ACL) Users() []*User {
+	a.mu.RLock()
+	users := make([]*User, 0, len(a.users))
+	for _, user := range a.users {
+		users = append(users, user)
+	}
+	a.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users
+}
+
+// 新连接是否自动以 default 用户认证
+func (a *ACL) DefaultNoAuth() bool {
+	user, ok := a.User(DefaultUser)
+	return ok && user.Enabled && user.NoPass
+}
+
+func (a *ACL) Authenticate(name, password string) bool {
+	user, ok := a.User(name)
+	return ok && user.Enabled && user.checkPassword(password)
+}
+
+// 按规则创建或修改用户. 任一规则出错时不做任何修改
+func (a *ACL) SetUser(name string, rules []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user, ok := a.users[name]
+	if ok {
+		user = user.clone()
+	} else {
+		user = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := user.applyRule(rule, a.validator); err != nil {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %s", rule, err.Error())
+		}
+	}
+	a.users[name] = user
+	return nil
+}
+
+func (a *ACL) DelUser(name string) (bool, error) {
+	if name == DefaultUser {
+		return false, errDelDefault
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.users[name]
+	delete(a.users, name)
+	return ok, nil
+}
+
+// 从 aclfile 重新加载全部用户，返回加载前存在、加载后被删除的用户.
+// 文件中任一行出错时不做任何修改
+func (a *ACL) Load() ([]string, error) {
+	if a.file == "" {
+		return nil, errNoACLFile
+	}
+
+	file, err := os.Open(a.file)
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error loading ACLs, opening file '%s': %w", a.file, err)
+	}
+	defer file.Close()
+
+	users := make(map[string]*User)
+	var errs []string
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			errs = append(errs, fmt.Sprintf("%s:%d: should start with user keyword followed by the username.", a.file, lineNum))
+			continue
+		}
+		if _, ok := users[fields[1]]; ok {
+			errs = append(errs, fmt.Sprintf("%s:%d: duplicate user '%s' found.", a.file, lineNum, fields[1]))
+			continue
+		}
+
+		user := newUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := user.applyRule(rule, a.validator); err != nil {
+				errs = append(errs, fmt.Sprintf("%s:%d: %s.", a.file, lineNum, err.Error()))
+				break
+			}
+		}
+		users[user.Name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.New("ERR " + strings.Join(errs, " "))
+	}
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = newDefaultUser()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var removed []string
+	for name := range a.users {
+		if _, ok := users[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	a.users = users
+	return removed, nil
+}
+
+// 将全部用户写入 aclfile. 先写临时文件再替换，避免写入中途失败损坏原文件
+func (a *ACL) Save() error {
+	if a.file == "" {
+		return errNoACLFile
+	}
+
+	var b strings.Builder
+	for _, user := range a.Users() {
+		b.WriteString(user.String())
+		b.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.file), filepath.Base(a.file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(b.String()); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.file)
+}
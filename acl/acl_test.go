@@ -0,0 +1,188 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testThinker struct {
+	pass, file string
+}
+
+func (t testThinker) RequirePass() string {
+	return t.pass
+}
+
+func (t testThinker) ACLFile() string {
+	return t.file
+}
+
+func (t testThinker) ACLLogMaxLen() int {
+	return 2
+}
+
+// 只认识 get、set 与 @read、@write
+type testValidator struct{}
+
+func (testValidator) ValidCmd(name string) bool {
+	return name == "get" || name == "set"
+}
+
+func (testValidator) ValidCategory(category string) bool {
+	return category == "@read" || category == "@write"
+}
+
+func Test_acl_requirepass(t *testing.T) {
+	a, err := New(testThinker{pass: "secret"}, nil)
+	assert.NoError(t, err)
+	assert.False(t, a.DefaultNoAuth())
+	assert.True(t, a.Authenticate(DefaultUser, "secret"))
+	assert.False(t, a.Authenticate(DefaultUser, "wrong"))
+	assert.False(t, a.Authenticate("nobody", "secret"))
+
+	a, err = New(nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, a.DefaultNoAuth())
+	assert.True(t, a.Authenticate(DefaultUser, "any"))
+}
+
+func Test_acl_setuser(t *testing.T) {
+	a, _ := New(nil, testValidator{})
+
+	assert.NoError(t, a.SetUser("alice", []string{"on", ">p1", "~cache:*", "%R~ro:*", "&news", "+@read", "-get", "+set"}))
+	user, ok := a.User("alice")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"on"}, user.Flags())
+	assert.Equal(t, []string{hashPassword("p1")}, user.Passwords())
+	assert.Equal(t, "-@all +@read -get +set", user.CommandsDesc())
+	assert.Equal(t, "~cache:* %R~ro:*", user.KeysDesc())
+	assert.Equal(t, "&news", user.ChannelsDesc())
+	assert.Equal(t, "user alice on #"+hashPassword("p1")+" ~cache:* %R~ro:* &news -@all +@read -get +set", user.String())
+
+	// 出错时不修改用户
+	assert.EqualError(t, a.SetUser("alice", []string{"off", "+del"}),
+		"ERR Error in ACL SETUSER modifier '+del': Unknown command or category name in ACL")
+	assert.EqualError(t, a.SetUser("alice", []string{"<nope"}),
+		"ERR Error in ACL SETUSER modifier '<nope': The password you are trying to remove from the user does not exist")
+	assert.EqualError(t, a.SetUser("alice", []string{"#abc"}),
+		"ERR Error in ACL SETUSER modifier '#abc': The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	assert.EqualError(t, a.SetUser("alice", []string{"%X~a"}), "ERR Error in ACL SETUSER modifier '%X~a': Syntax error")
+	after, _ := a.User("alice")
+	assert.Same(t, user, after)
+
+	assert.NoError(t, a.SetUser("alice", []string{"nopass", "allcommands", "reset"}))
+	user, _ = a.User("alice")
+	assert.Equal(t, "user alice off resetchannels -@all", user.String())
+
+	_, err := a.DelUser(DefaultUser)
+	assert.EqualError(t, err, "ERR The 'default' user cannot be removed")
+	ok, err = a.DelUser("alice")
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	ok, _ = a.DelUser("alice")
+	assert.False(t, ok)
+}
+
+func Test_user_check(t *testing.T) {
+	user := newUser("alice")
+	for _, rule := range []string{"on", "+@read", "+set", "-get", "~cache:*", "%R~ro:*", "%W~wo:*", "&news.*"} {
+		assert.NoError(t, user.applyRule(rule, nil))
+	}
+
+	cases := []struct {
+		req    Request
+		expect string
+	}{
+		{Request{Cmd: "mget", Categories: []string{"@read"}, Keys: []string{"cache:a", "ro:b"}}, ""},
+		{Request{Cmd: "get", Categories: []string{"@read"}, Keys: []string{"cache:a"}},
+			"NOPERM User alice has no permissions to run the 'get' command"},
+		{Request{Cmd: "set", Categories: []string{"@write"}, Keys: []string{"wo:a"}, Write: true}, ""},
+		{Request{Cmd: "set", Categories: []string{"@write"}, Keys: []string{"ro:a"}, Write: true},
+			"NOPERM No permissions to access a key"},
+		{Request{Cmd: "mget", Categories: []string{"@read"}, Keys: []string{"wo:a"}}, "NOPERM No permissions to access a key"},
+		{Request{Cmd: "publish", SubCmd: "", Categories: []string{"@read"}, Channels: []string{"news.tech"}}, ""},
+		{Request{Cmd: "publish", Categories: []string{"@read"}, Channels: []string{"sport"}},
+			"NOPERM No permissions to access a channel"},
+		{Request{Cmd: "client", SubCmd: "kill", Categories: []string{"@admin"}},
+			"NOPERM User alice has no permissions to run the 'client|kill' command"},
+	}
+	for _, c := range cases {
+		err := user.Check(&c.req)
+		if c.expect == "" {
+			assert.NoError(t, err, c.req.Cmd)
+		} else {
+			assert.EqualError(t, err, c.expect, c.req.Cmd)
+		}
+	}
+
+	// 子指令规则
+	assert.NoError(t, user.applyRule("+client|kill", nil))
+	assert.NoError(t, user.Check(&Request{Cmd: "client", SubCmd: "kill", Categories: []string{"@admin"}}))
+	assert.Error(t, user.Check(&Request{Cmd: "client", SubCmd: "list", Categories: []string{"@admin"}}))
+}
+
+func Test_acl_load_save(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.acl")
+
+	// 文件不存在时使用 requirepass
+	a, err := New(testThinker{pass: "secret", file: file}, testValidator{})
+	assert.NoError(t, err)
+	assert.True(t, a.Authenticate(DefaultUser, "secret"))
+
+	assert.NoError(t, a.SetUser("alice", []string{"on", ">p1", "~*", "+get"}))
+	assert.NoError(t, a.Save())
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "user alice on #"+hashPassword("p1")+" ~* resetchannels -@all +get\n"+
+		"user default on #"+hashPassword("secret")+" ~* &* +@all\n", string(data))
+
+	b, err := New(testThinker{file: file}, testValidator{})
+	assert.NoError(t, err)
+	assert.True(t, b.Authenticate("alice", "p1"))
+	assert.True(t, b.Authenticate(DefaultUser, "secret"))
+
+	// 加载时删除文件中不存在的用户
+	assert.NoError(t, os.WriteFile(file, []byte("user bob on nopass\n"), 0644))
+	removed, err := b.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, removed)
+	assert.True(t, b.DefaultNoAuth())
+
+	// 任一行出错时不做修改
+	assert.NoError(t, os.WriteFile(file, []byte("user carol on\nuser dave +del\nuser carol on\n"), 0644))
+	_, err = b.Load()
+	assert.EqualError(t, err, "ERR "+file+":2: Unknown command or category name in ACL. "+file+":3: duplicate user 'carol' found.")
+	_, ok := b.User("bob")
+	assert.True(t, ok)
+
+	_, err = New(testThinker{file: file}, testValidator{})
+	assert.Error(t, err)
+}
+
+func Test_acl_log(t *testing.T) {
+	a, _ := New(testThinker{}, nil)
+	a.LogDenial(ReasonCommand, "toplevel", "get", "alice", "id=1")
+	a.LogDenial(ReasonCommand, "toplevel", "get", "alice", "id=2")
+	a.LogDenial(ReasonKey, "toplevel", "k", "alice", "id=3")
+
+	entries := a.LogEntries(-1)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, ReasonKey, entries[0].Reason)
+	assert.Equal(t, 1, entries[0].Count)
+	// 相同的记录合并计数
+	assert.Equal(t, 2, entries[1].Count)
+	assert.Equal(t, "id=2", entries[1].ClientInfo)
+
+	// 超过 acllog-max-len 时丢弃最旧的记录
+	a.LogDenial(ReasonAuth, "toplevel", "AUTH", "bob", "id=4")
+	entries = a.LogEntries(-1)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, ReasonAuth, entries[0].Reason)
+	assert.Len(t, a.LogEntries(1), 1)
+
+	a.ResetLog()
+	assert.Empty(t, a.LogEntries(-1))
+}
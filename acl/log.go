@@ -0,0 +1,95 @@
+package acl
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogMaxLen = 128
+	// 相同的拒绝记录在该时间内合并计数
+	logGroupInterval = 60 * time.Second
+)
+
+// ACL LOG 中的一条记录
+type LogEntry struct {
+	Count    int
+	Reason   string
+	Context  string
+	Object   string
+	Username string
+	// 被拒绝的客户端信息，格式与 CLIENT INFO 一致
+	ClientInfo string
+	EntryID    int64
+	Created    time.Time
+	Updated    time.Time
+}
+
+// 权限检查失败与认证失败的记录，最新的记录在前
+type denialLog struct {
+	mu      sync.Mutex
+	entries []*LogEntry
+	maxLen  int
+	nextID  int64
+}
+
+func newDenialLog(maxLen int) *denialLog {
+	return &denialLog{maxLen: maxLen}
+}
+
+func (d *denialLog) add(entry LogEntry, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, e := range d.entries {
+		if e.Reason == entry.Reason && e.Context == entry.Context && e.Object == entry.Object &&
+			e.Username == entry.Username && now.Sub(e.Updated) < logGroupInterval {
+			e.Count++
+			e.Updated, e.ClientInfo = now, entry.ClientInfo
+			// 更新后移到最前
+			copy(d.entries[1:i+1], d.entries[:i])
+			d.entries[0] = e
+			return
+		}
+	}
+
+	entry.Count, entry.EntryID = 1, d.nextID
+	entry.Created, entry.Updated = now, now
+	d.nextID++
+	d.entries = append([]*LogEntry{&entry}, d.entries...)
+	if len(d.entries) > d.maxLen {
+		d.entries = d.entries[:d.maxLen]
+	}
+}
+
+// 记录一次拒绝. context 为 toplevel 或 lua
+func (a *ACL) LogDenial(reason, context, object, username, clientInfo string) {
+	a.log.add(LogEntry{
+		Reason:     reason,
+		Context:    context,
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+	}, time.Now())
+}
+
+// 最近的 count 条记录，count 小于 0 时返回全部
+func (a *ACL) LogEntries(count int) []LogEntry {
+	a.log.mu.Lock()
+	defer a.log.mu.Unlock()
+
+	if count < 0 || count > len(a.log.entries) {
+		count = len(a.log.entries)
+	}
+	entries := make([]LogEntry, 0, count)
+	for _, e := range a.log.entries[:count] {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+func (a *ACL) ResetLog() {
+	a.log.mu.Lock()
+	defer a.log.mu.Unlock()
+	a.log.entries = nil
+}
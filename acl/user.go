@@ -0,0 +1,423 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"goredis/lib"
+	"strings"
+)
+
+var (
+	errRuleSyntax       = errors.New("Syntax error")
+	errUnknownCmd       = errors.New("Unknown command or category name in ACL")
+	errPasswordNotExist = errors.New("The password you are trying to remove from the user does not exist")
+	errBadPasswordHash  = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+)
+
+// 指令规则. name 为指令名、子指令(如 client|kill)或带 @ 前缀的类别
+type cmdRule struct {
+	allow bool
+	name  string
+}
+
+func (r cmdRule) String() string {
+	if r.allow {
+		return "+" + r.name
+	}
+	return "-" + r.name
+}
+
+func (r cmdRule) match(cmd, subCmd string, categories []string) bool {
+	if r.name == "@all" {
+		return true
+	}
+	if strings.HasPrefix(r.name, "@") {
+		for _, category := range categories {
+			if category == r.name {
+				return true
+			}
+		}
+		return false
+	}
+	return r.name == cmd || (subCmd != "" && r.name == cmd+"|"+subCmd)
+}
+
+// key 模式，~ 可读写，%R~ 只读，%W~ 只写
+type keyPattern struct {
+	pattern     string
+	read, write bool
+}
+
+func (k keyPattern) String() string {
+	switch {
+	case k.read && k.write:
+		return "~" + k.pattern
+	case k.read:
+		return "%R~" + k.pattern
+	default:
+		return "%W~" + k.pattern
+	}
+}
+
+// ACL 用户. 创建后不再修改，修改规则时生成新的用户替换，检查权限时无需加锁
+type User struct {
+	Name    string
+	Enabled bool
+	// 无需密码，任意密码都可以认证
+	NoPass bool
+	// 密码的 sha256，按添加顺序排列
+	passwords []string
+	// 按顺序生效，后面的规则覆盖前面的规则
+	cmdRules []cmdRule
+	keys     []keyPattern
+	channels []string
+}
+
+func newUser(name string) *User {
+	return &User{Name: name}
+}
+
+func (u *User) clone() *User {
+	user := *u
+	user.passwords = append([]string(nil), u.passwords...)
+	user.cmdRules = append([]cmdRule(nil), u.cmdRules...)
+	user.keys = append([]keyPattern(nil), u.keys...)
+	user.channels = append([]string(nil), u.channels...)
+	return &user
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func validPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *User) checkPassword(password string) bool {
+	if u.NoPass {
+		return true
+	}
+	hash := hashPassword(password)
+	for _, p := range u.passwords {
+		if p == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) addPassword(hash string) {
+	u.NoPass = false
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+}
+
+func (u *User) removePassword(hash string) bool {
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 追加指令规则. @all 覆盖之前的全部规则，同名规则只保留最后一条
+func (u *User) addCmdRule(rule cmdRule) {
+	if rule.name == "@all" {
+		u.cmdRules = u.cmdRules[:0]
+	}
+	rules := u.cmdRules[:0]
+	for _, r := range u.cmdRules {
+		if r.name != rule.name {
+			rules = append(rules, r)
+		}
+	}
+	u.cmdRules = append(rules, rule)
+}
+
+func (u *User) addKeyPattern(key keyPattern) {
+	for i, k := range u.keys {
+		if k.pattern == key.pattern {
+			u.keys[i].read = k.read || key.read
+			u.keys[i].write = k.write || key.write
+			return
+		}
+	}
+	u.keys = append(u.keys, key)
+}
+
+func (u *User) addChannel(channel string) {
+	for _, c := range u.channels {
+		if c == channel {
+			return
+		}
+	}
+	u.channels = append(u.channels, channel)
+}
+
+// 按 ACL SETUSER 的规则修改用户
+func (u *User) applyRule(rule string, validator Validator) error {
+	lower := strings.ToLower(rule)
+	switch lower {
+	case "on":
+		u.Enabled = true
+	case "off":
+		u.Enabled = false
+	case "nopass":
+		u.NoPass, u.passwords = true, nil
+	case "resetpass":
+		u.NoPass, u.passwords = false, nil
+	case "allkeys":
+		u.keys = []keyPattern{{pattern: "*", read: true, write: true}}
+	case "resetkeys":
+		u.keys = nil
+	case "allchannels":
+		u.channels = []string{"*"}
+	case "resetchannels":
+		u.channels = nil
+	case "allcommands":
+		u.addCmdRule(cmdRule{allow: true, name: "@all"})
+	case "nocommands":
+		u.addCmdRule(cmdRule{allow: false, name: "@all"})
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "nocommands"} {
+			_ = u.applyRule(r, validator)
+		}
+	default:
+		return u.applyPatternRule(rule, validator)
+	}
+	return nil
+}
+
+func (u *User) applyPatternRule(rule string, validator Validator) error {
+	if rule == "" {
+		return errRuleSyntax
+	}
+	switch rule[0] {
+	case '>':
+		u.addPassword(hashPassword(rule[1:]))
+	case '<':
+		if !u.removePassword(hashPassword(rule[1:])) {
+			return errPasswordNotExist
+		}
+	case '#':
+		if !validPasswordHash(rule[1:]) {
+			return errBadPasswordHash
+		}
+		u.addPassword(rule[1:])
+	case '!':
+		if !validPasswordHash(rule[1:]) {
+			return errBadPasswordHash
+		}
+		if !u.removePassword(rule[1:]) {
+			return errPasswordNotExist
+		}
+	case '~':
+		u.addKeyPattern(keyPattern{pattern: rule[1:], read: true, write: true})
+	case '%':
+		pivot := strings.IndexByte(rule, '~')
+		if pivot < 2 {
+			return errRuleSyntax
+		}
+		var key keyPattern
+		for _, c := range strings.ToUpper(rule[1:pivot]) {
+			switch c {
+			case 'R':
+				key.read = true
+			case 'W':
+				key.write = true
+			default:
+				return errRuleSyntax
+			}
+		}
+		key.pattern = rule[pivot+1:]
+		u.addKeyPattern(key)
+	case '&':
+		u.addChannel(rule[1:])
+	case '+', '-':
+		name := strings.ToLower(rule[1:])
+		if name == "" {
+			return errRuleSyntax
+		}
+		if validator != nil {
+			valid := name == "@all"
+			if strings.HasPrefix(name, "@") {
+				valid = valid || validator.ValidCategory(name)
+			} else {
+				valid = validator.ValidCmd(name)
+			}
+			if !valid {
+				return errUnknownCmd
+			}
+		}
+		u.addCmdRule(cmdRule{allow: rule[0] == '+', name: name})
+	default:
+		return errRuleSyntax
+	}
+	return nil
+}
+
+// 标识，与 ACL GETUSER 中的 flags 一致
+func (u *User) Flags() []string {
+	flags := []string{"off"}
+	if u.Enabled {
+		flags[0] = "on"
+	}
+	if u.NoPass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *User) Passwords() []string {
+	return append([]string(nil), u.passwords...)
+}
+
+// 指令规则的描述，如 -@all +get
+func (u *User) CommandsDesc() string {
+	rules := make([]string, 0, len(u.cmdRules)+1)
+	if len(u.cmdRules) == 0 || u.cmdRules[0].name != "@all" {
+		rules = append(rules, "-@all")
+	}
+	for _, rule := range u.cmdRules {
+		rules = append(rules, rule.String())
+	}
+	return strings.Join(rules, " ")
+}
+
+func (u *User) KeysDesc() string {
+	keys := make([]string, 0, len(u.keys))
+	for _, key := range u.keys {
+		keys = append(keys, key.String())
+	}
+	return strings.Join(keys, " ")
+}
+
+func (u *User) ChannelsDesc() string {
+	channels := make([]string, 0, len(u.channels))
+	for _, channel := range u.channels {
+		channels = append(channels, "&"+channel)
+	}
+	return strings.Join(channels, " ")
+}
+
+// ACL LIST 与 aclfile 中的格式，可以作为 ACL SETUSER 的规则还原用户
+func (u *User) String() string {
+	parts := []string{"user", u.Name}
+	parts = append(parts, u.Flags()...)
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	if keys := u.KeysDesc(); keys != "" {
+		parts = append(parts, keys)
+	}
+	if channels := u.ChannelsDesc(); channels != "" {
+		parts = append(parts, channels)
+	} else {
+		parts = append(parts, "resetchannels")
+	}
+	parts = append(parts, u.CommandsDesc())
+	return strings.Join(parts, " ")
+}
+
+// 权限检查的请求
+type Request struct {
+	Cmd, SubCmd string
+	// 指令所属的类别，带 @ 前缀
+	Categories []string
+	Keys       []string
+	// 是否写入 key
+	Write    bool
+	Channels []string
+}
+
+// 权限不足. Reason 为 command、key 或 channel，Object 为被拒绝的指令名、key 或频道
+type PermError struct {
+	User   string
+	Reason string
+	Object string
+}
+
+func (p *PermError) Error() string {
+	switch p.Reason {
+	case ReasonKey:
+		return "NOPERM No permissions to access a key"
+	case ReasonChannel:
+		return "NOPERM No permissions to access a channel"
+	default:
+		return "NOPERM User " + p.User + " has no permissions to run the '" + p.Object + "' command"
+	}
+}
+
+const (
+	ReasonCommand = "command"
+	ReasonKey     = "key"
+	ReasonChannel = "channel"
+	ReasonAuth    = "auth"
+)
+
+// 检查用户是否可以执行指令. 写指令访问的 key 需要写权限，其他指令需要读权限
+func (u *User) Check(req *Request) error {
+	var allowed bool
+	for _, rule := range u.cmdRules {
+		if rule.match(req.Cmd, req.SubCmd, req.Categories) {
+			allowed = rule.allow
+		}
+	}
+	if !allowed {
+		object := req.Cmd
+		if req.SubCmd != "" {
+			object += "|" + req.SubCmd
+		}
+		return &PermError{User: u.Name, Reason: ReasonCommand, Object: object}
+	}
+
+	for _, key := range req.Keys {
+		if !u.checkKey(key, req.Write) {
+			return &PermError{User: u.Name, Reason: ReasonKey, Object: key}
+		}
+	}
+	for _, channel := range req.Channels {
+		if !u.checkChannel(channel) {
+			return &PermError{User: u.Name, Reason: ReasonChannel, Object: channel}
+		}
+	}
+	return nil
+}
+
+func (u *User) checkKey(key string, write bool) bool {
+	for _, k := range u.keys {
+		if (write && !k.write) || (!write && !k.read) {
+			continue
+		}
+		if lib.GlobMatch(k.pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) checkChannel(channel string) bool {
+	for _, c := range u.channels {
+		if lib.GlobMatch(c, channel) {
+			return true
+		}
+	}
+	return false
+}
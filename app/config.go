@@ -3,8 +3,14 @@ package app
 import (
 	"bufio"
 	"fmt"
+	"goredis/database"
+	"goredis/datastore"
+	"goredis/handler"
 	"goredis/persist"
+	"goredis/protocol"
+	"goredis/server"
 	"io"
+	"math"
 	"os"
 	"reflect"
 	"strconv"
@@ -19,12 +25,48 @@ type Config struct {
 	AppendFileName_         string `cfg:"appendfilename"`
 	AppendFsync_            string `cfg:"appendfsync"`
 	AutoAofRewriteAfterCmd_ int    `cfg:"auto-aof-rewrite-after-cmds"`
+
+	SetMaxIntsetEntries_    int `cfg:"set-max-intset-entries"`
+	HashMaxListpackEntries_ int `cfg:"hash-max-listpack-entries"`
+	HashMaxListpackValue_   int `cfg:"hash-max-listpack-value"`
+	ZSetMaxListpackEntries_ int `cfg:"zset-max-listpack-entries"`
+	ZSetMaxListpackValue_   int `cfg:"zset-max-listpack-value"`
+
+	ProtoMaxBulkLen_        int `cfg:"proto-max-bulk-len"`
+	ProtoMaxMultiBulkLen_   int `cfg:"proto-max-multibulk-len"`
+	ClientQueryBufferLimit_ int `cfg:"client-query-buffer-limit"`
+
+	ClientOutputBufferLimit_ string `cfg:"client-output-buffer-limit"`
+
+	RequirePass_  string `cfg:"requirepass"`
+	ACLFile_      string `cfg:"aclfile"`
+	ACLLogMaxLen_ int    `cfg:"acllog-max-len"`
+
+	TLSPort_            int    `cfg:"tls-port"`
+	TLSCertFile_        string `cfg:"tls-cert-file"`
+	TLSKeyFile_         string `cfg:"tls-key-file"`
+	TLSCACertFile_      string `cfg:"tls-ca-cert-file"`
+	TLSAuthClients_     string `cfg:"tls-auth-clients"`
+	TLSAuthClientsUser_ string `cfg:"tls-auth-clients-user"`
+
+	LuaTimeLimit_ int `cfg:"lua-time-limit"`
 }
 
+// port 为 0 时不监听明文端口
 func (c *Config) Address() string {
+	if c.Port == 0 {
+		return ""
+	}
 	return fmt.Sprintf("%s:%d", c.Bind, c.Port)
 }
 
+func (c *Config) TLSAddress() string {
+	if c.TLSPort_ == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", c.Bind, c.TLSPort_)
+}
+
 func (c *Config) AppendOnly() bool {
 	return c.AppendOnly_
 }
@@ -41,6 +83,78 @@ func (c *Config) AutoAofRewriteAfterCmd() int {
 	return c.AutoAofRewriteAfterCmd_
 }
 
+func (c *Config) SetMaxIntsetEntries() int {
+	return c.SetMaxIntsetEntries_
+}
+
+func (c *Config) HashMaxListpackEntries() int {
+	return c.HashMaxListpackEntries_
+}
+
+func (c *Config) HashMaxListpackValue() int {
+	return c.HashMaxListpackValue_
+}
+
+func (c *Config) ZSetMaxListpackEntries() int {
+	return c.ZSetMaxListpackEntries_
+}
+
+func (c *Config) ZSetMaxListpackValue() int {
+	return c.ZSetMaxListpackValue_
+}
+
+func (c *Config) ProtoMaxBulkLen() int {
+	return c.ProtoMaxBulkLen_
+}
+
+func (c *Config) ProtoMaxMultiBulkLen() int {
+	return c.ProtoMaxMultiBulkLen_
+}
+
+func (c *Config) ClientQueryBufferLimit() int {
+	return c.ClientQueryBufferLimit_
+}
+
+func (c *Config) ClientOutputBufferLimit() string {
+	return c.ClientOutputBufferLimit_
+}
+
+func (c *Config) RequirePass() string {
+	return c.RequirePass_
+}
+
+func (c *Config) ACLFile() string {
+	return c.ACLFile_
+}
+
+func (c *Config) ACLLogMaxLen() int {
+	return c.ACLLogMaxLen_
+}
+
+func (c *Config) TLSCertFile() string {
+	return c.TLSCertFile_
+}
+
+func (c *Config) TLSKeyFile() string {
+	return c.TLSKeyFile_
+}
+
+func (c *Config) TLSCACertFile() string {
+	return c.TLSCACertFile_
+}
+
+func (c *Config) TLSAuthClients() string {
+	return c.TLSAuthClients_
+}
+
+func (c *Config) TLSAuthClientsUser() string {
+	return c.TLSAuthClientsUser_
+}
+
+func (c *Config) LuaTimeLimit() int {
+	return c.LuaTimeLimit_
+}
+
 var (
 	confOnce   sync.Once
 	globalConf *Config
@@ -50,6 +164,26 @@ func PersistThinker() persist.Thinker {
 	return SetUpConfig()
 }
 
+func DataStoreThinker() datastore.Thinker {
+	return SetUpConfig()
+}
+
+func ProtocolThinker() protocol.Thinker {
+	return SetUpConfig()
+}
+
+func HandlerThinker() handler.Thinker {
+	return SetUpConfig()
+}
+
+func ServerThinker() server.Thinker {
+	return SetUpConfig()
+}
+
+func DBThinker() database.Thinker {
+	return SetUpConfig()
+}
+
 func SetUpConfig() *Config {
 	confOnce.Do(func() {
 		defer func() {
@@ -94,7 +228,8 @@ func setUpConfig(src io.Reader) *Config {
 		return nil
 	}
 
-	conf := &Config{}
+	// 未配置的项沿用默认值
+	conf := defaultConf()
 
 	t := reflect.TypeOf(conf)
 	v := reflect.ValueOf(conf)
@@ -129,5 +264,24 @@ func defaultConf() *Config {
 		Bind:        "0.0.0.0",
 		Port:        6379,
 		AppendOnly_: false,
+
+		SetMaxIntsetEntries_:    512,
+		HashMaxListpackEntries_: 128,
+		HashMaxListpackValue_:   64,
+		ZSetMaxListpackEntries_: 128,
+		ZSetMaxListpackValue_:   64,
+
+		ProtoMaxBulkLen_:        512 * 1024 * 1024,
+		ProtoMaxMultiBulkLen_:   math.MaxInt32,
+		ClientQueryBufferLimit_: 1024 * 1024 * 1024,
+
+		ClientOutputBufferLimit_: "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60",
+
+		ACLLogMaxLen_: 128,
+
+		TLSAuthClients_:     "yes",
+		TLSAuthClientsUser_: "off",
+
+		LuaTimeLimit_: 5000,
 	}
 }
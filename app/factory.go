@@ -18,6 +18,11 @@ func init() {
 	// 配置加载
 	_ = container.Provide(SetUpConfig)
 	_ = container.Provide(PersistThinker)
+	_ = container.Provide(DataStoreThinker)
+	_ = container.Provide(ProtocolThinker)
+	_ = container.Provide(HandlerThinker)
+	_ = container.Provide(ServerThinker)
+	_ = container.Provide(DBThinker)
 	// 日志打印 logger
 	_ = container.Provide(log.GetDefaultLogger)
 
@@ -53,5 +58,11 @@ func ConstructServer() (*server.Server, error) {
 	}); err != nil {
 		return nil, err
 	}
-	return server.NewServer(h, l), nil
+	var thinker server.Thinker
+	if err := container.Invoke(func(_thinker server.Thinker) {
+		thinker = _thinker
+	}); err != nil {
+		return nil, err
+	}
+	return server.NewServer(h, l, thinker), nil
 }
\ No newline at end of file
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"goredis/handler"
+	"time"
+)
+
+// 阻塞指令暂无可用数据时返回. 执行器会挂起该指令，直到关注的 key 被写入、超时或连接断开
+type BlockedReply struct {
+	keys []string
+	// 0 代表永久阻塞
+	timeout time.Duration
+}
+
+func NewBlockedReply(keys []string, timeout time.Duration) *BlockedReply {
+	return &BlockedReply{
+		keys:    keys,
+		timeout: timeout,
+	}
+}
+
+// 阻塞超时的回复为空数组
+func (b *BlockedReply) ToBytes() []byte {
+	return handler.NewNullMultiBulkReply().ToBytes()
+}
+
+func (b *BlockedReply) ToResp3Bytes() []byte {
+	return handler.NewNullMultiBulkReply().ToResp3Bytes()
+}
+
+func (b *BlockedReply) Keys() []string {
+	return b.keys
+}
+
+func (b *BlockedReply) Timeout() time.Duration {
+	return b.timeout
+}
+
+// 挂起阻塞指令. 只在 run 协程中调用
+func (e *DBExecutor) block(cmd *Command, blocked *BlockedReply) {
+	cmd.blocked = blocked
+	for _, key := range blocked.keys {
+		e.blocking[key] = append(e.blocking[key], cmd)
+	}
+}
+
+// 将指令从所有关注 key 的等待队列中移除. 返回指令此前是否处于挂起状态
+func (e *DBExecutor) removeBlocked(cmd *Command) bool {
+	if cmd.blocked == nil {
+		return false
+	}
+
+	for _, key := range cmd.blocked.keys {
+		waiters := e.blocking[key]
+		for i, waiter := range waiters {
+			if waiter == cmd {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(e.blocking, key)
+			continue
+		}
+		e.blocking[key] = waiters
+	}
+	cmd.blocked = nil
+	return true
+}
+
+// key 被写入后，按阻塞先后顺序重新执行挂起的指令，直到数据被取完
+func (e *DBExecutor) serveBlocked(key string) {
+	waiters, ok := e.blocking[key]
+	if !ok {
+		return
+	}
+
+	for _, waiter := range append([]*Command(nil), waiters...) {
+		reply := e.cmdHandlers[waiter.cmd](waiter)
+		if _, ok := reply.(*BlockedReply); ok {
+			continue
+		}
+		e.removeBlocked(waiter)
+		waiter.receiver <- reply
+	}
+}
+
+// 阻塞超时或连接断开时取消挂起. 如果指令已经被唤醒执行，则回复已经写入 receiver
+func (e *DBExecutor) unblock(cmd *Command) {
+	blocked := cmd.blocked
+	if !e.removeBlocked(cmd) {
+		return
+	}
+	cmd.receiver <- blocked
+}
+
+func (e *DBExecutor) Unblock(cmd *Command) bool {
+	select {
+	case <-e.ctx.Done():
+		return false
+	case e.unblockCh <- cmd:
+		return true
+	}
+}
@@ -0,0 +1,169 @@
+package database_test
+
+import (
+	"context"
+	"goredis/database"
+	"goredis/datastore"
+	"goredis/handler"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePersister struct {
+	mu   sync.Mutex
+	cmds [][][]byte
+}
+
+func (f *fakePersister) Reloader() (io.ReadCloser, error) {
+	return nil, io.EOF
+}
+
+func (f *fakePersister) PersistCmd(ctx context.Context, cmd [][]byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cmds = append(f.cmds, cmd)
+}
+
+func (f *fakePersister) Close() {}
+
+func newTestTrigger() (handler.DB, *fakePersister) {
+	persister := &fakePersister{}
+	executor := database.NewDBExecutor(datastore.NewKVStore(persister, nil), persister, nil)
+	return database.NewDBTrigger(executor), persister
+}
+
+func cmdLine(args ...string) [][]byte {
+	res := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		res = append(res, []byte(arg))
+	}
+	return res
+}
+
+// 异步执行指令，等待其进入阻塞状态
+func doAsync(db handler.DB, ctx context.Context, args ...string) <-chan handler.Reply {
+	replyc := make(chan handler.Reply, 1)
+	go func() {
+		replyc <- db.Do(ctx, cmdLine(args...))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	return replyc
+}
+
+func Test_blocking_pop_wake_up(t *testing.T) {
+	db, persister := newTestTrigger()
+	defer db.Close()
+
+	first := doAsync(db, context.Background(), "bzpopmin", "a", "b", "0")
+	second := doAsync(db, context.Background(), "bzmpop", "0", "1", "b", "MAX", "COUNT", "2")
+
+	// 先阻塞的指令优先获得数据
+	db.Do(context.Background(), cmdLine("zadd", "b", "1", "m1"))
+	assert.Equal(t, "*3\r\n$1\r\nb\r\n$2\r\nm1\r\n$1\r\n1\r\n", string((<-first).ToBytes()))
+
+	select {
+	case reply := <-second:
+		t.Fatalf("unexpected reply: %s", reply.ToBytes())
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	db.Do(context.Background(), cmdLine("zadd", "b", "2", "m2", "3", "m3"))
+	assert.Equal(t, "*2\r\n$1\r\nb\r\n*2\r\n*2\r\n$2\r\nm3\r\n$1\r\n3\r\n*2\r\n$2\r\nm2\r\n$1\r\n2\r\n", string((<-second).ToBytes()))
+
+	persister.mu.Lock()
+	defer persister.mu.Unlock()
+	assert.Equal(t, cmdLine("zpopmin", "b", "1"), persister.cmds[1])
+	assert.Equal(t, cmdLine("zpopmax", "b", "2"), persister.cmds[3])
+}
+
+func Test_blocking_pop_timeout(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+
+	start := time.Now()
+	reply := db.Do(context.Background(), cmdLine("bzpopmax", "a", "0.1"))
+	assert.Equal(t, "*-1\r\n", string(reply.ToBytes()))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// 超时的指令不再占用数据
+	db.Do(context.Background(), cmdLine("zadd", "a", "1", "m1"))
+	reply = db.Do(context.Background(), cmdLine("zcard", "a"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+}
+
+func Test_blocking_pop_disconnect(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	replyc := doAsync(db, ctx, "bzpopmin", "a", "0")
+	cancel()
+	assert.Equal(t, "*-1\r\n", string((<-replyc).ToBytes()))
+
+	db.Do(context.Background(), cmdLine("zadd", "a", "1", "m1"))
+	reply := db.Do(context.Background(), cmdLine("zcard", "a"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+}
+
+func Test_blocking_xread(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+
+	db.Do(context.Background(), cmdLine("xadd", "s", "1-1", "a", "1"))
+	replyc := doAsync(db, context.Background(), "xread", "BLOCK", "0", "STREAMS", "s", "$")
+
+	// 其他 key 的写入不会唤醒
+	db.Do(context.Background(), cmdLine("zadd", "s2", "1", "m1"))
+	db.Do(context.Background(), cmdLine("xadd", "s", "2-1", "b", "2"))
+	assert.Equal(t, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-1\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n", string((<-replyc).ToBytes()))
+
+	reply := db.Do(context.Background(), cmdLine("xread", "BLOCK", "50", "STREAMS", "s", "$"))
+	assert.Equal(t, "*-1\r\n", string(reply.ToBytes()))
+}
+
+func Test_blocking_xreadgroup(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+
+	db.Do(context.Background(), cmdLine("xgroup", "create", "s", "g", "$", "mkstream"))
+	replyc := doAsync(db, context.Background(), "xreadgroup", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "s", ">")
+
+	db.Do(context.Background(), cmdLine("xadd", "s", "1-1", "a", "1"))
+	assert.Equal(t, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n", string((<-replyc).ToBytes()))
+
+	reply := db.Do(context.Background(), cmdLine("xpending", "s", "g"))
+	assert.Equal(t, "*4\r\n:1\r\n$3\r\n1-1\r\n$3\r\n1-1\r\n*1\r\n*2\r\n$1\r\nc\r\n$1\r\n1\r\n", string(reply.ToBytes()))
+}
+
+func Test_blocking_wake_up_by_declared_key(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+
+	// XGROUP DESTROY 的 key 不在首个参数，同样唤醒阻塞在该 key 上的指令
+	db.Do(context.Background(), cmdLine("xgroup", "create", "s", "g", "$", "mkstream"))
+	replyc := doAsync(db, context.Background(), "xreadgroup", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "s", ">")
+	db.Do(context.Background(), cmdLine("xgroup", "destroy", "s", "g"))
+	select {
+	case reply := <-replyc:
+		assert.Contains(t, string(reply.ToBytes()), "NOGROUP")
+	case <-time.After(time.Second):
+		t.Fatal("xreadgroup not woken up")
+	}
+}
+
+func Test_expire_declared_key(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+
+	// 指令声明的每个 key 在执行前都惰性过期，而不只是首个参数
+	db.Do(context.Background(), cmdLine("set", "a", "1"))
+	db.Do(context.Background(), cmdLine("set", "b", "2"))
+	db.Do(context.Background(), cmdLine("expire", "b", "1"))
+	time.Sleep(1100 * time.Millisecond)
+	reply := db.Do(context.Background(), cmdLine("mget", "a", "b"))
+	assert.Equal(t, "*2\r\n$1\r\n1\r\n$-1\r\n", string(reply.ToBytes()))
+}
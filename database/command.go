@@ -0,0 +1,483 @@
+package database
+
+import (
+	"goredis/handler"
+	"goredis/lib"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const CmdTypeCommand CmdType = "command"
+
+// 连接层处理的指令，不进入执行器，只在指令表中声明供 COMMAND 查询
+const (
+	CmdTypeHello CmdType = "hello"
+	CmdTypePing  CmdType = "ping"
+)
+
+func newCmdSpec(group string, arity, firstKey, lastKey, step int, flags ...string) *CmdSpec {
+	return &CmdSpec{
+		Arity:    arity,
+		Flags:    flags,
+		FirstKey: firstKey,
+		LastKey:  lastKey,
+		Step:     step,
+		Group:    group,
+	}
+}
+
+// key 个数由参数指定的指令，如 EVAL script numkeys key [key ...]. index 为 numkeys 的位置，从 1 开始
+func withNumKeys(spec *CmdSpec, index int) *CmdSpec {
+	spec.movableKeys = func(args [][]byte) []string {
+		if index > len(args) {
+			return nil
+		}
+		// numkeys 来自客户端，先与剩余参数个数比较，避免相加溢出
+		numKeys, err := strconv.Atoi(string(args[index-1]))
+		if err != nil || numKeys <= 0 || numKeys > len(args)-index {
+			return nil
+		}
+		keyArgs := args[index : index+numKeys]
+		keys := make([]string, 0, len(keyArgs))
+		for _, arg := range keyArgs {
+			keys = append(keys, string(arg))
+		}
+		return keys
+	}
+	return spec
+}
+
+// key 位于 STREAMS 关键字之后的前一半参数，如 XREAD [COUNT count] STREAMS key [key ...] id [id ...]
+func withStreamsKeys(spec *CmdSpec) *CmdSpec {
+	spec.movableKeys = func(args [][]byte) []string {
+		for i, arg := range args {
+			if !strings.EqualFold(string(arg), "streams") {
+				continue
+			}
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil
+			}
+			keys := make([]string, 0, len(rest)/2)
+			for _, key := range rest[:len(rest)/2] {
+				keys = append(keys, string(key))
+			}
+			return keys
+		}
+		return nil
+	}
+	return spec
+}
+
+// 内置指令表. 参数个数与 key 位置与 redis 一致
+var cmdTable = map[CmdType]*CmdSpec{
+	CmdTypeHello:   newCmdSpec("connection", -1, 0, 0, 0, CmdFlagNoScript, CmdFlagLoading, CmdFlagStale, CmdFlagFast),
+	CmdTypePing:    newCmdSpec("connection", -1, 0, 0, 0, CmdFlagFast),
+	CmdTypeCommand: newCmdSpec("server", -1, 0, 0, 0, CmdFlagLoading, CmdFlagStale),
+
+	CmdTypeExpire:   newCmdSpec("generic", -3, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeExpireAt: newCmdSpec("generic", -3, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeObject:   newCmdSpec("generic", -2, 2, 2, 1, CmdFlagReadOnly),
+
+	CmdTypeGet:  newCmdSpec("string", 2, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeSet:  newCmdSpec("string", -3, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeMGet: newCmdSpec("string", -2, 1, -1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeMSet: newCmdSpec("string", -3, 1, -1, 2, CmdFlagWrite, CmdFlagDenyOOM),
+
+	CmdTypeLPush:  newCmdSpec("list", -3, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM, CmdFlagFast),
+	CmdTypeLPop:   newCmdSpec("list", -2, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeRPush:  newCmdSpec("list", -3, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM, CmdFlagFast),
+	CmdTypeRPop:   newCmdSpec("list", -2, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeLRange: newCmdSpec("list", 4, 1, 1, 1, CmdFlagReadOnly),
+
+	CmdTypeHSet:    newCmdSpec("hash", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM, CmdFlagFast),
+	CmdTypeHGet:    newCmdSpec("hash", 3, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeHDel:    newCmdSpec("hash", -3, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeHGetAll: newCmdSpec("hash", 2, 1, 1, 1, CmdFlagReadOnly),
+
+	CmdTypeSAdd:        newCmdSpec("set", -3, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM, CmdFlagFast),
+	CmdTypeSIsMember:   newCmdSpec("set", 3, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeSRem:        newCmdSpec("set", -3, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeSMembers:    newCmdSpec("set", 2, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeSCard:       newCmdSpec("set", 2, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeSInter:      newCmdSpec("set", -2, 1, -1, 1, CmdFlagReadOnly),
+	CmdTypeSUnion:      newCmdSpec("set", -2, 1, -1, 1, CmdFlagReadOnly),
+	CmdTypeSDiff:       newCmdSpec("set", -2, 1, -1, 1, CmdFlagReadOnly),
+	CmdTypeSInterStore: newCmdSpec("set", -3, 1, -1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeSUnionStore: newCmdSpec("set", -3, 1, -1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeSDiffStore:  newCmdSpec("set", -3, 1, -1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeSMove:       newCmdSpec("set", 4, 1, 2, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeSPop:        newCmdSpec("set", -2, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeSRandMember: newCmdSpec("set", -2, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeSMIsMember:  newCmdSpec("set", -3, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeSInterCard:  withNumKeys(newCmdSpec("set", -3, 0, 0, 0, CmdFlagReadOnly), 1),
+
+	CmdTypeZAdd:             newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM, CmdFlagFast),
+	CmdTypeZIncrBy:          newCmdSpec("sorted-set", 4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM, CmdFlagFast),
+	CmdTypeZRange:           newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeZRevRange:        newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeZRangeByScore:    newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeZRevRangeByScore: newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeZRangeByLex:      newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeZRevRangeByLex:   newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeZRank:            newCmdSpec("sorted-set", -3, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeZRevRank:         newCmdSpec("sorted-set", -3, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeZScore:           newCmdSpec("sorted-set", 3, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeZMScore:          newCmdSpec("sorted-set", -3, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeZCard:            newCmdSpec("sorted-set", 2, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeZCount:           newCmdSpec("sorted-set", 4, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeZLexCount:        newCmdSpec("sorted-set", 4, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeZPopMin:          newCmdSpec("sorted-set", -2, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeZPopMax:          newCmdSpec("sorted-set", -2, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeBZPopMin:         newCmdSpec("sorted-set", -3, 1, -2, 1, CmdFlagWrite, CmdFlagFast, CmdFlagBlocking),
+	CmdTypeBZPopMax:         newCmdSpec("sorted-set", -3, 1, -2, 1, CmdFlagWrite, CmdFlagFast, CmdFlagBlocking),
+	CmdTypeZMPop:            withNumKeys(newCmdSpec("sorted-set", -4, 0, 0, 0, CmdFlagWrite), 1),
+	CmdTypeBZMPop:           withNumKeys(newCmdSpec("sorted-set", -5, 0, 0, 0, CmdFlagWrite, CmdFlagBlocking), 2),
+	CmdTypeZRem:             newCmdSpec("sorted-set", -3, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeZRemRangeByRank:  newCmdSpec("sorted-set", 4, 1, 1, 1, CmdFlagWrite),
+	CmdTypeZRemRangeByScore: newCmdSpec("sorted-set", 4, 1, 1, 1, CmdFlagWrite),
+	CmdTypeZRemRangeByLex:   newCmdSpec("sorted-set", 4, 1, 1, 1, CmdFlagWrite),
+	CmdTypeZUnion:           withNumKeys(newCmdSpec("sorted-set", -3, 0, 0, 0, CmdFlagReadOnly), 1),
+	CmdTypeZInter:           withNumKeys(newCmdSpec("sorted-set", -3, 0, 0, 0, CmdFlagReadOnly), 1),
+	CmdTypeZDiff:            withNumKeys(newCmdSpec("sorted-set", -3, 0, 0, 0, CmdFlagReadOnly), 1),
+	CmdTypeZUnionStore:      withNumKeys(newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM), 2),
+	CmdTypeZInterStore:      withNumKeys(newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM), 2),
+	CmdTypeZDiffStore:       withNumKeys(newCmdSpec("sorted-set", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM), 2),
+	CmdTypeZRangeStore:      newCmdSpec("sorted-set", -5, 1, 2, 1, CmdFlagWrite, CmdFlagDenyOOM),
+
+	CmdTypeXAdd:      newCmdSpec("stream", -5, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM, CmdFlagFast),
+	CmdTypeXRange:    newCmdSpec("stream", -4, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeXRevRange: newCmdSpec("stream", -4, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeXLen:      newCmdSpec("stream", 2, 1, 1, 1, CmdFlagReadOnly, CmdFlagFast),
+	CmdTypeXTrim:     newCmdSpec("stream", -4, 1, 1, 1, CmdFlagWrite),
+	CmdTypeXDel:      newCmdSpec("stream", -3, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeXRead:     withStreamsKeys(newCmdSpec("stream", -4, 0, 0, 0, CmdFlagReadOnly, CmdFlagBlocking)),
+	CmdTypeXSetID:    newCmdSpec("stream", -3, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM, CmdFlagFast),
+
+	CmdTypeXGroup:     newCmdSpec("stream", -2, 2, 2, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeXReadGroup: withStreamsKeys(newCmdSpec("stream", -7, 0, 0, 0, CmdFlagWrite, CmdFlagBlocking)),
+	CmdTypeXAck:       newCmdSpec("stream", -4, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeXPending:   newCmdSpec("stream", -3, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeXClaim:     newCmdSpec("stream", -6, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeXAutoClaim: newCmdSpec("stream", -6, 1, 1, 1, CmdFlagWrite, CmdFlagFast),
+	CmdTypeXInfo:      newCmdSpec("stream", -3, 2, 2, 1, CmdFlagReadOnly),
+
+	CmdTypeJSONSet:       newCmdSpec("json", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeJSONGet:       newCmdSpec("json", -2, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeJSONDel:       newCmdSpec("json", -2, 1, 1, 1, CmdFlagWrite),
+	CmdTypeJSONNumIncrBy: newCmdSpec("json", 4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeJSONArrAppend: newCmdSpec("json", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+
+	CmdTypeBFReserve:     newCmdSpec("bf", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeBFAdd:         newCmdSpec("bf", 3, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeBFMAdd:        newCmdSpec("bf", -3, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeBFExists:      newCmdSpec("bf", 3, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeBFMExists:     newCmdSpec("bf", -3, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeBFLoadChunk:   newCmdSpec("bf", 4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeCMSInitByDim:  newCmdSpec("cms", 4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeCMSInitByProb: newCmdSpec("cms", 4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeCMSIncrBy:     newCmdSpec("cms", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeCMSQuery:      newCmdSpec("cms", -3, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeCMSMerge:      withNumKeys(newCmdSpec("cms", -4, 1, 1, 1, CmdFlagWrite), 2),
+	CmdTypeCMSLoadChunk:  newCmdSpec("cms", 3, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+
+	CmdTypeTSCreate:   newCmdSpec("timeseries", -2, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeTSAdd:      newCmdSpec("timeseries", -4, 1, 1, 1, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeTSRange:    newCmdSpec("timeseries", -4, 1, 1, 1, CmdFlagReadOnly),
+	CmdTypeTSRevRange: newCmdSpec("timeseries", -4, 1, 1, 1, CmdFlagReadOnly),
+	// 按标签匹配的序列在执行前无法确定，由 datastore 在匹配后检查 ACL 的 key 模式
+	CmdTypeTSMRange: newCmdSpec("timeseries", -4, 0, 0, 0, CmdFlagReadOnly),
+
+	// 索引覆盖的 hash 在执行前无法确定，同上，按索引的前缀检查
+	CmdTypeFTCreate:    newCmdSpec("search", -2, 0, 0, 0, CmdFlagWrite, CmdFlagDenyOOM),
+	CmdTypeFTSearch:    newCmdSpec("search", -3, 0, 0, 0, CmdFlagReadOnly),
+	CmdTypeFTDropIndex: newCmdSpec("search", -2, 0, 0, 0, CmdFlagWrite),
+
+	CmdTypeEval:    withNumKeys(newCmdSpec("scripting", -3, 0, 0, 0, CmdFlagNoScript, CmdFlagMayReplicate), 2),
+	CmdTypeEvalSha: withNumKeys(newCmdSpec("scripting", -3, 0, 0, 0, CmdFlagNoScript, CmdFlagMayReplicate), 2),
+	CmdTypeScript:  newCmdSpec("scripting", -2, 0, 0, 0, CmdFlagNoScript, CmdFlagMayReplicate),
+
+	CmdTypeFunction: newCmdSpec("scripting", -2, 0, 0, 0, CmdFlagNoScript, CmdFlagMayReplicate),
+	CmdTypeFCall:    withNumKeys(newCmdSpec("scripting", -3, 0, 0, 0, CmdFlagNoScript, CmdFlagMayReplicate), 2),
+	CmdTypeFCallRO:  withNumKeys(newCmdSpec("scripting", -3, 0, 0, 0, CmdFlagNoScript), 2),
+}
+
+// 指令组对应的 ACL 类别
+var groupCategories = map[string]string{
+	"connection": "@connection",
+	"server":     "@admin",
+	"generic":    "@keyspace",
+	"string":     "@string",
+	"list":       "@list",
+	"hash":       "@hash",
+	"set":        "@set",
+	"sorted-set": "@sortedset",
+	"stream":     "@stream",
+	"scripting":  "@scripting",
+	"json":       "@json",
+	"bf":         "@bloom",
+	"cms":        "@cms",
+	"timeseries": "@timeseries",
+	"search":     "@search",
+}
+
+// 全部 ACL 类别
+func aclCategories() []string {
+	categories := []string{"@write", "@read", "@admin", "@dangerous", "@fast", "@slow", "@blocking"}
+	for _, category := range groupCategories {
+		if category != "@admin" {
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// ACL 类别，由指令组与读写标识推导
+func (c *CmdSpec) categories() []string {
+	categories := []string{}
+	if c.hasFlag(CmdFlagWrite) {
+		categories = append(categories, "@write")
+	}
+	if c.hasFlag(CmdFlagReadOnly) {
+		categories = append(categories, "@read")
+	}
+	if c.hasFlag(CmdFlagAdmin) {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if c.hasFlag(CmdFlagFast) {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	if c.hasFlag(CmdFlagBlocking) {
+		categories = append(categories, "@blocking")
+	}
+	if category, ok := groupCategories[c.Group]; ok && category != "@admin" {
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+// COMMAND INFO 中的 key 声明，只描述固定位置的 key. 位置可变的 key 需通过 COMMAND GETKEYS 获取
+func (c *CmdSpec) keySpecs() handler.Reply {
+	if c.FirstKey <= 0 {
+		return handler.NewArrayReply([]handler.Reply{})
+	}
+
+	lastKey := c.LastKey
+	if lastKey >= 0 {
+		lastKey -= c.FirstKey
+	}
+	flag := "RO"
+	if c.hasFlag(CmdFlagWrite) {
+		flag = "RW"
+	}
+	return handler.NewArrayReply([]handler.Reply{
+		handler.NewMapReply([]handler.Reply{
+			bulkString("flags"), handler.NewSetReply([]handler.Reply{handler.NewSimpleStringReply(flag)}),
+			bulkString("begin_search"), handler.NewMapReply([]handler.Reply{
+				bulkString("type"), bulkString("index"),
+				bulkString("spec"), handler.NewMapReply([]handler.Reply{
+					bulkString("index"), handler.NewIntReply(int64(c.FirstKey)),
+				}),
+			}),
+			bulkString("find_keys"), handler.NewMapReply([]handler.Reply{
+				bulkString("type"), bulkString("range"),
+				bulkString("spec"), handler.NewMapReply([]handler.Reply{
+					bulkString("lastkey"), handler.NewIntReply(int64(lastKey)),
+					bulkString("keystep"), handler.NewIntReply(int64(c.Step)),
+					bulkString("limit"), handler.NewIntReply(0),
+				}),
+			}),
+		}),
+	})
+}
+
+func bulkString(str string) handler.Reply {
+	return handler.NewBulkReply([]byte(str))
+}
+
+func statusSet(strs []string) handler.Reply {
+	replies := make([]handler.Reply, 0, len(strs))
+	for _, str := range strs {
+		replies = append(replies, handler.NewSimpleStringReply(str))
+	}
+	return handler.NewSetReply(replies)
+}
+
+// 单个指令的 COMMAND INFO 回复
+func (c *CmdSpec) info(name CmdType) handler.Reply {
+	flags := c.Flags
+	if c.movableKeys != nil {
+		flags = append(append([]string{}, flags...), CmdFlagMovableKeys)
+	}
+	if flags == nil {
+		flags = []string{}
+	}
+	return handler.NewArrayReply([]handler.Reply{
+		bulkString(name.String()),
+		handler.NewIntReply(int64(c.Arity)),
+		statusSet(flags),
+		handler.NewIntReply(int64(c.FirstKey)),
+		handler.NewIntReply(int64(c.LastKey)),
+		handler.NewIntReply(int64(c.Step)),
+		statusSet(c.categories()),
+		handler.NewArrayReply([]handler.Reply{}),
+		c.keySpecs(),
+		handler.NewArrayReply([]handler.Reply{}),
+	})
+}
+
+// 单个指令的 COMMAND DOCS 回复
+func (c *CmdSpec) docs() handler.Reply {
+	docs := []handler.Reply{
+		bulkString("group"), bulkString(c.Group),
+	}
+	if c.module != "" {
+		docs = append(docs, bulkString("module"), bulkString(c.module))
+	}
+	return handler.NewMapReply(docs)
+}
+
+// 按名称排序的指令列表
+func (e *DBExecutor) sortedCmds() []CmdType {
+	cmds := make([]CmdType, 0, len(e.cmdSpecs))
+	for cmd := range e.cmdSpecs {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i] < cmds[j]
+	})
+	return cmds
+}
+
+func (e *DBExecutor) lookupSpec(name []byte) (CmdType, *CmdSpec, bool) {
+	cmd := CmdType(strings.ToLower(string(name)))
+	spec, ok := e.cmdSpecs[cmd]
+	return cmd, spec, ok
+}
+
+// COMMAND [COUNT | INFO [name ...] | DOCS [name ...] | GETKEYS cmd [arg ...] | LIST [FILTERBY MODULE name | ACLCAT category | PATTERN pattern]]
+func (e *DBExecutor) command(cmd *Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) == 0 {
+		return e.commandInfos()
+	}
+
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "count":
+		if len(args) != 1 {
+			break
+		}
+		return handler.NewIntReply(int64(len(e.cmdSpecs)))
+
+	case "info":
+		names := args[1:]
+		if len(names) == 0 {
+			return e.commandInfos()
+		}
+		replies := make([]handler.Reply, 0, len(names))
+		for _, name := range names {
+			if cmdType, spec, ok := e.lookupSpec(name); ok {
+				replies = append(replies, spec.info(cmdType))
+				continue
+			}
+			replies = append(replies, handler.NewNullMultiBulkReply())
+		}
+		return handler.NewArrayReply(replies)
+
+	case "docs":
+		var names []CmdType
+		for _, name := range args[1:] {
+			if cmdType, _, ok := e.lookupSpec(name); ok {
+				names = append(names, cmdType)
+			}
+		}
+		if len(args) == 1 {
+			names = e.sortedCmds()
+		}
+		replies := make([]handler.Reply, 0, 2*len(names))
+		for _, name := range names {
+			replies = append(replies, bulkString(name.String()), e.cmdSpecs[name].docs())
+		}
+		return handler.NewMapReply(replies)
+
+	case "getkeys":
+		if len(args) < 2 {
+			break
+		}
+		_, spec, ok := e.lookupSpec(args[1])
+		if !ok {
+			return handler.NewErrReply("ERR Invalid command specified")
+		}
+		if !spec.checkArity(len(args) - 1) {
+			return handler.NewErrReply("ERR Invalid number of arguments specified for command")
+		}
+		keys := spec.keys(args[2:])
+		if len(keys) == 0 {
+			return handler.NewErrReply("ERR The command has no key arguments")
+		}
+		replies := make([]handler.Reply, 0, len(keys))
+		for _, key := range keys {
+			replies = append(replies, bulkString(key))
+		}
+		return handler.NewArrayReply(replies)
+
+	case "list":
+		return e.commandList(args[1:])
+	}
+
+	return handler.NewErrReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[0]) + "'. Try COMMAND HELP.")
+}
+
+// 全部指令的 COMMAND INFO 回复
+func (e *DBExecutor) commandInfos() handler.Reply {
+	replies := make([]handler.Reply, 0, len(e.cmdSpecs))
+	for _, name := range e.sortedCmds() {
+		replies = append(replies, e.cmdSpecs[name].info(name))
+	}
+	return handler.NewArrayReply(replies)
+}
+
+func (e *DBExecutor) commandList(args [][]byte) handler.Reply {
+	filter := func(name CmdType, spec *CmdSpec) bool { return true }
+	switch {
+	case len(args) == 0:
+	case len(args) == 3 && strings.EqualFold(string(args[0]), "filterby"):
+		value := string(args[2])
+		switch strings.ToLower(string(args[1])) {
+		case "module":
+			filter = func(name CmdType, spec *CmdSpec) bool {
+				return spec.module == value
+			}
+		case "aclcat":
+			filter = func(name CmdType, spec *CmdSpec) bool {
+				for _, category := range spec.categories() {
+					if strings.EqualFold(category[1:], value) {
+						return true
+					}
+				}
+				return false
+			}
+		case "pattern":
+			filter = func(name CmdType, spec *CmdSpec) bool {
+				return lib.GlobMatch(strings.ToLower(value), name.String())
+			}
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	default:
+		return handler.NewSyntaxErrReply()
+	}
+
+	replies := []handler.Reply{}
+	for _, name := range e.sortedCmds() {
+		if filter(name, e.cmdSpecs[name]) {
+			replies = append(replies, bulkString(name.String()))
+		}
+	}
+	return handler.NewArrayReply(replies)
+}
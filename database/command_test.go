@@ -0,0 +1,139 @@
+package database_test
+
+import (
+	"context"
+	"goredis/database"
+	"goredis/handler"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_command_arity(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	cases := [][]string{
+		{"get"},
+		{"get", "a", "b"},
+		{"set", "a"},
+		{"sintercard"},
+		{"zrange", "a", "0"},
+		{"xread", "streams", "a"},
+		{"eval", "return 1"},
+	}
+	for _, c := range cases {
+		reply := db.Do(ctx, cmdLine(c...))
+		assert.Equal(t, "-ERR wrong number of arguments for '"+c[0]+"' command\r\n", string(reply.ToBytes()), c)
+	}
+	assert.NotContains(t, string(db.Do(ctx, cmdLine("set", "a", "1")).ToBytes()), "wrong number")
+}
+
+func Test_command_info(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	count := db.Do(ctx, cmdLine("command", "count")).(*handler.IntReply).Code
+	all := db.Do(ctx, cmdLine("command")).(*handler.ArrayReply).Replies()
+	assert.Equal(t, int(count), len(all))
+
+	infos := db.Do(ctx, cmdLine("command", "info", "GET", "mset", "nosuchcmd")).(*handler.ArrayReply).Replies()
+	assert.Len(t, infos, 3)
+	get := infos[0].(*handler.ArrayReply).Replies()
+	assert.Equal(t, "$3\r\nget\r\n", string(get[0].ToBytes()))
+	assert.Equal(t, ":2\r\n", string(get[1].ToBytes()))
+	assert.Equal(t, "~2\r\n+readonly\r\n+fast\r\n", string(handler.EncodeReply(get[2], handler.Resp3)))
+	assert.Equal(t, ":1\r\n:1\r\n:1\r\n", string(get[3].ToBytes())+string(get[4].ToBytes())+string(get[5].ToBytes()))
+	assert.Equal(t, "~3\r\n+@read\r\n+@fast\r\n+@string\r\n", string(handler.EncodeReply(get[6], handler.Resp3)))
+	mset := infos[1].(*handler.ArrayReply).Replies()
+	assert.Equal(t, ":1\r\n:-1\r\n:2\r\n", string(mset[3].ToBytes())+string(mset[4].ToBytes())+string(mset[5].ToBytes()))
+	assert.Equal(t, "*-1\r\n", string(infos[2].ToBytes()))
+
+	docs := db.Do(ctx, cmdLine("command", "docs", "zadd"))
+	assert.Equal(t, "%1\r\n$4\r\nzadd\r\n%1\r\n$5\r\ngroup\r\n$10\r\nsorted-set\r\n", string(handler.EncodeReply(docs, handler.Resp3)))
+
+	list := db.Do(ctx, cmdLine("command", "list", "filterby", "pattern", "xread*"))
+	assert.Equal(t, "*2\r\n$5\r\nxread\r\n$10\r\nxreadgroup\r\n", string(list.ToBytes()))
+	list = db.Do(ctx, cmdLine("command", "list", "filterby", "aclcat", "scripting"))
+	assert.Len(t, list.(*handler.ArrayReply).Replies(), 6)
+	assert.Equal(t, "-ERR syntax error\r\n", string(db.Do(ctx, cmdLine("command", "list", "filterby", "x", "y")).ToBytes()))
+}
+
+func Test_command_getkeys(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	cases := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"get", "a"}, "*1\r\n$1\r\na\r\n"},
+		{[]string{"mset", "a", "1", "b", "2"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"bzpopmin", "a", "b", "0"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"eval", "return 1", "2", "a", "b", "arg"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"zunionstore", "dst", "2", "a", "b", "weights", "1", "2"}, "*3\r\n$3\r\ndst\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"xread", "count", "1", "STREAMS", "a", "b", "0", "0"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"eval", "return 1", "0"}, "-ERR The command has no key arguments\r\n"},
+		{[]string{"zunion", "9223372036854775807", "a"}, "-ERR The command has no key arguments\r\n"},
+		{[]string{"get"}, "-ERR Invalid number of arguments specified for command\r\n"},
+		{[]string{"nosuchcmd", "a"}, "-ERR Invalid command specified\r\n"},
+	}
+	for _, c := range cases {
+		reply := db.Do(ctx, cmdLine(append([]string{"command", "getkeys"}, c.args...)...))
+		assert.Equal(t, c.expect, string(reply.ToBytes()), c.args)
+	}
+}
+
+// numkeys 接近 int64 上限时曾在计算 key 时溢出并 panic
+func Test_command_huge_numkeys(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	huge := "9223372036854775807"
+	assert.Empty(t, db.(handler.CmdDescriber).CmdKeys(cmdLine("zunion", huge, "a")))
+	cases := [][]string{
+		{"zunion", huge, "a"},
+		{"zinterstore", "dst", huge, "a"},
+		{"zmpop", huge, "a", "min"},
+		{"sintercard", huge, "a"},
+		{"cms.merge", "dst", huge, "a"},
+		{"eval", "return 1", huge, "a"},
+		{"eval", "return redis.call('zunion', '" + huge + "', 'a')", "0"},
+	}
+	for _, c := range cases {
+		reply := db.Do(ctx, cmdLine(c...))
+		assert.True(t, strings.HasPrefix(string(reply.ToBytes()), "-"), "%v: %s", c, reply.ToBytes())
+	}
+	// 执行器仍在运行
+	assert.Equal(t, "+OK\r\n", string(db.Do(ctx, cmdLine("set", "a", "1")).ToBytes()))
+}
+
+// 执行中 panic 的指令
+type panicModule struct{}
+
+func (panicModule) Name() string {
+	return "panic"
+}
+
+func (panicModule) OnLoad(e *database.DBExecutor) error {
+	return e.RegisterCommand("panic.now", database.CmdSpec{Arity: 1, Flags: []string{database.CmdFlagReadOnly}},
+		func(cmd *database.Command) handler.Reply {
+			panic("boom")
+		})
+}
+
+func Test_command_panic(t *testing.T) {
+	database.RegisterModule(panicModule{})
+	db, _ := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	reply := db.Do(ctx, cmdLine("panic.now"))
+	assert.Equal(t, "-ERR command 'panic.now' panicked: boom\r\n", string(reply.ToBytes()))
+	assert.Equal(t, "+OK\r\n", string(db.Do(ctx, cmdLine("set", "a", "1")).ToBytes()))
+}
@@ -0,0 +1,86 @@
+package database_test
+
+import (
+	"bufio"
+	"context"
+	"goredis/handler"
+	"goredis/log"
+	"goredis/protocol"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 兼容性测试. testdata/compat 下为 redis 的交互记录，以 "> " 开头的行为内联格式的请求，
+// 其后直到下一个请求为 redis 的原始回复，每行末尾的 \r\n 省略. 以 # 开头的行为注释
+func Test_compat_transcripts(t *testing.T) {
+	files, err := filepath.Glob("testdata/compat/*.txt")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			runTranscript(t, file)
+		})
+	}
+}
+
+type transcriptStep struct {
+	line   int
+	req    string
+	expect []string
+}
+
+func loadTranscript(t *testing.T, file string) []*transcriptStep {
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+
+	var steps []*transcriptStep
+	for i, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "> "):
+			steps = append(steps, &transcriptStep{line: i + 1, req: line[2:]})
+		case len(steps) > 0:
+			steps[len(steps)-1].expect = append(steps[len(steps)-1].expect, line)
+		}
+	}
+	return steps
+}
+
+func runTranscript(t *testing.T, file string) {
+	db, _ := newTestTrigger()
+	logger := log.GetDefaultLogger()
+	h, err := handler.NewHandler(db, &fakePersister{}, protocol.NewParser(logger, nil), logger, nil)
+	assert.NoError(t, err)
+	defer h.Close()
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go h.Handle(context.Background(), server)
+
+	reader := bufio.NewReader(client)
+	for _, step := range loadTranscript(t, file) {
+		// 回复行数少于预期时避免一直等待
+		_ = client.SetDeadline(time.Now().Add(time.Second))
+		_, err := client.Write([]byte(step.req + "\r\n"))
+		if !assert.NoError(t, err, "%s:%d", file, step.line) {
+			return
+		}
+
+		got := make([]string, 0, len(step.expect))
+		for range step.expect {
+			line, err := reader.ReadString('\n')
+			if !assert.NoError(t, err, "%s:%d", file, step.line) {
+				return
+			}
+			got = append(got, strings.TrimSuffix(line, "\r\n"))
+		}
+		assert.Equal(t, step.expect, got, "%s:%d %s", file, step.line, step.req)
+	}
+}
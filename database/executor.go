@@ -5,9 +5,19 @@ import (
 	"fmt"
 	"goredis/handler"
 	"goredis/lib/pool"
+	"sync"
 	"time"
+
+	lua "github.com/yuin/gopher-lua"
 )
 
+type Thinker interface {
+	// 脚本执行超过该毫秒数后，其他指令回复 BUSY. 小于等于 0 时不限制
+	LuaTimeLimit() int
+}
+
+const defaultLuaTimeLimit = 5 * time.Second
+
 type DBExecutor struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -16,21 +26,64 @@ type DBExecutor struct {
 	cmdHandlers map[CmdType]CmdHandler
 	dataStore   DataStore
 
+	// 指令表，包含内置指令与模块注册的指令，以及 key 空间事件的订阅者
+	cmdSpecs    map[CmdType]*CmdSpec
+	subscribers []func(event KeyspaceEvent)
+	// 正在加载的模块名
+	loadingModule string
+
+	// 挂起的阻塞指令，key -> 按阻塞先后顺序排列的指令
+	blocking  map[string][]*Command
+	unblockCh chan *Command
+
+	// lua 脚本虚拟机与 sha1 -> 已编译脚本的缓存
+	lua     *lua.LState
+	scripts map[string]*lua.LFunction
+	// 脚本环境的元表，读取时落到只读的全局表，拒绝写入
+	luaEnvMeta *lua.LTable
+	// 正在执行的脚本指令，以及脚本中写入过的 key
+	scriptCmd      *Command
+	scriptKeys     []string
+	scriptReadOnly bool
+	// 正在执行的脚本，其他协程据此回复 BUSY 或终止脚本
+	scriptMu     sync.Mutex
+	scriptRun    *scriptRun
+	luaTimeLimit time.Duration
+
+	// 函数库，库名 -> 库，以及函数名 -> 函数. loadingLib 为正在加载的库
+	libraries  map[string]*functionLibrary
+	functions  map[string]*scriptFunction
+	loadingLib *functionLibrary
+	// 函数库不属于任何 key，由执行器直接持久化
+	persister handler.Persister
+
 	gcTicker *time.Ticker
 }
 
-func NewDBExecutor(dataStore DataStore) Executor {
+func NewDBExecutor(dataStore DataStore, persister handler.Persister, thinker Thinker) Executor {
 	ctx, cancel := context.WithCancel(context.Background())
 	e := DBExecutor{
-		dataStore: dataStore,
-		ch:        make(chan *Command),
-		ctx:       ctx,
-		cancel:    cancel,
-		gcTicker:  time.NewTicker(time.Minute),
+		luaTimeLimit: defaultLuaTimeLimit,
+		dataStore:    dataStore,
+		ch:           make(chan *Command),
+		blocking:     make(map[string][]*Command),
+		unblockCh:    make(chan *Command),
+		scripts:      make(map[string]*lua.LFunction),
+		libraries:    make(map[string]*functionLibrary),
+		functions:    make(map[string]*scriptFunction),
+		persister:    persister,
+		cmdSpecs:     make(map[CmdType]*CmdSpec),
+		ctx:          ctx,
+		cancel:       cancel,
+		gcTicker:     time.NewTicker(time.Minute),
+	}
+	if thinker != nil {
+		e.luaTimeLimit = time.Duration(thinker.LuaTimeLimit()) * time.Millisecond
 	}
 	e.cmdHandlers = map[CmdType]CmdHandler{
 		CmdTypeExpire:   e.dataStore.Expire,
 		CmdTypeExpireAt: e.dataStore.ExpireAt,
+		CmdTypeObject:   e.dataStore.Object,
 
 		// string
 		CmdTypeGet:  e.dataStore.Get,
@@ -46,20 +99,123 @@ func NewDBExecutor(dataStore DataStore) Executor {
 		CmdTypeLRange: e.dataStore.LRange,
 
 		// set
-		CmdTypeSAdd:      e.dataStore.SAdd,
-		CmdTypeSIsMember: e.dataStore.SIsMember,
-		CmdTypeSRem:      e.dataStore.SRem,
+		CmdTypeSAdd:        e.dataStore.SAdd,
+		CmdTypeSIsMember:   e.dataStore.SIsMember,
+		CmdTypeSRem:        e.dataStore.SRem,
+		CmdTypeSMembers:    e.dataStore.SMembers,
+		CmdTypeSCard:       e.dataStore.SCard,
+		CmdTypeSInter:      e.dataStore.SInter,
+		CmdTypeSUnion:      e.dataStore.SUnion,
+		CmdTypeSDiff:       e.dataStore.SDiff,
+		CmdTypeSInterStore: e.dataStore.SInterStore,
+		CmdTypeSUnionStore: e.dataStore.SUnionStore,
+		CmdTypeSDiffStore:  e.dataStore.SDiffStore,
+		CmdTypeSMove:       e.dataStore.SMove,
+		CmdTypeSPop:        e.dataStore.SPop,
+		CmdTypeSRandMember: e.dataStore.SRandMember,
+		CmdTypeSMIsMember:  e.dataStore.SMIsMember,
+		CmdTypeSInterCard:  e.dataStore.SInterCard,
 
 		// hash
-		CmdTypeHSet: e.dataStore.HSet,
-		CmdTypeHGet: e.dataStore.HGet,
-		CmdTypeHDel: e.dataStore.HDel,
+		CmdTypeHSet:    e.dataStore.HSet,
+		CmdTypeHGet:    e.dataStore.HGet,
+		CmdTypeHDel:    e.dataStore.HDel,
+		CmdTypeHGetAll: e.dataStore.HGetAll,
 
 		// sorted set
-		CmdTypeZAdd:          e.dataStore.ZAdd,
-		CmdTypeZRangeByScore: e.dataStore.ZRangeByScore,
-		CmdTypeZRem:          e.dataStore.ZRem,
+		CmdTypeZAdd:             e.dataStore.ZAdd,
+		CmdTypeZIncrBy:          e.dataStore.ZIncrBy,
+		CmdTypeZRange:           e.dataStore.ZRange,
+		CmdTypeZRevRange:        e.dataStore.ZRevRange,
+		CmdTypeZRangeByScore:    e.dataStore.ZRangeByScore,
+		CmdTypeZRevRangeByScore: e.dataStore.ZRevRangeByScore,
+		CmdTypeZRangeByLex:      e.dataStore.ZRangeByLex,
+		CmdTypeZRevRangeByLex:   e.dataStore.ZRevRangeByLex,
+		CmdTypeZRank:            e.dataStore.ZRank,
+		CmdTypeZRevRank:         e.dataStore.ZRevRank,
+		CmdTypeZScore:           e.dataStore.ZScore,
+		CmdTypeZMScore:          e.dataStore.ZMScore,
+		CmdTypeZCard:            e.dataStore.ZCard,
+		CmdTypeZCount:           e.dataStore.ZCount,
+		CmdTypeZLexCount:        e.dataStore.ZLexCount,
+		CmdTypeZPopMin:          e.dataStore.ZPopMin,
+		CmdTypeZPopMax:          e.dataStore.ZPopMax,
+		CmdTypeBZPopMin:         e.dataStore.BZPopMin,
+		CmdTypeBZPopMax:         e.dataStore.BZPopMax,
+		CmdTypeZMPop:            e.dataStore.ZMPop,
+		CmdTypeBZMPop:           e.dataStore.BZMPop,
+		CmdTypeZRem:             e.dataStore.ZRem,
+		CmdTypeZRemRangeByRank:  e.dataStore.ZRemRangeByRank,
+		CmdTypeZRemRangeByScore: e.dataStore.ZRemRangeByScore,
+		CmdTypeZRemRangeByLex:   e.dataStore.ZRemRangeByLex,
+		CmdTypeZUnion:           e.dataStore.ZUnion,
+		CmdTypeZInter:           e.dataStore.ZInter,
+		CmdTypeZDiff:            e.dataStore.ZDiff,
+		CmdTypeZUnionStore:      e.dataStore.ZUnionStore,
+		CmdTypeZInterStore:      e.dataStore.ZInterStore,
+		CmdTypeZDiffStore:       e.dataStore.ZDiffStore,
+		CmdTypeZRangeStore:      e.dataStore.ZRangeStore,
+
+		CmdTypeXAdd:      e.dataStore.XAdd,
+		CmdTypeXRange:    e.dataStore.XRange,
+		CmdTypeXRevRange: e.dataStore.XRevRange,
+		CmdTypeXLen:      e.dataStore.XLen,
+		CmdTypeXTrim:     e.dataStore.XTrim,
+		CmdTypeXDel:      e.dataStore.XDel,
+		CmdTypeXRead:     e.dataStore.XRead,
+		CmdTypeXSetID:    e.dataStore.XSetID,
+
+		CmdTypeXGroup:     e.dataStore.XGroup,
+		CmdTypeXReadGroup: e.dataStore.XReadGroup,
+		CmdTypeXAck:       e.dataStore.XAck,
+		CmdTypeXPending:   e.dataStore.XPending,
+		CmdTypeXClaim:     e.dataStore.XClaim,
+		CmdTypeXAutoClaim: e.dataStore.XAutoClaim,
+		CmdTypeXInfo:      e.dataStore.XInfo,
+
+		CmdTypeJSONSet:       e.dataStore.JSONSet,
+		CmdTypeJSONGet:       e.dataStore.JSONGet,
+		CmdTypeJSONDel:       e.dataStore.JSONDel,
+		CmdTypeJSONNumIncrBy: e.dataStore.JSONNumIncrBy,
+		CmdTypeJSONArrAppend: e.dataStore.JSONArrAppend,
+
+		CmdTypeBFReserve:     e.dataStore.BFReserve,
+		CmdTypeBFAdd:         e.dataStore.BFAdd,
+		CmdTypeBFMAdd:        e.dataStore.BFMAdd,
+		CmdTypeBFExists:      e.dataStore.BFExists,
+		CmdTypeBFMExists:     e.dataStore.BFMExists,
+		CmdTypeBFLoadChunk:   e.dataStore.BFLoadChunk,
+		CmdTypeCMSInitByDim:  e.dataStore.CMSInitByDim,
+		CmdTypeCMSInitByProb: e.dataStore.CMSInitByProb,
+		CmdTypeCMSIncrBy:     e.dataStore.CMSIncrBy,
+		CmdTypeCMSQuery:      e.dataStore.CMSQuery,
+		CmdTypeCMSMerge:      e.dataStore.CMSMerge,
+		CmdTypeCMSLoadChunk:  e.dataStore.CMSLoadChunk,
+
+		CmdTypeTSCreate:   e.dataStore.TSCreate,
+		CmdTypeTSAdd:      e.dataStore.TSAdd,
+		CmdTypeTSRange:    e.dataStore.TSRange,
+		CmdTypeTSRevRange: e.dataStore.TSRevRange,
+		CmdTypeTSMRange:   e.dataStore.TSMRange,
+
+		CmdTypeFTCreate:    e.dataStore.FTCreate,
+		CmdTypeFTSearch:    e.dataStore.FTSearch,
+		CmdTypeFTDropIndex: e.dataStore.FTDropIndex,
+
+		CmdTypeEval:    e.eval,
+		CmdTypeEvalSha: e.evalSha,
+		CmdTypeScript:  e.script,
+
+		CmdTypeFunction: e.function,
+		CmdTypeFCall:    e.fcall,
+		CmdTypeFCallRO:  e.fcallRO,
+
+		CmdTypeCommand: e.command,
+	}
+	for cmd, spec := range cmdTable {
+		e.cmdSpecs[cmd] = spec
 	}
+	e.loadModules()
 
 	pool.Submit(e.run)
 	return &e
@@ -82,16 +238,61 @@ func (e *DBExecutor) run() {
 	for {
 		select {
 		case <-e.ctx.Done():
+			if e.lua != nil {
+				e.lua.Close()
+			}
 			return
 		case <-e.gcTicker.C:
 			e.dataStore.GC()
+		case cmd := <-e.unblockCh:
+			e.unblock(cmd)
 		case cmd := <-e.ch:
-			cmdFunc, ok := e.cmdHandlers[cmd.cmd]
-			if !ok {
-				cmd.receiver <- handler.NewErrReply(fmt.Sprintf("unknown command '%s'", cmd.cmd))				
+			e.exec(cmd)
+		}
+	}
+}
+
+func (e *DBExecutor) exec(cmd *Command) {
+	// 单条指令出错不能导致执行器协程退出，否则之后的指令都会一直等待
+	defer func() {
+		if err := recover(); err != nil {
+			// 回复可能已经发出，不能阻塞执行器
+			select {
+			case cmd.receiver <- handler.NewErrReply(fmt.Sprintf("ERR command '%s' panicked: %v", cmd.cmd, err)):
+			default:
 			}
-			e.dataStore.ExpirePreprocess(string(cmd.args[0]))
-			cmd.receiver <- cmdFunc(cmd)
 		}
+	}()
+
+	cmdFunc, ok := e.cmdHandlers[cmd.cmd]
+	if !ok {
+		cmd.receiver <- handler.NewUnknownCmdErrReply(cmd.Cmd())
+		return
+	}
+
+	if reply := e.checkArity(cmd); reply != nil {
+		cmd.receiver <- reply
+		return
+	}
+
+	keys := e.cmdKeys(cmd)
+	for _, key := range keys {
+		e.dataStore.ExpirePreprocess(key)
+	}
+	reply := cmdFunc(cmd)
+	if blocked, ok := reply.(*BlockedReply); ok && !handler.IsLoadingPattern(cmd.ctx) {
+		e.block(cmd, blocked)
+		cmd.receiver <- reply
+		return
+	}
+	e.notifyKeyspace(cmd, reply)
+	cmd.receiver <- reply
+
+	// 唤醒阻塞在指令访问的 key 上的指令
+	for _, key := range keys {
+		if len(e.blocking) == 0 {
+			break
+		}
+		e.serveBlocked(key)
 	}
 }
@@ -0,0 +1,475 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"goredis/handler"
+	"hash/crc32"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	functionEngineLua    = "LUA"
+	functionFlagNoWrites = "no-writes"
+	// 与 redis 一致，库的顶层代码只用于注册函数，执行超过该时间视为出错
+	functionLoadTimeout = 500 * time.Millisecond
+)
+
+var (
+	errFunctionNotFound   = errors.New("ERR Function not found")
+	errLibraryNotFound    = errors.New("ERR Library not found")
+	errFunctionNoRegister = errors.New("ERR No functions registered")
+	errFunctionMetadata   = errors.New("ERR Missing library metadata")
+	errFunctionPayload    = errors.New("ERR payload version or checksum are wrong")
+	errFunctionWriteFlag  = errors.New("ERR Can not execute a script with write flag using *_ro command.")
+	errFunctionTimeout    = errors.New("ERR Error registering functions: FUNCTION LOAD timeout")
+)
+
+type scriptFunction struct {
+	name     string
+	library  string
+	fn       *lua.LFunction
+	flags    []string
+	readOnly bool
+}
+
+type functionLibrary struct {
+	name string
+	// 库的源码，以 #!lua name=<库名> 开头
+	code      string
+	functions map[string]*scriptFunction
+}
+
+// 解析首行的 #!<engine> name=<库名>
+func parseLibraryMetadata(code string) (string, error) {
+	header, _, _ := strings.Cut(code, "\n")
+	if !strings.HasPrefix(header, "#!") {
+		return "", errFunctionMetadata
+	}
+
+	fields := strings.Fields(header[2:])
+	if len(fields) == 0 {
+		return "", errFunctionMetadata
+	}
+	if engine := strings.ToUpper(fields[0]); engine != functionEngineLua {
+		return "", fmt.Errorf("ERR Engine '%s' not found", fields[0])
+	}
+
+	var name string
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key != "name" {
+			return "", fmt.Errorf("ERR Invalid metadata value given: %s", field)
+		}
+		name = value
+	}
+	if name == "" {
+		return "", errors.New("ERR Library name was not given")
+	}
+	return name, nil
+}
+
+// 编译并执行库的源码，收集其中通过 redis.register_function 注册的函数
+func (e *DBExecutor) compileLibrary(code string) (*functionLibrary, error) {
+	name, err := parseLibraryMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+
+	// 首行元数据转为注释，保持行号不变
+	L := e.luaVM()
+	fn, err := L.Load(strings.NewReader("--"+code), name)
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %s", err.Error())
+	}
+
+	// 每个库使用独立的全局环境，其中注册的函数共享该环境
+	fn.Env = e.luaScriptEnv(nil)
+
+	lib := functionLibrary{
+		name:      name,
+		code:      code,
+		functions: make(map[string]*scriptFunction),
+	}
+	e.loadingLib = &lib
+	defer func() {
+		e.loadingLib = nil
+	}()
+
+	// 加载期间不能被 FUNCTION KILL 终止，超时后直接中断
+	ctx, cancel := context.WithTimeout(e.ctx, functionLoadTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(fn)
+	if err := L.PCall(0, 0, nil); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errFunctionTimeout
+		}
+		if msg, ok := luaErrMsg(err); ok {
+			return nil, errors.New(msg)
+		}
+		return nil, fmt.Errorf("ERR Error registering functions: %s", luaErrText(err))
+	}
+	if len(lib.functions) == 0 {
+		return nil, errFunctionNoRegister
+	}
+	return &lib, nil
+}
+
+// redis.register_function(name, callback) 或 redis.register_function{function_name=, callback=, flags=}
+func (e *DBExecutor) luaRegisterFunction(L *lua.LState) int {
+	if e.loadingLib == nil {
+		L.Error(luaStatusTable(L, "err", "ERR redis.register_function can only be called on FUNCTION LOAD command"), 1)
+		return 0
+	}
+
+	var (
+		name     lua.LValue
+		callback lua.LValue
+		flags    lua.LValue = lua.LNil
+	)
+	if table, ok := L.Get(1).(*lua.LTable); ok && L.GetTop() == 1 {
+		name, callback, flags = table.RawGetString("function_name"), table.RawGetString("callback"), table.RawGetString("flags")
+	} else {
+		name, callback = L.Get(1), L.Get(2)
+	}
+
+	nameStr, ok := name.(lua.LString)
+	if !ok || nameStr == "" {
+		L.Error(luaStatusTable(L, "err", "ERR Function name must be a non empty string"), 1)
+		return 0
+	}
+	fn, ok := callback.(*lua.LFunction)
+	if !ok {
+		L.Error(luaStatusTable(L, "err", "ERR callback must be a function"), 1)
+		return 0
+	}
+	if _, ok := e.loadingLib.functions[string(nameStr)]; ok {
+		L.Error(luaStatusTable(L, "err", fmt.Sprintf("ERR Function %s already exists", nameStr)), 1)
+		return 0
+	}
+
+	function := scriptFunction{
+		name:    string(nameStr),
+		library: e.loadingLib.name,
+		fn:      fn,
+		flags:   []string{},
+	}
+	if table, ok := flags.(*lua.LTable); ok {
+		for i := 1; i <= table.Len(); i++ {
+			flag := table.RawGetInt(i).String()
+			if flag == functionFlagNoWrites {
+				function.readOnly = true
+			}
+			function.flags = append(function.flags, flag)
+		}
+	} else if flags != lua.LNil {
+		L.Error(luaStatusTable(L, "err", "ERR flags argument to redis.register_function must be a table representing function flags"), 1)
+		return 0
+	}
+
+	e.loadingLib.functions[function.name] = &function
+	return 0
+}
+
+// 以新的函数库集合重建函数索引，不同库中的函数不允许重名
+func indexFunctions(libraries map[string]*functionLibrary) (map[string]*scriptFunction, error) {
+	functions := make(map[string]*scriptFunction)
+	for _, lib := range libraries {
+		for name, function := range lib.functions {
+			if _, ok := functions[name]; ok {
+				return nil, fmt.Errorf("ERR Function %s already exists", name)
+			}
+			functions[name] = function
+		}
+	}
+	return functions, nil
+}
+
+func (e *DBExecutor) copyLibraries() map[string]*functionLibrary {
+	libraries := make(map[string]*functionLibrary, len(e.libraries))
+	for name, lib := range e.libraries {
+		libraries[name] = lib
+	}
+	return libraries
+}
+
+// 校验通过后整体替换函数库
+func (e *DBExecutor) commitLibraries(libraries map[string]*functionLibrary) error {
+	functions, err := indexFunctions(libraries)
+	if err != nil {
+		return err
+	}
+	e.libraries, e.functions = libraries, functions
+	return nil
+}
+
+func (e *DBExecutor) sortedLibraries() []*functionLibrary {
+	libraries := make([]*functionLibrary, 0, len(e.libraries))
+	for _, lib := range e.libraries {
+		libraries = append(libraries, lib)
+	}
+	sort.Slice(libraries, func(i, j int) bool {
+		return libraries[i].name < libraries[j].name
+	})
+	return libraries
+}
+
+// 函数库不属于任何 key，aof 重写时以 function load 指令先于其他数据还原
+func (e *DBExecutor) MetaCmds() [][][]byte {
+	libraries := e.sortedLibraries()
+	cmds := make([][][]byte, 0, len(libraries))
+	for _, lib := range libraries {
+		cmds = append(cmds, [][]byte{[]byte(CmdTypeFunction), []byte("load"), []byte(lib.code)})
+	}
+	return cmds
+}
+
+// dump 格式: 依次排列的 uvarint 长度 + 库源码，末尾 4 字节 crc32 校验
+func (e *DBExecutor) dumpLibraries() []byte {
+	var buf bytes.Buffer
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, lib := range e.sortedLibraries() {
+		n := binary.PutUvarint(lenBuf, uint64(len(lib.code)))
+		buf.Write(lenBuf[:n])
+		buf.WriteString(lib.code)
+	}
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+func parseLibrariesDump(payload []byte) ([]string, error) {
+	if len(payload) < 4 {
+		return nil, errFunctionPayload
+	}
+	body := payload[:len(payload)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(payload[len(payload)-4:]) {
+		return nil, errFunctionPayload
+	}
+
+	codes := []string{}
+	reader := bytes.NewReader(body)
+	for reader.Len() > 0 {
+		size, err := binary.ReadUvarint(reader)
+		if err != nil || size > uint64(reader.Len()) {
+			return nil, errFunctionPayload
+		}
+		code := make([]byte, size)
+		_, _ = reader.Read(code)
+		codes = append(codes, string(code))
+	}
+	return codes, nil
+}
+
+// FUNCTION LOAD [REPLACE] code | DELETE library | LIST [LIBRARYNAME pattern] [WITHCODE]
+// | DUMP | RESTORE payload [FLUSH|APPEND|REPLACE] | FLUSH [ASYNC|SYNC] | KILL
+func (e *DBExecutor) function(cmd *Command) handler.Reply {
+	args := cmd.args
+	switch subCmd := strings.ToLower(string(args[0])); subCmd {
+	case "load":
+		return e.functionLoad(cmd)
+	case "delete":
+		if len(args) != 2 {
+			return handler.NewSyntaxErrReply()
+		}
+		libraries := e.copyLibraries()
+		if _, ok := libraries[string(args[1])]; !ok {
+			return handler.NewErrReply(errLibraryNotFound.Error())
+		}
+		delete(libraries, string(args[1]))
+		_ = e.commitLibraries(libraries)
+		e.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+		return handler.NewOKReply()
+	case "list":
+		return e.functionList(cmd)
+	case "dump":
+		if len(args) != 1 {
+			return handler.NewSyntaxErrReply()
+		}
+		return handler.NewBulkReply(e.dumpLibraries())
+	case "restore":
+		return e.functionRestore(cmd)
+	case "flush":
+		if len(args) > 2 {
+			return handler.NewSyntaxErrReply()
+		}
+		_ = e.commitLibraries(make(map[string]*functionLibrary))
+		e.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+		return handler.NewOKReply()
+	case "kill":
+		// 与 SCRIPT KILL 相同，函数执行期间由 DBTrigger 直接处理
+		if len(args) != 1 {
+			return handler.NewSyntaxErrReply()
+		}
+		return handler.NewErrReply(errScriptNotBusy)
+	default:
+		return handler.NewUnknownSubCmdErrReply("function", args[0])
+	}
+}
+
+func (e *DBExecutor) functionLoad(cmd *Command) handler.Reply {
+	args := cmd.args[1:]
+	var replace bool
+	if len(args) == 2 && strings.ToLower(string(args[0])) == "replace" {
+		replace = true
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	lib, err := e.compileLibrary(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	libraries := e.copyLibraries()
+	if _, ok := libraries[lib.name]; ok && !replace {
+		return handler.NewErrReply(fmt.Sprintf("ERR Library '%s' already exists", lib.name))
+	}
+	libraries[lib.name] = lib
+	if err := e.commitLibraries(libraries); err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	e.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewBulkReply([]byte(lib.name))
+}
+
+func (e *DBExecutor) functionList(cmd *Command) handler.Reply {
+	var (
+		pattern  string
+		withCode bool
+	)
+	args := cmd.args[1:]
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "withcode":
+			withCode = true
+		case "libraryname":
+			if i+1 >= len(args) {
+				return handler.NewSyntaxErrReply()
+			}
+			pattern = string(args[i+1])
+			i++
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	res := []handler.Reply{}
+	for _, lib := range e.sortedLibraries() {
+		if pattern != "" {
+			if matched, _ := path.Match(pattern, lib.name); !matched {
+				continue
+			}
+		}
+
+		names := make([]string, 0, len(lib.functions))
+		for name := range lib.functions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		functions := make([]handler.Reply, 0, len(names))
+		for _, name := range names {
+			flags := make([][]byte, 0, len(lib.functions[name].flags))
+			for _, flag := range lib.functions[name].flags {
+				flags = append(flags, []byte(flag))
+			}
+			functions = append(functions, handler.NewArrayReply([]handler.Reply{
+				handler.NewBulkReply([]byte("name")), handler.NewBulkReply([]byte(name)),
+				handler.NewBulkReply([]byte("description")), handler.NewNillReply(),
+				handler.NewBulkReply([]byte("flags")), handler.NewMultiBulkReply(flags),
+			}))
+		}
+
+		item := []handler.Reply{
+			handler.NewBulkReply([]byte("library_name")), handler.NewBulkReply([]byte(lib.name)),
+			handler.NewBulkReply([]byte("engine")), handler.NewBulkReply([]byte(functionEngineLua)),
+			handler.NewBulkReply([]byte("functions")), handler.NewArrayReply(functions),
+		}
+		if withCode {
+			item = append(item, handler.NewBulkReply([]byte("library_code")), handler.NewBulkReply([]byte(lib.code)))
+		}
+		res = append(res, handler.NewArrayReply(item))
+	}
+	return handler.NewArrayReply(res)
+}
+
+func (e *DBExecutor) functionRestore(cmd *Command) handler.Reply {
+	args := cmd.args[1:]
+	if len(args) < 1 || len(args) > 2 {
+		return handler.NewSyntaxErrReply()
+	}
+	policy := "append"
+	if len(args) == 2 {
+		policy = strings.ToLower(string(args[1]))
+		if policy != "append" && policy != "replace" && policy != "flush" {
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	codes, err := parseLibrariesDump(args[0])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	libraries := e.copyLibraries()
+	if policy == "flush" {
+		libraries = make(map[string]*functionLibrary)
+	}
+	for _, code := range codes {
+		lib, err := e.compileLibrary(code)
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		if _, ok := libraries[lib.name]; ok && policy == "append" {
+			return handler.NewErrReply(fmt.Sprintf("ERR Library %s already exists", lib.name))
+		}
+		libraries[lib.name] = lib
+	}
+	if err := e.commitLibraries(libraries); err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	e.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+// FCALL function numkeys [key ...] [arg ...]. 函数以 keys, args 两个 table 作为参数
+func (e *DBExecutor) callFunction(cmd *Command, readOnly bool) handler.Reply {
+	if len(cmd.args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	function, ok := e.functions[string(cmd.args[0])]
+	if !ok {
+		return handler.NewErrReply(errFunctionNotFound.Error())
+	}
+	if readOnly && !function.readOnly {
+		return handler.NewErrReply(errFunctionWriteFlag.Error())
+	}
+
+	keys, argv, errReply := parseScriptArgs(e.luaVM(), cmd.args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	return e.callScript(cmd, function.readOnly, function.fn, keys, argv)
+}
+
+func (e *DBExecutor) fcall(cmd *Command) handler.Reply {
+	return e.callFunction(cmd, false)
+}
+
+func (e *DBExecutor) fcallRO(cmd *Command) handler.Reply {
+	return e.callFunction(cmd, true)
+}
@@ -0,0 +1,131 @@
+package database_test
+
+import (
+	"context"
+	"goredis/database"
+	"goredis/datastore"
+	"goredis/handler"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testLibrary = `#!lua name=mylib
+redis.register_function('setget', function(keys, args)
+	redis.call('set', keys[1], args[1])
+	return redis.call('get', keys[1])
+end)
+redis.register_function{function_name='peek', callback=function(keys) return redis.call('get', keys[1]) end, flags={'no-writes'}}
+redis.register_function{function_name='sneak', callback=function(keys) return redis.call('set', keys[1], 'x') end, flags={'no-writes'}}`
+
+func Test_function_load_and_call(t *testing.T) {
+	db, persister := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	reply := db.Do(ctx, cmdLine("function", "load", testLibrary))
+	assert.Equal(t, "$5\r\nmylib\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("function", "load", testLibrary))
+	assert.Equal(t, "-ERR Library 'mylib' already exists\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("function", "load", "replace", testLibrary))
+	assert.Equal(t, "$5\r\nmylib\r\n", string(reply.ToBytes()))
+
+	cases := []struct {
+		cmd    []string
+		expect string
+	}{
+		{[]string{"fcall", "setget", "1", "a", "v"}, "$1\r\nv\r\n"},
+		{[]string{"fcall_ro", "peek", "1", "a"}, "$1\r\nv\r\n"},
+		{[]string{"fcall_ro", "setget", "1", "a", "v"}, "-ERR Can not execute a script with write flag using *_ro command.\r\n"},
+		{[]string{"fcall", "sneak", "1", "a"}, "-ERR Write commands are not allowed from read-only scripts.\r\n"},
+		{[]string{"fcall", "nosuch", "0"}, "-ERR Function not found\r\n"},
+		{[]string{"function", "load", "return 1"}, "-ERR Missing library metadata\r\n"},
+		{[]string{"function", "load", "#!js name=x\nreturn 1"}, "-ERR Engine 'js' not found\r\n"},
+		{[]string{"function", "load", "#!lua name=empty\nreturn 1"}, "-ERR No functions registered\r\n"},
+		{[]string{"function", "load", "#!lua name=other\nredis.register_function('peek', function() return 1 end)"}, "-ERR Function peek already exists\r\n"},
+		{[]string{"function", "load", "#!lua name=bad\nredis.call('set', 'a', 1)"}, "-ERR redis.call can not be called on FUNCTION LOAD command\r\n"},
+		{[]string{"eval", "redis.register_function('f', function() end)", "0"}, "-ERR redis.register_function can only be called on FUNCTION LOAD command\r\n"},
+		{[]string{"function", "delete", "nosuch"}, "-ERR Library not found\r\n"},
+	}
+	for _, c := range cases {
+		reply = db.Do(ctx, cmdLine(c.cmd...))
+		assert.Equal(t, c.expect, string(reply.ToBytes()), c.cmd)
+	}
+
+	reply = db.Do(ctx, cmdLine("function", "list", "libraryname", "my*"))
+	assert.Equal(t, "*1\r\n*6\r\n$12\r\nlibrary_name\r\n$5\r\nmylib\r\n$6\r\nengine\r\n$3\r\nLUA\r\n$9\r\nfunctions\r\n*3\r\n"+
+		"*6\r\n$4\r\nname\r\n$4\r\npeek\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n"+
+		"*6\r\n$4\r\nname\r\n$6\r\nsetget\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*0\r\n"+
+		"*6\r\n$4\r\nname\r\n$5\r\nsneak\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("function", "list", "libraryname", "x*"))
+	assert.Equal(t, "*0\r\n", string(reply.ToBytes()))
+
+	reply = db.Do(ctx, cmdLine("function", "delete", "mylib"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("fcall", "setget", "1", "a", "v"))
+	assert.Equal(t, "-ERR Function not found\r\n", string(reply.ToBytes()))
+
+	persister.mu.Lock()
+	assert.Equal(t, [][][]byte{
+		cmdLine("function", "load", testLibrary),
+		cmdLine("function", "load", "replace", testLibrary),
+		cmdLine("set", "a", "v"),
+		cmdLine("function", "delete", "mylib"),
+	}, persister.cmds)
+	persister.mu.Unlock()
+}
+
+func Test_function_load_timeout(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	// 库的顶层代码执行超时后中断，不影响之后的指令
+	reply := db.Do(ctx, cmdLine("function", "load", "#!lua name=spin\nwhile true do end"))
+	assert.Equal(t, "-ERR Error registering functions: FUNCTION LOAD timeout\r\n", string(reply.ToBytes()))
+	assert.Equal(t, "*0\r\n", string(db.Do(ctx, cmdLine("function", "list")).ToBytes()))
+	reply = db.Do(ctx, cmdLine("function", "load", "#!lua name=ok\nredis.register_function('f', function() while true do end end)"))
+	assert.Equal(t, "$2\r\nok\r\n", string(reply.ToBytes()))
+}
+
+func Test_function_dump_restore(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	db.Do(ctx, cmdLine("function", "load", testLibrary))
+	payload := db.Do(ctx, cmdLine("function", "dump")).(*handler.BulkReply).Arg
+
+	reply := db.Do(ctx, cmdLine("function", "restore", string(payload)))
+	assert.Equal(t, "-ERR Library mylib already exists\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("function", "restore", string(payload[1:])))
+	assert.Equal(t, "-ERR payload version or checksum are wrong\r\n", string(reply.ToBytes()))
+
+	reply = db.Do(ctx, cmdLine("function", "flush"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("fcall", "setget", "1", "a", "v"))
+	assert.Equal(t, "-ERR Function not found\r\n", string(reply.ToBytes()))
+
+	reply = db.Do(ctx, cmdLine("function", "restore", string(payload), "replace"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("fcall", "setget", "1", "a", "v"))
+	assert.Equal(t, "$1\r\nv\r\n", string(reply.ToBytes()))
+}
+
+func Test_function_replay(t *testing.T) {
+	persister := &fakePersister{}
+	kvStore := datastore.NewKVStore(persister, nil)
+	executor := database.NewDBExecutor(kvStore, persister, nil)
+	db := database.NewDBTrigger(executor)
+	defer db.Close()
+
+	// 重放持久化的指令后函数库可用
+	ctx := handler.SetLoadingPattern(context.Background())
+	reply := db.Do(ctx, cmdLine("function", "load", testLibrary))
+	assert.Equal(t, "$5\r\nmylib\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("fcall", "setget", "1", "a", "v"))
+	assert.Equal(t, "$1\r\nv\r\n", string(reply.ToBytes()))
+
+	// aof 重写时函数库以 function load 指令写入
+	assert.Equal(t, [][][]byte{cmdLine("function", "load", testLibrary)}, executor.(database.MetaCmdAdapter).MetaCmds())
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"fmt"
+	"goredis/handler"
+	"sync"
+)
+
+// 指令标识
+const (
+	CmdFlagWrite       = "write"
+	CmdFlagReadOnly    = "readonly"
+	CmdFlagAdmin       = "admin"
+	CmdFlagDenyOOM     = "denyoom"
+	CmdFlagFast        = "fast"
+	CmdFlagBlocking    = "blocking"
+	CmdFlagNoScript    = "noscript"
+	CmdFlagLoading     = "loading"
+	CmdFlagStale       = "stale"
+	CmdFlagMovableKeys = "movablekeys"
+	// 本身不带 write 标识，但可能写入数据，如脚本
+	CmdFlagMayReplicate = "may_replicate"
+)
+
+// 指令的参数个数与 key 位置声明，格式与 redis COMMAND 一致.
+// Arity 包含指令名，负数代表至少 -Arity 个参数;
+// FirstKey, LastKey 为 key 参数的位置，从 1 开始，LastKey 为负数时从末尾倒数，FirstKey 为 0 代表不含 key;
+// Group 为 COMMAND DOCS 中的指令组，模块指令为空时记为 module
+type CmdSpec struct {
+	Arity    int
+	Flags    []string
+	FirstKey int
+	LastKey  int
+	Step     int
+	Group    string
+
+	// 位置由参数决定的 key，如 EVAL 的 numkeys 之后的参数
+	movableKeys func(args [][]byte) []string
+	// 注册指令的模块名
+	module string
+}
+
+func (c *CmdSpec) hasFlag(flag string) bool {
+	for _, f := range c.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CmdSpec) checkArity(argc int) bool {
+	if c.Arity >= 0 {
+		return argc == c.Arity
+	}
+	return argc >= -c.Arity
+}
+
+// 按声明从参数中取出 key，args 不含指令名
+func (c *CmdSpec) keys(args [][]byte) []string {
+	keys := []string{}
+	if c.FirstKey > 0 {
+		last := c.LastKey
+		if last < 0 {
+			last += len(args) + 1
+		}
+		step := c.Step
+		if step <= 0 {
+			step = 1
+		}
+		for i := c.FirstKey; i <= last && i <= len(args); i += step {
+			keys = append(keys, string(args[i-1]))
+		}
+	}
+	if c.movableKeys != nil {
+		keys = append(keys, c.movableKeys(args)...)
+	}
+	return keys
+}
+
+// 写指令执行后发出的 key 空间事件
+type KeyspaceEvent struct {
+	Cmd CmdType
+	Key string
+}
+
+// 模块在执行器创建时加载，通过执行器注册指令、订阅 key 空间事件
+type Module interface {
+	Name() string
+	OnLoad(e *DBExecutor) error
+}
+
+var (
+	modulesMu sync.Mutex
+	modules   []Module
+)
+
+// 注册模块，之后创建的执行器(包括 aof 重写时使用的执行器)都会加载该模块. 需在服务启动前调用，通常位于模块的 init 中
+func RegisterModule(module Module) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	modules = append(modules, module)
+}
+
+// 模块加载失败属于编码错误，直接 panic
+func (e *DBExecutor) loadModules() {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	for _, module := range modules {
+		e.loadingModule = module.Name()
+		if err := module.OnLoad(e); err != nil {
+			panic(fmt.Sprintf("load module %s failed: %s", module.Name(), err.Error()))
+		}
+	}
+	e.loadingModule = ""
+}
+
+// 注册自定义指令. 只能在模块的 OnLoad 中调用
+func (e *DBExecutor) RegisterCommand(cmd CmdType, spec CmdSpec, cmdHandler CmdHandler) error {
+	if _, ok := e.cmdSpecs[cmd]; ok {
+		return fmt.Errorf("command '%s' already exists", cmd)
+	}
+	if spec.Group == "" {
+		spec.Group = "module"
+	}
+	spec.module = e.loadingModule
+	e.cmdHandlers[cmd] = cmdHandler
+	e.cmdSpecs[cmd] = &spec
+	return nil
+}
+
+// 订阅写指令产生的 key 空间事件. 回调在执行器协程中执行，不能阻塞
+func (e *DBExecutor) SubscribeKeyspace(subscriber func(event KeyspaceEvent)) {
+	e.subscribers = append(e.subscribers, subscriber)
+}
+
+// 自定义类型的数据读写入口
+func (e *DBExecutor) Keyspace() Keyspace {
+	return e.dataStore
+}
+
+// 持久化指令，供自定义指令在写入成功后调用
+func (e *DBExecutor) Persist(cmd *Command) {
+	e.persister.PersistCmd(cmd.Ctx(), cmd.Cmd())
+}
+
+func (e *DBExecutor) hasFlag(cmd CmdType, flag string) bool {
+	spec, ok := e.cmdSpecs[cmd]
+	return ok && spec.hasFlag(flag)
+}
+
+// 指令表在执行器创建后不再变化，可以在其他协程中调用
+func (e *DBExecutor) Spec(cmd CmdType) (*CmdSpec, bool) {
+	spec, ok := e.cmdSpecs[cmd]
+	return spec, ok
+}
+
+// 是否可能写入数据. 指令表在执行器创建后不再变化，可以在其他协程中调用
+func (e *DBExecutor) MayWrite(cmd CmdType) bool {
+	return e.hasFlag(cmd, CmdFlagWrite) || e.hasFlag(cmd, CmdFlagMayReplicate)
+}
+
+func (e *DBExecutor) readOnly(cmd CmdType) bool {
+	return e.hasFlag(cmd, CmdFlagReadOnly)
+}
+
+// 指令表声明的 key. 执行前逐个惰性过期，执行后逐个唤醒阻塞的指令
+func (e *DBExecutor) cmdKeys(cmd *Command) []string {
+	spec, ok := e.cmdSpecs[cmd.cmd]
+	if !ok {
+		return nil
+	}
+	return spec.keys(cmd.args)
+}
+
+// 按指令表校验参数个数
+func (e *DBExecutor) checkArity(cmd *Command) handler.Reply {
+	if spec, ok := e.cmdSpecs[cmd.cmd]; ok && !spec.checkArity(len(cmd.args)+1) {
+		return handler.NewArgNumErrReply(cmd.cmd.String())
+	}
+	return nil
+}
+
+// 写指令执行成功后通知订阅者. 脚本类指令由其中执行的指令各自通知
+func (e *DBExecutor) notifyKeyspace(cmd *Command, reply handler.Reply) {
+	if len(e.subscribers) == 0 || !e.hasFlag(cmd.cmd, CmdFlagWrite) {
+		return
+	}
+	if _, ok := errReplyMsg(reply); ok {
+		return
+	}
+
+	for _, key := range e.cmdSpecs[cmd.cmd].keys(cmd.args) {
+		for _, subscriber := range e.subscribers {
+			subscriber(KeyspaceEvent{Cmd: cmd.cmd, Key: key})
+		}
+	}
+}
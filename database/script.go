@@ -0,0 +1,594 @@
+package database
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"goredis/handler"
+	"strconv"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const scriptChunkName = "user_script"
+
+// 只读表的元表中 __metatable 的值. getmetatable 返回该值，setmetatable 与 rawset 据此拒绝修改
+const luaReadOnlyMarker = lua.LString("readonly")
+
+const (
+	errScriptNoMatch       = "NOSCRIPT No matching script. Please use EVAL."
+	errScriptNotAllowed    = "ERR This Redis command is not allowed from script"
+	errScriptReadOnly      = "ERR Write commands are not allowed from read-only scripts."
+	errScriptNotBusy       = "NOTBUSY No scripts in execution right now."
+	errScriptReadOnlyTable = "Attempt to modify a readonly table"
+	errScriptKilled        = "ERR Script killed by user with SCRIPT KILL..."
+	errScriptUnkillable    = "UNKILLABLE Sorry the script already executed write commands against the dataset. " +
+		"You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command."
+)
+
+// 正在执行的脚本，字段由 scriptMu 保护
+type scriptRun struct {
+	cancel context.CancelFunc
+	// FCALL 与 FCALL_RO 执行的函数
+	function bool
+	// 执行时间超过 lua-time-limit
+	busy bool
+	// 执行过写指令后不能再终止
+	written bool
+	killed  bool
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// 延迟创建 lua 虚拟机，只开放无副作用的标准库. 只在 run 协程中调用
+func (e *DBExecutor) luaVM() *lua.LState {
+	if e.lua != nil {
+		return e.lua
+	}
+
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// setfenv 可以替换之后加载的代码的全局环境
+	for _, name := range []string{"dofile", "loadfile", "print", "setfenv"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetGlobal("rawset", L.NewFunction(luaRawSet))
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			return e.luaCall(L, false)
+		},
+		"pcall": func(L *lua.LState) int {
+			return e.luaCall(L, true)
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(luaStatusTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(luaStatusTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(sha1Hex(L.CheckString(1))))
+			return 1
+		},
+		"register_function": e.luaRegisterFunction,
+	})
+	L.SetGlobal("redis", redis)
+
+	e.luaEnvMeta = luaProtectGlobals(L)
+	e.lua = L
+	return L
+}
+
+// 全局表与其中的库改为只读，避免脚本替换 redis.call 等影响其他脚本. 返回脚本环境使用的元表
+func luaProtectGlobals(L *lua.LState) *lua.LTable {
+	global := L.G.Global
+	base := L.NewTable()
+	var names []lua.LValue
+	global.ForEach(func(name, value lua.LValue) {
+		if table, ok := value.(*lua.LTable); ok && table != global {
+			value = luaReadOnlyTable(L, table)
+		}
+		base.RawSet(name, value)
+		names = append(names, name)
+	})
+	// 全局表本身只作为代理，内容移到 base 中
+	for _, name := range names {
+		global.RawSet(name, lua.LNil)
+	}
+	base.RawSetString("_G", global)
+	L.SetMetatable(global, luaReadOnlyMeta(L, base))
+
+	// 字符串方法通过字符串的元表查找，同样不能修改
+	if meta, ok := L.GetMetatable(lua.LString("")).(*lua.LTable); ok {
+		meta.RawSetString("__metatable", luaReadOnlyMarker)
+	}
+	return luaReadOnlyMeta(L, global)
+}
+
+// 读取时落到 index，写入时报错的元表
+func luaReadOnlyMeta(L *lua.LState, index *lua.LTable) *lua.LTable {
+	meta := L.NewTable()
+	meta.RawSetString("__index", index)
+	meta.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError(errScriptReadOnlyTable)
+		return 0
+	}))
+	meta.RawSetString("__metatable", luaReadOnlyMarker)
+	return meta
+}
+
+func luaReadOnlyTable(L *lua.LState, table *lua.LTable) *lua.LTable {
+	proxy := L.NewTable()
+	L.SetMetatable(proxy, luaReadOnlyMeta(L, table))
+	return proxy
+}
+
+// rawset 会绕过 __newindex，对只读表报错
+func luaRawSet(L *lua.LState) int {
+	table := L.CheckTable(1)
+	if L.GetMetaField(table, "__metatable") == luaReadOnlyMarker {
+		L.RaiseError(errScriptReadOnlyTable)
+	}
+	L.RawSet(table, L.CheckAny(2), L.CheckAny(3))
+	return 0
+}
+
+// 脚本执行时的全局环境. 每次执行使用新的环境，vars 只对本次执行可见，不能创建全局变量
+func (e *DBExecutor) luaScriptEnv(vars map[string]lua.LValue) *lua.LTable {
+	env := e.lua.NewTable()
+	for name, value := range vars {
+		env.RawSetString(name, value)
+	}
+	env.RawSetString("_G", env)
+	e.lua.SetMetatable(env, e.luaEnvMeta)
+	return env
+}
+
+func luaStatusTable(L *lua.LState, field, msg string) *lua.LTable {
+	table := L.NewTable()
+	table.RawSetString(field, lua.LString(msg))
+	return table
+}
+
+// redis.call 与 redis.pcall. 指令出错时 call 抛出错误，pcall 返回 {err=...}
+func (e *DBExecutor) luaCall(L *lua.LState, protected bool) int {
+	if e.scriptCmd == nil {
+		return luaRaise(L, "ERR redis.call can not be called on FUNCTION LOAD command", protected)
+	}
+
+	args := make([][]byte, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString, lua.LNumber:
+			args = append(args, []byte(v.String()))
+		default:
+			return luaRaise(L, "ERR Lua redis lib command arguments must be strings or integers", protected)
+		}
+	}
+	if len(args) == 0 {
+		return luaRaise(L, "ERR Please specify at least one argument for this redis lib call", protected)
+	}
+
+	reply := e.scriptExec(args)
+	if msg, ok := errReplyMsg(reply); ok {
+		return luaRaise(L, msg, protected)
+	}
+	L.Push(replyToLua(L, reply))
+	return 1
+}
+
+func luaRaise(L *lua.LState, msg string, protected bool) int {
+	table := luaStatusTable(L, "err", msg)
+	if !protected {
+		L.Error(table, 1)
+		return 0
+	}
+	L.Push(table)
+	return 1
+}
+
+// 在脚本中执行指令. 阻塞指令在脚本中不会阻塞，直接返回空值
+func (e *DBExecutor) scriptExec(args [][]byte) handler.Reply {
+	cmdType := CmdType(strings.ToLower(string(args[0])))
+	if e.hasFlag(cmdType, CmdFlagNoScript) {
+		return handler.NewErrReply(errScriptNotAllowed)
+	}
+	cmdFunc, ok := e.cmdHandlers[cmdType]
+	if !ok {
+		return handler.NewErrReply("ERR Unknown Redis command called from script")
+	}
+	if e.scriptReadOnly && !e.readOnly(cmdType) {
+		return handler.NewErrReply(errScriptReadOnly)
+	}
+
+	cmd := Command{
+		ctx:  e.scriptCmd.ctx,
+		cmd:  cmdType,
+		args: args[1:],
+	}
+	if reply := e.checkArity(&cmd); reply != nil {
+		return reply
+	}
+	// 以调用脚本的连接的用户检查权限
+	if authorize, ok := handler.GetScriptAuthorizer(cmd.ctx); ok {
+		if reply := authorize(args); reply != nil {
+			return reply
+		}
+	}
+	if !e.readOnly(cmdType) {
+		e.scriptMu.Lock()
+		e.scriptRun.written = true
+		e.scriptMu.Unlock()
+	}
+	keys := e.cmdKeys(&cmd)
+	for _, key := range keys {
+		e.dataStore.ExpirePreprocess(key)
+	}
+	reply := cmdFunc(&cmd)
+	if _, ok := reply.(*BlockedReply); ok {
+		return handler.NewNullMultiBulkReply()
+	}
+	e.notifyKeyspace(&cmd, reply)
+	e.scriptKeys = append(e.scriptKeys, keys...)
+	return reply
+}
+
+// 错误类回复的内容
+func errReplyMsg(reply handler.Reply) (string, bool) {
+	switch r := reply.(type) {
+	case *handler.ErrReply:
+		return r.ErrStr, true
+	case error:
+		return r.Error(), true
+	}
+	return "", false
+}
+
+// redis 回复转为 lua 值
+func replyToLua(L *lua.LState, reply handler.Reply) lua.LValue {
+	switch r := reply.(type) {
+	case *handler.IntReply:
+		return lua.LNumber(r.Code)
+	case *handler.BulkReply:
+		if r.Arg == nil {
+			return lua.LFalse
+		}
+		return lua.LString(r.Arg)
+	case *handler.OKReply:
+		return luaStatusTable(L, "ok", "OK")
+	case *handler.SimpleStringReply:
+		return luaStatusTable(L, "ok", r.Str)
+	case *handler.MultiBulkReply:
+		table := L.NewTable()
+		for _, arg := range r.Args() {
+			if arg == nil {
+				table.Append(lua.LFalse)
+				continue
+			}
+			table.Append(lua.LString(arg))
+		}
+		return table
+	case *handler.EmptyMultiBulkReply:
+		return L.NewTable()
+	case *handler.DoubleReply:
+		return lua.LString(handler.FormatDouble(r.Value))
+	case *handler.BoolReply:
+		if r.Value {
+			return lua.LNumber(1)
+		}
+		return lua.LFalse
+	case *handler.BigNumberReply:
+		return lua.LString(r.Str)
+	case *handler.VerbatimReply:
+		return lua.LString(r.Text)
+	case *handler.AttributeReply:
+		return replyToLua(L, r.Reply())
+	case interface{ Replies() []handler.Reply }:
+		// map、set 等聚合类型按 RESP2 语义转为平铺的数组
+		table := L.NewTable()
+		for _, sub := range r.Replies() {
+			table.Append(replyToLua(L, sub))
+		}
+		return table
+	}
+
+	if msg, ok := errReplyMsg(reply); ok {
+		return luaStatusTable(L, "err", msg)
+	}
+	return lua.LFalse
+}
+
+// lua 值转为 redis 回复. 数字截断为整数，数组遇到 nil 截止
+func luaToReply(value lua.LValue) handler.Reply {
+	switch v := value.(type) {
+	case lua.LNumber:
+		return handler.NewIntReply(int64(v))
+	case lua.LString:
+		return handler.NewBulkReply([]byte(v))
+	case lua.LBool:
+		if v {
+			return handler.NewIntReply(1)
+		}
+		return handler.NewNillReply()
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return handler.NewErrReply(string(msg))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return handler.NewSimpleStringReply(string(msg))
+		}
+
+		replies := []handler.Reply{}
+		for i := 1; ; i++ {
+			elem := v.RawGetInt(i)
+			if elem == lua.LNil {
+				break
+			}
+			replies = append(replies, luaToReply(elem))
+		}
+		return handler.NewArrayReply(replies)
+	}
+	return handler.NewNillReply()
+}
+
+func (e *DBExecutor) loadScript(body string) (string, *lua.LFunction, error) {
+	sha := sha1Hex(body)
+	if fn, ok := e.scripts[sha]; ok {
+		return sha, fn, nil
+	}
+
+	fn, err := e.luaVM().Load(strings.NewReader(body), scriptChunkName)
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script: %s", err.Error())
+	}
+	e.scripts[sha] = fn
+	return sha, fn, nil
+}
+
+// 解析 numkeys [key ...] [arg ...]
+func parseScriptArgs(L *lua.LState, args [][]byte) (*lua.LTable, *lua.LTable, handler.Reply) {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, nil, handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return nil, nil, handler.NewErrReply("ERR Number of keys can't be negative")
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, nil, handler.NewErrReply("ERR Number of keys can't be greater than number of args")
+	}
+
+	keys, argv := L.NewTable(), L.NewTable()
+	for _, key := range args[1 : 1+numKeys] {
+		keys.Append(lua.LString(key))
+	}
+	for _, arg := range args[1+numKeys:] {
+		argv.Append(lua.LString(arg))
+	}
+	return keys, argv, nil
+}
+
+// 在执行器协程中原子地执行脚本. 脚本本身不持久化，执行的写指令各自持久化.
+// 执行超过 lua-time-limit 后其他指令回复 BUSY，直到脚本结束或被 SCRIPT KILL 终止
+func (e *DBExecutor) callScript(cmd *Command, readOnly bool, fn *lua.LFunction, fnArgs ...lua.LValue) handler.Reply {
+	L := e.luaVM()
+	e.scriptCmd, e.scriptKeys, e.scriptReadOnly = cmd, nil, readOnly
+
+	// 执行器关闭时同样终止脚本
+	ctx, cancel := context.WithCancel(e.ctx)
+	run := &scriptRun{cancel: cancel, function: cmd.cmd == CmdTypeFCall || cmd.cmd == CmdTypeFCallRO}
+	e.setScriptRun(run)
+	L.SetContext(ctx)
+	if e.luaTimeLimit > 0 {
+		timer := time.AfterFunc(e.luaTimeLimit, func() {
+			e.scriptMu.Lock()
+			run.busy = true
+			e.scriptMu.Unlock()
+		})
+		defer timer.Stop()
+	}
+
+	defer func() {
+		L.RemoveContext()
+		cancel()
+		e.setScriptRun(nil)
+		// 唤醒阻塞在脚本写入的 key 上的指令
+		touched := e.scriptKeys
+		e.scriptCmd, e.scriptKeys, e.scriptReadOnly = nil, nil, false
+		for _, key := range touched {
+			if len(e.blocking) == 0 {
+				break
+			}
+			e.serveBlocked(key)
+		}
+	}()
+
+	L.Push(fn)
+	for _, arg := range fnArgs {
+		L.Push(arg)
+	}
+	if err := L.PCall(len(fnArgs), 1, nil); err != nil {
+		e.scriptMu.Lock()
+		killed := run.killed
+		e.scriptMu.Unlock()
+		if killed {
+			return handler.NewErrReply(errScriptKilled)
+		}
+		if msg, ok := luaErrMsg(err); ok {
+			return handler.NewErrReply(msg)
+		}
+		return handler.NewErrReply("ERR Error running script: " + luaErrText(err))
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+	return luaToReply(ret)
+}
+
+func (e *DBExecutor) setScriptRun(run *scriptRun) {
+	e.scriptMu.Lock()
+	defer e.scriptMu.Unlock()
+	e.scriptRun = run
+}
+
+// 脚本执行超过 lua-time-limit 时返回 BUSY 回复，否则返回 nil. 在调用方协程中执行
+func (e *DBExecutor) Busy() handler.Reply {
+	e.scriptMu.Lock()
+	defer e.scriptMu.Unlock()
+	if e.scriptRun == nil || !e.scriptRun.busy {
+		return nil
+	}
+	if e.scriptRun.function {
+		return handler.BusyFunctionErrReply
+	}
+	return handler.BusyErrReply
+}
+
+// SCRIPT KILL 与 FUNCTION KILL. 脚本占用着执行器，因此在调用方协程中执行.
+// 只能终止对应类型且未执行过写指令的脚本
+func (e *DBExecutor) KillScript(function bool) handler.Reply {
+	e.scriptMu.Lock()
+	defer e.scriptMu.Unlock()
+	run := e.scriptRun
+	switch {
+	case run == nil:
+		return handler.NewErrReply(errScriptNotBusy)
+	case run.written:
+		return handler.NewErrReply(errScriptUnkillable)
+	case run.function && !function:
+		return handler.BusyFunctionErrReply
+	case !run.function && function:
+		return handler.BusyErrReply
+	}
+	run.killed = true
+	run.cancel()
+	return handler.NewOKReply()
+}
+
+// 运行错误的内容，不含调用栈. 错误回复不能包含换行
+func luaErrText(err error) string {
+	if apiErr, ok := err.(*lua.ApiError); ok {
+		return apiErr.Object.String()
+	}
+	return err.Error()
+}
+
+// 通过 error_reply 或 redis.call 抛出的错误内容
+func luaErrMsg(err error) (string, bool) {
+	apiErr, ok := err.(*lua.ApiError)
+	if !ok {
+		return "", false
+	}
+	table, ok := apiErr.Object.(*lua.LTable)
+	if !ok {
+		return "", false
+	}
+	msg, ok := table.RawGetString("err").(lua.LString)
+	return string(msg), ok
+}
+
+func (e *DBExecutor) runScript(cmd *Command, fn *lua.LFunction, args [][]byte) handler.Reply {
+	L := e.luaVM()
+	keys, argv, errReply := parseScriptArgs(L, args)
+	if errReply != nil {
+		return errReply
+	}
+	fn.Env = e.luaScriptEnv(map[string]lua.LValue{"KEYS": keys, "ARGV": argv})
+	return e.callScript(cmd, false, fn)
+}
+
+// EVAL script numkeys [key ...] [arg ...]
+func (e *DBExecutor) eval(cmd *Command) handler.Reply {
+	if len(cmd.args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	_, fn, err := e.loadScript(string(cmd.args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	return e.runScript(cmd, fn, cmd.args[1:])
+}
+
+// EVALSHA sha1 numkeys [key ...] [arg ...]
+func (e *DBExecutor) evalSha(cmd *Command) handler.Reply {
+	if len(cmd.args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	fn, ok := e.scripts[strings.ToLower(string(cmd.args[0]))]
+	if !ok {
+		return handler.NewErrReply(errScriptNoMatch)
+	}
+	return e.runScript(cmd, fn, cmd.args[1:])
+}
+
+// SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH | KILL
+func (e *DBExecutor) script(cmd *Command) handler.Reply {
+	args := cmd.args
+	switch subCmd := strings.ToLower(string(args[0])); subCmd {
+	case "load":
+		if len(args) != 2 {
+			return handler.NewSyntaxErrReply()
+		}
+		sha, _, err := e.loadScript(string(args[1]))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		return handler.NewBulkReply([]byte(sha))
+
+	case "exists":
+		if len(args) < 2 {
+			return handler.NewSyntaxErrReply()
+		}
+		res := make([]handler.Reply, 0, len(args)-1)
+		for _, sha := range args[1:] {
+			if _, ok := e.scripts[strings.ToLower(string(sha))]; ok {
+				res = append(res, handler.NewIntReply(1))
+				continue
+			}
+			res = append(res, handler.NewIntReply(0))
+		}
+		return handler.NewArrayReply(res)
+
+	case "flush":
+		// 支持 ASYNC|SYNC 参数，均同步清理
+		if len(args) > 2 {
+			return handler.NewSyntaxErrReply()
+		}
+		e.scripts = make(map[string]*lua.LFunction)
+		return handler.NewOKReply()
+
+	case "kill":
+		// 脚本执行期间的 SCRIPT KILL 由 DBTrigger 直接处理，到达执行器时没有正在执行的脚本
+		if len(args) != 1 {
+			return handler.NewSyntaxErrReply()
+		}
+		return handler.NewErrReply(errScriptNotBusy)
+
+	default:
+		return handler.NewUnknownSubCmdErrReply("script", args[0])
+	}
+}
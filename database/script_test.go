@@ -0,0 +1,232 @@
+package database_test
+
+import (
+	"context"
+	"goredis/database"
+	"goredis/datastore"
+	"goredis/handler"
+	"goredis/log"
+	"goredis/protocol"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_script_eval(t *testing.T) {
+	db, persister := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	reply := db.Do(ctx, cmdLine("eval", "redis.call('set', KEYS[1], ARGV[1]) return redis.call('get', KEYS[1])", "1", "a", "v"))
+	assert.Equal(t, "$1\r\nv\r\n", string(reply.ToBytes()))
+
+	// lua 值与 redis 回复互相转换
+	cases := []struct {
+		script string
+		expect string
+	}{
+		{"return 3.9", ":3\r\n"},
+		{"return true", ":1\r\n"},
+		{"return false", "$-1\r\n"},
+		{"return {1, 'x', {2}, nil, 3}", "*3\r\n:1\r\n$1\r\nx\r\n*1\r\n:2\r\n"},
+		{"return redis.status_reply('PONG')", "+PONG\r\n"},
+		{"return redis.error_reply('ERR boom')", "-ERR boom\r\n"},
+		{"return redis.call('set', 'b', 1)", "+OK\r\n"},
+		{"return redis.call('get', 'none')", "$-1\r\n"},
+		{"return redis.call('rpush', 'l', 'x')", ":1\r\n"},
+		{"return redis.call('rpush', 'l', 'y')", ":2\r\n"},
+		{"return redis.call('lrange', 'l', 0, -1)", "*2\r\n$1\r\nx\r\n$1\r\ny\r\n"},
+		{"return redis.pcall('lpush', 'a', 'x')", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"local r = redis.pcall('lpush', 'a', 'x') return r.err ~= nil", ":1\r\n"},
+		{"redis.call('lpush', 'a', 'x') return 1", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"return redis.call('eval', 'return 1', 0)", "-ERR This Redis command is not allowed from script\r\n"},
+		{"return redis.call('nosuchcmd', 'x')", "-ERR Unknown Redis command called from script\r\n"},
+		{"return redis.call('bzpopmin', 'none', 0)", "$-1\r\n"},
+		{"return dofile", "$-1\r\n"},
+		{"return redis.sha1hex('')", "$40\r\nda39a3ee5e6b4b0d3255bfef95601890afd80709\r\n"},
+	}
+	for _, c := range cases {
+		reply = db.Do(ctx, cmdLine("eval", c.script, "0"))
+		assert.Equal(t, c.expect, string(reply.ToBytes()), c.script)
+	}
+
+	reply = db.Do(ctx, cmdLine("eval", "return (", "0"))
+	assert.Contains(t, string(reply.ToBytes()), "-ERR Error compiling script")
+	reply = db.Do(ctx, cmdLine("eval", "return 1", "2", "a"))
+	assert.Equal(t, "-ERR Number of keys can't be greater than number of args\r\n", string(reply.ToBytes()))
+
+	// 只持久化脚本中执行的写指令
+	persister.mu.Lock()
+	assert.Equal(t, [][][]byte{cmdLine("set", "a", "v"), cmdLine("set", "b", "1"), cmdLine("rpush", "l", "y")}, persister.cmds)
+	persister.mu.Unlock()
+}
+
+func Test_script_sandbox(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	// 脚本不能替换 redis 库中的函数，影响之后其他连接执行的脚本
+	hijacks := []string{
+		"redis.call = function() return 'hijacked' end",
+		"redis = {call = function() return 'hijacked' end}",
+		"_G.redis = {call = function() return 'hijacked' end}",
+		"rawset(redis, 'call', function() return 'hijacked' end)",
+		"string.upper = function() return 'hijacked' end",
+	}
+	for _, script := range hijacks {
+		reply := db.Do(ctx, cmdLine("eval", script, "0"))
+		assert.Contains(t, string(reply.ToBytes()), "Attempt to modify a readonly table", script)
+	}
+	reply := db.Do(ctx, cmdLine("eval", "setmetatable(redis, nil)", "0"))
+	assert.Contains(t, string(reply.ToBytes()), "cannot change a protected metatable")
+	reply = db.Do(ctx, cmdLine("eval", "return setfenv", "0"))
+	assert.Equal(t, "$-1\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("eval", "redis.call('set', 'a', 'v') return {redis.call('get', 'a'), string.upper('x')}", "0"))
+	assert.Equal(t, "*2\r\n$1\r\nv\r\n$1\r\nX\r\n", string(reply.ToBytes()))
+
+	// 全局变量不会保留到之后的脚本
+	reply = db.Do(ctx, cmdLine("eval", "leaked = 1", "0"))
+	assert.Equal(t, "-ERR Error running script: user_script:1: Attempt to modify a readonly table\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("eval", "return leaked", "0"))
+	assert.Equal(t, "$-1\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("eval", "return KEYS[1]", "1", "a"))
+	assert.Equal(t, "$1\r\na\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("eval", "return KEYS[1]", "0"))
+	assert.Equal(t, "$-1\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("eval", "local x = 2 local f = function() return x end return f()", "0"))
+	assert.Equal(t, ":2\r\n", string(reply.ToBytes()))
+
+	// 函数库同样不能创建全局变量
+	reply = db.Do(ctx, cmdLine("function", "load", "#!lua name=g\nleaked = 1\nredis.register_function('f', function() return 1 end)"))
+	assert.Contains(t, string(reply.ToBytes()), "Attempt to modify a readonly table")
+}
+
+func Test_script_cache(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	script := "return ARGV[1] .. KEYS[1]"
+	reply := db.Do(ctx, cmdLine("script", "load", script))
+	shaValue := string(reply.(*handler.BulkReply).Arg)
+	assert.Equal(t, 40, len(shaValue))
+
+	reply = db.Do(ctx, cmdLine("evalsha", shaValue, "1", "k", "v"))
+	assert.Equal(t, "$2\r\nvk\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("script", "exists", shaValue, "ffff"))
+	assert.Equal(t, "*2\r\n:1\r\n:0\r\n", string(reply.ToBytes()))
+
+	reply = db.Do(ctx, cmdLine("script", "flush"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = db.Do(ctx, cmdLine("evalsha", shaValue, "1", "k", "v"))
+	assert.Equal(t, "-NOSCRIPT No matching script. Please use EVAL.\r\n", string(reply.ToBytes()))
+}
+
+func Test_script_wake_up_blocked(t *testing.T) {
+	db, _ := newTestTrigger()
+	defer db.Close()
+
+	blocked := doAsync(db, context.Background(), "bzpopmin", "z", "0")
+	reply := db.Do(context.Background(), cmdLine("eval", "return redis.call('zadd', KEYS[1], 1, 'm')", "1", "z"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+	assert.Equal(t, "*3\r\n$1\r\nz\r\n$1\r\nm\r\n$1\r\n1\r\n", string((<-blocked).ToBytes()))
+}
+
+type luaThinker struct{}
+
+func (luaThinker) LuaTimeLimit() int {
+	return 10
+}
+
+func newLimitedTrigger() handler.DB {
+	persister := &fakePersister{}
+	executor := database.NewDBExecutor(datastore.NewKVStore(persister, nil), persister, luaThinker{})
+	return database.NewDBTrigger(executor)
+}
+
+// 等待脚本执行超时，其他指令回复 BUSY
+func waitBusy(t *testing.T, db handler.DB, expect handler.Reply) {
+	assert.Eventually(t, func() bool {
+		return db.Do(context.Background(), cmdLine("get", "a")) == expect
+	}, time.Second, time.Millisecond)
+}
+
+func Test_script_kill(t *testing.T) {
+	db := newLimitedTrigger()
+	defer db.Close()
+	ctx := context.Background()
+
+	reply := db.Do(ctx, cmdLine("script", "kill"))
+	assert.Equal(t, "-NOTBUSY No scripts in execution right now.\r\n", string(reply.ToBytes()))
+
+	done := doAsync(db, ctx, "eval", "while true do end", "0")
+	waitBusy(t, db, handler.BusyErrReply)
+	assert.Equal(t, handler.BusyErrReply, db.Do(ctx, cmdLine("function", "kill")))
+	assert.Equal(t, "+OK\r\n", string(db.Do(ctx, cmdLine("script", "kill")).ToBytes()))
+	assert.Equal(t, "-ERR Script killed by user with SCRIPT KILL...\r\n", string((<-done).ToBytes()))
+	assert.Equal(t, "$-1\r\n", string(db.Do(ctx, cmdLine("get", "a")).ToBytes()))
+
+	// FCALL 执行的函数只能由 FUNCTION KILL 终止
+	reply = db.Do(ctx, cmdLine("function", "load", "#!lua name=spin\nredis.register_function('spin', function() while true do end end)"))
+	assert.Equal(t, "$4\r\nspin\r\n", string(reply.ToBytes()))
+	done = doAsync(db, ctx, "fcall", "spin", "0")
+	waitBusy(t, db, handler.BusyFunctionErrReply)
+	assert.Equal(t, handler.BusyFunctionErrReply, db.Do(ctx, cmdLine("script", "kill")))
+	assert.Equal(t, "+OK\r\n", string(db.Do(ctx, cmdLine("function", "kill")).ToBytes()))
+	assert.Equal(t, "-ERR Script killed by user with SCRIPT KILL...\r\n", string((<-done).ToBytes()))
+	reply = db.Do(ctx, cmdLine("function", "kill"))
+	assert.Equal(t, "-NOTBUSY No scripts in execution right now.\r\n", string(reply.ToBytes()))
+}
+
+func Test_script_unkillable(t *testing.T) {
+	db := newLimitedTrigger()
+	ctx := context.Background()
+
+	done := doAsync(db, ctx, "eval", "redis.call('set', 'b', 1) while true do end", "0")
+	waitBusy(t, db, handler.BusyErrReply)
+	reply := db.Do(ctx, cmdLine("script", "kill"))
+	assert.Contains(t, string(reply.ToBytes()), "-UNKILLABLE Sorry the script already executed write commands")
+
+	// 执行器关闭时终止脚本
+	db.Close()
+	assert.Contains(t, string((<-done).ToBytes()), "-ERR Error running script")
+}
+
+func Test_script_acl(t *testing.T) {
+	db, _ := newTestTrigger()
+	logger := log.GetDefaultLogger()
+	h, err := handler.NewHandler(db, &fakePersister{}, protocol.NewParser(logger, nil), logger, nil)
+	assert.NoError(t, err)
+	defer h.Close()
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go h.Handle(context.Background(), server)
+	replies := protocol.NewParser(logger, nil).ParseStream(client)
+	do := func(req string) string {
+		_ = client.SetDeadline(time.Now().Add(time.Second))
+		_, err := client.Write([]byte(req + "\r\n"))
+		assert.NoError(t, err)
+		droplet := <-replies
+		assert.NoError(t, droplet.Err)
+		return string(droplet.Reply.ToBytes())
+	}
+
+	assert.Equal(t, "+OK\r\n", do("ACL SETUSER dave on nopass ~foo* +@all"))
+	assert.Equal(t, "+OK\r\n", do("AUTH dave any"))
+	assert.Equal(t, "+OK\r\n", do(`EVAL "return redis.call('set', 'foo1', 'v')" 0`))
+	// 脚本中的指令同样以调用者的权限检查
+	assert.Equal(t, "-NOPERM No permissions to access a key\r\n", do(`EVAL "return redis.call('get', 'bar')" 0`))
+	assert.Equal(t, "$37\r\nNOPERM No permissions to access a key\r\n", do(`EVAL "return redis.pcall('get', 'bar').err" 0`))
+
+	assert.Equal(t, "+OK\r\n", do("AUTH default any"))
+	entry := do("ACL LOG 1")
+	assert.Contains(t, entry, "$6\r\nreason\r\n$3\r\nkey\r\n")
+	assert.Contains(t, entry, "$7\r\ncontext\r\n$3\r\nlua\r\n")
+	assert.Contains(t, entry, "$6\r\nobject\r\n$3\r\nbar\r\n")
+	assert.Contains(t, entry, "$8\r\nusername\r\n$4\r\ndave\r\n")
+	assert.Contains(t, entry, "$5\r\ncount\r\n:2\r\n")
+}
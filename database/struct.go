@@ -10,6 +10,14 @@ import (
 type Executor interface {
 	Entrance() chan<- *Command
 	ValidCommand(cmd CmdType) bool
+	MayWrite(cmd CmdType) bool
+	Spec(cmd CmdType) (*CmdSpec, bool)
+	// 取消挂起的阻塞指令，执行器已关闭时返回 false
+	Unblock(cmd *Command) bool
+	// 脚本执行超时期间返回 BUSY 回复，否则返回 nil
+	Busy() handler.Reply
+	// 终止正在执行的脚本，function 为 true 时对应 FUNCTION KILL
+	KillScript(function bool) handler.Reply
 	Close()
 }
 
@@ -22,6 +30,7 @@ func (c CmdType) String() string {
 const (
 	CmdTypeExpire   CmdType = "expire"
 	CmdTypeExpireAt CmdType = "expireat"
+	CmdTypeObject   CmdType = "object"
 
 	// string
 	CmdTypeGet  CmdType = "get"
@@ -37,26 +46,141 @@ const (
 	CmdTypeLRange CmdType = "lrange"
 
 	// hash
-	CmdTypeHSet CmdType = "hset"
-	CmdTypeHGet CmdType = "hget"
-	CmdTypeHDel CmdType = "hdel"
+	CmdTypeHSet    CmdType = "hset"
+	CmdTypeHGet    CmdType = "hget"
+	CmdTypeHDel    CmdType = "hdel"
+	CmdTypeHGetAll CmdType = "hgetall"
 
 	// set
-	CmdTypeSAdd      CmdType = "sadd"
-	CmdTypeSIsMember CmdType = "sismember"
-	CmdTypeSRem      CmdType = "srem"
+	CmdTypeSAdd        CmdType = "sadd"
+	CmdTypeSIsMember   CmdType = "sismember"
+	CmdTypeSRem        CmdType = "srem"
+	CmdTypeSMembers    CmdType = "smembers"
+	CmdTypeSCard       CmdType = "scard"
+	CmdTypeSInter      CmdType = "sinter"
+	CmdTypeSUnion      CmdType = "sunion"
+	CmdTypeSDiff       CmdType = "sdiff"
+	CmdTypeSInterStore CmdType = "sinterstore"
+	CmdTypeSUnionStore CmdType = "sunionstore"
+	CmdTypeSDiffStore  CmdType = "sdiffstore"
+	CmdTypeSMove       CmdType = "smove"
+	CmdTypeSPop        CmdType = "spop"
+	CmdTypeSRandMember CmdType = "srandmember"
+	CmdTypeSMIsMember  CmdType = "smismember"
+	CmdTypeSInterCard  CmdType = "sintercard"
 
 	// sorted set
-	CmdTypeZAdd          CmdType = "zadd"
-	CmdTypeZRangeByScore CmdType = "zrangebyscore"
-	CmdTypeZRem          CmdType = "zrem"
+	CmdTypeZAdd             CmdType = "zadd"
+	CmdTypeZIncrBy          CmdType = "zincrby"
+	CmdTypeZRange           CmdType = "zrange"
+	CmdTypeZRevRange        CmdType = "zrevrange"
+	CmdTypeZRangeByScore    CmdType = "zrangebyscore"
+	CmdTypeZRevRangeByScore CmdType = "zrevrangebyscore"
+	CmdTypeZRangeByLex      CmdType = "zrangebylex"
+	CmdTypeZRevRangeByLex   CmdType = "zrevrangebylex"
+	CmdTypeZRank            CmdType = "zrank"
+	CmdTypeZRevRank         CmdType = "zrevrank"
+	CmdTypeZScore           CmdType = "zscore"
+	CmdTypeZMScore          CmdType = "zmscore"
+	CmdTypeZCard            CmdType = "zcard"
+	CmdTypeZCount           CmdType = "zcount"
+	CmdTypeZLexCount        CmdType = "zlexcount"
+	CmdTypeZPopMin          CmdType = "zpopmin"
+	CmdTypeZPopMax          CmdType = "zpopmax"
+	CmdTypeBZPopMin         CmdType = "bzpopmin"
+	CmdTypeBZPopMax         CmdType = "bzpopmax"
+	CmdTypeZMPop            CmdType = "zmpop"
+	CmdTypeBZMPop           CmdType = "bzmpop"
+	CmdTypeZRem             CmdType = "zrem"
+	CmdTypeZRemRangeByRank  CmdType = "zremrangebyrank"
+	CmdTypeZRemRangeByScore CmdType = "zremrangebyscore"
+	CmdTypeZRemRangeByLex   CmdType = "zremrangebylex"
+	CmdTypeZUnion           CmdType = "zunion"
+	CmdTypeZInter           CmdType = "zinter"
+	CmdTypeZDiff            CmdType = "zdiff"
+	CmdTypeZUnionStore      CmdType = "zunionstore"
+	CmdTypeZInterStore      CmdType = "zinterstore"
+	CmdTypeZDiffStore       CmdType = "zdiffstore"
+	CmdTypeZRangeStore      CmdType = "zrangestore"
+
+	CmdTypeXAdd      CmdType = "xadd"
+	CmdTypeXRange    CmdType = "xrange"
+	CmdTypeXRevRange CmdType = "xrevrange"
+	CmdTypeXLen      CmdType = "xlen"
+	CmdTypeXTrim     CmdType = "xtrim"
+	CmdTypeXDel      CmdType = "xdel"
+	CmdTypeXRead     CmdType = "xread"
+	CmdTypeXSetID    CmdType = "xsetid"
+
+	CmdTypeXGroup     CmdType = "xgroup"
+	CmdTypeXReadGroup CmdType = "xreadgroup"
+	CmdTypeXAck       CmdType = "xack"
+	CmdTypeXPending   CmdType = "xpending"
+	CmdTypeXClaim     CmdType = "xclaim"
+	CmdTypeXAutoClaim CmdType = "xautoclaim"
+	CmdTypeXInfo      CmdType = "xinfo"
+
+	CmdTypeJSONSet       CmdType = "json.set"
+	CmdTypeJSONGet       CmdType = "json.get"
+	CmdTypeJSONDel       CmdType = "json.del"
+	CmdTypeJSONNumIncrBy CmdType = "json.numincrby"
+	CmdTypeJSONArrAppend CmdType = "json.arrappend"
+
+	CmdTypeBFReserve     CmdType = "bf.reserve"
+	CmdTypeBFAdd         CmdType = "bf.add"
+	CmdTypeBFMAdd        CmdType = "bf.madd"
+	CmdTypeBFExists      CmdType = "bf.exists"
+	CmdTypeBFMExists     CmdType = "bf.mexists"
+	CmdTypeBFLoadChunk   CmdType = "bf.loadchunk"
+	CmdTypeCMSInitByDim  CmdType = "cms.initbydim"
+	CmdTypeCMSInitByProb CmdType = "cms.initbyprob"
+	CmdTypeCMSIncrBy     CmdType = "cms.incrby"
+	CmdTypeCMSQuery      CmdType = "cms.query"
+	CmdTypeCMSMerge      CmdType = "cms.merge"
+	CmdTypeCMSLoadChunk  CmdType = "cms.loadchunk"
+
+	CmdTypeTSCreate   CmdType = "ts.create"
+	CmdTypeTSAdd      CmdType = "ts.add"
+	CmdTypeTSRange    CmdType = "ts.range"
+	CmdTypeTSRevRange CmdType = "ts.revrange"
+	CmdTypeTSMRange   CmdType = "ts.mrange"
+
+	CmdTypeFTCreate    CmdType = "ft.create"
+	CmdTypeFTSearch    CmdType = "ft.search"
+	CmdTypeFTDropIndex CmdType = "ft.dropindex"
+
+	CmdTypeEval    CmdType = "eval"
+	CmdTypeEvalSha CmdType = "evalsha"
+	CmdTypeScript  CmdType = "script"
+
+	CmdTypeFunction CmdType = "function"
+	CmdTypeFCall    CmdType = "fcall"
+	CmdTypeFCallRO  CmdType = "fcall_ro"
 )
 
 type CmdAdapter interface {
 	ToCmd() [][]byte
 }
 
+// 无法通过单条指令还原的数据实现该接口，aof 重写时优先使用
+type MultiCmdAdapter interface {
+	ToCmds() [][][]byte
+}
+
+// 不属于任何 key 的全局状态，如函数库、索引定义. Executor 或 DataStore 实现该接口时，aof 重写先于数据写入这些指令
+type MetaCmdAdapter interface {
+	MetaCmds() [][][]byte
+}
+
+// 按 key 读写任意类型的数据，供模块存取自定义类型
+type Keyspace interface {
+	GetEntity(key string) (interface{}, bool)
+	PutEntity(key string, entity CmdAdapter)
+	DelEntity(key string) bool
+}
+
 type DataStore interface {
+	Keyspace
 	ForEach(task func(key string, adapter CmdAdapter, expireAt *time.Time))
 
 	ExpirePreprocess(key string)
@@ -64,6 +188,7 @@ type DataStore interface {
 
 	Expire(*Command) handler.Reply
 	ExpireAt(*Command) handler.Reply
+	Object(*Command) handler.Reply
 
 	Get(*Command) handler.Reply
 	MGet(*Command) handler.Reply
@@ -79,14 +204,103 @@ type DataStore interface {
 	SAdd(*Command) handler.Reply
 	SIsMember(*Command) handler.Reply
 	SRem(*Command) handler.Reply
+	SMembers(*Command) handler.Reply
+	SCard(*Command) handler.Reply
+	SInter(*Command) handler.Reply
+	SUnion(*Command) handler.Reply
+	SDiff(*Command) handler.Reply
+	SInterStore(*Command) handler.Reply
+	SUnionStore(*Command) handler.Reply
+	SDiffStore(*Command) handler.Reply
+	SMove(*Command) handler.Reply
+	SPop(*Command) handler.Reply
+	SRandMember(*Command) handler.Reply
+	SMIsMember(*Command) handler.Reply
+	SInterCard(*Command) handler.Reply
 
 	HSet(*Command) handler.Reply
 	HGet(*Command) handler.Reply
 	HDel(*Command) handler.Reply
+	HGetAll(*Command) handler.Reply
 
 	ZAdd(*Command) handler.Reply
+	ZIncrBy(*Command) handler.Reply
+	ZRange(*Command) handler.Reply
+	ZRevRange(*Command) handler.Reply
 	ZRangeByScore(*Command) handler.Reply
+	ZRevRangeByScore(*Command) handler.Reply
+	ZRangeByLex(*Command) handler.Reply
+	ZRevRangeByLex(*Command) handler.Reply
+	ZRank(*Command) handler.Reply
+	ZRevRank(*Command) handler.Reply
+	ZScore(*Command) handler.Reply
+	ZMScore(*Command) handler.Reply
+	ZCard(*Command) handler.Reply
+	ZCount(*Command) handler.Reply
+	ZLexCount(*Command) handler.Reply
+	ZPopMin(*Command) handler.Reply
+	ZPopMax(*Command) handler.Reply
+	BZPopMin(*Command) handler.Reply
+	BZPopMax(*Command) handler.Reply
+	ZMPop(*Command) handler.Reply
+	BZMPop(*Command) handler.Reply
 	ZRem(*Command) handler.Reply
+	ZRemRangeByRank(*Command) handler.Reply
+	ZRemRangeByScore(*Command) handler.Reply
+	ZRemRangeByLex(*Command) handler.Reply
+	ZUnion(*Command) handler.Reply
+	ZInter(*Command) handler.Reply
+	ZDiff(*Command) handler.Reply
+	ZUnionStore(*Command) handler.Reply
+	ZInterStore(*Command) handler.Reply
+	ZDiffStore(*Command) handler.Reply
+	ZRangeStore(*Command) handler.Reply
+
+	XAdd(*Command) handler.Reply
+	XRange(*Command) handler.Reply
+	XRevRange(*Command) handler.Reply
+	XLen(*Command) handler.Reply
+	XTrim(*Command) handler.Reply
+	XDel(*Command) handler.Reply
+	XRead(*Command) handler.Reply
+	XSetID(*Command) handler.Reply
+
+	XGroup(*Command) handler.Reply
+	XReadGroup(*Command) handler.Reply
+	XAck(*Command) handler.Reply
+	XPending(*Command) handler.Reply
+	XClaim(*Command) handler.Reply
+	XAutoClaim(*Command) handler.Reply
+	XInfo(*Command) handler.Reply
+
+	JSONSet(*Command) handler.Reply
+	JSONGet(*Command) handler.Reply
+	JSONDel(*Command) handler.Reply
+	JSONNumIncrBy(*Command) handler.Reply
+	JSONArrAppend(*Command) handler.Reply
+
+	BFReserve(*Command) handler.Reply
+	BFAdd(*Command) handler.Reply
+	BFMAdd(*Command) handler.Reply
+	BFExists(*Command) handler.Reply
+	BFMExists(*Command) handler.Reply
+	BFLoadChunk(*Command) handler.Reply
+	CMSInitByDim(*Command) handler.Reply
+	CMSInitByProb(*Command) handler.Reply
+	CMSIncrBy(*Command) handler.Reply
+	CMSQuery(*Command) handler.Reply
+	CMSMerge(*Command) handler.Reply
+	CMSLoadChunk(*Command) handler.Reply
+
+	TSCreate(*Command) handler.Reply
+	TSAdd(*Command) handler.Reply
+	TSRange(*Command) handler.Reply
+	TSRevRange(*Command) handler.Reply
+	TSMRange(*Command) handler.Reply
+
+	FTCreate(*Command) handler.Reply
+	FTSearch(*Command) handler.Reply
+	FTDropIndex(*Command) handler.Reply
 }
 
 type CmdHandler func(*Command) handler.Reply
@@ -97,6 +311,7 @@ type Command struct {
 	cmd      CmdType
 	args     [][]byte
 	receiver CmdReceiver
+	blocked  *BlockedReply
 }
 
 func NewCommand(cmd CmdType, args [][]byte) *Command {
@@ -114,6 +329,10 @@ func (c *Command) Receiver() CmdReceiver {
 	return c.receiver
 }
 
+func (c *Command) Type() CmdType {
+	return c.cmd
+}
+
 func (c *Command) Args() [][]byte {
 	return c.args
 }
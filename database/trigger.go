@@ -2,9 +2,10 @@ package database
 
 import (
 	"context"
-	"fmt"
 	"goredis/handler"
+	"strings"
 	"sync"
+	"time"
 )
 
 type DBTrigger struct {
@@ -17,24 +18,91 @@ func NewDBTrigger(executor Executor) handler.DB {
 }
 
 func (d *DBTrigger) Do(ctx context.Context, cmdLine [][]byte) handler.Reply {
-	if len(cmdLine) < 2 {
-		return handler.NewErrReply(fmt.Sprintf("invalid cmd line: %v", cmdLine))
+	if len(cmdLine) == 0 {
+		return handler.NewUnknownCmdErrReply(cmdLine)
 	}
 
-	cmdType := CmdType(cmdLine[0])
+	// 指令名不区分大小写，统一转为小写，持久化时也使用小写
+	cmdType := CmdType(strings.ToLower(string(cmdLine[0])))
 	if !d.executor.ValidCommand(cmdType) {
-		return handler.NewErrReply(fmt.Sprintf("unknowm cmd '%s'", cmdLine[0]))
+		return handler.NewUnknownCmdErrReply(cmdLine)
 	}
 
+	// 脚本占用执行器期间，只有 SCRIPT KILL 与 FUNCTION KILL 可以执行
+	if (cmdType == CmdTypeScript || cmdType == CmdTypeFunction) &&
+		len(cmdLine) == 2 && strings.ToLower(string(cmdLine[1])) == "kill" {
+		return d.executor.KillScript(cmdType == CmdTypeFunction)
+	}
+	if reply := d.executor.Busy(); reply != nil {
+		return reply
+	}
+
+	// 阻塞指令会收到两次回复，预留缓冲避免执行器与取消操作互相等待
 	cmd := Command{
 		ctx:      ctx,
 		cmd:      cmdType,
 		args:     cmdLine[1:],
-		receiver: make(CmdReceiver),
+		receiver: make(CmdReceiver, 1),
 	}
 
 	d.executor.Entrance() <- &cmd
 
+	reply := <-cmd.Receiver()
+	if blocked, ok := reply.(*BlockedReply); ok && !handler.IsLoadingPattern(ctx) {
+		return d.waitBlocked(ctx, &cmd, blocked)
+	}
+	return reply
+}
+
+// 供 CLIENT PAUSE WRITE 判断指令是否需要暂停
+func (d *DBTrigger) MayWrite(cmdLine [][]byte) bool {
+	return len(cmdLine) > 0 && d.executor.MayWrite(CmdType(strings.ToLower(string(cmdLine[0]))))
+}
+
+// 指令所属的 ACL 类别，供连接层检查权限
+func (d *DBTrigger) CmdCategories(cmd string) ([]string, bool) {
+	spec, ok := d.executor.Spec(CmdType(strings.ToLower(cmd)))
+	if !ok {
+		return nil, false
+	}
+	return spec.categories(), true
+}
+
+// 指令访问的 key，供连接层检查权限
+func (d *DBTrigger) CmdKeys(cmdLine [][]byte) []string {
+	if len(cmdLine) == 0 {
+		return nil
+	}
+	spec, ok := d.executor.Spec(CmdType(strings.ToLower(string(cmdLine[0]))))
+	if !ok {
+		return nil
+	}
+	return spec.keys(cmdLine[1:])
+}
+
+func (d *DBTrigger) Categories() []string {
+	return aclCategories()
+}
+
+// 等待挂起的阻塞指令被唤醒. 超时或连接断开时通知执行器取消挂起
+func (d *DBTrigger) waitBlocked(ctx context.Context, cmd *Command, blocked *BlockedReply) handler.Reply {
+	var timeoutc <-chan time.Time
+	if blocked.Timeout() > 0 {
+		timer := time.NewTimer(blocked.Timeout())
+		defer timer.Stop()
+		timeoutc = timer.C
+	}
+
+	select {
+	case reply := <-cmd.Receiver():
+		return reply
+	case <-timeoutc:
+	case <-ctx.Done():
+	}
+
+	if !d.executor.Unblock(cmd) {
+		return blocked
+	}
 	return <-cmd.Receiver()
 }
 
@@ -0,0 +1,243 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"goredis/database"
+	"goredis/handler"
+	"hash/fnv"
+	"math"
+)
+
+func (k *KVStore) getAsBloom(key string) (Bloom, error) {
+	v, ok := k.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	bloom, ok := v.(Bloom)
+	if !ok {
+		return nil, handler.NewWrongTypeErrReply()
+	}
+
+	return bloom, nil
+}
+
+func (k *KVStore) putAsBloom(key string, bloom Bloom) {
+	k.data[key] = bloom
+}
+
+const (
+	bloomDefaultErrorRate = 0.01
+	bloomDefaultCapacity  = 100
+	bloomDefaultExpansion = 2
+	// 每新增一层子过滤器，误判率收紧的比例
+	bloomTighteningRatio = 0.5
+)
+
+var (
+	errBloomFull    = errors.New("ERR non scaling filter is full")
+	errBloomBadData = errors.New("ERR received bad data")
+)
+
+// 对 item 计算两个独立的哈希值，用于双重哈希生成多个位置
+func hashItem(item []byte) (uint64, uint64) {
+	h1 := fnv.New64a()
+	_, _ = h1.Write(item)
+	h2 := fnv.New64()
+	_, _ = h2.Write(item)
+	// h2 为奇数，保证步长不为 0
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+// 单个定长的布隆过滤器
+type bloomLayer struct {
+	capacity  uint64
+	count     uint64
+	hashes    uint32
+	errorRate float64
+	bits      []byte
+}
+
+func newBloomLayer(capacity uint64, errorRate float64) *bloomLayer {
+	size, hashes := bloomLayerParams(capacity, errorRate)
+	return &bloomLayer{
+		capacity:  capacity,
+		hashes:    hashes,
+		errorRate: errorRate,
+		bits:      make([]byte, size),
+	}
+}
+
+// 按容量与误判率计算位图字节数与哈希次数
+func bloomLayerParams(capacity uint64, errorRate float64) (uint64, uint32) {
+	// m = -n*ln(p) / (ln2)^2, k = m/n * ln2
+	nbits := uint64(math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)))
+	if nbits < 8 {
+		nbits = 8
+	}
+	hashes := uint32(math.Ceil(float64(nbits) / float64(capacity) * math.Ln2))
+	if hashes == 0 {
+		hashes = 1
+	}
+	return (nbits + 7) / 8, hashes
+}
+
+func (l *bloomLayer) nbits() uint64 {
+	return uint64(len(l.bits)) * 8
+}
+
+func (l *bloomLayer) exist(h1, h2 uint64) bool {
+	for i := uint32(0); i < l.hashes; i++ {
+		pos := (h1 + uint64(i)*h2) % l.nbits()
+		if l.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) add(h1, h2 uint64) {
+	for i := uint32(0); i < l.hashes; i++ {
+		pos := (h1 + uint64(i)*h2) % l.nbits()
+		l.bits[pos/8] |= 1 << (pos % 8)
+	}
+	l.count++
+}
+
+type Bloom interface {
+	// 添加 item，返回 item 此前是否不存在
+	Add(item []byte) (bool, error)
+	Exist(item []byte) bool
+	// 已添加的元素数
+	Len() int64
+	database.CmdAdapter
+}
+
+// 可扩容的布隆过滤器. 最后一层写满时新增容量为 expansion 倍的子过滤器
+type bloomEntity struct {
+	key        string
+	expansion  uint32
+	nonScaling bool
+	layers     []*bloomLayer
+}
+
+func newBloomEntity(key string, errorRate float64, capacity uint64, expansion uint32, nonScaling bool) Bloom {
+	return &bloomEntity{
+		key:        key,
+		expansion:  expansion,
+		nonScaling: nonScaling,
+		layers:     []*bloomLayer{newBloomLayer(capacity, errorRate)},
+	}
+}
+
+func (b *bloomEntity) Add(item []byte) (bool, error) {
+	h1, h2 := hashItem(item)
+	if b.exist(h1, h2) {
+		return false, nil
+	}
+
+	last := b.layers[len(b.layers)-1]
+	if last.count >= last.capacity {
+		if b.nonScaling {
+			return false, errBloomFull
+		}
+		last = newBloomLayer(last.capacity*uint64(b.expansion), last.errorRate*bloomTighteningRatio)
+		b.layers = append(b.layers, last)
+	}
+	last.add(h1, h2)
+	return true, nil
+}
+
+func (b *bloomEntity) Exist(item []byte) bool {
+	return b.exist(hashItem(item))
+}
+
+func (b *bloomEntity) exist(h1, h2 uint64) bool {
+	for _, layer := range b.layers {
+		if layer.exist(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bloomEntity) Len() int64 {
+	var count uint64
+	for _, layer := range b.layers {
+		count += layer.count
+	}
+	return int64(count)
+}
+
+// 以 bf.loadchunk 指令还原完整的二进制状态
+func (b *bloomEntity) ToCmd() [][]byte {
+	return [][]byte{[]byte(database.CmdTypeBFLoadChunk), []byte(b.key), []byte("1"), b.marshal()}
+}
+
+// 二进制格式: expansion | nonScaling | 层数 | 各层 (capacity | count | hashes | errorRate | 位图长度 | 位图)
+func (b *bloomEntity) marshal() []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, b.expansion)
+	_ = binary.Write(&buf, binary.LittleEndian, b.nonScaling)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(b.layers)))
+	for _, layer := range b.layers {
+		_ = binary.Write(&buf, binary.LittleEndian, layer.capacity)
+		_ = binary.Write(&buf, binary.LittleEndian, layer.count)
+		_ = binary.Write(&buf, binary.LittleEndian, layer.hashes)
+		_ = binary.Write(&buf, binary.LittleEndian, layer.errorRate)
+		_ = binary.Write(&buf, binary.LittleEndian, uint64(len(layer.bits)))
+		buf.Write(layer.bits)
+	}
+	return buf.Bytes()
+}
+
+func unmarshalBloom(key string, data []byte) (Bloom, error) {
+	reader := bytes.NewReader(data)
+	b := bloomEntity{key: key}
+	var layers uint32
+	if err := readBinary(reader, &b.expansion, &b.nonScaling, &layers); err != nil {
+		return nil, err
+	}
+	if b.expansion == 0 || layers == 0 || uint64(layers) > uint64(reader.Len()) {
+		return nil, errBloomBadData
+	}
+
+	for i := uint32(0); i < layers; i++ {
+		var (
+			layer bloomLayer
+			size  uint64
+		)
+		if err := readBinary(reader, &layer.capacity, &layer.count, &layer.hashes, &layer.errorRate, &size); err != nil {
+			return nil, err
+		}
+		if layer.capacity == 0 || layer.count > layer.capacity || size == 0 || size > uint64(reader.Len()) {
+			return nil, errBloomBadData
+		}
+		// 哈希次数与位图长度必须与容量、误判率一致，避免伪造的数据使后续指令执行过多轮哈希
+		if !(layer.errorRate > 0 && layer.errorRate < 1) {
+			return nil, errBloomBadData
+		}
+		if expectSize, expectHashes := bloomLayerParams(layer.capacity, layer.errorRate); layer.hashes != expectHashes || size != expectSize {
+			return nil, errBloomBadData
+		}
+		layer.bits = make([]byte, size)
+		_, _ = reader.Read(layer.bits)
+		b.layers = append(b.layers, &layer)
+	}
+	if reader.Len() > 0 {
+		return nil, errBloomBadData
+	}
+	return &b, nil
+}
+
+// 依次读取定长字段，数据不足时返回 errBloomBadData
+func readBinary(reader *bytes.Reader, fields ...interface{}) error {
+	for _, field := range fields {
+		if err := binary.Read(reader, binary.LittleEndian, field); err != nil {
+			return errBloomBadData
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"goredis/database"
+	"math"
+	"testing"
+
+	"github.com/spf13/cast"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_bloom_scaling(t *testing.T) {
+	bloom := newBloomEntity("", 0.01, 100, 2, false)
+	for i := 0; i < 1000; i++ {
+		_, err := bloom.Add([]byte(cast.ToString(i)))
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		assert.True(t, bloom.Exist([]byte(cast.ToString(i))))
+	}
+	// 100 + 200 + 400 + 800
+	assert.Equal(t, 4, len(bloom.(*bloomEntity).layers))
+
+	var falsePositive int
+	for i := 1000; i < 11000; i++ {
+		if bloom.Exist([]byte(cast.ToString(i))) {
+			falsePositive++
+		}
+	}
+	assert.Less(t, falsePositive, 300)
+
+	nonScaling := newBloomEntity("", 0.01, 10, 2, true)
+	for i := 0; i < 10; i++ {
+		_, _ = nonScaling.Add([]byte(cast.ToString(i)))
+	}
+	var err error
+	for i := 10; i < 20 && err == nil; i++ {
+		_, err = nonScaling.Add([]byte(cast.ToString(i)))
+	}
+	assert.Equal(t, errBloomFull, err)
+}
+
+func Test_kv_bloom_cmds(t *testing.T) {
+	kv, persister := newTestKVStore()
+
+	reply := kv.BFReserve(newTestCmd(database.CmdTypeBFReserve, "bf", "1", "100"))
+	assert.Equal(t, "-ERR (0 < error rate range < 1)\r\n", string(reply.ToBytes()))
+	reply = kv.BFReserve(newTestCmd(database.CmdTypeBFReserve, "bf", "0.01", "0"))
+	assert.Equal(t, "-ERR (capacity should be larger than 0)\r\n", string(reply.ToBytes()))
+	reply = kv.BFReserve(newTestCmd(database.CmdTypeBFReserve, "bf", "0.001", "2", "EXPANSION", "3"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = kv.BFReserve(newTestCmd(database.CmdTypeBFReserve, "bf", "0.001", "2"))
+	assert.Equal(t, "-ERR item exists\r\n", string(reply.ToBytes()))
+
+	reply = kv.BFAdd(newTestCmd(database.CmdTypeBFAdd, "bf", "a"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+	reply = kv.BFAdd(newTestCmd(database.CmdTypeBFAdd, "bf", "a"))
+	assert.Equal(t, ":0\r\n", string(reply.ToBytes()))
+	reply = kv.BFMAdd(newTestCmd(database.CmdTypeBFMAdd, "bf", "a", "b", "c", "d"))
+	assert.Equal(t, "*4\r\n:0\r\n:1\r\n:1\r\n:1\r\n", string(reply.ToBytes()))
+	assert.Equal(t, 2, len(kv.data["bf"].(*bloomEntity).layers))
+	assert.Equal(t, [][]byte{[]byte("bf.madd"), []byte("bf"), []byte("b"), []byte("c"), []byte("d")}, persister.cmds[2])
+
+	reply = kv.BFExists(newTestCmd(database.CmdTypeBFExists, "bf", "c"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+	reply = kv.BFExists(newTestCmd(database.CmdTypeBFExists, "none", "c"))
+	assert.Equal(t, ":0\r\n", string(reply.ToBytes()))
+	reply = kv.BFMExists(newTestCmd(database.CmdTypeBFMExists, "bf", "a", "d", "e"))
+	assert.Equal(t, "*3\r\n:1\r\n:1\r\n:0\r\n", string(reply.ToBytes()))
+
+	// 不存在时按默认参数创建
+	reply = kv.BFAdd(newTestCmd(database.CmdTypeBFAdd, "auto", "a"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+	assert.Equal(t, uint64(bloomDefaultCapacity), kv.data["auto"].(*bloomEntity).layers[0].capacity)
+
+	// 通过二进制状态还原
+	bloom, _ := kv.getAsBloom("bf")
+	cmd := bloom.ToCmd()
+	restore, _ := newTestKVStore()
+	reply = restore.BFLoadChunk(database.NewCommand(database.CmdTypeBFLoadChunk, cmd[1:]))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	assert.Equal(t, bloom, restore.data["bf"])
+	reply = restore.BFLoadChunk(newTestCmd(database.CmdTypeBFLoadChunk, "bf", "1", string(cmd[3][:len(cmd[3])-1])))
+	assert.Equal(t, "-"+errBloomBadData.Error()+"\r\n", string(reply.ToBytes()))
+	reply = restore.BFLoadChunk(newTestCmd(database.CmdTypeBFLoadChunk, "bf", "1", "x"))
+	assert.Equal(t, "-"+errBloomBadData.Error()+"\r\n", string(reply.ToBytes()))
+}
+
+func Test_bloom_unmarshal_bad_header(t *testing.T) {
+	data := newBloomEntity("bf", 0.01, 100, 2, false).(*bloomEntity).marshal()
+	_, err := unmarshalBloom("bf", data)
+	assert.NoError(t, err)
+
+	// 首层头部的偏移: capacity 9, count 17, hashes 25, errorRate 29, 位图长度 37
+	tamper := func(f func(b []byte)) []byte {
+		bad := append([]byte(nil), data...)
+		f(bad)
+		return bad
+	}
+	cases := [][]byte{
+		tamper(func(b []byte) { binary.LittleEndian.PutUint32(b[25:], 0) }),
+		tamper(func(b []byte) { binary.LittleEndian.PutUint32(b[25:], math.MaxUint32) }),
+		tamper(func(b []byte) { binary.LittleEndian.PutUint64(b[29:], math.Float64bits(0)) }),
+		tamper(func(b []byte) { binary.LittleEndian.PutUint64(b[29:], math.Float64bits(1)) }),
+		tamper(func(b []byte) { binary.LittleEndian.PutUint64(b[29:], math.Float64bits(math.NaN())) }),
+		tamper(func(b []byte) { binary.LittleEndian.PutUint64(b[17:], 101) }),
+		// 位图长度与容量、误判率不符
+		append(tamper(func(b []byte) { binary.LittleEndian.PutUint64(b[37:], binary.LittleEndian.Uint64(b[37:])+1) }), 0),
+	}
+	for i, c := range cases {
+		_, err := unmarshalBloom("bf", c)
+		assert.Equal(t, errBloomBadData, err, i)
+	}
+}
+
+func Test_kv_cms_cmds(t *testing.T) {
+	kv, _ := newTestKVStore()
+
+	reply := kv.CMSInitByDim(newTestCmd(database.CmdTypeCMSInitByDim, "a", "0", "5"))
+	assert.Equal(t, "-CMS: invalid width\r\n", string(reply.ToBytes()))
+	reply = kv.CMSInitByDim(newTestCmd(database.CmdTypeCMSInitByDim, "a", "2000", "5"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = kv.CMSInitByDim(newTestCmd(database.CmdTypeCMSInitByDim, "a", "2000", "5"))
+	assert.Equal(t, "-"+errCMSKeyExist.Error()+"\r\n", string(reply.ToBytes()))
+	reply = kv.CMSInitByProb(newTestCmd(database.CmdTypeCMSInitByProb, "b", "0.001", "0.01"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	b, _ := kv.getAsCMS("b")
+	assert.Equal(t, int64(2000), b.Width())
+	assert.Equal(t, int64(7), b.Depth())
+
+	reply = kv.CMSIncrBy(newTestCmd(database.CmdTypeCMSIncrBy, "a", "x", "3", "y", "-1"))
+	assert.Equal(t, "-CMS: Cannot parse number\r\n", string(reply.ToBytes()))
+	reply = kv.CMSIncrBy(newTestCmd(database.CmdTypeCMSIncrBy, "a", "x", "3", "y", "1", "x", "2"))
+	assert.Equal(t, "*3\r\n:3\r\n:1\r\n:5\r\n", string(reply.ToBytes()))
+	reply = kv.CMSIncrBy(newTestCmd(database.CmdTypeCMSIncrBy, "none", "x", "3"))
+	assert.Equal(t, "-"+errCMSKeyNotExist.Error()+"\r\n", string(reply.ToBytes()))
+	reply = kv.CMSQuery(newTestCmd(database.CmdTypeCMSQuery, "a", "x", "y", "z"))
+	assert.Equal(t, "*3\r\n:5\r\n:1\r\n:0\r\n", string(reply.ToBytes()))
+
+	// 估计值不小于真实值
+	counts := map[string]int64{}
+	for i := 0; i < 5000; i++ {
+		item := cast.ToString(i % 500)
+		counts[item]++
+		kv.CMSIncrBy(newTestCmd(database.CmdTypeCMSIncrBy, "a", item, "1"))
+	}
+	a, _ := kv.getAsCMS("a")
+	for item, count := range counts {
+		assert.GreaterOrEqual(t, a.Query([]byte(item)), count)
+	}
+
+	reply = kv.CMSMerge(newTestCmd(database.CmdTypeCMSMerge, "a", "1", "b"))
+	assert.Equal(t, "-CMS: width/depth is not equal\r\n", string(reply.ToBytes()))
+	kv.CMSInitByDim(newTestCmd(database.CmdTypeCMSInitByDim, "c", "2000", "5"))
+	reply = kv.CMSMerge(newTestCmd(database.CmdTypeCMSMerge, "c", "2", "a", "a", "WEIGHTS", "2", "1"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	c, _ := kv.getAsCMS("c")
+	assert.Equal(t, 3*a.Count(), c.Count())
+	assert.Equal(t, 3*a.Query([]byte("x")), c.Query([]byte("x")))
+	reply = kv.CMSMerge(newTestCmd(database.CmdTypeCMSMerge, "c", "3", "a", "a"))
+	assert.Equal(t, "-CMS: invalid numkeys\r\n", string(reply.ToBytes()))
+
+	// 通过二进制状态还原
+	cmd := c.ToCmd()
+	restore, _ := newTestKVStore()
+	reply = restore.CMSLoadChunk(database.NewCommand(database.CmdTypeCMSLoadChunk, cmd[1:]))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	assert.Equal(t, c, restore.data["c"])
+	reply = restore.CMSLoadChunk(newTestCmd(database.CmdTypeCMSLoadChunk, "c", string(cmd[2][:len(cmd[2])-8])))
+	assert.Equal(t, "-"+errBloomBadData.Error()+"\r\n", string(reply.ToBytes()))
+}
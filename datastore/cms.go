@@ -0,0 +1,147 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"goredis/database"
+	"goredis/handler"
+)
+
+func (k *KVStore) getAsCMS(key string) (CMS, error) {
+	v, ok := k.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	cms, ok := v.(CMS)
+	if !ok {
+		return nil, handler.NewWrongTypeErrReply()
+	}
+
+	return cms, nil
+}
+
+func (k *KVStore) putAsCMS(key string, cms CMS) {
+	k.data[key] = cms
+}
+
+type CMS interface {
+	// 为 item 增加计数，返回增加后的估计值
+	IncrBy(item []byte, incr int64) int64
+	// 返回 item 的估计值
+	Query(item []byte) int64
+	Width() int64
+	Depth() int64
+	// 所有 item 计数之和
+	Count() int64
+	// 将 sources 按权重累加到当前 sketch，调用方需保证维度一致
+	Merge(sources []CMS, weights []int64)
+	database.CmdAdapter
+}
+
+// depth 行 width 列的计数矩阵，每行通过不同的哈希位置计数，取各行最小值作为估计值
+type cmsEntity struct {
+	key      string
+	width    uint32
+	depth    uint32
+	count    int64
+	counters []int64
+}
+
+func newCMSEntity(key string, width, depth uint32) CMS {
+	return &cmsEntity{
+		key:      key,
+		width:    width,
+		depth:    depth,
+		counters: make([]int64, uint64(width)*uint64(depth)),
+	}
+}
+
+func (c *cmsEntity) pos(row uint32, h1, h2 uint64) uint64 {
+	return uint64(row)*uint64(c.width) + (h1+uint64(row)*h2)%uint64(c.width)
+}
+
+func (c *cmsEntity) IncrBy(item []byte, incr int64) int64 {
+	h1, h2 := hashItem(item)
+	min := int64(-1)
+	for row := uint32(0); row < c.depth; row++ {
+		pos := c.pos(row, h1, h2)
+		c.counters[pos] += incr
+		if min < 0 || c.counters[pos] < min {
+			min = c.counters[pos]
+		}
+	}
+	c.count += incr
+	return min
+}
+
+func (c *cmsEntity) Query(item []byte) int64 {
+	h1, h2 := hashItem(item)
+	min := int64(-1)
+	for row := uint32(0); row < c.depth; row++ {
+		if counter := c.counters[c.pos(row, h1, h2)]; min < 0 || counter < min {
+			min = counter
+		}
+	}
+	return min
+}
+
+func (c *cmsEntity) Width() int64 {
+	return int64(c.width)
+}
+
+func (c *cmsEntity) Depth() int64 {
+	return int64(c.depth)
+}
+
+func (c *cmsEntity) Count() int64 {
+	return c.count
+}
+
+func (c *cmsEntity) Merge(sources []CMS, weights []int64) {
+	counters := make([]int64, len(c.counters))
+	var count int64
+	for i, source := range sources {
+		src := source.(*cmsEntity)
+		for j, counter := range src.counters {
+			counters[j] += counter * weights[i]
+		}
+		count += src.count * weights[i]
+	}
+	// dest 可能同时是 source，最后统一覆盖
+	c.counters = counters
+	c.count = count
+}
+
+// 以 cms.loadchunk 指令还原完整的二进制状态
+func (c *cmsEntity) ToCmd() [][]byte {
+	return [][]byte{[]byte(database.CmdTypeCMSLoadChunk), []byte(c.key), c.marshal()}
+}
+
+// 二进制格式: width | depth | count | 计数矩阵
+func (c *cmsEntity) marshal() []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, c.width)
+	_ = binary.Write(&buf, binary.LittleEndian, c.depth)
+	_ = binary.Write(&buf, binary.LittleEndian, c.count)
+	_ = binary.Write(&buf, binary.LittleEndian, c.counters)
+	return buf.Bytes()
+}
+
+func unmarshalCMS(key string, data []byte) (CMS, error) {
+	reader := bytes.NewReader(data)
+	c := cmsEntity{key: key}
+	if err := readBinary(reader, &c.width, &c.depth, &c.count); err != nil {
+		return nil, err
+	}
+	size := uint64(c.width) * uint64(c.depth)
+	if size == 0 || size*8 != uint64(reader.Len()) {
+		return nil, errBloomBadData
+	}
+
+	c.counters = make([]int64, size)
+	if err := readBinary(reader, c.counters); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,88 @@
+package datastore
+
+import (
+	"goredis/database"
+	"goredis/handler"
+	"strings"
+)
+
+// 数据编码类型，通过 object encoding 指令对外展示
+const (
+	encodingInt       = "int"
+	encodingEmbstr    = "embstr"
+	encodingRaw       = "raw"
+	encodingQuicklist = "quicklist"
+	encodingIntset    = "intset"
+	encodingListpack  = "listpack"
+	encodingHashtable = "hashtable"
+	encodingSkiplist  = "skiplist"
+	encodingStream    = "stream"
+)
+
+// 紧凑编码阈值配置
+type Thinker interface {
+	SetMaxIntsetEntries() int
+	HashMaxListpackEntries() int
+	HashMaxListpackValue() int
+	ZSetMaxListpackEntries() int
+	ZSetMaxListpackValue() int
+}
+
+type encodingConf struct {
+	setMaxIntsetEntries    int
+	hashMaxListpackEntries int
+	hashMaxListpackValue   int
+	zsetMaxListpackEntries int
+	zsetMaxListpackValue   int
+}
+
+var defaultEncodingConf = &encodingConf{
+	setMaxIntsetEntries:    512,
+	hashMaxListpackEntries: 128,
+	hashMaxListpackValue:   64,
+	zsetMaxListpackEntries: 128,
+	zsetMaxListpackValue:   64,
+}
+
+func newEncodingConf(thinker Thinker) *encodingConf {
+	if thinker == nil {
+		return defaultEncodingConf
+	}
+
+	return &encodingConf{
+		setMaxIntsetEntries:    thinker.SetMaxIntsetEntries(),
+		hashMaxListpackEntries: thinker.HashMaxListpackEntries(),
+		hashMaxListpackValue:   thinker.HashMaxListpackValue(),
+		zsetMaxListpackEntries: thinker.ZSetMaxListpackEntries(),
+		zsetMaxListpackValue:   thinker.ZSetMaxListpackValue(),
+	}
+}
+
+type encoder interface {
+	Encoding() string
+}
+
+func (k *KVStore) Object(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	subCmd := strings.ToLower(string(args[0]))
+	if subCmd != "encoding" {
+		return handler.NewUnknownSubCmdErrReply("object", args[0])
+	}
+
+	key := string(args[1])
+	k.ExpirePreprocess(key)
+	v, ok := k.data[key]
+	if !ok {
+		return handler.NewNillReply()
+	}
+
+	_encoder, ok := v.(encoder)
+	if !ok {
+		return handler.NewNillReply()
+	}
+	return handler.NewBulkReply([]byte(_encoder.Encoding()))
+}
@@ -0,0 +1,112 @@
+package datastore
+
+import (
+	"goredis/database"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cast"
+	"github.com/stretchr/testify/assert"
+)
+
+var testEncodingConf = &encodingConf{
+	setMaxIntsetEntries:    3,
+	hashMaxListpackEntries: 3,
+	hashMaxListpackValue:   8,
+	zsetMaxListpackEntries: 3,
+	zsetMaxListpackValue:   8,
+}
+
+func Test_set_encoding(t *testing.T) {
+	t.Run("intset_to_hashtable_by_entries", func(t *testing.T) {
+		set := newSetEntityWithConf("", testEncodingConf)
+		for i := 3; i > 0; i-- {
+			set.Add(cast.ToString(i))
+		}
+		assert.Equal(t, encodingIntset, set.Encoding())
+		assert.Equal(t, []string{"1", "2", "3"}, set.Members())
+
+		set.Add("4")
+		assert.Equal(t, encodingHashtable, set.Encoding())
+		assert.Equal(t, int64(4), set.Len())
+		assert.Equal(t, int64(1), set.Exist("1"))
+	})
+
+	t.Run("intset_to_hashtable_by_value", func(t *testing.T) {
+		set := newSetEntityWithConf("", testEncodingConf)
+		set.Add("1")
+		set.Add("01")
+		assert.Equal(t, encodingHashtable, set.Encoding())
+		assert.Equal(t, int64(1), set.Exist("1"))
+		assert.Equal(t, int64(1), set.Exist("01"))
+	})
+}
+
+func Test_hashmap_encoding(t *testing.T) {
+	t.Run("listpack_to_hashtable_by_entries", func(t *testing.T) {
+		hmap := newHashMapEntityWithConf("", testEncodingConf)
+		for i := 0; i < 3; i++ {
+			hmap.Put(cast.ToString(i), []byte(cast.ToString(i)))
+		}
+		assert.Equal(t, encodingListpack, hmap.Encoding())
+		hmap.Put("0", []byte("new"))
+		assert.Equal(t, encodingListpack, hmap.Encoding())
+
+		hmap.Put("3", []byte("3"))
+		assert.Equal(t, encodingHashtable, hmap.Encoding())
+		assert.Equal(t, []byte("new"), hmap.Get("0"))
+		assert.Equal(t, []byte("3"), hmap.Get("3"))
+	})
+
+	t.Run("listpack_to_hashtable_by_value", func(t *testing.T) {
+		hmap := newHashMapEntityWithConf("", testEncodingConf)
+		hmap.Put("k", []byte("v"))
+		hmap.Put("k", []byte(strings.Repeat("v", 9)))
+		assert.Equal(t, encodingHashtable, hmap.Encoding())
+		assert.Equal(t, strings.Repeat("v", 9), string(hmap.Get("k")))
+	})
+}
+
+func Test_sorted_set_encoding(t *testing.T) {
+	zset := newSortedSetEntity("", testEncodingConf)
+	zset.Add(3, "c")
+	zset.Add(1, "a")
+	zset.Add(2, "b")
+	assert.Equal(t, encodingListpack, zset.Encoding())
+	assert.Equal(t, []string{"a", "b", "c"}, zset.Range(0, math.Inf(1)))
+
+	zset.Add(0, "c")
+	assert.Equal(t, []string{"c", "a", "b"}, zset.Range(0, math.Inf(1)))
+
+	zset.Add(4, "d")
+	assert.Equal(t, encodingSkiplist, zset.Encoding())
+	members := zset.Range(1, 4)
+	sort.Strings(members)
+	assert.Equal(t, []string{"a", "b", "d"}, members)
+
+	long := newSortedSetEntity("", testEncodingConf)
+	long.Add(1, strings.Repeat("m", 9))
+	assert.Equal(t, encodingSkiplist, long.Encoding())
+}
+
+func Test_object_encoding(t *testing.T) {
+	k, _ := newTestKVStore()
+	k.Set(newTestCmd(database.CmdTypeSet, "int", "100"))
+	k.Set(newTestCmd(database.CmdTypeSet, "str", "abc"))
+	k.SAdd(newTestCmd(database.CmdTypeSAdd, "set", "1", "2"))
+	k.HSet(newTestCmd(database.CmdTypeHSet, "hash", "f", "v"))
+
+	cases := map[string]string{
+		"int":  "$3\r\nint\r\n",
+		"str":  "$6\r\nembstr\r\n",
+		"set":  "$6\r\nintset\r\n",
+		"hash": "$8\r\nlistpack\r\n",
+		"none": "$-1\r\n",
+	}
+	for key, expect := range cases {
+		reply := k.Object(newTestCmd(database.CmdTypeObject, "ENCODING", key))
+		assert.Equal(t, expect, string(reply.ToBytes()))
+	}
+}
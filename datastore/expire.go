@@ -7,9 +7,10 @@ import (
 
 func (k *KVStore) GC() {
 	nowUnix := lib.TimeNow().Unix()
-	for _, expiredKey := range k.expireTimeWheel.Range(0, nowUnix) {
+	for _, expiredKey := range k.expireTimeWheel.Range(0, float64(nowUnix)) {
 		k.expireProcess(expiredKey)
 	}
+	k.trimTimeSeries()
 }
 
 func (k *KVStore) ExpirePreprocess(key string)  {
@@ -29,6 +30,7 @@ func (k *KVStore) expireProcess(key string) {
 	delete(k.expiredAt, key)
 	delete(k.data, key)
 	k.expireTimeWheel.Rem(key)
+	k.reindex(key)
 }
 
 func (k *KVStore) expire(key string, expiredAt time.Time) {
@@ -36,5 +38,5 @@ func (k *KVStore) expire(key string, expiredAt time.Time) {
 		return
 	}
 	k.expiredAt[key] = expiredAt
-	k.expireTimeWheel.Add(expiredAt.Unix(), key)
+	k.expireTimeWheel.Add(float64(expiredAt.Unix()), key)
 }
\ No newline at end of file
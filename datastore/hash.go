@@ -27,30 +27,84 @@ type HashMap interface {
 	Put(key string, value []byte)
 	Get(key string) []byte
 	Del(key string) int64
+	// 遍历所有 field，listpack 编码时按写入顺序
+	ForEach(f func(field string, value []byte))
+	Encoding() string
 	database.CmdAdapter
 }
 
+// field 数量较少且 field/value 较短时，采用 listpack 编码顺序存储；否则转为 hashtable 编码
 type hashMapEntity struct {
-	key  string
-	data map[string][]byte
+	key      string
+	conf     *encodingConf
+	listpack []hashPair
+	data     map[string][]byte
+}
+
+type hashPair struct {
+	field string
+	value []byte
 }
 
 func newHashMapEntity(key string) HashMap {
-	return &hashMapEntity{
+	return newHashMapEntityWithConf(key, defaultEncodingConf)
+}
+
+func newHashMapEntityWithConf(key string, conf *encodingConf) HashMap {
+	h := hashMapEntity{
 		key:  key,
-		data: make(map[string][]byte),
+		conf: conf,
 	}
+	if conf.hashMaxListpackEntries <= 0 {
+		h.data = make(map[string][]byte)
+	}
+	return &h
+}
+
+func (h *hashMapEntity) isListpack() bool {
+	return h.data == nil
 }
 
 func (h *hashMapEntity) Put(key string, value []byte) {
+	if h.isListpack() {
+		if index := h.listpackSearch(key); index >= 0 {
+			if len(value) <= h.conf.hashMaxListpackValue {
+				h.listpack[index].value = value
+				return
+			}
+		} else if len(h.listpack) < h.conf.hashMaxListpackEntries &&
+			len(key) <= h.conf.hashMaxListpackValue && len(value) <= h.conf.hashMaxListpackValue {
+			h.listpack = append(h.listpack, hashPair{field: key, value: value})
+			return
+		}
+		// 超出阈值，转为 hashtable 编码
+		h.convertToHashtable()
+	}
+
 	h.data[key] = value
 }
 
 func (h *hashMapEntity) Get(key string) []byte {
+	if h.isListpack() {
+		if index := h.listpackSearch(key); index >= 0 {
+			return h.listpack[index].value
+		}
+		return nil
+	}
+
 	return h.data[key]
 }
 
 func (h *hashMapEntity) Del(key string) int64 {
+	if h.isListpack() {
+		index := h.listpackSearch(key)
+		if index < 0 {
+			return 0
+		}
+		h.listpack = append(h.listpack[:index], h.listpack[index+1:]...)
+		return 1
+	}
+
 	if _, ok := h.data[key]; !ok {
 		return 0
 	}
@@ -58,7 +112,53 @@ func (h *hashMapEntity) Del(key string) int64 {
 	return 1
 }
 
+func (h *hashMapEntity) ForEach(f func(field string, value []byte)) {
+	if h.isListpack() {
+		for _, pair := range h.listpack {
+			f(pair.field, pair.value)
+		}
+		return
+	}
+
+	for field, value := range h.data {
+		f(field, value)
+	}
+}
+
+func (h *hashMapEntity) Encoding() string {
+	if h.isListpack() {
+		return encodingListpack
+	}
+	return encodingHashtable
+}
+
+func (h *hashMapEntity) listpackSearch(key string) int {
+	for i, pair := range h.listpack {
+		if pair.field == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *hashMapEntity) convertToHashtable() {
+	h.data = make(map[string][]byte, len(h.listpack))
+	for _, pair := range h.listpack {
+		h.data[pair.field] = pair.value
+	}
+	h.listpack = nil
+}
+
 func (h *hashMapEntity) ToCmd() [][]byte {
+	if h.isListpack() {
+		args := make([][]byte, 0, 2+2*len(h.listpack))
+		args = append(args, []byte(database.CmdTypeHSet), []byte(h.key))
+		for _, pair := range h.listpack {
+			args = append(args, []byte(pair.field), pair.value)
+		}
+		return args
+	}
+
 	args := make([][]byte, 0, 2+2*len(h.data))
 	args = append(args, []byte(database.CmdTypeHSet), []byte(h.key))
 	for k, v := range h.data {
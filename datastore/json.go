@@ -0,0 +1,714 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goredis/database"
+	"goredis/handler"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func (k *KVStore) getAsJSON(key string) (JSON, error) {
+	v, ok := k.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	doc, ok := v.(JSON)
+	if !ok {
+		return nil, handler.NewWrongTypeErrReply()
+	}
+
+	return doc, nil
+}
+
+func (k *KVStore) putAsJSON(key string, doc JSON) {
+	k.data[key] = doc
+}
+
+type JSONType int
+
+const (
+	JSONNull JSONType = iota
+	JSONBool
+	JSONNumber
+	JSONString
+	JSONArray
+	JSONObject
+)
+
+// json 值. object 记录 key 的插入顺序，序列化时保持原有顺序
+type JSONValue struct {
+	Type    JSONType
+	Boolean bool
+	// 数字的文本形式
+	Number string
+	Str    string
+	Array  []*JSONValue
+	keys   []string
+	fields map[string]*JSONValue
+}
+
+func newJSONObject() *JSONValue {
+	return &JSONValue{Type: JSONObject, fields: make(map[string]*JSONValue)}
+}
+
+func (v *JSONValue) Field(key string) (*JSONValue, bool) {
+	field, ok := v.fields[key]
+	return field, ok
+}
+
+func (v *JSONValue) SetField(key string, field *JSONValue) {
+	if _, ok := v.fields[key]; !ok {
+		v.keys = append(v.keys, key)
+	}
+	v.fields[key] = field
+}
+
+func (v *JSONValue) DelField(key string) bool {
+	if _, ok := v.fields[key]; !ok {
+		return false
+	}
+
+	delete(v.fields, key)
+	for i, _key := range v.keys {
+		if _key == key {
+			v.keys = append(v.keys[:i], v.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// 类型名称，用于错误提示
+func (v *JSONValue) TypeName() string {
+	switch v.Type {
+	case JSONBool:
+		return "boolean"
+	case JSONNumber:
+		if _, err := strconv.ParseInt(v.Number, 10, 64); err == nil {
+			return "integer"
+		}
+		return "number"
+	case JSONString:
+		return "string"
+	case JSONArray:
+		return "array"
+	case JSONObject:
+		return "object"
+	default:
+		return "null"
+	}
+}
+
+func (v *JSONValue) Clone() *JSONValue {
+	clone := *v
+	switch v.Type {
+	case JSONArray:
+		clone.Array = make([]*JSONValue, 0, len(v.Array))
+		for _, elem := range v.Array {
+			clone.Array = append(clone.Array, elem.Clone())
+		}
+	case JSONObject:
+		clone.keys = append([]string(nil), v.keys...)
+		clone.fields = make(map[string]*JSONValue, len(v.fields))
+		for key, field := range v.fields {
+			clone.fields[key] = field.Clone()
+		}
+	}
+	return &clone
+}
+
+func parseJSON(raw []byte) (*JSONValue, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	v, err := decodeJSONValue(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("ERR invalid json: %s", err.Error())
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, errors.New("ERR invalid json: trailing characters")
+	}
+	return v, nil
+}
+
+func decodeJSONValue(decoder *json.Decoder) (*JSONValue, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case nil:
+		return &JSONValue{Type: JSONNull}, nil
+	case bool:
+		return &JSONValue{Type: JSONBool, Boolean: t}, nil
+	case json.Number:
+		return newJSONNumber(string(t))
+	case string:
+		return &JSONValue{Type: JSONString, Str: t}, nil
+	case json.Delim:
+		if t == '[' {
+			v := JSONValue{Type: JSONArray, Array: []*JSONValue{}}
+			for decoder.More() {
+				elem, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				v.Array = append(v.Array, elem)
+			}
+			_, err = decoder.Token()
+			return &v, err
+		}
+
+		v := newJSONObject()
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			field, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			v.SetField(keyToken.(string), field)
+		}
+		_, err = decoder.Token()
+		return v, err
+	}
+	return nil, fmt.Errorf("unexpected token %v", token)
+}
+
+// 整数保持原样，浮点数统一格式
+func newJSONNumber(raw string) (*JSONValue, error) {
+	if _, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return &JSONValue{Type: JSONNumber, Number: raw}, nil
+	}
+
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("invalid number %s", raw)
+	}
+	return &JSONValue{Type: JSONNumber, Number: formatJSONFloat(f)}, nil
+}
+
+// 浮点数总是带有小数点或指数，与整数区分
+func formatJSONFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEn") {
+		s += ".0"
+	}
+	return s
+}
+
+// 数值累加，两个整数相加且不溢出时结果仍为整数
+func (v *JSONValue) incrBy(by *JSONValue) error {
+	a, errA := strconv.ParseInt(v.Number, 10, 64)
+	b, errB := strconv.ParseInt(by.Number, 10, 64)
+	if errA == nil && errB == nil {
+		sum := a + b
+		if (sum > a) == (b > 0) {
+			v.Number = strconv.FormatInt(sum, 10)
+			return nil
+		}
+	}
+
+	fa, _ := strconv.ParseFloat(v.Number, 64)
+	fb, _ := strconv.ParseFloat(by.Number, 64)
+	sum := fa + fb
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return errors.New("ERR result is not a number")
+	}
+	v.Number = formatJSONFloat(sum)
+	return nil
+}
+
+// 序列化格式. 均为空时输出紧凑格式
+type jsonFormat struct {
+	indent  string
+	newline string
+	space   string
+}
+
+func (v *JSONValue) Marshal(format *jsonFormat) []byte {
+	if format == nil {
+		format = &jsonFormat{}
+	}
+	var buf bytes.Buffer
+	v.write(&buf, format, 0)
+	return buf.Bytes()
+}
+
+func (v *JSONValue) write(buf *bytes.Buffer, format *jsonFormat, level int) {
+	switch v.Type {
+	case JSONNull:
+		buf.WriteString("null")
+	case JSONBool:
+		buf.WriteString(strconv.FormatBool(v.Boolean))
+	case JSONNumber:
+		buf.WriteString(v.Number)
+	case JSONString:
+		writeJSONString(buf, v.Str)
+	case JSONArray:
+		if len(v.Array) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteByte('[')
+		for i, elem := range v.Array {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			format.writeIndent(buf, level+1)
+			elem.write(buf, format, level+1)
+		}
+		format.writeIndent(buf, level)
+		buf.WriteByte(']')
+	case JSONObject:
+		if len(v.keys) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			format.writeIndent(buf, level+1)
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			buf.WriteString(format.space)
+			v.fields[key].write(buf, format, level+1)
+		}
+		format.writeIndent(buf, level)
+		buf.WriteByte('}')
+	}
+}
+
+func (f *jsonFormat) writeIndent(buf *bytes.Buffer, level int) {
+	buf.WriteString(f.newline)
+	for i := 0; i < level; i++ {
+		buf.WriteString(f.indent)
+	}
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+	// Encode 会追加换行符
+	buf.Truncate(buf.Len() - 1)
+}
+
+type jsonSegmentType int
+
+const (
+	jsonSegmentKey jsonSegmentType = iota
+	jsonSegmentIndex
+	jsonSegmentWildcard
+	jsonSegmentSlice
+)
+
+type jsonPathSegment struct {
+	typ jsonSegmentType
+	// .. 递归匹配所有后代节点
+	recursive bool
+	keys      []string
+	indexes   []int
+	// 切片 [start:end]，nil 代表省略
+	start, end *int
+}
+
+// 支持 JSONPath 的子集: $、.key、['key']、[n]、[a,b]、[start:end]、*、..key.
+// 不以 $ 开头的路径为旧版路径，只返回第一个匹配的值
+type JSONPath struct {
+	raw      string
+	legacy   bool
+	segments []jsonPathSegment
+}
+
+func parseJSONPath(raw string) (*JSONPath, error) {
+	path := JSONPath{raw: raw}
+	errInvalid := fmt.Errorf("ERR invalid JSON path '%s'", raw)
+
+	s := raw
+	if strings.HasPrefix(s, "$") {
+		s = s[1:]
+	} else {
+		path.legacy = true
+		if s == "." {
+			s = ""
+		} else if !strings.HasPrefix(s, ".") && !strings.HasPrefix(s, "[") {
+			s = "." + s
+		}
+	}
+
+	for len(s) > 0 {
+		var (
+			segment jsonPathSegment
+			err     error
+		)
+		switch {
+		case strings.HasPrefix(s, ".."):
+			segment.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				segment, s, err = parseJSONPathBracket(s)
+				segment.recursive = true
+			} else {
+				segment, s, err = parseJSONPathName(s)
+				segment.recursive = true
+			}
+		case s[0] == '.':
+			segment, s, err = parseJSONPathName(s[1:])
+		case s[0] == '[':
+			segment, s, err = parseJSONPathBracket(s)
+		default:
+			err = errInvalid
+		}
+		if err != nil {
+			return nil, errInvalid
+		}
+		path.segments = append(path.segments, segment)
+	}
+	return &path, nil
+}
+
+func parseJSONPathName(s string) (jsonPathSegment, string, error) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		end = len(s)
+	}
+	name := s[:end]
+	if name == "" {
+		return jsonPathSegment{}, "", errors.New("empty name")
+	}
+	if name == "*" {
+		return jsonPathSegment{typ: jsonSegmentWildcard}, s[end:], nil
+	}
+	return jsonPathSegment{typ: jsonSegmentKey, keys: []string{name}}, s[end:], nil
+}
+
+func parseJSONPathBracket(s string) (jsonPathSegment, string, error) {
+	// 寻找不在引号内的 ]
+	var quote byte
+	end := -1
+	for i := 1; i < len(s) && end < 0; i++ {
+		switch {
+		case quote != 0 && s[i] == '\\':
+			i++
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote == 0 && (s[i] == '\'' || s[i] == '"'):
+			quote = s[i]
+		case quote == 0 && s[i] == ']':
+			end = i
+		}
+	}
+	if end < 0 {
+		return jsonPathSegment{}, "", errors.New("unclosed bracket")
+	}
+
+	content, rest := strings.TrimSpace(s[1:end]), s[end+1:]
+	if content == "*" {
+		return jsonPathSegment{typ: jsonSegmentWildcard}, rest, nil
+	}
+
+	if content != "" && (content[0] == '\'' || content[0] == '"') {
+		segment := jsonPathSegment{typ: jsonSegmentKey}
+		for _, part := range splitJSONPathUnion(content) {
+			key, err := unquoteJSONPathKey(part)
+			if err != nil {
+				return jsonPathSegment{}, "", err
+			}
+			segment.keys = append(segment.keys, key)
+		}
+		return segment, rest, nil
+	}
+
+	if strings.Contains(content, ":") {
+		parts := strings.Split(content, ":")
+		if len(parts) != 2 {
+			return jsonPathSegment{}, "", errors.New("invalid slice")
+		}
+		segment := jsonPathSegment{typ: jsonSegmentSlice}
+		for i, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return jsonPathSegment{}, "", err
+			}
+			if i == 0 {
+				segment.start = &n
+			} else {
+				segment.end = &n
+			}
+		}
+		return segment, rest, nil
+	}
+
+	segment := jsonPathSegment{typ: jsonSegmentIndex}
+	for _, part := range strings.Split(content, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return jsonPathSegment{}, "", err
+		}
+		segment.indexes = append(segment.indexes, n)
+	}
+	return segment, rest, nil
+}
+
+// 按不在引号内的逗号切分
+func splitJSONPathUnion(s string) []string {
+	var (
+		parts []string
+		quote byte
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0 && s[i] == '\\':
+			i++
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote == 0 && (s[i] == '\'' || s[i] == '"'):
+			quote = s[i]
+		case quote == 0 && s[i] == ',':
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+func unquoteJSONPathKey(s string) (string, error) {
+	if len(s) < 2 || s[0] != s[len(s)-1] || (s[0] != '\'' && s[0] != '"') {
+		return "", errors.New("invalid key")
+	}
+	if s[0] == '"' {
+		return strconv.Unquote(s)
+	}
+	return strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`), nil
+}
+
+func (p *JSONPath) IsRoot() bool {
+	return len(p.segments) == 0
+}
+
+// 路径匹配到的节点. parent 为 nil 代表根节点
+type jsonNode struct {
+	value  *JSONValue
+	parent *JSONValue
+	key    string
+	index  int
+}
+
+func (p *JSONPath) eval(root *JSONValue) []jsonNode {
+	return evalJSONSegments(root, p.segments)
+}
+
+func evalJSONSegments(root *JSONValue, segments []jsonPathSegment) []jsonNode {
+	nodes := []jsonNode{{value: root}}
+	for _, segment := range segments {
+		next := []jsonNode{}
+		for _, node := range nodes {
+			if !segment.recursive {
+				next = append(next, segment.children(node.value)...)
+				continue
+			}
+			walkJSON(node.value, func(v *JSONValue) {
+				next = append(next, segment.children(v)...)
+			})
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// 先序遍历 v 及其所有后代节点
+func walkJSON(v *JSONValue, f func(v *JSONValue)) {
+	f(v)
+	switch v.Type {
+	case JSONArray:
+		for _, elem := range v.Array {
+			walkJSON(elem, f)
+		}
+	case JSONObject:
+		for _, key := range v.keys {
+			walkJSON(v.fields[key], f)
+		}
+	}
+}
+
+func (s *jsonPathSegment) children(v *JSONValue) []jsonNode {
+	var nodes []jsonNode
+	switch s.typ {
+	case jsonSegmentKey:
+		if v.Type != JSONObject {
+			return nil
+		}
+		for _, key := range s.keys {
+			if field, ok := v.fields[key]; ok {
+				nodes = append(nodes, jsonNode{value: field, parent: v, key: key})
+			}
+		}
+	case jsonSegmentWildcard:
+		switch v.Type {
+		case JSONArray:
+			for i, elem := range v.Array {
+				nodes = append(nodes, jsonNode{value: elem, parent: v, index: i})
+			}
+		case JSONObject:
+			for _, key := range v.keys {
+				nodes = append(nodes, jsonNode{value: v.fields[key], parent: v, key: key})
+			}
+		}
+	case jsonSegmentIndex:
+		if v.Type != JSONArray {
+			return nil
+		}
+		for _, index := range s.indexes {
+			if index < 0 {
+				index += len(v.Array)
+			}
+			if index >= 0 && index < len(v.Array) {
+				nodes = append(nodes, jsonNode{value: v.Array[index], parent: v, index: index})
+			}
+		}
+	case jsonSegmentSlice:
+		if v.Type != JSONArray {
+			return nil
+		}
+		start, end := 0, len(v.Array)
+		if s.start != nil {
+			start = normalizeJSONIndex(*s.start, len(v.Array))
+		}
+		if s.end != nil {
+			end = normalizeJSONIndex(*s.end, len(v.Array))
+		}
+		for i := start; i < end; i++ {
+			nodes = append(nodes, jsonNode{value: v.Array[i], parent: v, index: i})
+		}
+	}
+	return nodes
+}
+
+// 负数下标从尾部计算，结果限制在 [0, length] 内
+func normalizeJSONIndex(index, length int) int {
+	if index < 0 {
+		index += length
+	}
+	if index < 0 {
+		return 0
+	}
+	if index > length {
+		return length
+	}
+	return index
+}
+
+type JSON interface {
+	// 返回路径匹配到的值
+	Get(path *JSONPath) []*JSONValue
+	// 写入 value，返回是否有值被写入. 路径最后一段为 key 时可以在已有的 object 中新增字段
+	Set(path *JSONPath, value *JSONValue, nx, xx bool) bool
+	// 删除路径匹配到的值，返回删除的数量. 根节点不可删除，由调用方删除整个 key
+	Del(path *JSONPath) int64
+	database.CmdAdapter
+}
+
+type jsonEntity struct {
+	key  string
+	root *JSONValue
+}
+
+func newJSONEntity(key string, root *JSONValue) JSON {
+	return &jsonEntity{
+		key:  key,
+		root: root,
+	}
+}
+
+func (j *jsonEntity) Get(path *JSONPath) []*JSONValue {
+	nodes := path.eval(j.root)
+	values := make([]*JSONValue, 0, len(nodes))
+	for _, node := range nodes {
+		values = append(values, node.value)
+	}
+	return values
+}
+
+func (j *jsonEntity) Set(path *JSONPath, value *JSONValue, nx, xx bool) bool {
+	nodes := path.eval(j.root)
+	if (nx && len(nodes) > 0) || (xx && len(nodes) == 0) {
+		return false
+	}
+
+	if path.IsRoot() {
+		j.root = value
+		return true
+	}
+
+	// 最后一段为单个 key 时，在所有匹配的父节点中写入或新增该字段
+	last := path.segments[len(path.segments)-1]
+	if last.typ == jsonSegmentKey && !last.recursive && len(last.keys) == 1 {
+		var updated bool
+		for _, parent := range evalJSONSegments(j.root, path.segments[:len(path.segments)-1]) {
+			if parent.value.Type != JSONObject {
+				continue
+			}
+			parent.value.SetField(last.keys[0], value.Clone())
+			updated = true
+		}
+		return updated
+	}
+
+	for _, node := range nodes {
+		node.replace(value.Clone())
+	}
+	return len(nodes) > 0
+}
+
+func (n *jsonNode) replace(value *JSONValue) {
+	if n.parent.Type == JSONObject {
+		n.parent.fields[n.key] = value
+		return
+	}
+	n.parent.Array[n.index] = value
+}
+
+func (j *jsonEntity) Del(path *JSONPath) int64 {
+	var deleted int64
+	for _, node := range path.eval(j.root) {
+		if node.parent == nil {
+			continue
+		}
+		if node.parent.Type == JSONObject {
+			if node.parent.DelField(node.key) {
+				deleted++
+			}
+			continue
+		}
+		// 前面的删除可能导致下标失效，按引用查找数组元素
+		for i, elem := range node.parent.Array {
+			if elem == node.value {
+				node.parent.Array = append(node.parent.Array[:i], node.parent.Array[i+1:]...)
+				deleted++
+				break
+			}
+		}
+	}
+	return deleted
+}
+
+func (j *jsonEntity) ToCmd() [][]byte {
+	return [][]byte{[]byte(database.CmdTypeJSONSet), []byte(j.key), []byte("$"), j.root.Marshal(nil)}
+}
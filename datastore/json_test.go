@@ -0,0 +1,128 @@
+package datastore
+
+import (
+	"goredis/database"
+	"goredis/handler"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_json_path(t *testing.T) {
+	root, err := parseJSON([]byte(`{"a":{"b":[1,2,{"c":3}]},"c":"x","d":[{"c":true},{"e":null}]}`))
+	assert.NoError(t, err)
+	doc := newJSONEntity("", root)
+
+	cases := []struct {
+		path   string
+		expect string
+	}{
+		{"$", `[{"a":{"b":[1,2,{"c":3}]},"c":"x","d":[{"c":true},{"e":null}]}]`},
+		{"$.a.b[0]", `[1]`},
+		{"$.a.b[-1].c", `[3]`},
+		{"$.a.b[0,1]", `[1,2]`},
+		{"$.a.b[1:]", `[2,{"c":3}]`},
+		{"$.a.b[:-1]", `[1,2]`},
+		{"$..c", `["x",3,true]`},
+		{"$.d[*].c", `[true]`},
+		{"$['a']['b'][2]", `[{"c":3}]`},
+		{"$.none", `[]`},
+		{".c", `["x"]`},
+		{"a.b[2]", `[{"c":3}]`},
+	}
+	for _, c := range cases {
+		path, err := parseJSONPath(c.path)
+		assert.NoError(t, err, c.path)
+		values := &JSONValue{Type: JSONArray, Array: doc.Get(path)}
+		assert.Equal(t, c.expect, string(values.Marshal(nil)), c.path)
+	}
+
+	for _, raw := range []string{"$.", "$[", "$[a]", "$.a[1:2:x]"} {
+		_, err := parseJSONPath(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func Test_kv_json_cmds(t *testing.T) {
+	kv, persister := newTestKVStore()
+
+	reply := kv.JSONSet(newTestCmd(database.CmdTypeJSONSet, "doc", ".a", "1"))
+	assert.Equal(t, "-ERR new objects must be created at the root\r\n", string(reply.ToBytes()))
+	reply = kv.JSONSet(newTestCmd(database.CmdTypeJSONSet, "doc", "$", "{\"a\":1", "XX"))
+	assert.Equal(t, "-ERR invalid json: unexpected end of JSON input\r\n", string(reply.ToBytes()))
+	reply = kv.JSONSet(newTestCmd(database.CmdTypeJSONSet, "doc", "$", `{"a":1,"b":[1,"x"],"c":{"a":2.5}}`))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = kv.JSONSet(newTestCmd(database.CmdTypeJSONSet, "doc", "$.d", `"<&>"`, "NX"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = kv.JSONSet(newTestCmd(database.CmdTypeJSONSet, "doc", "$.d", `1`, "NX"))
+	assert.Equal(t, "$-1\r\n", string(reply.ToBytes()))
+	reply = kv.JSONSet(newTestCmd(database.CmdTypeJSONSet, "doc", "$.e", `1`, "XX"))
+	assert.Equal(t, "$-1\r\n", string(reply.ToBytes()))
+	reply = kv.JSONSet(newTestCmd(database.CmdTypeJSONSet, "doc", "$..a", `3`))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	assert.Equal(t, 3, len(persister.cmds))
+
+	reply = kv.JSONGet(newTestCmd(database.CmdTypeJSONGet, "doc"))
+	assert.Equal(t, "$41\r\n{\"a\":3,\"b\":[1,\"x\"],\"c\":{\"a\":3},\"d\":\"<&>\"}\r\n", string(reply.ToBytes()))
+	reply = kv.JSONGet(newTestCmd(database.CmdTypeJSONGet, "doc", "INDENT", "\t", "NEWLINE", "\n", "SPACE", " ", "$.b"))
+	assert.Equal(t, "$20\r\n[\n\t[\n\t\t1,\n\t\t\"x\"\n\t]\n]\r\n", string(reply.ToBytes()))
+	reply = kv.JSONGet(newTestCmd(database.CmdTypeJSONGet, "doc", ".a", "$.c.a"))
+	assert.Equal(t, "$20\r\n{\".a\":3,\"$.c.a\":[3]}\r\n", string(reply.ToBytes()))
+	reply = kv.JSONGet(newTestCmd(database.CmdTypeJSONGet, "doc", ".none"))
+	assert.Equal(t, "-ERR Path '.none' does not exist\r\n", string(reply.ToBytes()))
+	reply = kv.JSONGet(newTestCmd(database.CmdTypeJSONGet, "none"))
+	assert.Equal(t, "$-1\r\n", string(reply.ToBytes()))
+
+	reply = kv.JSONNumIncrBy(newTestCmd(database.CmdTypeJSONNumIncrBy, "doc", ".a", "2"))
+	assert.Equal(t, "$1\r\n5\r\n", string(reply.ToBytes()))
+	reply = kv.JSONNumIncrBy(newTestCmd(database.CmdTypeJSONNumIncrBy, "doc", "$..a", "0.5"))
+	assert.Equal(t, "$9\r\n[5.5,3.5]\r\n", string(reply.ToBytes()))
+	reply = kv.JSONNumIncrBy(newTestCmd(database.CmdTypeJSONNumIncrBy, "doc", ".d", "1"))
+	assert.Equal(t, "-ERR wrong type of path value - expected a number but found string\r\n", string(reply.ToBytes()))
+	reply = kv.JSONNumIncrBy(newTestCmd(database.CmdTypeJSONNumIncrBy, "none", ".a", "1"))
+	assert.Equal(t, "-ERR could not perform this operation on a key that doesn't exist\r\n", string(reply.ToBytes()))
+
+	reply = kv.JSONArrAppend(newTestCmd(database.CmdTypeJSONArrAppend, "doc", ".b", "true", `{"x":[]}`))
+	assert.Equal(t, ":4\r\n", string(reply.ToBytes()))
+	reply = kv.JSONArrAppend(newTestCmd(database.CmdTypeJSONArrAppend, "doc", "$.*", "null"))
+	assert.Equal(t, "*4\r\n$-1\r\n:5\r\n$-1\r\n$-1\r\n", string(reply.ToBytes()))
+	reply = kv.JSONArrAppend(newTestCmd(database.CmdTypeJSONArrAppend, "doc", ".d", "1"))
+	assert.Equal(t, "-ERR wrong type of path value - expected an array but found string\r\n", string(reply.ToBytes()))
+
+	reply = kv.JSONDel(newTestCmd(database.CmdTypeJSONDel, "doc", "$.b[0,1]"))
+	assert.Equal(t, ":2\r\n", string(reply.ToBytes()))
+	reply = kv.JSONDel(newTestCmd(database.CmdTypeJSONDel, "doc", "$.none"))
+	assert.Equal(t, ":0\r\n", string(reply.ToBytes()))
+	reply = kv.JSONGet(newTestCmd(database.CmdTypeJSONGet, "doc", "$.b"))
+	assert.Equal(t, "$22\r\n[[true,{\"x\":[]},null]]\r\n", string(reply.ToBytes()))
+
+	// 重放持久化指令得到相同的文档
+	replay, _ := newTestKVStore()
+	handlers := map[database.CmdType]func(*database.Command) handler.Reply{
+		database.CmdTypeJSONSet:       replay.JSONSet,
+		database.CmdTypeJSONDel:       replay.JSONDel,
+		database.CmdTypeJSONNumIncrBy: replay.JSONNumIncrBy,
+		database.CmdTypeJSONArrAppend: replay.JSONArrAppend,
+	}
+	for _, cmd := range persister.cmds {
+		cmdType := database.CmdType(cmd[0])
+		reply := handlers[cmdType](database.NewCommand(cmdType, cmd[1:]))
+		assert.NotEqual(t, byte('-'), reply.ToBytes()[0], string(reply.ToBytes()))
+	}
+	expect := kv.JSONGet(newTestCmd(database.CmdTypeJSONGet, "doc"))
+	assert.Equal(t, string(expect.ToBytes()), string(replay.JSONGet(newTestCmd(database.CmdTypeJSONGet, "doc")).ToBytes()))
+
+	// 重写后以单条 json.set 还原
+	doc, _ := kv.getAsJSON("doc")
+	cmd := doc.ToCmd()
+	assert.Equal(t, "$", string(cmd[2]))
+	rewrite, _ := newTestKVStore()
+	reply = rewrite.JSONSet(database.NewCommand(database.CmdTypeJSONSet, cmd[1:]))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	assert.Equal(t, string(expect.ToBytes()), string(rewrite.JSONGet(newTestCmd(database.CmdTypeJSONGet, "doc")).ToBytes()))
+
+	reply = kv.JSONDel(newTestCmd(database.CmdTypeJSONDel, "doc"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+	_, ok := kv.data["doc"]
+	assert.False(t, ok)
+}
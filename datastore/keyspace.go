@@ -0,0 +1,22 @@
+package datastore
+
+import "goredis/database"
+
+// 供模块读写自定义类型的数据. 过期处理由执行器在指令执行前完成
+func (k *KVStore) GetEntity(key string) (interface{}, bool) {
+	v, ok := k.data[key]
+	return v, ok
+}
+
+func (k *KVStore) PutEntity(key string, entity database.CmdAdapter) {
+	k.data[key] = entity
+	k.reindex(key)
+}
+
+func (k *KVStore) DelEntity(key string) bool {
+	if _, ok := k.data[key]; !ok {
+		return false
+	}
+	k.removeKey(key)
+	return true
+}
@@ -2,9 +2,13 @@ package datastore
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"goredis/database"
 	"goredis/handler"
 	"goredis/lib"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,23 +19,41 @@ type KVStore struct {
 	expiredAt       map[string]time.Time
 	expireTimeWheel SortedSet
 	persister       handler.Persister
+	encoding        *encodingConf
+	timeSeries      map[string]struct{}
+	indexes         map[string]*searchIndex
 }
 
-func NewKVStore(persister handler.Persister) database.DataStore {
+func NewKVStore(persister handler.Persister, thinker Thinker) database.DataStore {
 	return &KVStore{
 		data:            make(map[string]interface{}),
 		expiredAt:       make(map[string]time.Time),
 		expireTimeWheel: newSkiplist("expireTimeWheel"),
 		persister:       persister,
+		encoding:        newEncodingConf(thinker),
+		timeSeries:      make(map[string]struct{}),
+		indexes:         make(map[string]*searchIndex),
 	}
 }
 
+var errNotInteger = errors.New("ERR value is not an integer or out of range")
+
+// 删除 key 及其过期信息
+func (k *KVStore) removeKey(key string) {
+	delete(k.data, key)
+	if _, ok := k.expiredAt[key]; ok {
+		delete(k.expiredAt, key)
+		k.expireTimeWheel.Rem(key)
+	}
+	k.reindex(key)
+}
+
 func (k *KVStore) Expire(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
 	key := string(args[0])
 	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
-		return handler.NewSyntaxErrReply()
+		return handler.NewErrReply(errNotInteger.Error())
 	}
 
 	if ttl <= 0 {
@@ -77,20 +99,18 @@ func (k *KVStore) Get(cmd *database.Command) handler.Reply {
 
 func (k *KVStore) MGet(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	res := make([][]byte, 0, len(args))
+	res := make([]handler.Reply, 0, len(args))
 	for _, arg := range args {
+		// 与 redis 一致，非字符串类型的 key 同样返回空值
 		v, err := k.getAsString(string(arg))
-		if err != nil {
-			return handler.NewErrReply(err.Error())
-		}
-		if v == nil {
-			res = append(res, []byte("(nil)"))
+		if err != nil || v == nil {
+			res = append(res, handler.NewNillReply())
 			continue
 		}
-		res = append(res, v.Bytes())
+		res = append(res, handler.NewBulkReply(v.Bytes()))
 	}
 
-	return handler.NewMultiBulkReply(res)
+	return handler.NewArrayReply(res)
 }
 
 func (k *KVStore) Set(cmd *database.Command) handler.Reply {
@@ -154,7 +174,7 @@ func (k *KVStore) Set(cmd *database.Command) handler.Reply {
 	if affected > 0 {
 		// 指令持久化
 		k.persister.PersistCmd(cmd.Ctx(), append([][]byte{[]byte(database.CmdTypeSet)}, args...))
-		return handler.NewIntReply(affected)
+		return handler.NewOKReply()
 	}
 
 	return handler.NewNillReply()
@@ -163,7 +183,7 @@ func (k *KVStore) Set(cmd *database.Command) handler.Reply {
 func (k *KVStore) MSet(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
 	if len(args)&1 == 1 {
-		return handler.NewSyntaxErrReply()
+		return handler.NewArgNumErrReply(database.CmdTypeMSet.String())
 	}
 
 	for i := 0; i < len(args); i += 2 {
@@ -171,7 +191,7 @@ func (k *KVStore) MSet(cmd *database.Command) handler.Reply {
 	}
 
 	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd())
-	return handler.NewIntReply(int64(len(args) >> 1))
+	return handler.NewOKReply()
 }
 
 func (k *KVStore) LPush(cmd *database.Command) handler.Reply {
@@ -298,7 +318,12 @@ func (k *KVStore) RPop(cmd *database.Command) handler.Reply {
 		return handler.NewBulkReply(poped[0])
 	}
 
-	return handler.NewMultiBulkReply(poped)
+	// 与 redis 一致，按弹出顺序返回
+	res := make([][]byte, 0, len(poped))
+	for i := len(poped) - 1; i >= 0; i-- {
+		res = append(res, poped[i])
+	}
+	return handler.NewMultiBulkReply(res)
 }
 
 func (k *KVStore) LRange(cmd *database.Command) handler.Reply {
@@ -343,7 +368,7 @@ func (k *KVStore) SAdd(cmd *database.Command) handler.Reply {
 	}
 
 	if set == nil {
-		set = newSetEntity(key)
+		set = newSetEntityWithConf(key, k.encoding)
 		k.putAsSet(key, set)
 	}
 
@@ -392,12 +417,297 @@ func (k *KVStore) SRem(cmd *database.Command) handler.Reply {
 		remed += set.Rem(string(arg))
 	}
 
+	if set.Len() == 0 {
+		k.removeKey(key)
+	}
+
 	if remed > 0 {
 		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
 	}
 	return handler.NewIntReply(remed)
 }
 
+func (k *KVStore) SMembers(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 1 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	set, err := k.getAsSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if set == nil {
+		return handler.NewSetReply([]handler.Reply{})
+	}
+
+	return newSetMembersReply(set.Members())
+}
+
+func (k *KVStore) SCard(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 1 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	set, err := k.getAsSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if set == nil {
+		return handler.NewIntReply(0)
+	}
+
+	return handler.NewIntReply(set.Len())
+}
+
+func (k *KVStore) SInter(cmd *database.Command) handler.Reply {
+	return k.setAlgebra(cmd.Args(), setInter)
+}
+
+func (k *KVStore) SUnion(cmd *database.Command) handler.Reply {
+	return k.setAlgebra(cmd.Args(), setUnion)
+}
+
+func (k *KVStore) SDiff(cmd *database.Command) handler.Reply {
+	return k.setAlgebra(cmd.Args(), setDiff)
+}
+
+func (k *KVStore) setAlgebra(keys [][]byte, algebra func([]Set) []string) handler.Reply {
+	sets, err := k.getAsSets(keys)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	return newSetMembersReply(algebra(sets))
+}
+
+func (k *KVStore) SInterStore(cmd *database.Command) handler.Reply {
+	return k.setAlgebraStore(cmd, setInter)
+}
+
+func (k *KVStore) SUnionStore(cmd *database.Command) handler.Reply {
+	return k.setAlgebraStore(cmd, setUnion)
+}
+
+func (k *KVStore) SDiffStore(cmd *database.Command) handler.Reply {
+	return k.setAlgebraStore(cmd, setDiff)
+}
+
+func (k *KVStore) setAlgebraStore(cmd *database.Command, algebra func([]Set) []string) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	sets, err := k.getAsSets(args[1:])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	// 目标 key 无论原先是何种类型，都会被覆盖
+	dest := string(args[0])
+	members := algebra(sets)
+	k.removeKey(dest)
+	if len(members) > 0 {
+		set := newSetEntityWithConf(dest, k.encoding)
+		for _, member := range members {
+			set.Add(member)
+		}
+		k.putAsSet(dest, set)
+	}
+
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewIntReply(int64(len(members)))
+}
+
+func (k *KVStore) SMove(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	srcKey, destKey, member := string(args[0]), string(args[1]), string(args[2])
+	k.ExpirePreprocess(destKey)
+	src, err := k.getAsSet(srcKey)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	dest, err := k.getAsSet(destKey)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if src == nil || src.Exist(member) == 0 {
+		return handler.NewIntReply(0)
+	}
+
+	if srcKey == destKey {
+		return handler.NewIntReply(1)
+	}
+
+	src.Rem(member)
+	if src.Len() == 0 {
+		k.removeKey(srcKey)
+	}
+
+	if dest == nil {
+		dest = newSetEntityWithConf(destKey, k.encoding)
+		k.putAsSet(destKey, dest)
+	}
+	dest.Add(member)
+
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewIntReply(1)
+}
+
+func (k *KVStore) SPop(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 1 && len(args) != 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	key := string(args[0])
+	cnt := int64(1)
+	if len(args) == 2 {
+		rawCnt, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || rawCnt < 0 {
+			return handler.NewErrReply("ERR value is out of range, must be positive")
+		}
+		cnt = rawCnt
+	}
+
+	set, err := k.getAsSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if set == nil || set.Len() == 0 || cnt == 0 {
+		if len(args) == 1 {
+			return handler.NewNillReply()
+		}
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	poped := set.RandMembers(cnt)
+	for _, member := range poped {
+		set.Rem(member)
+	}
+	if set.Len() == 0 {
+		k.removeKey(key)
+	}
+
+	// 随机结果不可重放，以 srem 指令的形式进行持久化
+	remCmd := make([][]byte, 0, 2+len(poped))
+	remCmd = append(remCmd, []byte(database.CmdTypeSRem), []byte(key))
+	for _, member := range poped {
+		remCmd = append(remCmd, []byte(member))
+	}
+	k.persister.PersistCmd(cmd.Ctx(), remCmd) // 持久化
+
+	if len(args) == 1 {
+		return handler.NewBulkReply([]byte(poped[0]))
+	}
+	return newMembersReply(poped)
+}
+
+func (k *KVStore) SRandMember(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 1 && len(args) != 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var cnt int64 = 1
+	if len(args) == 2 {
+		rawCnt, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return handler.NewErrReply("ERR value is not an integer or out of range")
+		}
+		// 与 redis 一致，负数按绝对值选取，范围为 [-LONG_MAX, LONG_MAX]
+		if rawCnt == math.MinInt64 {
+			return handler.NewErrReply("ERR value is out of range")
+		}
+		cnt = rawCnt
+	}
+
+	set, err := k.getAsSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if len(args) == 1 {
+		if set == nil || set.Len() == 0 {
+			return handler.NewNillReply()
+		}
+		return handler.NewBulkReply([]byte(set.RandMembers(1)[0]))
+	}
+
+	if set == nil || cnt == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	return newMembersReply(set.RandMembers(cnt))
+}
+
+func (k *KVStore) SMIsMember(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	set, err := k.getAsSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	res := make([]handler.Reply, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		if set == nil {
+			res = append(res, handler.NewIntReply(0))
+			continue
+		}
+		res = append(res, handler.NewIntReply(set.Exist(string(arg))))
+	}
+	return handler.NewArrayReply(res)
+}
+
+func (k *KVStore) SInterCard(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil || numKeys <= 0 {
+		return handler.NewErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-1) {
+		return handler.NewErrReply("ERR Number of keys can't be greater than number of args")
+	}
+
+	// 支持 LIMIT
+	var limit int64
+	rest := args[1+numKeys:]
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToLower(string(rest[0])) == "limit":
+		limit, err = strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil || limit < 0 {
+			return handler.NewErrReply("ERR LIMIT can't be negative")
+		}
+	default:
+		return handler.NewSyntaxErrReply()
+	}
+
+	sets, err := k.getAsSets(args[1 : 1+numKeys])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	card := int64(len(setInter(sets)))
+	if limit > 0 && card > limit {
+		card = limit
+	}
+	return handler.NewIntReply(card)
+}
+
 // hash
 func (k *KVStore) HSet(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
@@ -412,7 +722,7 @@ func (k *KVStore) HSet(cmd *database.Command) handler.Reply {
 	}
 
 	if hmap == nil {
-		hmap = newHashMapEntity(key)
+		hmap = newHashMapEntityWithConf(key, k.encoding)
 		k.putAsHashMap(key, hmap)
 	}
 
@@ -421,6 +731,7 @@ func (k *KVStore) HSet(cmd *database.Command) handler.Reply {
 		hvalue := args[i+2]
 		hmap.Put(hkey, hvalue)
 	}
+	k.reindex(key)
 
 	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
 	return handler.NewIntReply(int64((len(args) - 1) >> 1))
@@ -445,6 +756,27 @@ func (k *KVStore) HGet(cmd *database.Command) handler.Reply {
 	return handler.NewNillReply()
 }
 
+// RESP3 下编码为 map 类型
+func (k *KVStore) HGetAll(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 1 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	hmap, err := k.getAsHashMap(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	res := []handler.Reply{}
+	if hmap != nil {
+		hmap.ForEach(func(field string, value []byte) {
+			res = append(res, handler.NewBulkReply([]byte(field)), handler.NewBulkReply(value))
+		})
+	}
+	return handler.NewMapReply(res)
+}
+
 func (k *KVStore) HDel(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
 	key := string(args[0])
@@ -463,6 +795,7 @@ func (k *KVStore) HDel(cmd *database.Command) handler.Reply {
 	}
 
 	if remed > 0 {
+		k.reindex(key)
 		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
 	}
 	return handler.NewIntReply(remed)
@@ -471,24 +804,58 @@ func (k *KVStore) HDel(cmd *database.Command) handler.Reply {
 // sorted set
 func (k *KVStore) ZAdd(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	if len(args)&1 != 1 {
+	key := string(args[0])
+
+	// 支持 NX XX GT LT CH INCR
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		case "ch":
+			ch = true
+		case "incr":
+			incr = true
+		default:
+			break flags
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)&1 == 1 {
 		return handler.NewSyntaxErrReply()
 	}
+	if nx && xx {
+		return handler.NewErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && nx) || (lt && nx) || (gt && lt) {
+		return handler.NewErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return handler.NewErrReply("ERR INCR option supports a single increment-element pair")
+	}
 
-	key := string(args[0])
 	var (
-		scores  = make([]int64, 0, (len(args)-1)>>1)
-		members = make([]string, 0, (len(args)-1)>>1)
+		scores  = make([]float64, 0, len(pairs)>>1)
+		members = make([]string, 0, len(pairs)>>1)
 	)
 
-	for i := 0; i < len(args)-1; i += 2 {
-		score, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := parseScore(string(pairs[i]))
 		if err != nil {
-			return handler.NewSyntaxErrReply()
+			return handler.NewErrReply(err.Error())
 		}
 
 		scores = append(scores, score)
-		members = append(members, string(args[i+2]))
+		members = append(members, string(pairs[i+1]))
 	}
 
 	zset, err := k.getAsSortedSet(key)
@@ -496,33 +863,78 @@ func (k *KVStore) ZAdd(cmd *database.Command) handler.Reply {
 		return handler.NewErrReply(err.Error())
 	}
 
-	if zset == nil {
-		zset = newSkiplist(key)
-		k.putAsSortedSet(key, zset)
+	var (
+		added, changed int64
+		incrScore      *float64
+	)
+	for i := 0; i < len(scores); i++ {
+		score, member := scores[i], members[i]
+		var (
+			curScore float64
+			exist    bool
+		)
+		if zset != nil {
+			curScore, exist = zset.Score(member)
+		}
+
+		if (exist && nx) || (!exist && xx) {
+			continue
+		}
+
+		if incr && exist {
+			score += curScore
+			if math.IsNaN(score) {
+				return handler.NewErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+
+		if exist {
+			// gt lt 只约束已存在 member 的更新
+			if (gt && score <= curScore) || (lt && score >= curScore) {
+				continue
+			}
+			if score != curScore {
+				zset.Add(score, member)
+				changed++
+			}
+		} else {
+			if zset == nil {
+				zset = newSortedSetEntity(key, k.encoding)
+				k.putAsSortedSet(key, zset)
+			}
+			zset.Add(score, member)
+			added++
+		}
+		incrScore = &score
 	}
 
-	for i := 0; i < len(scores); i++ {
-		zset.Add(scores[i], members[i])
+	if added+changed > 0 {
+		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
 	}
 
-	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
-	return handler.NewIntReply(int64(len(scores)))
+	if incr {
+		if incrScore == nil {
+			return handler.NewNillReply()
+		}
+		return handler.NewDoubleReply(*incrScore)
+	}
+
+	if ch {
+		return handler.NewIntReply(added + changed)
+	}
+	return handler.NewIntReply(added)
 }
 
-func (k *KVStore) ZRangeByScore(cmd *database.Command) handler.Reply {
+func (k *KVStore) ZIncrBy(cmd *database.Command) handler.Reply {
 	args := cmd.Args()
-	if len(args) < 3 {
+	if len(args) != 3 {
 		return handler.NewSyntaxErrReply()
 	}
 
-	key := string(args[0])
-	score1, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return handler.NewSyntaxErrReply()
-	}
-	score2, err := strconv.ParseInt(string(args[2]), 10, 64)
+	key, member := string(args[0]), string(args[2])
+	incr, err := parseScore(string(args[1]))
 	if err != nil {
-		return handler.NewSyntaxErrReply()
+		return handler.NewErrReply(err.Error())
 	}
 
 	zset, err := k.getAsSortedSet(key)
@@ -530,42 +942,3546 @@ func (k *KVStore) ZRangeByScore(cmd *database.Command) handler.Reply {
 		return handler.NewErrReply(err.Error())
 	}
 
-	if zset == nil {
-		return handler.NewNillReply()
+	var score float64
+	if zset != nil {
+		score, _ = zset.Score(member)
 	}
-
-	rawRes := zset.Range(score1, score2)
-	if len(rawRes) == 0 {
-		return handler.NewNillReply()
+	score += incr
+	if math.IsNaN(score) {
+		return handler.NewErrReply("ERR resulting score is not a number (NaN)")
 	}
 
-	res := make([][]byte, 0, len(rawRes))
-	for _, item := range rawRes {
-		res = append(res, []byte(item))
+	// 校验通过后再创建，出错时不留下空的 key
+	if zset == nil {
+		zset = newSortedSetEntity(key, k.encoding)
+		k.putAsSortedSet(key, zset)
 	}
+	zset.Add(score, member)
 
-	return handler.NewMultiBulkReply(res)
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewDoubleReply(score)
 }
 
-func (k *KVStore) ZRem(cmd *database.Command) handler.Reply {
-	args := cmd.Args()
-	key := string(args[0])
-	zset, err := k.getAsSortedSet(key)
-	if err != nil {
-		return handler.NewErrReply(err.Error())
-	}
+type zrangeBy int
 
-	if zset == nil {
-		return handler.NewIntReply(0)
-	}
+const (
+	zrangeByRank zrangeBy = iota
+	zrangeByScore
+	zrangeByLex
+)
 
-	var remed int64
-	for _, arg := range args {
-		remed += zset.Rem(string(arg))
+// zrange 系列指令的查询参数
+type zrangeSpec struct {
+	by zrangeBy
+	// 指令中的原始区间参数. 按 score 或字典序倒序查询时，依次为 max、min
+	start, stop   string
+	reverse       bool
+	offset, limit int64
+	withScores    bool
+}
+
+// 解析 zrange 系列指令的区间及可选参数. generic 为 true 时，支持 BYSCORE BYLEX REV
+func parseZRangeSpec(args [][]byte, spec *zrangeSpec, generic bool) error {
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
 	}
 
-	if remed > 0 {
+	spec.start, spec.stop = string(args[0]), string(args[1])
+	spec.limit = -1
+	var hasLimit bool
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(string(args[i])); {
+		case option == "withscores":
+			spec.withScores = true
+		case option == "limit" && i+2 < len(args):
+			offset, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			limit, err := strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			spec.offset, spec.limit = offset, limit
+			hasLimit = true
+			i += 2
+		case generic && option == "byscore":
+			spec.by = zrangeByScore
+		case generic && option == "bylex":
+			spec.by = zrangeByLex
+		case generic && option == "rev":
+			spec.reverse = true
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	if hasLimit && spec.by == zrangeByRank {
+		return errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.by == zrangeByLex {
+		return errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// 按照 spec 查询 zset 中的元素. 先完成区间参数的校验，zset 为 nil 时返回空结果
+func zrangeMembers(zset SortedSet, spec *zrangeSpec) ([]ZMember, error) {
+	switch spec.by {
+	case zrangeByScore:
+		min, max := spec.start, spec.stop
+		if spec.reverse {
+			min, max = max, min
+		}
+		minBorder, err := parseScoreBorder(min)
+		if err != nil {
+			return nil, err
+		}
+		maxBorder, err := parseScoreBorder(max)
+		if err != nil {
+			return nil, err
+		}
+		if zset == nil || spec.offset < 0 {
+			return []ZMember{}, nil
+		}
+		return zset.RangeByScore(minBorder, maxBorder, spec.offset, spec.limit, spec.reverse), nil
+
+	case zrangeByLex:
+		min, max := spec.start, spec.stop
+		if spec.reverse {
+			min, max = max, min
+		}
+		minBorder, err := parseLexBorder(min)
+		if err != nil {
+			return nil, err
+		}
+		maxBorder, err := parseLexBorder(max)
+		if err != nil {
+			return nil, err
+		}
+		if zset == nil || spec.offset < 0 {
+			return []ZMember{}, nil
+		}
+		return zset.RangeByLex(minBorder, maxBorder, spec.offset, spec.limit, spec.reverse), nil
+	}
+
+	start, err := strconv.ParseInt(spec.start, 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	stop, err := strconv.ParseInt(spec.stop, 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if zset == nil {
+		return []ZMember{}, nil
+	}
+
+	// 负数下标代表从尾部开始计数
+	length := zset.Len()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	return zset.RangeByRank(start, stop, spec.reverse), nil
+}
+
+// 带 score 时 RESP3 下编码为 [member, score] 的数组
+func newZMembersReply(zmembers []ZMember, withScores bool) handler.Reply {
+	if len(zmembers) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	if withScores {
+		res := make([]handler.Reply, 0, 2*len(zmembers))
+		for _, zmember := range zmembers {
+			res = append(res, handler.NewBulkReply([]byte(zmember.Member)), handler.NewDoubleReply(zmember.Score))
+		}
+		return handler.NewPairsReply(res)
+	}
+
+	res := make([][]byte, 0, len(zmembers))
+	for _, zmember := range zmembers {
+		res = append(res, []byte(zmember.Member))
+	}
+	return handler.NewMultiBulkReply(res)
+}
+
+func (k *KVStore) zrange(args [][]byte, spec *zrangeSpec, generic bool) handler.Reply {
+	if len(args) < 1 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	if err := parseZRangeSpec(args[1:], spec, generic); err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	zmembers, err := zrangeMembers(zset, spec)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	return newZMembersReply(zmembers, spec.withScores)
+}
+
+func (k *KVStore) ZRange(cmd *database.Command) handler.Reply {
+	return k.zrange(cmd.Args(), &zrangeSpec{}, true)
+}
+
+func (k *KVStore) ZRevRange(cmd *database.Command) handler.Reply {
+	return k.zrange(cmd.Args(), &zrangeSpec{reverse: true}, false)
+}
+
+func (k *KVStore) ZRangeByScore(cmd *database.Command) handler.Reply {
+	return k.zrange(cmd.Args(), &zrangeSpec{by: zrangeByScore}, false)
+}
+
+func (k *KVStore) ZRevRangeByScore(cmd *database.Command) handler.Reply {
+	return k.zrange(cmd.Args(), &zrangeSpec{by: zrangeByScore, reverse: true}, false)
+}
+
+func (k *KVStore) ZRangeByLex(cmd *database.Command) handler.Reply {
+	return k.zrange(cmd.Args(), &zrangeSpec{by: zrangeByLex}, false)
+}
+
+func (k *KVStore) ZRevRangeByLex(cmd *database.Command) handler.Reply {
+	return k.zrange(cmd.Args(), &zrangeSpec{by: zrangeByLex, reverse: true}, false)
+}
+
+func (k *KVStore) ZRank(cmd *database.Command) handler.Reply {
+	return k.zrank(cmd.Args(), false)
+}
+
+func (k *KVStore) ZRevRank(cmd *database.Command) handler.Reply {
+	return k.zrank(cmd.Args(), true)
+}
+
+func (k *KVStore) zrank(args [][]byte, reverse bool) handler.Reply {
+	if len(args) != 2 && len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	withScore := len(args) == 3
+	if withScore && strings.ToLower(string(args[2])) != "withscore" {
+		return handler.NewSyntaxErrReply()
+	}
+
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var (
+		rank  int64
+		exist bool
+	)
+	if zset != nil {
+		rank, exist = zset.Rank(string(args[1]), reverse)
+	}
+
+	if !exist {
+		if withScore {
+			return handler.NewNullMultiBulkReply()
+		}
+		return handler.NewNillReply()
+	}
+
+	if !withScore {
+		return handler.NewIntReply(rank)
+	}
+	score, _ := zset.Score(string(args[1]))
+	return handler.NewArrayReply([]handler.Reply{
+		handler.NewIntReply(rank),
+		handler.NewDoubleReply(score),
+	})
+}
+
+func (k *KVStore) ZScore(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if zset == nil {
+		return handler.NewNillReply()
+	}
+
+	score, ok := zset.Score(string(args[1]))
+	if !ok {
+		return handler.NewNillReply()
+	}
+	return handler.NewDoubleReply(score)
+}
+
+func (k *KVStore) ZMScore(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	res := make([]handler.Reply, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		if zset == nil {
+			res = append(res, handler.NewNillReply())
+			continue
+		}
+		score, ok := zset.Score(string(arg))
+		if !ok {
+			res = append(res, handler.NewNillReply())
+			continue
+		}
+		res = append(res, handler.NewDoubleReply(score))
+	}
+	return handler.NewArrayReply(res)
+}
+
+func (k *KVStore) ZCard(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 1 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if zset == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(zset.Len())
+}
+
+func (k *KVStore) ZCount(cmd *database.Command) handler.Reply {
+	return k.zcount(cmd.Args(), zrangeByScore)
+}
+
+func (k *KVStore) ZLexCount(cmd *database.Command) handler.Reply {
+	return k.zcount(cmd.Args(), zrangeByLex)
+}
+
+func (k *KVStore) zcount(args [][]byte, by zrangeBy) handler.Reply {
+	if len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	zset, err := k.getAsSortedSet(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	spec := zrangeSpec{by: by, start: string(args[1]), stop: string(args[2]), limit: -1}
+	zmembers, err := zrangeMembers(zset, &spec)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	return handler.NewIntReply(int64(len(zmembers)))
+}
+
+func (k *KVStore) ZPopMin(cmd *database.Command) handler.Reply {
+	return k.zpop(cmd, false)
+}
+
+func (k *KVStore) ZPopMax(cmd *database.Command) handler.Reply {
+	return k.zpop(cmd, true)
+}
+
+func (k *KVStore) zpop(cmd *database.Command, max bool) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 1 && len(args) != 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var cnt int64 = 1
+	if len(args) == 2 {
+		rawCnt, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || rawCnt < 0 {
+			return handler.NewErrReply("ERR value is out of range, must be positive")
+		}
+		cnt = rawCnt
+	}
+
+	key := string(args[0])
+	zset, err := k.getAsSortedSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	poped := k.zpopMembers(key, zset, cnt, max)
+	if len(poped) > 0 {
 		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
 	}
-	return handler.NewIntReply(remed)
-}
\ No newline at end of file
+	return newZMembersReply(poped, true)
+}
+
+// 弹出 score 最小(max 为 true 时最大)的 cnt 个元素，zset 为空时删除 key
+func (k *KVStore) zpopMembers(key string, zset SortedSet, cnt int64, max bool) []ZMember {
+	if zset == nil || cnt == 0 {
+		return []ZMember{}
+	}
+
+	poped := zset.RangeByRank(0, cnt-1, max)
+	for _, zmember := range poped {
+		zset.Rem(zmember.Member)
+	}
+	if zset.Len() == 0 {
+		k.removeKey(key)
+	}
+	return poped
+}
+
+// 弹出结果以 zpopmin/zpopmax 指令的形式进行持久化
+func (k *KVStore) persistZPop(ctx context.Context, key string, cnt int, max bool) {
+	popCmd := database.CmdTypeZPopMin
+	if max {
+		popCmd = database.CmdTypeZPopMax
+	}
+	k.persister.PersistCmd(ctx, [][]byte{[]byte(popCmd), []byte(key), []byte(strconv.Itoa(cnt))}) // 持久化
+}
+
+// 阻塞超时时间，单位为秒，支持小数. 0 代表永久阻塞
+func parseBlockTimeout(raw []byte) (time.Duration, error) {
+	timeout, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, errors.New("ERR timeout is not a float or out of range")
+	}
+	if timeout < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	return time.Duration(timeout * float64(time.Second)), nil
+}
+
+func (k *KVStore) BZPopMin(cmd *database.Command) handler.Reply {
+	return k.bzpop(cmd, false)
+}
+
+func (k *KVStore) BZPopMax(cmd *database.Command) handler.Reply {
+	return k.bzpop(cmd, true)
+}
+
+func (k *KVStore) bzpop(cmd *database.Command, max bool) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	timeout, err := parseBlockTimeout(args[len(args)-1])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	keys := make([]string, 0, len(args)-1)
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		keys = append(keys, key)
+		k.ExpirePreprocess(key)
+		zset, err := k.getAsSortedSet(key)
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+
+		poped := k.zpopMembers(key, zset, 1, max)
+		if len(poped) == 0 {
+			continue
+		}
+
+		k.persistZPop(cmd.Ctx(), key, 1, max)
+		return handler.NewMultiBulkReply([][]byte{[]byte(key), []byte(poped[0].Member), []byte(formatScore(poped[0].Score))})
+	}
+
+	// 所有 key 均为空，挂起等待
+	return database.NewBlockedReply(keys, timeout)
+}
+
+func (k *KVStore) ZMPop(cmd *database.Command) handler.Reply {
+	keys, poped, err := k.zmpop(cmd, cmd.Args())
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if keys != nil {
+		return handler.NewNullMultiBulkReply()
+	}
+	return poped
+}
+
+func (k *KVStore) BZMPop(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	timeout, err := parseBlockTimeout(args[0])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	keys, poped, err := k.zmpop(cmd, args[1:])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if keys != nil {
+		return database.NewBlockedReply(keys, timeout)
+	}
+	return poped
+}
+
+// numkeys key [key ...] MIN|MAX [COUNT count]. 所有 key 均为空时返回关注的 keys
+func (k *KVStore) zmpop(cmd *database.Command, args [][]byte) ([]string, handler.Reply, error) {
+	if len(args) < 3 {
+		return nil, nil, handler.NewSyntaxErrReply()
+	}
+
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil || numKeys <= 0 {
+		return nil, nil, errors.New("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-2) {
+		return nil, nil, handler.NewSyntaxErrReply()
+	}
+
+	var max bool
+	switch strings.ToLower(string(args[1+numKeys])) {
+	case "min":
+	case "max":
+		max = true
+	default:
+		return nil, nil, handler.NewSyntaxErrReply()
+	}
+
+	var cnt int64 = 1
+	rest := args[2+numKeys:]
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToLower(string(rest[0])) == "count":
+		cnt, err = strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil || cnt <= 0 {
+			return nil, nil, errors.New("ERR count should be greater than 0")
+		}
+	default:
+		return nil, nil, handler.NewSyntaxErrReply()
+	}
+
+	keys := make([]string, 0, numKeys)
+	for _, arg := range args[1 : 1+numKeys] {
+		key := string(arg)
+		keys = append(keys, key)
+		k.ExpirePreprocess(key)
+		zset, err := k.getAsSortedSet(key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		poped := k.zpopMembers(key, zset, cnt, max)
+		if len(poped) == 0 {
+			continue
+		}
+
+		k.persistZPop(cmd.Ctx(), key, len(poped), max)
+		replies := make([]handler.Reply, 0, len(poped))
+		for _, zmember := range poped {
+			replies = append(replies, handler.NewMultiBulkReply([][]byte{[]byte(zmember.Member), []byte(formatScore(zmember.Score))}))
+		}
+		return nil, handler.NewArrayReply([]handler.Reply{handler.NewBulkReply([]byte(key)), handler.NewArrayReply(replies)}), nil
+	}
+
+	return keys, nil, nil
+}
+
+func (k *KVStore) ZRemRangeByRank(cmd *database.Command) handler.Reply {
+	return k.zremRange(cmd, zrangeByRank)
+}
+
+func (k *KVStore) ZRemRangeByScore(cmd *database.Command) handler.Reply {
+	return k.zremRange(cmd, zrangeByScore)
+}
+
+func (k *KVStore) ZRemRangeByLex(cmd *database.Command) handler.Reply {
+	return k.zremRange(cmd, zrangeByLex)
+}
+
+func (k *KVStore) zremRange(cmd *database.Command, by zrangeBy) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	key := string(args[0])
+	zset, err := k.getAsSortedSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	spec := zrangeSpec{by: by, start: string(args[1]), stop: string(args[2]), limit: -1}
+	zmembers, err := zrangeMembers(zset, &spec)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if len(zmembers) == 0 {
+		return handler.NewIntReply(0)
+	}
+
+	for _, zmember := range zmembers {
+		zset.Rem(zmember.Member)
+	}
+	if zset.Len() == 0 {
+		k.removeKey(key)
+	}
+
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewIntReply(int64(len(zmembers)))
+}
+
+func (k *KVStore) ZRem(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	zset, err := k.getAsSortedSet(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if zset == nil {
+		return handler.NewIntReply(0)
+	}
+
+	var remed int64
+	for _, arg := range args[1:] {
+		remed += zset.Rem(string(arg))
+	}
+
+	if zset.Len() == 0 {
+		k.removeKey(key)
+	}
+
+	if remed > 0 {
+		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	}
+	return handler.NewIntReply(remed)
+}
+
+// 读取聚合运算的数据源. 普通 set 视为所有 member 的 score 均为 1 的有序集合，不存在的 key 返回 nil
+func (k *KVStore) getAsZSetSource(key string) (map[string]float64, error) {
+	k.ExpirePreprocess(key)
+	v, ok := k.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	switch data := v.(type) {
+	case SortedSet:
+		source := make(map[string]float64, data.Len())
+		for _, zmember := range data.RangeByRank(0, data.Len()-1, false) {
+			source[zmember.Member] = zmember.Score
+		}
+		return source, nil
+	case Set:
+		source := make(map[string]float64, data.Len())
+		for _, member := range data.Members() {
+			source[member] = 1
+		}
+		return source, nil
+	}
+	return nil, handler.NewWrongTypeErrReply()
+}
+
+// zunion zinter zdiff 系列指令的参数
+type zsetOpSpec struct {
+	sources    []map[string]float64
+	weights    []float64
+	aggregate  zaggregate
+	withScores bool
+}
+
+// 解析 numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func (k *KVStore) parseZSetOpSpec(cmdType database.CmdType, args [][]byte, weighted, withScores bool) (*zsetOpSpec, error) {
+	if len(args) < 1 {
+		return nil, handler.NewSyntaxErrReply()
+	}
+
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if numKeys <= 0 {
+		return nil, fmt.Errorf("ERR at least 1 input key is needed for '%s' command", cmdType)
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, handler.NewSyntaxErrReply()
+	}
+
+	spec := zsetOpSpec{
+		weights:   make([]float64, numKeys),
+		aggregate: zaggregates["sum"],
+	}
+	for i := range spec.weights {
+		spec.weights[i] = 1
+	}
+
+	for i := 1 + numKeys; i < int64(len(args)); i++ {
+		switch option := strings.ToLower(string(args[i])); {
+		case weighted && option == "weights" && i+numKeys < int64(len(args)):
+			for j := int64(0); j < numKeys; j++ {
+				weight, err := parseScore(string(args[i+1+j]))
+				if err != nil {
+					return nil, errors.New("ERR weight value is not a float")
+				}
+				spec.weights[j] = weight
+			}
+			i += numKeys
+		case weighted && option == "aggregate" && i+1 < int64(len(args)):
+			aggregate, ok := zaggregates[strings.ToLower(string(args[i+1]))]
+			if !ok {
+				return nil, handler.NewSyntaxErrReply()
+			}
+			spec.aggregate = aggregate
+			i++
+		case withScores && option == "withscores":
+			spec.withScores = true
+		default:
+			return nil, handler.NewSyntaxErrReply()
+		}
+	}
+
+	for _, key := range args[1 : 1+numKeys] {
+		source, err := k.getAsZSetSource(string(key))
+		if err != nil {
+			return nil, err
+		}
+		spec.sources = append(spec.sources, source)
+	}
+	return &spec, nil
+}
+
+// 将聚合结果写入目标 key，目标 key 原有的数据会被覆盖
+func (k *KVStore) storeZMembers(dest string, zmembers []ZMember) {
+	k.removeKey(dest)
+	if len(zmembers) == 0 {
+		return
+	}
+
+	zset := newSortedSetEntity(dest, k.encoding)
+	for _, zmember := range zmembers {
+		zset.Add(zmember.Score, zmember.Member)
+	}
+	k.putAsSortedSet(dest, zset)
+}
+
+func (k *KVStore) ZUnion(cmd *database.Command) handler.Reply {
+	return k.zsetOp(cmd, func(spec *zsetOpSpec) []ZMember {
+		return zsetUnion(spec.sources, spec.weights, spec.aggregate)
+	}, true)
+}
+
+func (k *KVStore) ZInter(cmd *database.Command) handler.Reply {
+	return k.zsetOp(cmd, func(spec *zsetOpSpec) []ZMember {
+		return zsetInter(spec.sources, spec.weights, spec.aggregate)
+	}, true)
+}
+
+func (k *KVStore) ZDiff(cmd *database.Command) handler.Reply {
+	return k.zsetOp(cmd, func(spec *zsetOpSpec) []ZMember {
+		return zsetDiff(spec.sources)
+	}, false)
+}
+
+func (k *KVStore) zsetOp(cmd *database.Command, op func(*zsetOpSpec) []ZMember, weighted bool) handler.Reply {
+	spec, err := k.parseZSetOpSpec(cmd.Type(), cmd.Args(), weighted, true)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	return newZMembersReply(op(spec), spec.withScores)
+}
+
+func (k *KVStore) ZUnionStore(cmd *database.Command) handler.Reply {
+	return k.zsetOpStore(cmd, func(spec *zsetOpSpec) []ZMember {
+		return zsetUnion(spec.sources, spec.weights, spec.aggregate)
+	}, true)
+}
+
+func (k *KVStore) ZInterStore(cmd *database.Command) handler.Reply {
+	return k.zsetOpStore(cmd, func(spec *zsetOpSpec) []ZMember {
+		return zsetInter(spec.sources, spec.weights, spec.aggregate)
+	}, true)
+}
+
+func (k *KVStore) ZDiffStore(cmd *database.Command) handler.Reply {
+	return k.zsetOpStore(cmd, func(spec *zsetOpSpec) []ZMember {
+		return zsetDiff(spec.sources)
+	}, false)
+}
+
+func (k *KVStore) zsetOpStore(cmd *database.Command, op func(*zsetOpSpec) []ZMember, weighted bool) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	spec, err := k.parseZSetOpSpec(cmd.Type(), args[1:], weighted, false)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	zmembers := op(spec)
+	k.storeZMembers(string(args[0]), zmembers)
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewIntReply(int64(len(zmembers)))
+}
+
+func (k *KVStore) ZRangeStore(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	spec := zrangeSpec{}
+	if err := parseZRangeSpec(args[2:], &spec, true); err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if spec.withScores {
+		return handler.NewSyntaxErrReply()
+	}
+
+	src := string(args[1])
+	k.ExpirePreprocess(src)
+	zset, err := k.getAsSortedSet(src)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	zmembers, err := zrangeMembers(zset, &spec)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	k.storeZMembers(string(args[0]), zmembers)
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewIntReply(int64(len(zmembers)))
+}
+
+var (
+	errXAddIDTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errXAddIDZero     = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+)
+
+// 生成 xadd 使用的 id. 支持 * 自动生成和 ms-* 自动生成序号
+func nextStreamID(raw string, lastID StreamID) (StreamID, error) {
+	if raw == "*" {
+		ms := uint64(lib.TimeNow().UnixMilli())
+		if ms > lastID.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+		id, ok := lastID.incr()
+		if !ok {
+			return StreamID{}, errXAddIDTooSmall
+		}
+		return id, nil
+	}
+
+	if rawMs, rawSeq, ok := strings.Cut(raw, "-"); ok && rawSeq == "*" {
+		ms, err := strconv.ParseUint(rawMs, 10, 64)
+		if err != nil {
+			return StreamID{}, errInvalidStreamID
+		}
+		if ms < lastID.Ms || (ms == lastID.Ms && lastID.Seq == math.MaxUint64) {
+			return StreamID{}, errXAddIDTooSmall
+		}
+		if ms == lastID.Ms {
+			return StreamID{Ms: ms, Seq: lastID.Seq + 1}, nil
+		}
+		return StreamID{Ms: ms}, nil
+	}
+
+	if raw == "-" || raw == "+" {
+		return StreamID{}, errInvalidStreamID
+	}
+	id, err := parseStreamID(raw, 0)
+	if err != nil {
+		return StreamID{}, err
+	}
+	if id == minStreamID {
+		return StreamID{}, errXAddIDZero
+	}
+	if !lastID.Less(id) {
+		return StreamID{}, errXAddIDTooSmall
+	}
+	return id, nil
+}
+
+type streamTrimSpec struct {
+	byMinID bool
+	approx  bool
+	maxLen  int64
+	minID   StreamID
+	limit   int64
+}
+
+// 解析 MAXLEN|MINID [=|~] threshold [LIMIT count]. 不存在裁剪参数时返回 nil
+func parseStreamTrimSpec(args [][]byte) (*streamTrimSpec, [][]byte, error) {
+	if len(args) == 0 {
+		return nil, args, nil
+	}
+
+	spec := streamTrimSpec{}
+	switch strings.ToLower(string(args[0])) {
+	case "maxlen":
+	case "minid":
+		spec.byMinID = true
+	default:
+		return nil, args, nil
+	}
+
+	args = args[1:]
+	if len(args) > 0 && (string(args[0]) == "=" || string(args[0]) == "~") {
+		spec.approx = string(args[0]) == "~"
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, nil, handler.NewSyntaxErrReply()
+	}
+
+	if spec.byMinID {
+		minID, err := parseStreamID(string(args[0]), 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		spec.minID = minID
+	} else {
+		maxLen, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return nil, nil, errNotInteger
+		}
+		if maxLen < 0 {
+			return nil, nil, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		spec.maxLen = maxLen
+	}
+	args = args[1:]
+
+	// 近似裁剪默认单次最多移除 100 个 chunk
+	if spec.approx {
+		spec.limit = 100 * streamChunkMaxEntries
+	}
+	if len(args) >= 2 && strings.ToLower(string(args[0])) == "limit" {
+		limit, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return nil, nil, errNotInteger
+		}
+		if limit < 0 {
+			return nil, nil, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+		if !spec.approx {
+			return nil, nil, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		spec.limit = limit
+		args = args[2:]
+	}
+	return &spec, args, nil
+}
+
+func trimStream(stream Stream, spec *streamTrimSpec) int64 {
+	if spec.byMinID {
+		return stream.TrimMinID(spec.minID, spec.approx, spec.limit)
+	}
+	return stream.TrimMaxLen(spec.maxLen, spec.approx, spec.limit)
+}
+
+// 裁剪结果与 chunk 分布有关，统一以精确的 xtrim maxlen 指令进行持久化
+func (k *KVStore) persistXTrim(ctx context.Context, key string, stream Stream) {
+	k.persister.PersistCmd(ctx, [][]byte{[]byte(database.CmdTypeXTrim), []byte(key), []byte("maxlen"), []byte(strconv.FormatInt(stream.Len(), 10))}) // 持久化
+}
+
+func newStreamEntriesReply(entries []StreamEntry) handler.Reply {
+	if len(entries) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	replies := make([]handler.Reply, 0, len(entries))
+	for _, entry := range entries {
+		replies = append(replies, newStreamEntryReply(entry))
+	}
+	return handler.NewArrayReply(replies)
+}
+
+func newStreamEntryReply(entry StreamEntry) handler.Reply {
+	return handler.NewArrayReply([]handler.Reply{
+		handler.NewBulkReply([]byte(entry.ID.String())),
+		handler.NewMultiBulkReply(entry.Fields),
+	})
+}
+
+func (k *KVStore) XAdd(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 4 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	key := string(args[0])
+	rest := args[1:]
+	var noMkStream bool
+	if strings.ToLower(string(rest[0])) == "nomkstream" {
+		noMkStream = true
+		rest = rest[1:]
+	}
+
+	spec, rest, err := parseStreamTrimSpec(rest)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if len(rest) < 3 || len(rest)%2 == 0 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil && noMkStream {
+		return handler.NewNillReply()
+	}
+
+	lastID := minStreamID
+	if stream != nil {
+		lastID = stream.LastID()
+	}
+	id, err := nextStreamID(string(rest[0]), lastID)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if stream == nil {
+		stream = newStreamEntity(key)
+		k.putAsStream(key, stream)
+	}
+	stream.Add(id, rest[1:])
+
+	// 自动生成的 id 不可重放，以具体 id 进行持久化
+	addCmd := make([][]byte, 0, 2+len(rest))
+	addCmd = append(addCmd, []byte(database.CmdTypeXAdd), []byte(key), []byte(id.String()))
+	addCmd = append(addCmd, rest[1:]...)
+	k.persister.PersistCmd(cmd.Ctx(), addCmd) // 持久化
+
+	if spec != nil && trimStream(stream, spec) > 0 {
+		k.persistXTrim(cmd.Ctx(), key, stream)
+	}
+	return handler.NewBulkReply([]byte(id.String()))
+}
+
+func (k *KVStore) XTrim(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	spec, rest, err := parseStreamTrimSpec(args[1:])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if spec == nil || len(rest) > 0 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	key := string(args[0])
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewIntReply(0)
+	}
+
+	removed := trimStream(stream, spec)
+	if removed > 0 {
+		k.persistXTrim(cmd.Ctx(), key, stream)
+	}
+	return handler.NewIntReply(removed)
+}
+
+// xrange 的区间边界，支持 ( 开区间. end 省略 seq 时取最大序号
+func parseStreamRangeID(raw string, end bool) (StreamID, error) {
+	var missingSeq uint64
+	if end {
+		missingSeq = math.MaxUint64
+	}
+
+	exclude := strings.HasPrefix(raw, "(")
+	if !exclude {
+		return parseStreamID(raw, missingSeq)
+	}
+
+	id, err := parseStreamID(raw[1:], missingSeq)
+	if err != nil {
+		return StreamID{}, err
+	}
+
+	var ok bool
+	if end {
+		if id, ok = id.decr(); !ok {
+			return StreamID{}, errors.New("ERR invalid end ID for the interval")
+		}
+		return id, nil
+	}
+	if id, ok = id.incr(); !ok {
+		return StreamID{}, errors.New("ERR invalid start ID for the interval")
+	}
+	return id, nil
+}
+
+func (k *KVStore) XRange(cmd *database.Command) handler.Reply {
+	return k.xrange(cmd, false)
+}
+
+func (k *KVStore) XRevRange(cmd *database.Command) handler.Reply {
+	return k.xrange(cmd, true)
+}
+
+func (k *KVStore) xrange(cmd *database.Command, reverse bool) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 3 && len(args) != 5 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	// xrevrange 的参数顺序为 end start
+	rawStart, rawEnd := string(args[1]), string(args[2])
+	if reverse {
+		rawStart, rawEnd = rawEnd, rawStart
+	}
+	start, err := parseStreamRangeID(rawStart, false)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	end, err := parseStreamRangeID(rawEnd, true)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var count int64 = -1
+	if len(args) == 5 {
+		if strings.ToLower(string(args[3])) != "count" {
+			return handler.NewSyntaxErrReply()
+		}
+		if count, err = strconv.ParseInt(string(args[4]), 10, 64); err != nil {
+			return handler.NewErrReply(errNotInteger.Error())
+		}
+		if count <= 0 {
+			return handler.NewEmptyMultiBulkReply()
+		}
+	}
+
+	stream, err := k.getAsStream(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	return newStreamEntriesReply(stream.Range(start, end, count, reverse))
+}
+
+func (k *KVStore) XLen(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 1 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	stream, err := k.getAsStream(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(stream.Len())
+}
+
+func (k *KVStore) XDel(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	ids := make([]StreamID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, err := parseStreamID(string(arg), 0)
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		ids = append(ids, id)
+	}
+
+	stream, err := k.getAsStream(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewIntReply(0)
+	}
+
+	var deleted int64
+	for _, id := range ids {
+		if stream.Del(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	}
+	return handler.NewIntReply(deleted)
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func (k *KVStore) XRead(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+
+	var (
+		count    int64
+		block    bool
+		timeout  time.Duration
+		streamsI = -1
+	)
+	for i := 0; i < len(args) && streamsI < 0; i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "count":
+			if i+1 >= len(args) {
+				return handler.NewSyntaxErrReply()
+			}
+			i++
+			rawCount, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return handler.NewErrReply(errNotInteger.Error())
+			}
+			count = rawCount
+		case "block":
+			if i+1 >= len(args) {
+				return handler.NewSyntaxErrReply()
+			}
+			i++
+			ms, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return handler.NewErrReply("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return handler.NewErrReply("ERR timeout is negative")
+			}
+			block = true
+			timeout = time.Duration(ms) * time.Millisecond
+		case "streams":
+			streamsI = i
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+	if streamsI < 0 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	streams := args[streamsI+1:]
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		return handler.NewErrReply("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+
+	numKeys := len(streams) / 2
+	keys := make([]string, 0, numKeys)
+	replies := []handler.Reply{}
+	for i := 0; i < numKeys; i++ {
+		key := string(streams[i])
+		keys = append(keys, key)
+		k.ExpirePreprocess(key)
+		stream, err := k.getAsStream(key)
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+
+		var id StreamID
+		if rawID := string(streams[numKeys+i]); rawID == "$" {
+			if stream != nil {
+				id = stream.LastID()
+			}
+			// 阻塞时将 $ 替换为具体 id，被唤醒重新执行时只读取新到达的消息
+			if block {
+				streams[numKeys+i] = []byte(id.String())
+			}
+		} else if id, err = parseStreamID(rawID, 0); err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+
+		if stream == nil {
+			continue
+		}
+		start, ok := id.incr()
+		if !ok {
+			continue
+		}
+		entries := stream.Range(start, maxStreamID, count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		replies = append(replies, handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte(key)),
+			newStreamEntriesReply(entries),
+		}))
+	}
+
+	if len(replies) > 0 {
+		return handler.NewArrayReply(replies)
+	}
+	if block {
+		return database.NewBlockedReply(keys, timeout)
+	}
+	return handler.NewNullMultiBulkReply()
+}
+
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func (k *KVStore) XSetID(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 2 && len(args) != 4 && len(args) != 6 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	lastID, err := parseStreamID(string(args[1]), 0)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	key := string(args[0])
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewErrReply("ERR no such key")
+	}
+
+	entriesAdded, maxDeletedID := stream.EntriesAdded(), stream.MaxDeletedID()
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToLower(string(args[i])) {
+		case "entriesadded":
+			if entriesAdded, err = strconv.ParseInt(string(args[i+1]), 10, 64); err != nil || entriesAdded < 0 {
+				return handler.NewErrReply("ERR entries_added must be positive")
+			}
+		case "maxdeletedid":
+			if maxDeletedID, err = parseStreamID(string(args[i+1]), 0); err != nil {
+				return handler.NewErrReply(err.Error())
+			}
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	if top := stream.Range(minStreamID, maxStreamID, 1, true); len(top) > 0 && lastID.Less(top[0].ID) {
+		return handler.NewErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	if entriesAdded < stream.Len() {
+		return handler.NewErrReply("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if lastID.Less(maxDeletedID) {
+		return handler.NewErrReply("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+	}
+
+	stream.SetID(lastID, entriesAdded, maxDeletedID)
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+func errNoGroup(key, group string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+var errXGroupKeyNotExist = errors.New("ERR The XGROUP subcommand requires the key to exist. " +
+	"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+// 获取 stream 及其消费组，二者任一不存在时返回 NOGROUP 错误
+func (k *KVStore) getStreamGroup(key, group string) (Stream, *StreamGroup, error) {
+	k.ExpirePreprocess(key)
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stream == nil || stream.Group(group) == nil {
+		return nil, nil, errNoGroup(key, group)
+	}
+	return stream, stream.Group(group), nil
+}
+
+// 消费组当前读取位置的持久化. 还原 last delivered id 与已读数量
+func (k *KVStore) persistXGroupSetID(ctx context.Context, key string, group *StreamGroup) {
+	k.persister.PersistCmd(ctx, [][]byte{
+		[]byte(database.CmdTypeXGroup), []byte("setid"), []byte(key), []byte(group.Name), []byte(group.LastID.String()),
+		[]byte("entriesread"), []byte(strconv.FormatInt(group.EntriesRead, 10)),
+	}) // 持久化
+}
+
+// 获取消费者，不存在时创建并持久化
+func (k *KVStore) streamConsumer(ctx context.Context, key string, group *StreamGroup, name string) *StreamConsumer {
+	now := lib.TimeNow()
+	consumer, created := group.CreateConsumer(name, now)
+	if created {
+		k.persister.PersistCmd(ctx, [][]byte{[]byte(database.CmdTypeXGroup), []byte("createconsumer"), []byte(key), []byte(group.Name), []byte(name)}) // 持久化
+	}
+	consumer.SeenTime = now
+	return consumer
+}
+
+func (k *KVStore) XGroup(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	switch strings.ToLower(string(args[0])) {
+	case "create":
+		return k.xgroupCreate(cmd, args[1:])
+	case "setid":
+		return k.xgroupSetID(cmd, args[1:])
+	case "destroy":
+		return k.xgroupDestroy(cmd, args[1:])
+	case "createconsumer":
+		return k.xgroupCreateConsumer(cmd, args[1:])
+	case "delconsumer":
+		return k.xgroupDelConsumer(cmd, args[1:])
+	default:
+		return handler.NewUnknownSubCmdErrReply("xgroup", args[0])
+	}
+}
+
+// 解析 ENTRIESREAD 参数，未设置时返回 false
+func parseEntriesRead(args [][]byte) (int64, bool, error) {
+	if len(args) != 2 || strings.ToLower(string(args[0])) != "entriesread" {
+		return 0, false, handler.NewSyntaxErrReply()
+	}
+
+	entriesRead, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || entriesRead < -1 {
+		return 0, false, errors.New("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return entriesRead, true, nil
+}
+
+// 解析消费组的读取位置，$ 代表 stream 的 last id. 未指定 ENTRIESREAD 时推断已读数量
+func parseGroupID(raw string, stream Stream) (StreamID, int64, error) {
+	if raw == "$" {
+		if stream == nil {
+			return minStreamID, 0, nil
+		}
+		return stream.LastID(), stream.EntriesAdded(), nil
+	}
+
+	id, err := parseStreamID(raw, 0)
+	if err != nil {
+		return StreamID{}, 0, err
+	}
+	if id == minStreamID {
+		return id, 0, nil
+	}
+	return id, -1, nil
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+func (k *KVStore) xgroupCreate(cmd *database.Command, args [][]byte) handler.Reply {
+	if len(args) < 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var (
+		mkStream       bool
+		entriesRead    int64
+		hasEntriesRead bool
+		err            error
+	)
+	opts := args[3:]
+	if len(opts) > 0 && strings.ToLower(string(opts[0])) == "mkstream" {
+		mkStream = true
+		opts = opts[1:]
+	}
+	if len(opts) > 0 {
+		if entriesRead, hasEntriesRead, err = parseEntriesRead(opts); err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+	}
+
+	key, name := string(args[0]), string(args[1])
+	k.ExpirePreprocess(key)
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil && !mkStream {
+		return handler.NewErrReply(errXGroupKeyNotExist.Error())
+	}
+	if stream != nil && stream.Group(name) != nil {
+		return handler.NewErrReply("BUSYGROUP Consumer Group name already exists")
+	}
+
+	id, inferred, err := parseGroupID(string(args[2]), stream)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if !hasEntriesRead {
+		entriesRead = inferred
+	}
+
+	if stream == nil {
+		stream = newStreamEntity(key)
+		k.putAsStream(key, stream)
+	}
+	stream.CreateGroup(name, id, entriesRead)
+
+	// $ 不可重放，以具体 id 进行持久化
+	createCmd := [][]byte{[]byte(database.CmdTypeXGroup), []byte("create"), []byte(key), []byte(name), []byte(id.String())}
+	if mkStream {
+		createCmd = append(createCmd, []byte("mkstream"))
+	}
+	createCmd = append(createCmd, []byte("entriesread"), []byte(strconv.FormatInt(entriesRead, 10)))
+	k.persister.PersistCmd(cmd.Ctx(), createCmd) // 持久化
+	return handler.NewOKReply()
+}
+
+// XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+func (k *KVStore) xgroupSetID(cmd *database.Command, args [][]byte) handler.Reply {
+	if len(args) != 3 && len(args) != 5 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var (
+		entriesRead    int64
+		hasEntriesRead bool
+		err            error
+	)
+	if len(args) == 5 {
+		if entriesRead, hasEntriesRead, err = parseEntriesRead(args[3:]); err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+	}
+
+	key, name := string(args[0]), string(args[1])
+	k.ExpirePreprocess(key)
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewErrReply(errXGroupKeyNotExist.Error())
+	}
+	group := stream.Group(name)
+	if group == nil {
+		return handler.NewErrReply(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", name, key))
+	}
+
+	id, inferred, err := parseGroupID(string(args[2]), stream)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if !hasEntriesRead {
+		entriesRead = inferred
+	}
+
+	group.LastID, group.EntriesRead = id, entriesRead
+	k.persistXGroupSetID(cmd.Ctx(), key, group)
+	return handler.NewOKReply()
+}
+
+// XGROUP DESTROY key group
+func (k *KVStore) xgroupDestroy(cmd *database.Command, args [][]byte) handler.Reply {
+	if len(args) != 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	key := string(args[0])
+	k.ExpirePreprocess(key)
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil {
+		return handler.NewErrReply(errXGroupKeyNotExist.Error())
+	}
+
+	if !stream.DestroyGroup(string(args[1])) {
+		return handler.NewIntReply(0)
+	}
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewIntReply(1)
+}
+
+// XGROUP CREATECONSUMER key group consumer
+func (k *KVStore) xgroupCreateConsumer(cmd *database.Command, args [][]byte) handler.Reply {
+	if len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	_, group, err := k.getStreamGroup(string(args[0]), string(args[1]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if _, created := group.CreateConsumer(string(args[2]), lib.TimeNow()); !created {
+		return handler.NewIntReply(0)
+	}
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewIntReply(1)
+}
+
+// XGROUP DELCONSUMER key group consumer
+func (k *KVStore) xgroupDelConsumer(cmd *database.Command, args [][]byte) handler.Reply {
+	if len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	_, group, err := k.getStreamGroup(string(args[0]), string(args[1]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	pending, ok := group.DelConsumer(string(args[2]))
+	if ok {
+		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	}
+	return handler.NewIntReply(pending)
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func (k *KVStore) XReadGroup(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 6 || strings.ToLower(string(args[0])) != "group" {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var (
+		groupName    = string(args[1])
+		consumerName = string(args[2])
+		count        int64
+		block        bool
+		noAck        bool
+		timeout      time.Duration
+		streamsI     = -1
+	)
+	for i := 3; i < len(args) && streamsI < 0; i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "count":
+			if i+1 >= len(args) {
+				return handler.NewSyntaxErrReply()
+			}
+			i++
+			rawCount, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return handler.NewErrReply(errNotInteger.Error())
+			}
+			count = rawCount
+		case "block":
+			if i+1 >= len(args) {
+				return handler.NewSyntaxErrReply()
+			}
+			i++
+			ms, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return handler.NewErrReply("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return handler.NewErrReply("ERR timeout is negative")
+			}
+			block = true
+			timeout = time.Duration(ms) * time.Millisecond
+		case "noack":
+			noAck = true
+		case "streams":
+			streamsI = i
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+	if streamsI < 0 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	streams := args[streamsI+1:]
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		return handler.NewErrReply("ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.")
+	}
+
+	// 先校验所有的 stream 和消费组，避免部分投递后报错
+	numKeys := len(streams) / 2
+	keys := make([]string, 0, numKeys)
+	groups := make([]*StreamGroup, 0, numKeys)
+	for i := 0; i < numKeys; i++ {
+		key := string(streams[i])
+		_, group, err := k.getStreamGroup(key, groupName)
+		if err != nil {
+			return handler.NewErrReply(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, groupName))
+		}
+		keys = append(keys, key)
+		groups = append(groups, group)
+	}
+
+	var history bool
+	replies := []handler.Reply{}
+	for i, key := range keys {
+		stream, _ := k.getAsStream(key)
+		group := groups[i]
+		consumer := k.streamConsumer(cmd.Ctx(), key, group, consumerName)
+
+		rawID := string(streams[numKeys+i])
+		if rawID == ">" {
+			entries := k.xreadGroupNew(cmd.Ctx(), key, stream, group, consumer, count, noAck)
+			if len(entries) > 0 {
+				replies = append(replies, handler.NewArrayReply([]handler.Reply{handler.NewBulkReply([]byte(key)), newStreamEntriesReply(entries)}))
+			}
+			continue
+		}
+
+		id, err := parseStreamID(rawID, 0)
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		history = true
+		replies = append(replies, handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte(key)),
+			k.xreadGroupHistory(cmd.Ctx(), key, stream, group, consumer, id, count),
+		}))
+	}
+
+	if len(replies) > 0 {
+		return handler.NewArrayReply(replies)
+	}
+	// 只有读取新消息时才会阻塞
+	if block && !history {
+		return database.NewBlockedReply(keys, timeout)
+	}
+	return handler.NewNullMultiBulkReply()
+}
+
+// 投递消费组尚未读取的新消息
+func (k *KVStore) xreadGroupNew(ctx context.Context, key string, stream Stream, group *StreamGroup,
+	consumer *StreamConsumer, count int64, noAck bool) []StreamEntry {
+	start, ok := group.LastID.incr()
+	if !ok {
+		return nil
+	}
+	entries := stream.Range(start, maxStreamID, count, false)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	now := lib.TimeNow()
+	for _, entry := range entries {
+		group.LastID = entry.ID
+		if noAck {
+			continue
+		}
+		nack := group.Claim(entry.ID, consumer)
+		nack.DeliveryTime, nack.DeliveryCount = now, 1
+		k.persister.PersistCmd(ctx, xclaimCmd(key, group.Name, nack)) // 持久化
+	}
+
+	if group.LastID == stream.LastID() {
+		group.EntriesRead = stream.EntriesAdded()
+	} else if group.EntriesRead >= 0 {
+		group.EntriesRead += int64(len(entries))
+	}
+	k.persistXGroupSetID(ctx, key, group)
+	consumer.ActiveTime = now
+	return entries
+}
+
+// 重新投递消费者名下 id 之后未确认的消息，已被删除的消息内容为空
+func (k *KVStore) xreadGroupHistory(ctx context.Context, key string, stream Stream, group *StreamGroup,
+	consumer *StreamConsumer, id StreamID, count int64) handler.Reply {
+	start, ok := id.incr()
+	if !ok {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	nacks := group.PendingRange(start, maxStreamID, count, consumer)
+	if len(nacks) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	now := lib.TimeNow()
+	replies := make([]handler.Reply, 0, len(nacks))
+	for _, nack := range nacks {
+		nack.DeliveryTime = now
+		nack.DeliveryCount++
+		k.persister.PersistCmd(ctx, xclaimCmd(key, group.Name, nack)) // 持久化
+
+		entry, ok := stream.Get(nack.ID)
+		if !ok {
+			replies = append(replies, handler.NewArrayReply([]handler.Reply{handler.NewBulkReply([]byte(nack.ID.String())), handler.NewNullMultiBulkReply()}))
+			continue
+		}
+		replies = append(replies, newStreamEntryReply(entry))
+	}
+	return handler.NewArrayReply(replies)
+}
+
+// XACK key group id [id ...]
+func (k *KVStore) XAck(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	ids := make([]StreamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(string(arg), 0)
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		ids = append(ids, id)
+	}
+
+	stream, err := k.getAsStream(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if stream == nil || stream.Group(string(args[1])) == nil {
+		return handler.NewIntReply(0)
+	}
+
+	group := stream.Group(string(args[1]))
+	var acked int64
+	for _, id := range ids {
+		if group.Ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	}
+	return handler.NewIntReply(acked)
+}
+
+func idleMillis(now, t time.Time) int64 {
+	if idle := now.Sub(t).Milliseconds(); idle > 0 {
+		return idle
+	}
+	return 0
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func (k *KVStore) XPending(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 || (len(args) > 2 && len(args) < 5) {
+		return handler.NewSyntaxErrReply()
+	}
+
+	key := string(args[0])
+	_, group, err := k.getStreamGroup(key, string(args[1]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	// 概要形式: 未确认消息数、最小 id、最大 id 以及各消费者的未确认消息数
+	if len(args) == 2 {
+		nacks := group.PendingRange(minStreamID, maxStreamID, 0, nil)
+		if len(nacks) == 0 {
+			return handler.NewArrayReply([]handler.Reply{
+				handler.NewIntReply(0), handler.NewNillReply(), handler.NewNillReply(), handler.NewNullMultiBulkReply(),
+			})
+		}
+
+		consumers := []handler.Reply{}
+		for _, consumer := range group.Consumers() {
+			if consumer.Pending() == 0 {
+				continue
+			}
+			consumers = append(consumers, handler.NewMultiBulkReply([][]byte{
+				[]byte(consumer.Name), []byte(strconv.FormatInt(consumer.Pending(), 10)),
+			}))
+		}
+		return handler.NewArrayReply([]handler.Reply{
+			handler.NewIntReply(int64(len(nacks))),
+			handler.NewBulkReply([]byte(nacks[0].ID.String())),
+			handler.NewBulkReply([]byte(nacks[len(nacks)-1].ID.String())),
+			handler.NewArrayReply(consumers),
+		})
+	}
+
+	var minIdle int64
+	rest := args[2:]
+	if strings.ToLower(string(rest[0])) == "idle" {
+		if minIdle, err = strconv.ParseInt(string(rest[1]), 10, 64); err != nil {
+			return handler.NewErrReply(errNotInteger.Error())
+		}
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	start, err := parseStreamRangeID(string(rest[0]), false)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	end, err := parseStreamRangeID(string(rest[1]), true)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	count, err := strconv.ParseInt(string(rest[2]), 10, 64)
+	if err != nil {
+		return handler.NewErrReply(errNotInteger.Error())
+	}
+	if count <= 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	var consumer *StreamConsumer
+	if len(rest) == 4 {
+		if consumer = group.Consumer(string(rest[3])); consumer == nil {
+			return handler.NewEmptyMultiBulkReply()
+		}
+	}
+
+	now := lib.TimeNow()
+	replies := []handler.Reply{}
+	for _, nack := range group.PendingRange(start, end, 0, consumer) {
+		if int64(len(replies)) >= count {
+			break
+		}
+		idle := idleMillis(now, nack.DeliveryTime)
+		if idle < minIdle {
+			continue
+		}
+		replies = append(replies, handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte(nack.ID.String())),
+			handler.NewBulkReply([]byte(nack.Consumer.Name)),
+			handler.NewIntReply(idle),
+			handler.NewIntReply(nack.DeliveryCount),
+		}))
+	}
+	if len(replies) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	return handler.NewArrayReply(replies)
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func (k *KVStore) XClaim(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 5 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	minIdle, err := strconv.ParseInt(string(args[3]), 10, 64)
+	if err != nil || minIdle < 0 {
+		return handler.NewErrReply("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+
+	ids := []StreamID{}
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(string(args[i]), 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return handler.NewErrReply(errInvalidStreamID.Error())
+	}
+
+	var (
+		now          = lib.TimeNow()
+		deliveryTime = now
+		retryCount   = int64(-1)
+		force        bool
+		justID       bool
+		lastID       *StreamID
+	)
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		switch opt {
+		case "force":
+			force = true
+			continue
+		case "justid":
+			justID = true
+			continue
+		case "idle", "time", "retrycount", "lastid":
+		default:
+			return handler.NewErrReply(fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i]))
+		}
+
+		if i+1 >= len(args) {
+			return handler.NewSyntaxErrReply()
+		}
+		i++
+		if opt == "lastid" {
+			id, err := parseStreamID(string(args[i]), 0)
+			if err != nil {
+				return handler.NewErrReply(err.Error())
+			}
+			lastID = &id
+			continue
+		}
+
+		val, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil {
+			return handler.NewErrReply(errNotInteger.Error())
+		}
+		switch opt {
+		case "idle":
+			deliveryTime = now.Add(-time.Duration(val) * time.Millisecond)
+		case "time":
+			deliveryTime = time.UnixMilli(val)
+		case "retrycount":
+			retryCount = val
+		}
+	}
+	if deliveryTime.After(now) {
+		deliveryTime = now
+	}
+
+	key, groupName := string(args[0]), string(args[1])
+	stream, group, err := k.getStreamGroup(key, groupName)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if lastID != nil && group.LastID.Less(*lastID) {
+		group.LastID = *lastID
+		k.persistXGroupSetID(cmd.Ctx(), key, group)
+	}
+
+	consumer := k.streamConsumer(cmd.Ctx(), key, group, string(args[2]))
+	replies := []handler.Reply{}
+	for _, id := range ids {
+		nack := group.NACK(id)
+		entry, exists := stream.Get(id)
+		if nack == nil && (!force || !exists) {
+			continue
+		}
+		// 消息已被删除，从 pel 中移除
+		if nack != nil && !exists {
+			group.Ack(id)
+			k.persister.PersistCmd(cmd.Ctx(), [][]byte{[]byte(database.CmdTypeXAck), []byte(key), []byte(groupName), []byte(id.String())}) // 持久化
+			continue
+		}
+		if nack != nil && idleMillis(now, nack.DeliveryTime) < minIdle {
+			continue
+		}
+
+		if nack == nil {
+			nack = group.Claim(id, consumer)
+			nack.DeliveryCount = 1
+		} else {
+			group.Claim(id, consumer)
+		}
+		nack.DeliveryTime = deliveryTime
+		if retryCount >= 0 {
+			nack.DeliveryCount = retryCount
+		} else if !justID {
+			nack.DeliveryCount++
+		}
+		consumer.ActiveTime = now
+		k.persister.PersistCmd(cmd.Ctx(), xclaimCmd(key, groupName, nack)) // 持久化
+
+		if justID {
+			replies = append(replies, handler.NewBulkReply([]byte(id.String())))
+		} else {
+			replies = append(replies, newStreamEntryReply(entry))
+		}
+	}
+
+	if len(replies) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	return handler.NewArrayReply(replies)
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func (k *KVStore) XAutoClaim(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 5 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	minIdle, err := strconv.ParseInt(string(args[3]), 10, 64)
+	if err != nil || minIdle < 0 {
+		return handler.NewErrReply("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, err := parseStreamRangeID(string(args[4]), false)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var (
+		count  int64 = 100
+		justID bool
+	)
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "justid":
+			justID = true
+		case "count":
+			if i+1 >= len(args) {
+				return handler.NewSyntaxErrReply()
+			}
+			i++
+			if count, err = strconv.ParseInt(string(args[i]), 10, 64); err != nil || count < 1 || count > math.MaxInt64/10 {
+				return handler.NewErrReply("ERR COUNT must be > 0")
+			}
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	key, groupName := string(args[0]), string(args[1])
+	stream, group, err := k.getStreamGroup(key, groupName)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	now := lib.TimeNow()
+	consumer := k.streamConsumer(cmd.Ctx(), key, group, string(args[2]))
+
+	// 最多扫描 count 的 10 倍条 pel，返回下一次扫描的起始 id
+	attempts := count * 10
+	next := minStreamID
+	claimed, deleted := []handler.Reply{}, [][]byte{}
+	for _, nack := range group.PendingRange(start, maxStreamID, 0, nil) {
+		if attempts == 0 || count == 0 {
+			next = nack.ID
+			break
+		}
+		attempts--
+
+		entry, exists := stream.Get(nack.ID)
+		if !exists {
+			group.Ack(nack.ID)
+			deleted = append(deleted, []byte(nack.ID.String()))
+			k.persister.PersistCmd(cmd.Ctx(), [][]byte{[]byte(database.CmdTypeXAck), []byte(key), []byte(groupName), []byte(nack.ID.String())}) // 持久化
+			continue
+		}
+		if idleMillis(now, nack.DeliveryTime) < minIdle {
+			continue
+		}
+
+		group.Claim(nack.ID, consumer)
+		nack.DeliveryTime = now
+		if !justID {
+			nack.DeliveryCount++
+		}
+		consumer.ActiveTime = now
+		k.persister.PersistCmd(cmd.Ctx(), xclaimCmd(key, groupName, nack)) // 持久化
+		count--
+
+		if justID {
+			claimed = append(claimed, handler.NewBulkReply([]byte(nack.ID.String())))
+		} else {
+			claimed = append(claimed, newStreamEntryReply(entry))
+		}
+	}
+
+	claimedReply := handler.Reply(handler.NewEmptyMultiBulkReply())
+	if len(claimed) > 0 {
+		claimedReply = handler.NewArrayReply(claimed)
+	}
+	deletedReply := handler.Reply(handler.NewEmptyMultiBulkReply())
+	if len(deleted) > 0 {
+		deletedReply = handler.NewMultiBulkReply(deleted)
+	}
+	return handler.NewArrayReply([]handler.Reply{handler.NewBulkReply([]byte(next.String())), claimedReply, deletedReply})
+}
+
+func (k *KVStore) XInfo(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	switch strings.ToLower(string(args[0])) {
+	case "stream":
+		if len(args) != 2 {
+			return handler.NewSyntaxErrReply()
+		}
+		return k.xinfoStream(string(args[1]))
+	case "groups":
+		if len(args) != 2 {
+			return handler.NewSyntaxErrReply()
+		}
+		return k.xinfoGroups(string(args[1]))
+	case "consumers":
+		if len(args) != 3 {
+			return handler.NewSyntaxErrReply()
+		}
+		return k.xinfoConsumers(string(args[1]), string(args[2]))
+	default:
+		return handler.NewUnknownSubCmdErrReply("xinfo", args[0])
+	}
+}
+
+func (k *KVStore) getStreamForInfo(key string) (Stream, error) {
+	k.ExpirePreprocess(key)
+	stream, err := k.getAsStream(key)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil {
+		return nil, errors.New("ERR no such key")
+	}
+	return stream, nil
+}
+
+func newBulkStringReply(s string) handler.Reply {
+	return handler.NewBulkReply([]byte(s))
+}
+
+func (k *KVStore) xinfoStream(key string) handler.Reply {
+	stream, err := k.getStreamForInfo(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var (
+		firstEntry handler.Reply = handler.NewNillReply()
+		lastEntry  handler.Reply = handler.NewNillReply()
+		firstID                  = minStreamID
+	)
+	if first := stream.Range(minStreamID, maxStreamID, 1, false); len(first) > 0 {
+		firstEntry, firstID = newStreamEntryReply(first[0]), first[0].ID
+	}
+	if last := stream.Range(minStreamID, maxStreamID, 1, true); len(last) > 0 {
+		lastEntry = newStreamEntryReply(last[0])
+	}
+
+	return handler.NewArrayReply([]handler.Reply{
+		newBulkStringReply("length"), handler.NewIntReply(stream.Len()),
+		newBulkStringReply("radix-tree-keys"), handler.NewIntReply(stream.Chunks()),
+		newBulkStringReply("radix-tree-nodes"), handler.NewIntReply(stream.Chunks()),
+		newBulkStringReply("last-generated-id"), newBulkStringReply(stream.LastID().String()),
+		newBulkStringReply("max-deleted-entry-id"), newBulkStringReply(stream.MaxDeletedID().String()),
+		newBulkStringReply("entries-added"), handler.NewIntReply(stream.EntriesAdded()),
+		newBulkStringReply("recorded-first-entry-id"), newBulkStringReply(firstID.String()),
+		newBulkStringReply("groups"), handler.NewIntReply(int64(len(stream.Groups()))),
+		newBulkStringReply("first-entry"), firstEntry,
+		newBulkStringReply("last-entry"), lastEntry,
+	})
+}
+
+func (k *KVStore) xinfoGroups(key string) handler.Reply {
+	stream, err := k.getStreamForInfo(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	replies := []handler.Reply{}
+	for _, group := range stream.Groups() {
+		var entriesRead handler.Reply = handler.NewNillReply()
+		if group.EntriesRead >= 0 {
+			entriesRead = handler.NewIntReply(group.EntriesRead)
+		}
+
+		// 消费组尚未读取的消息数
+		var lag int64
+		if start, ok := group.LastID.incr(); ok {
+			lag = int64(len(stream.Range(start, maxStreamID, 0, false)))
+		}
+
+		replies = append(replies, handler.NewArrayReply([]handler.Reply{
+			newBulkStringReply("name"), newBulkStringReply(group.Name),
+			newBulkStringReply("consumers"), handler.NewIntReply(int64(len(group.Consumers()))),
+			newBulkStringReply("pending"), handler.NewIntReply(group.PendingLen()),
+			newBulkStringReply("last-delivered-id"), newBulkStringReply(group.LastID.String()),
+			newBulkStringReply("entries-read"), entriesRead,
+			newBulkStringReply("lag"), handler.NewIntReply(lag),
+		}))
+	}
+	if len(replies) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	return handler.NewArrayReply(replies)
+}
+
+func (k *KVStore) xinfoConsumers(key, groupName string) handler.Reply {
+	stream, err := k.getStreamForInfo(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	group := stream.Group(groupName)
+	if group == nil {
+		return handler.NewErrReply(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", groupName, key))
+	}
+
+	now := lib.TimeNow()
+	replies := []handler.Reply{}
+	for _, consumer := range group.Consumers() {
+		var inactive int64 = -1
+		if !consumer.ActiveTime.IsZero() {
+			inactive = idleMillis(now, consumer.ActiveTime)
+		}
+		replies = append(replies, handler.NewArrayReply([]handler.Reply{
+			newBulkStringReply("name"), newBulkStringReply(consumer.Name),
+			newBulkStringReply("pending"), handler.NewIntReply(consumer.Pending()),
+			newBulkStringReply("idle"), handler.NewIntReply(idleMillis(now, consumer.SeenTime)),
+			newBulkStringReply("inactive"), handler.NewIntReply(inactive),
+		}))
+	}
+	if len(replies) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	return handler.NewArrayReply(replies)
+}
+
+var errJSONKeyNotExist = errors.New("ERR could not perform this operation on a key that doesn't exist")
+
+func errJSONPathNotExist(path *JSONPath) error {
+	return fmt.Errorf("ERR Path '%s' does not exist", path.raw)
+}
+
+// JSON.SET key path value [NX|XX]
+func (k *KVStore) JSONSet(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 3 && len(args) != 4 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var nx, xx bool
+	if len(args) == 4 {
+		switch strings.ToLower(string(args[3])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	path, err := parseJSONPath(string(args[1]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	value, err := parseJSON(args[2])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	key := string(args[0])
+	doc, err := k.getAsJSON(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	if doc == nil {
+		if xx {
+			return handler.NewNillReply()
+		}
+		if !path.IsRoot() {
+			return handler.NewErrReply("ERR new objects must be created at the root")
+		}
+		k.putAsJSON(key, newJSONEntity(key, value))
+	} else if !doc.Set(path, value, nx, xx) {
+		return handler.NewNillReply()
+	}
+
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+// 旧版路径返回第一个匹配的值，JSONPath 返回所有匹配值组成的数组
+func jsonPathResult(doc JSON, path *JSONPath) (*JSONValue, error) {
+	values := doc.Get(path)
+	if !path.legacy {
+		return &JSONValue{Type: JSONArray, Array: values}, nil
+	}
+	if len(values) == 0 {
+		return nil, errJSONPathNotExist(path)
+	}
+	return values[0], nil
+}
+
+// JSON.GET key [INDENT indent] [NEWLINE newline] [SPACE space] [path [path ...]]
+func (k *KVStore) JSONGet(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+
+	var (
+		format = jsonFormat{}
+		paths  []*JSONPath
+	)
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "indent" || opt == "newline" || opt == "space" {
+			if i+1 >= len(args) {
+				return handler.NewSyntaxErrReply()
+			}
+			i++
+			switch opt {
+			case "indent":
+				format.indent = string(args[i])
+			case "newline":
+				format.newline = string(args[i])
+			case "space":
+				format.space = string(args[i])
+			}
+			continue
+		}
+
+		path, err := parseJSONPath(string(args[i]))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		paths = append(paths, path)
+	}
+
+	doc, err := k.getAsJSON(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if doc == nil {
+		return handler.NewNillReply()
+	}
+
+	if len(paths) == 0 {
+		paths = append(paths, &JSONPath{raw: ".", legacy: true})
+	}
+	if len(paths) == 1 {
+		result, err := jsonPathResult(doc, paths[0])
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		return handler.NewBulkReply(result.Marshal(&format))
+	}
+
+	// 多个路径时以 path 为 key 组成 object
+	results := newJSONObject()
+	for _, path := range paths {
+		result, err := jsonPathResult(doc, path)
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		results.SetField(path.raw, result)
+	}
+	return handler.NewBulkReply(results.Marshal(&format))
+}
+
+// JSON.DEL key [path]
+func (k *KVStore) JSONDel(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) > 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	rawPath := "$"
+	if len(args) == 2 {
+		rawPath = string(args[1])
+	}
+	path, err := parseJSONPath(rawPath)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	key := string(args[0])
+	doc, err := k.getAsJSON(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if doc == nil {
+		return handler.NewIntReply(0)
+	}
+
+	var deleted int64 = 1
+	if path.IsRoot() {
+		k.removeKey(key)
+	} else {
+		deleted = doc.Del(path)
+	}
+	if deleted > 0 {
+		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	}
+	return handler.NewIntReply(deleted)
+}
+
+// JSON.NUMINCRBY key path value
+func (k *KVStore) JSONNumIncrBy(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	path, err := parseJSONPath(string(args[1]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	by, err := parseJSON(args[2])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if by.Type != JSONNumber {
+		return handler.NewErrReply("ERR expected a number but found " + by.TypeName())
+	}
+
+	doc, err := k.getAsJSON(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if doc == nil {
+		return handler.NewErrReply(errJSONKeyNotExist.Error())
+	}
+
+	values := doc.Get(path)
+	if path.legacy {
+		if len(values) == 0 {
+			return handler.NewErrReply(errJSONPathNotExist(path).Error())
+		}
+		if values[0].Type != JSONNumber {
+			return handler.NewErrReply("ERR wrong type of path value - expected a number but found " + values[0].TypeName())
+		}
+		values = values[:1]
+	}
+
+	// 先校验结果，避免部分更新
+	results := &JSONValue{Type: JSONArray, Array: make([]*JSONValue, 0, len(values))}
+	for _, value := range values {
+		if value.Type != JSONNumber {
+			results.Array = append(results.Array, &JSONValue{Type: JSONNull})
+			continue
+		}
+		result := value.Clone()
+		if err := result.incrBy(by); err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		results.Array = append(results.Array, result)
+	}
+
+	var updated bool
+	for i, value := range values {
+		if value.Type == JSONNumber {
+			value.Number = results.Array[i].Number
+			updated = true
+		}
+	}
+	if updated {
+		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	}
+
+	if path.legacy {
+		return handler.NewBulkReply(results.Array[0].Marshal(nil))
+	}
+	return handler.NewBulkReply(results.Marshal(nil))
+}
+
+// JSON.ARRAPPEND key path value [value ...]
+func (k *KVStore) JSONArrAppend(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	path, err := parseJSONPath(string(args[1]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	elems := make([]*JSONValue, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		elem, err := parseJSON(arg)
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		elems = append(elems, elem)
+	}
+
+	doc, err := k.getAsJSON(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if doc == nil {
+		return handler.NewErrReply(errJSONKeyNotExist.Error())
+	}
+
+	values := doc.Get(path)
+	if path.legacy {
+		if len(values) == 0 {
+			return handler.NewErrReply(errJSONPathNotExist(path).Error())
+		}
+		if values[0].Type != JSONArray {
+			return handler.NewErrReply("ERR wrong type of path value - expected an array but found " + values[0].TypeName())
+		}
+		values = values[:1]
+	}
+
+	var updated bool
+	replies := make([]handler.Reply, 0, len(values))
+	for _, value := range values {
+		if value.Type != JSONArray {
+			replies = append(replies, handler.NewNillReply())
+			continue
+		}
+		for _, elem := range elems {
+			value.Array = append(value.Array, elem.Clone())
+		}
+		updated = true
+		replies = append(replies, handler.NewIntReply(int64(len(value.Array))))
+	}
+	if updated {
+		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	}
+
+	if path.legacy {
+		return replies[0]
+	}
+	if len(replies) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+	return handler.NewArrayReply(replies)
+}
+
+// BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+func (k *KVStore) BFReserve(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	errorRate, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || errorRate <= 0 || errorRate >= 1 {
+		return handler.NewErrReply("ERR (0 < error rate range < 1)")
+	}
+	capacity, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil || capacity <= 0 {
+		return handler.NewErrReply("ERR (capacity should be larger than 0)")
+	}
+
+	var (
+		expansion  int64 = bloomDefaultExpansion
+		nonScaling bool
+	)
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "expansion":
+			if i+1 >= len(args) {
+				return handler.NewSyntaxErrReply()
+			}
+			i++
+			if expansion, err = strconv.ParseInt(string(args[i]), 10, 64); err != nil || expansion < 1 || expansion > math.MaxUint32 {
+				return handler.NewErrReply("ERR expansion should be greater or equal to 1")
+			}
+		case "nonscaling":
+			nonScaling = true
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	key := string(args[0])
+	if _, ok := k.data[key]; ok {
+		return handler.NewErrReply("ERR item exists")
+	}
+
+	k.putAsBloom(key, newBloomEntity(key, errorRate, uint64(capacity), uint32(expansion), nonScaling))
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+// 获取布隆过滤器，不存在时按默认参数创建
+func (k *KVStore) getOrCreateBloom(key string) (Bloom, error) {
+	bloom, err := k.getAsBloom(key)
+	if err != nil || bloom != nil {
+		return bloom, err
+	}
+
+	bloom = newBloomEntity(key, bloomDefaultErrorRate, bloomDefaultCapacity, bloomDefaultExpansion, false)
+	k.putAsBloom(key, bloom)
+	return bloom, nil
+}
+
+// BF.ADD key item
+func (k *KVStore) BFAdd(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	bloom, err := k.getOrCreateBloom(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	added, err := bloom.Add(args[1])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if added {
+		k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+		return handler.NewIntReply(1)
+	}
+	return handler.NewIntReply(0)
+}
+
+// BF.MADD key item [item ...]
+func (k *KVStore) BFMAdd(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	bloom, err := k.getOrCreateBloom(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	// 只持久化实际写入的 item
+	persistCmd := [][]byte{[]byte(database.CmdTypeBFMAdd), args[0]}
+	res := make([]handler.Reply, 0, len(args)-1)
+	for _, item := range args[1:] {
+		added, err := bloom.Add(item)
+		if err != nil {
+			res = append(res, handler.NewErrReply(err.Error()))
+			continue
+		}
+		if !added {
+			res = append(res, handler.NewIntReply(0))
+			continue
+		}
+		persistCmd = append(persistCmd, item)
+		res = append(res, handler.NewIntReply(1))
+	}
+
+	if len(persistCmd) > 2 {
+		k.persister.PersistCmd(cmd.Ctx(), persistCmd) // 持久化
+	}
+	return handler.NewArrayReply(res)
+}
+
+// BF.EXISTS key item
+func (k *KVStore) BFExists(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	bloom, err := k.getAsBloom(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if bloom == nil || !bloom.Exist(args[1]) {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(1)
+}
+
+// BF.MEXISTS key item [item ...]
+func (k *KVStore) BFMExists(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	bloom, err := k.getAsBloom(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	res := make([]handler.Reply, 0, len(args)-1)
+	for _, item := range args[1:] {
+		if bloom == nil || !bloom.Exist(item) {
+			res = append(res, handler.NewIntReply(0))
+			continue
+		}
+		res = append(res, handler.NewIntReply(1))
+	}
+	return handler.NewArrayReply(res)
+}
+
+// BF.LOADCHUNK key iterator data. 整个过滤器作为一个 chunk 写入，覆盖已有的值
+func (k *KVStore) BFLoadChunk(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	if iter, err := strconv.ParseInt(string(args[1]), 10, 64); err != nil || iter <= 0 {
+		return handler.NewErrReply("ERR invalid iterator")
+	}
+
+	key := string(args[0])
+	if _, err := k.getAsBloom(key); err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	bloom, err := unmarshalBloom(key, args[2])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	k.putAsBloom(key, bloom)
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+var (
+	errCMSKeyExist    = errors.New("CMS: key already exists")
+	errCMSKeyNotExist = errors.New("CMS: key does not exist")
+)
+
+func (k *KVStore) initCMS(cmd *database.Command, width, depth uint32) handler.Reply {
+	key := string(cmd.Args()[0])
+	if _, ok := k.data[key]; ok {
+		return handler.NewErrReply(errCMSKeyExist.Error())
+	}
+
+	k.putAsCMS(key, newCMSEntity(key, width, depth))
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+// CMS.INITBYDIM key width depth
+func (k *KVStore) CMSInitByDim(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	width, err := strconv.ParseUint(string(args[1]), 10, 32)
+	if err != nil || width == 0 {
+		return handler.NewErrReply("CMS: invalid width")
+	}
+	depth, err := strconv.ParseUint(string(args[2]), 10, 32)
+	if err != nil || depth == 0 {
+		return handler.NewErrReply("CMS: invalid depth")
+	}
+	if width*depth > math.MaxUint32 {
+		return handler.NewErrReply("CMS: width*depth is too large")
+	}
+	return k.initCMS(cmd, uint32(width), uint32(depth))
+}
+
+// CMS.INITBYPROB key error probability
+func (k *KVStore) CMSInitByProb(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	overEst, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || overEst <= 0 || overEst >= 1 {
+		return handler.NewErrReply("CMS: invalid overestimation value")
+	}
+	prob, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || prob <= 0 || prob >= 1 {
+		return handler.NewErrReply("CMS: invalid prob value")
+	}
+
+	// 与 RedisBloom 一致: width = 2/error, depth = log(prob)/log(0.5)
+	width := math.Ceil(2 / overEst)
+	depth := math.Ceil(math.Log10(prob) / math.Log10(0.5))
+	if width*depth > math.MaxUint32 {
+		return handler.NewErrReply("CMS: width*depth is too large")
+	}
+	return k.initCMS(cmd, uint32(width), uint32(depth))
+}
+
+// CMS.INCRBY key item increment [item increment ...]
+func (k *KVStore) CMSIncrBy(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 3 || len(args)%2 != 1 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	incrs := make([]int64, 0, len(args)/2)
+	for i := 2; i < len(args); i += 2 {
+		incr, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil || incr < 0 {
+			return handler.NewErrReply("CMS: Cannot parse number")
+		}
+		incrs = append(incrs, incr)
+	}
+
+	cms, err := k.getAsCMS(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if cms == nil {
+		return handler.NewErrReply(errCMSKeyNotExist.Error())
+	}
+
+	res := make([]handler.Reply, 0, len(incrs))
+	for i, incr := range incrs {
+		res = append(res, handler.NewIntReply(cms.IncrBy(args[1+2*i], incr)))
+	}
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewArrayReply(res)
+}
+
+// CMS.QUERY key item [item ...]
+func (k *KVStore) CMSQuery(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	cms, err := k.getAsCMS(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if cms == nil {
+		return handler.NewErrReply(errCMSKeyNotExist.Error())
+	}
+
+	res := make([]handler.Reply, 0, len(args)-1)
+	for _, item := range args[1:] {
+		res = append(res, handler.NewIntReply(cms.Query(item)))
+	}
+	return handler.NewArrayReply(res)
+}
+
+// CMS.MERGE destination numKeys source [source ...] [WEIGHTS weight [weight ...]]
+func (k *KVStore) CMSMerge(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	numKeys, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || numKeys <= 0 || numKeys > int64(len(args)-2) {
+		return handler.NewErrReply("CMS: invalid numkeys")
+	}
+
+	weights := make([]int64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	if rest := args[2+numKeys:]; len(rest) > 0 {
+		if strings.ToLower(string(rest[0])) != "weights" || int64(len(rest)-1) != numKeys {
+			return handler.NewSyntaxErrReply()
+		}
+		for i, arg := range rest[1:] {
+			if weights[i], err = strconv.ParseInt(string(arg), 10, 64); err != nil {
+				return handler.NewErrReply("CMS: invalid weight value")
+			}
+		}
+	}
+
+	dest, err := k.getAsCMS(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if dest == nil {
+		return handler.NewErrReply(errCMSKeyNotExist.Error())
+	}
+
+	sources := make([]CMS, 0, numKeys)
+	for _, arg := range args[2 : 2+numKeys] {
+		k.ExpirePreprocess(string(arg))
+		source, err := k.getAsCMS(string(arg))
+		if err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		if source == nil {
+			return handler.NewErrReply(errCMSKeyNotExist.Error())
+		}
+		if source.Width() != dest.Width() || source.Depth() != dest.Depth() {
+			return handler.NewErrReply("CMS: width/depth is not equal")
+		}
+		sources = append(sources, source)
+	}
+
+	dest.Merge(sources, weights)
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+// CMS.LOADCHUNK key data. 以二进制状态还原整个 sketch，覆盖已有的值
+func (k *KVStore) CMSLoadChunk(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) != 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	key := string(args[0])
+	if _, err := k.getAsCMS(key); err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	cms, err := unmarshalCMS(key, args[1])
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	k.putAsCMS(key, cms)
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+type tsCreateOptions struct {
+	retention       int64
+	chunkSize       int
+	duplicatePolicy string
+	onDuplicate     string
+	labels          []string
+}
+
+// 解析 TS.CREATE 和 TS.ADD 的选项. ON_DUPLICATE 只在 TS.ADD 中可用
+func parseTSCreateOptions(args [][]byte, add bool) (*tsCreateOptions, error) {
+	opts := tsCreateOptions{
+		chunkSize:       tsDefaultChunkSize,
+		duplicatePolicy: tsDuplicateBlock,
+	}
+
+	var err error
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "labels" {
+			if rest := args[i+1:]; len(rest)%2 == 0 {
+				for _, label := range rest {
+					opts.labels = append(opts.labels, string(label))
+				}
+				return &opts, nil
+			}
+			return nil, errTSInvalidLabel
+		}
+		if i+1 >= len(args) || (opt == "on_duplicate" && !add) {
+			return nil, handler.NewSyntaxErrReply()
+		}
+
+		i++
+		switch opt {
+		case "retention":
+			if opts.retention, err = strconv.ParseInt(string(args[i]), 10, 64); err != nil || opts.retention < 0 {
+				return nil, errors.New("ERR TSDB: Couldn't parse RETENTION")
+			}
+		case "chunk_size":
+			if opts.chunkSize, err = strconv.Atoi(string(args[i])); err != nil || opts.chunkSize < tsMinChunkSize || opts.chunkSize > tsMaxChunkSize || opts.chunkSize%8 != 0 {
+				return nil, errors.New("ERR TSDB: CHUNK_SIZE value must be a multiple of 8 in the range [48 .. 1048576]")
+			}
+		case "duplicate_policy":
+			if opts.duplicatePolicy, err = parseTSDuplicatePolicy(string(args[i])); err != nil {
+				return nil, err
+			}
+		case "on_duplicate":
+			if opts.onDuplicate, err = parseTSDuplicatePolicy(string(args[i])); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, handler.NewSyntaxErrReply()
+		}
+	}
+	return &opts, nil
+}
+
+// TS.CREATE key [RETENTION retentionPeriod] [CHUNK_SIZE size] [DUPLICATE_POLICY policy] [LABELS label value ...]
+func (k *KVStore) TSCreate(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	opts, err := parseTSCreateOptions(args[1:], false)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	key := string(args[0])
+	if _, ok := k.data[key]; ok {
+		return handler.NewErrReply(errTSKeyExist.Error())
+	}
+
+	k.putAsTimeSeries(key, newTimeSeriesEntity(key, opts.retention, opts.chunkSize, opts.duplicatePolicy, opts.labels))
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+// TS.ADD key timestamp value [RETENTION retentionPeriod] [CHUNK_SIZE size] [ON_DUPLICATE policy] [DUPLICATE_POLICY policy] [LABELS label value ...]
+func (k *KVStore) TSAdd(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 3 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	var ts int64
+	if string(args[1]) == "*" {
+		ts = lib.TimeNow().UnixMilli()
+	} else if ts, _ = strconv.ParseInt(string(args[1]), 10, 64); ts < 0 || strconv.FormatInt(ts, 10) != string(args[1]) {
+		return handler.NewErrReply("ERR TSDB: invalid timestamp")
+	}
+	value, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || math.IsNaN(value) {
+		return handler.NewErrReply("ERR TSDB: invalid value")
+	}
+	opts, err := parseTSCreateOptions(args[3:], true)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	key := string(args[0])
+	series, err := k.getAsTimeSeries(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	// 序列不存在时按选项创建，已存在时忽略创建选项
+	if series == nil {
+		series = newTimeSeriesEntity(key, opts.retention, opts.chunkSize, opts.duplicatePolicy, opts.labels)
+		if err = series.Add(ts, value, opts.onDuplicate); err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+		k.putAsTimeSeries(key, series)
+	} else if err = series.Add(ts, value, opts.onDuplicate); err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	// 以具体的时间戳持久化
+	tsArg := []byte(strconv.FormatInt(ts, 10))
+	k.persister.PersistCmd(cmd.Ctx(), append([][]byte{[]byte(database.CmdTypeTSAdd), args[0], tsArg}, args[2:]...)) // 持久化
+	return handler.NewIntReply(ts)
+}
+
+type tsRangeOptions struct {
+	from, to   int64
+	count      int64
+	aggregator tsAggregator
+	bucket     int64
+	withLabels bool
+	filters    []*tsFilter
+}
+
+func parseTSTimestamp(raw []byte, unbounded string, bound int64) (int64, bool) {
+	if string(raw) == unbounded {
+		return bound, true
+	}
+	ts, err := strconv.ParseInt(string(raw), 10, 64)
+	return ts, err == nil && ts >= 0
+}
+
+// 解析 fromTimestamp toTimestamp [WITHLABELS] [COUNT count] [AGGREGATION aggregator bucketDuration] [FILTER filter ...]
+// WITHLABELS 与 FILTER 只在 TS.MRANGE 中可用
+func parseTSRangeOptions(args [][]byte, multi bool) (*tsRangeOptions, error) {
+	if len(args) < 2 {
+		return nil, handler.NewSyntaxErrReply()
+	}
+
+	var (
+		opts tsRangeOptions
+		ok   bool
+		err  error
+	)
+	if opts.from, ok = parseTSTimestamp(args[0], "-", 0); !ok {
+		return nil, errors.New("ERR TSDB: wrong fromTimestamp")
+	}
+	if opts.to, ok = parseTSTimestamp(args[1], "+", math.MaxInt64); !ok {
+		return nil, errors.New("ERR TSDB: wrong toTimestamp")
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "withlabels" && multi:
+			opts.withLabels = true
+		case opt == "count" && i+1 < len(args):
+			i++
+			if opts.count, err = strconv.ParseInt(string(args[i]), 10, 64); err != nil || opts.count <= 0 {
+				return nil, errors.New("ERR TSDB: Couldn't parse COUNT")
+			}
+		case opt == "aggregation" && i+2 < len(args):
+			if opts.aggregator, ok = tsAggregators[strings.ToLower(string(args[i+1]))]; !ok {
+				return nil, errors.New("ERR TSDB: Unknown aggregation type")
+			}
+			if opts.bucket, err = strconv.ParseInt(string(args[i+2]), 10, 64); err != nil || opts.bucket <= 0 {
+				return nil, errors.New("ERR TSDB: bucketDuration must be greater than zero")
+			}
+			i += 2
+		case opt == "filter" && multi:
+			for _, raw := range args[i+1:] {
+				filter, err := parseTSFilter(string(raw))
+				if err != nil {
+					return nil, err
+				}
+				opts.filters = append(opts.filters, filter)
+			}
+			i = len(args)
+		default:
+			return nil, handler.NewSyntaxErrReply()
+		}
+	}
+
+	if !multi {
+		return &opts, nil
+	}
+	if len(opts.filters) == 0 {
+		return nil, errors.New("ERR TSDB: missing FILTER argument")
+	}
+	for _, filter := range opts.filters {
+		if filter.isMatcher() {
+			return &opts, nil
+		}
+	}
+	return nil, errors.New("ERR TSDB: please provide at least one matcher")
+}
+
+// 按选项查询序列，返回 [[timestamp, value] ...]
+func (o *tsRangeOptions) query(series TimeSeries, reverse bool) handler.Reply {
+	samples := series.Range(o.from, o.to)
+	if o.aggregator != nil {
+		samples = aggregateTSSamples(samples, o.aggregator, o.bucket)
+	}
+	if reverse {
+		for i, j := 0, len(samples)-1; i < j; i, j = i+1, j-1 {
+			samples[i], samples[j] = samples[j], samples[i]
+		}
+	}
+	if o.count > 0 && int64(len(samples)) > o.count {
+		samples = samples[:o.count]
+	}
+
+	res := make([]handler.Reply, 0, len(samples))
+	for _, sample := range samples {
+		res = append(res, handler.NewArrayReply([]handler.Reply{
+			handler.NewIntReply(sample.ts),
+			handler.NewBulkReply([]byte(formatTSValue(sample.value))),
+		}))
+	}
+	return handler.NewArrayReply(res)
+}
+
+func (k *KVStore) tsRange(cmd *database.Command, reverse bool) handler.Reply {
+	args := cmd.Args()
+	opts, err := parseTSRangeOptions(args[1:], false)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	series, err := k.getAsTimeSeries(string(args[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if series == nil {
+		return handler.NewErrReply(errTSKeyNotExist.Error())
+	}
+	return opts.query(series, reverse)
+}
+
+// TS.RANGE key fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration]
+func (k *KVStore) TSRange(cmd *database.Command) handler.Reply {
+	return k.tsRange(cmd, false)
+}
+
+// TS.REVRANGE key fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration]
+func (k *KVStore) TSRevRange(cmd *database.Command) handler.Reply {
+	return k.tsRange(cmd, true)
+}
+
+// 执行前无法确定 key 的指令，匹配到 key 后以调用方的 key 模式检查权限. 没有连接上下文时不检查
+func authorizeKeys(cmd *database.Command, keys []string) handler.Reply {
+	if cmd.Ctx() == nil {
+		return nil
+	}
+	if authorize, ok := handler.GetKeyAuthorizer(cmd.Ctx()); ok {
+		return authorize(cmd.Cmd(), keys)
+	}
+	return nil
+}
+
+// TS.MRANGE fromTimestamp toTimestamp [WITHLABELS] [COUNT count] [AGGREGATION aggregator bucketDuration] FILTER filter ...
+func (k *KVStore) TSMRange(cmd *database.Command) handler.Reply {
+	opts, err := parseTSRangeOptions(cmd.Args(), true)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	var keys []string
+	k.forEachTimeSeries(func(key string, series TimeSeries) {
+		for _, filter := range opts.filters {
+			if !filter.match(series.Labels()) {
+				return
+			}
+		}
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	if reply := authorizeKeys(cmd, keys); reply != nil {
+		return reply
+	}
+
+	res := make([]handler.Reply, 0, len(keys))
+	for _, key := range keys {
+		k.ExpirePreprocess(key)
+		series, _ := k.getAsTimeSeries(key)
+		if series == nil {
+			continue
+		}
+
+		labels := []handler.Reply{}
+		if opts.withLabels {
+			for i := 0; i+1 < len(series.Labels()); i += 2 {
+				labels = append(labels, handler.NewMultiBulkReply([][]byte{[]byte(series.Labels()[i]), []byte(series.Labels()[i+1])}))
+			}
+		}
+		res = append(res, handler.NewArrayReply([]handler.Reply{
+			handler.NewBulkReply([]byte(key)),
+			handler.NewArrayReply(labels),
+			opts.query(series, false),
+		}))
+	}
+	return handler.NewArrayReply(res)
+}
+
+var errSearchNoIndex = errors.New("Unknown Index name")
+
+// FT.CREATE index [ON HASH] [PREFIX count prefix ...] SCHEMA field TEXT|TAG [SEPARATOR sep]|NUMERIC [SORTABLE] ...
+func (k *KVStore) FTCreate(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	name := string(args[0])
+
+	var prefixes []string
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "schema" {
+			break
+		}
+		switch {
+		case opt == "on" && i+1 < len(args):
+			i++
+			if strings.ToLower(string(args[i])) != "hash" {
+				return handler.NewErrReply("ERR only HASH indexes are supported")
+			}
+		case opt == "prefix" && i+1 < len(args):
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || count <= 0 || i+1+count >= len(args) {
+				return handler.NewErrReply("Bad arguments for PREFIX: invalid count")
+			}
+			for _, prefix := range args[i+2 : i+2+count] {
+				prefixes = append(prefixes, string(prefix))
+			}
+			i += 1 + count
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	var fields []*searchField
+	for i++; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return handler.NewSyntaxErrReply()
+		}
+		field := searchField{name: string(args[i]), typ: searchFieldType(strings.ToLower(string(args[i+1])))}
+		if field.typ != searchFieldText && field.typ != searchFieldTag && field.typ != searchFieldNumeric {
+			return handler.NewErrReply(fmt.Sprintf("Invalid field type for field `%s`", field.name))
+		}
+		for _, exist := range fields {
+			if exist.name == field.name {
+				return handler.NewErrReply(fmt.Sprintf("Duplicate field in schema - %s", field.name))
+			}
+		}
+		if field.typ == searchFieldTag {
+			field.separator = searchDefaultTagSeparator
+		}
+
+		// 字段选项
+		for i += 2; i < len(args); i++ {
+			opt := strings.ToLower(string(args[i]))
+			if opt == "sortable" {
+				field.sortable = true
+			} else if opt == "separator" && field.typ == searchFieldTag && i+1 < len(args) && len(args[i+1]) == 1 {
+				i++
+				field.separator = string(args[i])
+			} else {
+				break
+			}
+		}
+		i--
+		fields = append(fields, &field)
+	}
+	if len(fields) == 0 {
+		return handler.NewErrReply("Fields arguments are missing")
+	}
+
+	if _, ok := k.indexes[name]; ok {
+		return handler.NewErrReply("Index already exists")
+	}
+	if reply := authorizeKeys(cmd, searchKeyPatterns(prefixes)); reply != nil {
+		return reply
+	}
+
+	// 为已有数据建立索引
+	index := newSearchIndex(name, prefixes, fields)
+	for key := range k.data {
+		if !index.match(key) {
+			continue
+		}
+		if hmap, _ := k.getAsHashMap(key); hmap != nil {
+			index.add(key, hmap)
+		}
+	}
+	k.indexes[name] = index
+
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+// FT.DROPINDEX index [DD]. DD 同时删除索引中的文档
+func (k *KVStore) FTDropIndex(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) > 2 || (len(args) == 2 && strings.ToLower(string(args[1])) != "dd") {
+		return handler.NewSyntaxErrReply()
+	}
+
+	name := string(args[0])
+	index, ok := k.indexes[name]
+	if !ok {
+		return handler.NewErrReply(errSearchNoIndex.Error())
+	}
+	if reply := authorizeKeys(cmd, searchKeyPatterns(index.prefixes)); reply != nil {
+		return reply
+	}
+	delete(k.indexes, name)
+
+	if len(args) == 2 {
+		for key := range index.docs {
+			k.removeKey(key)
+		}
+	}
+	k.persister.PersistCmd(cmd.Ctx(), cmd.Cmd()) // 持久化
+	return handler.NewOKReply()
+}
+
+// FT.SEARCH index query [NOCONTENT] [RETURN count field ...] [SORTBY field [ASC|DESC]] [LIMIT offset num]
+func (k *KVStore) FTSearch(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return handler.NewSyntaxErrReply()
+	}
+
+	index, ok := k.indexes[string(args[0])]
+	if !ok {
+		return handler.NewErrReply(errSearchNoIndex.Error())
+	}
+	if reply := authorizeKeys(cmd, searchKeyPatterns(index.prefixes)); reply != nil {
+		return reply
+	}
+
+	var (
+		noContent     bool
+		returnFields  []string
+		sortBy        *searchField
+		desc          bool
+		offset, limit = 0, 10
+		err           error
+	)
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "nocontent":
+			noContent = true
+		case opt == "return" && i+1 < len(args):
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 0 || i+1+count >= len(args) {
+				return handler.NewErrReply("Bad arguments for RETURN: invalid count")
+			}
+			for _, field := range args[i+2 : i+2+count] {
+				returnFields = append(returnFields, string(field))
+			}
+			// RETURN 0 等同于 NOCONTENT
+			noContent = noContent || count == 0
+			i += 1 + count
+		case opt == "sortby" && i+1 < len(args):
+			i++
+			if sortBy = index.field(string(args[i])); sortBy == nil {
+				return handler.NewErrReply(fmt.Sprintf("Property `%s` not loaded nor in schema", string(args[i])))
+			}
+			if i+1 < len(args) {
+				switch strings.ToLower(string(args[i+1])) {
+				case "asc":
+					i++
+				case "desc":
+					desc = true
+					i++
+				}
+			}
+		case opt == "limit" && i+2 < len(args):
+			if offset, err = strconv.Atoi(string(args[i+1])); err != nil || offset < 0 {
+				return handler.NewErrReply("Bad arguments for LIMIT: invalid offset")
+			}
+			if limit, err = strconv.Atoi(string(args[i+2])); err != nil || limit < 0 {
+				return handler.NewErrReply("Bad arguments for LIMIT: invalid num")
+			}
+			i += 2
+		default:
+			return handler.NewSyntaxErrReply()
+		}
+	}
+
+	query, err := parseSearchQuery(index, string(args[1]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+
+	keys := make([]string, 0)
+	for key := range query.eval(index) {
+		keys = append(keys, key)
+	}
+	// 惰性过期的文档在此时移出索引
+	valid := keys[:0]
+	for _, key := range keys {
+		k.ExpirePreprocess(key)
+		if _, ok := k.data[key]; ok {
+			valid = append(valid, key)
+		}
+	}
+	keys = valid
+
+	sort.Strings(keys)
+	if sortBy != nil {
+		sortSearchKeys(index, keys, sortBy, desc)
+	}
+
+	total := len(keys)
+	if offset > len(keys) {
+		offset = len(keys)
+	}
+	keys = keys[offset:]
+	if limit < len(keys) {
+		keys = keys[:limit]
+	}
+
+	res := []handler.Reply{handler.NewIntReply(int64(total))}
+	for _, key := range keys {
+		res = append(res, handler.NewBulkReply([]byte(key)))
+		if noContent {
+			continue
+		}
+
+		hmap, _ := k.getAsHashMap(key)
+		var fields [][]byte
+		if len(returnFields) > 0 {
+			for _, field := range returnFields {
+				if value := hmap.Get(field); value != nil {
+					fields = append(fields, []byte(field), value)
+				}
+			}
+		} else {
+			hmap.ForEach(func(field string, value []byte) {
+				fields = append(fields, []byte(field), value)
+			})
+		}
+		res = append(res, handler.NewMultiBulkReply(fields))
+	}
+	return handler.NewArrayReply(res)
+}
+
+// 按字段值稳定排序，缺少该字段的文档排在最后. NUMERIC 字段按数值比较
+func sortSearchKeys(index *searchIndex, keys []string, field *searchField, desc bool) {
+	value := func(key string) (string, float64, bool) {
+		raw, ok := index.docs[key][field.name]
+		if !ok {
+			return "", 0, false
+		}
+		if field.typ != searchFieldNumeric {
+			return strings.ToLower(raw), 0, true
+		}
+		number, err := strconv.ParseFloat(raw, 64)
+		return raw, number, err == nil
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		si, ni, oki := value(keys[i])
+		sj, nj, okj := value(keys[j])
+		if !oki || !okj {
+			return oki && !okj
+		}
+		if field.typ == searchFieldNumeric {
+			if desc {
+				return ni > nj
+			}
+			return ni < nj
+		}
+		if desc {
+			return si > sj
+		}
+		return si < sj
+	})
+}
@@ -0,0 +1,280 @@
+package datastore
+
+import (
+	"context"
+	"goredis/database"
+	"goredis/handler"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 记录持久化指令，便于校验 aof 内容
+type recordPersister struct {
+	cmds [][][]byte
+}
+
+func (r *recordPersister) Reloader() (io.ReadCloser, error) {
+	return nil, io.EOF
+}
+
+func (r *recordPersister) PersistCmd(ctx context.Context, cmd [][]byte) {
+	r.cmds = append(r.cmds, cmd)
+}
+
+func (r *recordPersister) Close() {}
+
+func newTestKVStore() (*KVStore, *recordPersister) {
+	persister := &recordPersister{}
+	return NewKVStore(persister, nil).(*KVStore), persister
+}
+
+func newTestCmd(cmd database.CmdType, args ...string) *database.Command {
+	_args := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		_args = append(_args, []byte(arg))
+	}
+	return database.NewCommand(cmd, _args)
+}
+
+func Test_kv_set_cmds(t *testing.T) {
+	k, persister := newTestKVStore()
+	k.SAdd(newTestCmd(database.CmdTypeSAdd, "a", "1", "2", "3"))
+	k.SAdd(newTestCmd(database.CmdTypeSAdd, "b", "2", "3", "4"))
+	k.Set(newTestCmd(database.CmdTypeSet, "str", "v"))
+
+	t.Run("scard", func(t *testing.T) {
+		assert.Equal(t, ":3\r\n", string(k.SCard(newTestCmd(database.CmdTypeSCard, "a")).ToBytes()))
+		assert.Equal(t, ":0\r\n", string(k.SCard(newTestCmd(database.CmdTypeSCard, "none")).ToBytes()))
+	})
+
+	t.Run("wrong_type", func(t *testing.T) {
+		reply := k.SInter(newTestCmd(database.CmdTypeSInter, "a", "str"))
+		assert.Equal(t, handler.NewWrongTypeErrReply().ToBytes(), reply.ToBytes())
+	})
+
+	t.Run("sintercard", func(t *testing.T) {
+		assert.Equal(t, ":2\r\n", string(k.SInterCard(newTestCmd(database.CmdTypeSInterCard, "2", "a", "b")).ToBytes()))
+		assert.Equal(t, ":1\r\n", string(k.SInterCard(newTestCmd(database.CmdTypeSInterCard, "2", "a", "b", "LIMIT", "1")).ToBytes()))
+	})
+
+	t.Run("smismember", func(t *testing.T) {
+		reply := k.SMIsMember(newTestCmd(database.CmdTypeSMIsMember, "a", "1", "4"))
+		assert.Equal(t, "*2\r\n:1\r\n:0\r\n", string(reply.ToBytes()))
+	})
+
+	t.Run("sdiffstore", func(t *testing.T) {
+		assert.Equal(t, ":1\r\n", string(k.SDiffStore(newTestCmd(database.CmdTypeSDiffStore, "dest", "a", "b")).ToBytes()))
+		assert.Equal(t, "*1\r\n$1\r\n1\r\n", string(k.SMembers(newTestCmd(database.CmdTypeSMembers, "dest")).ToBytes()))
+
+		// 结果为空时，目标 key 被删除
+		k.SInterStore(newTestCmd(database.CmdTypeSInterStore, "str", "a", "none"))
+		_, ok := k.data["str"]
+		assert.False(t, ok)
+	})
+
+	t.Run("smove", func(t *testing.T) {
+		assert.Equal(t, ":1\r\n", string(k.SMove(newTestCmd(database.CmdTypeSMove, "dest", "c", "1")).ToBytes()))
+		_, ok := k.data["dest"]
+		assert.False(t, ok)
+		assert.Equal(t, ":1\r\n", string(k.SCard(newTestCmd(database.CmdTypeSCard, "c")).ToBytes()))
+	})
+
+	t.Run("spop", func(t *testing.T) {
+		persister.cmds = nil
+		reply := k.SPop(newTestCmd(database.CmdTypeSPop, "a", "5"))
+		multiReply, ok := reply.(*handler.MultiBulkReply)
+		assert.True(t, ok)
+		assert.Equal(t, 3, len(multiReply.Args()))
+		_, ok = k.data["a"]
+		assert.False(t, ok)
+
+		// 以 srem 形式持久化
+		assert.Equal(t, 1, len(persister.cmds))
+		assert.Equal(t, database.CmdTypeSRem, database.CmdType(persister.cmds[0][0]))
+		assert.Equal(t, multiReply.Args(), persister.cmds[0][2:])
+	})
+
+	t.Run("srandmember", func(t *testing.T) {
+		assert.Equal(t, "*0\r\n", string(k.SRandMember(newTestCmd(database.CmdTypeSRandMember, "a", "3")).ToBytes()))
+		reply := k.SRandMember(newTestCmd(database.CmdTypeSRandMember, "b", "-5"))
+		assert.Equal(t, 5, len(reply.(*handler.MultiBulkReply).Args()))
+		// 负数取绝对值，math.MinInt64 超出范围
+		reply = k.SRandMember(newTestCmd(database.CmdTypeSRandMember, "b", "-9223372036854775808"))
+		assert.Equal(t, "-ERR value is out of range\r\n", string(reply.ToBytes()))
+		reply = k.SRandMember(newTestCmd(database.CmdTypeSRandMember, "b", "-9223372036854775809"))
+		assert.Equal(t, "-ERR value is not an integer or out of range\r\n", string(reply.ToBytes()))
+		reply = k.SRandMember(newTestCmd(database.CmdTypeSRandMember, "none", "-9223372036854775807"))
+		assert.Equal(t, "*0\r\n", string(reply.ToBytes()))
+	})
+}
+
+func Test_kv_sorted_set_cmds(t *testing.T) {
+	k, persister := newTestKVStore()
+	exec := func(f func(*database.Command) handler.Reply, cmd database.CmdType, args ...string) string {
+		return string(f(newTestCmd(cmd, args...)).ToBytes())
+	}
+
+	t.Run("zadd_flags", func(t *testing.T) {
+		assert.Equal(t, ":3\r\n", exec(k.ZAdd, database.CmdTypeZAdd, "z", "1", "a", "2.5", "b", "3", "c"))
+		assert.Equal(t, ":0\r\n", exec(k.ZAdd, database.CmdTypeZAdd, "z", "NX", "10", "a"))
+		assert.Equal(t, ":1\r\n", exec(k.ZAdd, database.CmdTypeZAdd, "z", "XX", "CH", "1.5", "a", "1", "d"))
+		assert.Equal(t, ":0\r\n", exec(k.ZAdd, database.CmdTypeZAdd, "z", "GT", "CH", "1", "a"))
+		assert.Equal(t, "$-1\r\n", exec(k.ZAdd, database.CmdTypeZAdd, "z", "LT", "INCR", "1", "a"))
+		assert.Equal(t, "$1\r\n2\r\n", exec(k.ZAdd, database.CmdTypeZAdd, "z", "INCR", "0.5", "a"))
+		assert.Equal(t, "-ERR value is not a valid float\r\n", exec(k.ZAdd, database.CmdTypeZAdd, "z", "x", "a"))
+		assert.Equal(t, "-ERR XX and NX options at the same time are not compatible\r\n", exec(k.ZAdd, database.CmdTypeZAdd, "z", "NX", "XX", "1", "a"))
+		_, ok := k.data["none"]
+		exec(k.ZAdd, database.CmdTypeZAdd, "none", "XX", "1", "a")
+		assert.False(t, ok)
+	})
+
+	// z: a=2 b=2.5 c=3
+	t.Run("zrange", func(t *testing.T) {
+		assert.Equal(t, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", exec(k.ZRange, database.CmdTypeZRange, "z", "0", "-1"))
+		assert.Equal(t, "*2\r\n$1\r\nc\r\n$1\r\n3\r\n", exec(k.ZRange, database.CmdTypeZRange, "z", "0", "0", "REV", "WITHSCORES"))
+		assert.Equal(t, "*1\r\n$1\r\nb\r\n", exec(k.ZRange, database.CmdTypeZRange, "z", "(2", "(3", "BYSCORE"))
+		assert.Equal(t, "*1\r\n$1\r\nb\r\n", exec(k.ZRange, database.CmdTypeZRange, "z", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "1"))
+		assert.Equal(t, "*0\r\n", exec(k.ZRange, database.CmdTypeZRange, "none", "0", "-1"))
+		assert.Equal(t, "*2\r\n$1\r\nb\r\n$3\r\n2.5\r\n", exec(k.ZRangeByScore, database.CmdTypeZRangeByScore, "z", "2", "3", "WITHSCORES", "LIMIT", "1", "1"))
+		assert.Equal(t, "*2\r\n$1\r\nc\r\n$1\r\nb\r\n", exec(k.ZRevRangeByScore, database.CmdTypeZRevRangeByScore, "z", "3", "(2"))
+		assert.Equal(t, "*1\r\n$1\r\nc\r\n", exec(k.ZRevRange, database.CmdTypeZRevRange, "z", "0", "0"))
+		assert.Equal(t, "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n",
+			exec(k.ZRange, database.CmdTypeZRange, "z", "0", "-1", "LIMIT", "0", "1"))
+	})
+
+	t.Run("zrangebylex", func(t *testing.T) {
+		exec(k.ZAdd, database.CmdTypeZAdd, "lex", "0", "a", "0", "b", "0", "c", "0", "d")
+		assert.Equal(t, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n", exec(k.ZRangeByLex, database.CmdTypeZRangeByLex, "lex", "(a", "[c"))
+		assert.Equal(t, "*2\r\n$1\r\nd\r\n$1\r\nc\r\n", exec(k.ZRevRangeByLex, database.CmdTypeZRevRangeByLex, "lex", "+", "-", "LIMIT", "0", "2"))
+		assert.Equal(t, ":3\r\n", exec(k.ZLexCount, database.CmdTypeZLexCount, "lex", "[b", "+"))
+		assert.Equal(t, ":2\r\n", exec(k.ZRemRangeByLex, database.CmdTypeZRemRangeByLex, "lex", "-", "(c"))
+		assert.Equal(t, "-ERR min or max not valid string range item\r\n", exec(k.ZLexCount, database.CmdTypeZLexCount, "lex", "b", "+"))
+	})
+
+	t.Run("zrank_zscore", func(t *testing.T) {
+		assert.Equal(t, ":1\r\n", exec(k.ZRank, database.CmdTypeZRank, "z", "b"))
+		assert.Equal(t, ":0\r\n", exec(k.ZRevRank, database.CmdTypeZRevRank, "z", "c"))
+		assert.Equal(t, "*2\r\n:2\r\n$1\r\n3\r\n", exec(k.ZRank, database.CmdTypeZRank, "z", "c", "WITHSCORE"))
+		assert.Equal(t, "$-1\r\n", exec(k.ZRank, database.CmdTypeZRank, "z", "none"))
+		assert.Equal(t, "$3\r\n2.5\r\n", exec(k.ZScore, database.CmdTypeZScore, "z", "b"))
+		assert.Equal(t, "*2\r\n$1\r\n2\r\n$-1\r\n", exec(k.ZMScore, database.CmdTypeZMScore, "z", "a", "none"))
+		assert.Equal(t, ":3\r\n", exec(k.ZCard, database.CmdTypeZCard, "z"))
+		assert.Equal(t, ":2\r\n", exec(k.ZCount, database.CmdTypeZCount, "z", "(2", "+inf"))
+		assert.Equal(t, "$3\r\n4.5\r\n", exec(k.ZIncrBy, database.CmdTypeZIncrBy, "z", "2", "b"))
+
+		// 出错时不创建 key
+		assert.Equal(t, "-ERR value is not a valid float\r\n", exec(k.ZIncrBy, database.CmdTypeZIncrBy, "zincr", "x", "a"))
+		_, ok := k.data["zincr"]
+		assert.False(t, ok)
+		exec(k.ZAdd, database.CmdTypeZAdd, "zincr", "+inf", "a")
+		assert.Equal(t, "-ERR resulting score is not a number (NaN)\r\n", exec(k.ZIncrBy, database.CmdTypeZIncrBy, "zincr", "-inf", "a"))
+		assert.Equal(t, "$3\r\ninf\r\n", exec(k.ZScore, database.CmdTypeZScore, "zincr", "a"))
+	})
+
+	// z: a=2 c=3 b=4.5
+	t.Run("zpop", func(t *testing.T) {
+		persister.cmds = nil
+		assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\n2\r\n", exec(k.ZPopMin, database.CmdTypeZPopMin, "z"))
+		assert.Equal(t, "*4\r\n$1\r\nb\r\n$3\r\n4.5\r\n$1\r\nc\r\n$1\r\n3\r\n", exec(k.ZPopMax, database.CmdTypeZPopMax, "z", "5"))
+		assert.Equal(t, "*0\r\n", exec(k.ZPopMax, database.CmdTypeZPopMax, "z"))
+		_, ok := k.data["z"]
+		assert.False(t, ok)
+		assert.Equal(t, 2, len(persister.cmds))
+	})
+
+	t.Run("zrem", func(t *testing.T) {
+		exec(k.ZAdd, database.CmdTypeZAdd, "zrem", "1", "zrem", "2", "b")
+		assert.Equal(t, ":1\r\n", exec(k.ZRem, database.CmdTypeZRem, "zrem", "b"))
+		assert.Equal(t, ":1\r\n", exec(k.ZRemRangeByRank, database.CmdTypeZRemRangeByRank, "zrem", "0", "-1"))
+		_, ok := k.data["zrem"]
+		assert.False(t, ok)
+	})
+}
+
+func Test_kv_sorted_set_aggregate_cmds(t *testing.T) {
+	k, _ := newTestKVStore()
+	exec := func(f func(*database.Command) handler.Reply, cmd database.CmdType, args ...string) string {
+		return string(f(newTestCmd(cmd, args...)).ToBytes())
+	}
+
+	exec(k.ZAdd, database.CmdTypeZAdd, "z1", "1", "a", "2", "b", "3", "c")
+	exec(k.ZAdd, database.CmdTypeZAdd, "z2", "10", "b", "20", "c", "30", "d")
+	exec(k.SAdd, database.CmdTypeSAdd, "s", "c", "e")
+
+	t.Run("zunion", func(t *testing.T) {
+		assert.Equal(t, "*10\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\ne\r\n$1\r\n2\r\n$1\r\nb\r\n$2\r\n22\r\n$1\r\nc\r\n$2\r\n45\r\n$1\r\nd\r\n$2\r\n60\r\n",
+			exec(k.ZUnion, database.CmdTypeZUnion, "3", "z1", "z2", "s", "WEIGHTS", "1", "2", "2", "WITHSCORES"))
+		assert.Equal(t, "*8\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$2\r\n30\r\n",
+			exec(k.ZUnion, database.CmdTypeZUnion, "2", "z1", "z2", "AGGREGATE", "MIN", "WITHSCORES"))
+	})
+
+	t.Run("zinter", func(t *testing.T) {
+		assert.Equal(t, "*2\r\n$1\r\nc\r\n$2\r\n20\r\n", exec(k.ZInter, database.CmdTypeZInter, "3", "z1", "z2", "s", "AGGREGATE", "MAX", "WITHSCORES"))
+		assert.Equal(t, ":2\r\n", exec(k.ZInterStore, database.CmdTypeZInterStore, "dest", "2", "z1", "z2"))
+		assert.Equal(t, "*4\r\n$1\r\nb\r\n$2\r\n12\r\n$1\r\nc\r\n$2\r\n23\r\n", exec(k.ZRange, database.CmdTypeZRange, "dest", "0", "-1", "WITHSCORES"))
+		assert.Equal(t, ":0\r\n", exec(k.ZInterStore, database.CmdTypeZInterStore, "dest", "2", "z1", "none"))
+		_, ok := k.data["dest"]
+		assert.False(t, ok)
+
+		// 基数最小的集合不是首个集合时，成员同样需要在首个集合中存在
+		exec(k.ZAdd, database.CmdTypeZAdd, "zx", "1", "x", "2", "z")
+		exec(k.ZAdd, database.CmdTypeZAdd, "zy", "5", "y")
+		assert.Equal(t, "*0\r\n", exec(k.ZInter, database.CmdTypeZInter, "2", "zx", "zy", "WITHSCORES"))
+		assert.Equal(t, ":0\r\n", exec(k.ZInterStore, database.CmdTypeZInterStore, "dest", "2", "zx", "zy"))
+		_, ok = k.data["dest"]
+		assert.False(t, ok)
+	})
+
+	t.Run("zdiff", func(t *testing.T) {
+		assert.Equal(t, "*1\r\n$1\r\na\r\n", exec(k.ZDiff, database.CmdTypeZDiff, "2", "z1", "z2"))
+		assert.Equal(t, ":1\r\n", exec(k.ZDiffStore, database.CmdTypeZDiffStore, "dest", "2", "z2", "z1"))
+		assert.Equal(t, "-ERR syntax error\r\n", exec(k.ZDiff, database.CmdTypeZDiff, "2", "z1", "z2", "WEIGHTS", "1", "1"))
+	})
+
+	t.Run("zrangestore", func(t *testing.T) {
+		assert.Equal(t, ":2\r\n", exec(k.ZRangeStore, database.CmdTypeZRangeStore, "dest", "z2", "(10", "+inf", "BYSCORE"))
+		assert.Equal(t, "*2\r\n$1\r\nc\r\n$1\r\nd\r\n", exec(k.ZRange, database.CmdTypeZRange, "dest", "0", "-1"))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		exec(k.Set, database.CmdTypeSet, "str", "v")
+		assert.Equal(t, string(handler.NewWrongTypeErrReply().ToBytes()), exec(k.ZUnion, database.CmdTypeZUnion, "2", "z1", "str"))
+		assert.Equal(t, "-ERR at least 1 input key is needed for 'zunionstore' command\r\n", exec(k.ZUnionStore, database.CmdTypeZUnionStore, "dest", "0", "z1"))
+		assert.Equal(t, "-ERR weight value is not a float\r\n", exec(k.ZUnion, database.CmdTypeZUnion, "1", "z1", "WEIGHTS", "x"))
+	})
+}
+
+func Test_kv_resp3_replies(t *testing.T) {
+	k, _ := newTestKVStore()
+	k.HSet(newTestCmd(database.CmdTypeHSet, "h", "f1", "v1", "f2", "v2"))
+	k.ZAdd(newTestCmd(database.CmdTypeZAdd, "z", "1", "a", "2.5", "b"))
+	k.SAdd(newTestCmd(database.CmdTypeSAdd, "s", "x"))
+
+	encode := func(reply handler.Reply) [2]string {
+		return [2]string{string(handler.EncodeReply(reply, handler.Resp2)), string(handler.EncodeReply(reply, handler.Resp3))}
+	}
+
+	cases := []struct {
+		reply  handler.Reply
+		expect [2]string
+	}{
+		{k.HGetAll(newTestCmd(database.CmdTypeHGetAll, "h")), [2]string{
+			"*4\r\n$2\r\nf1\r\n$2\r\nv1\r\n$2\r\nf2\r\n$2\r\nv2\r\n",
+			"%2\r\n$2\r\nf1\r\n$2\r\nv1\r\n$2\r\nf2\r\n$2\r\nv2\r\n",
+		}},
+		{k.HGetAll(newTestCmd(database.CmdTypeHGetAll, "none")), [2]string{"*0\r\n", "%0\r\n"}},
+		{k.ZRange(newTestCmd(database.CmdTypeZRange, "z", "0", "-1", "WITHSCORES")), [2]string{
+			"*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$3\r\n2.5\r\n",
+			"*2\r\n*2\r\n$1\r\na\r\n,1\r\n*2\r\n$1\r\nb\r\n,2.5\r\n",
+		}},
+		{k.ZScore(newTestCmd(database.CmdTypeZScore, "z", "b")), [2]string{"$3\r\n2.5\r\n", ",2.5\r\n"}},
+		{k.ZScore(newTestCmd(database.CmdTypeZScore, "z", "none")), [2]string{"$-1\r\n", "_\r\n"}},
+		{k.ZMScore(newTestCmd(database.CmdTypeZMScore, "z", "a", "none")), [2]string{"*2\r\n$1\r\n1\r\n$-1\r\n", "*2\r\n,1\r\n_\r\n"}},
+		{k.SMembers(newTestCmd(database.CmdTypeSMembers, "s")), [2]string{"*1\r\n$1\r\nx\r\n", "~1\r\n$1\r\nx\r\n"}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expect, encode(c.reply))
+	}
+}
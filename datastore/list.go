@@ -28,6 +28,7 @@ type List interface {
 	RPop(cnt int64) [][]byte
 	Len() int64
 	Range(start, stop int64) [][]byte
+	Encoding() string
 	database.CmdAdapter
 }
 
@@ -91,6 +92,10 @@ func (l *listEntity) Range(start, stop int64) [][]byte {
 	return l.data[start : stop+1]
 }
 
+func (l *listEntity) Encoding() string {
+	return encodingQuicklist
+}
+
 func (l *listEntity) ToCmd() [][]byte {
 	args := make([][]byte, 0, 2+l.Len())
 	args = append(args, []byte(database.CmdTypeRPush), []byte(l.key))
@@ -0,0 +1,458 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"goredis/database"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 按 key 的当前值刷新前缀匹配的索引. key 不存在或不是 hash 时从索引中移除
+func (k *KVStore) reindex(key string) {
+	if len(k.indexes) == 0 {
+		return
+	}
+
+	hmap, _ := k.getAsHashMap(key)
+	for _, index := range k.indexes {
+		if !index.match(key) {
+			continue
+		}
+		index.remove(key)
+		if hmap != nil {
+			index.add(key, hmap)
+		}
+	}
+}
+
+// 索引定义不随数据持久化，重写时以 ft.create 指令还原，数据由写入 hash 时重新建立
+func (k *KVStore) MetaCmds() [][][]byte {
+	names := make([]string, 0, len(k.indexes))
+	for name := range k.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cmds := make([][][]byte, 0, len(names))
+	for _, name := range names {
+		cmds = append(cmds, k.indexes[name].toCmd())
+	}
+	return cmds
+}
+
+type searchFieldType string
+
+const (
+	searchFieldText    searchFieldType = "text"
+	searchFieldTag     searchFieldType = "tag"
+	searchFieldNumeric searchFieldType = "numeric"
+)
+
+const searchDefaultTagSeparator = ","
+
+type searchField struct {
+	name      string
+	typ       searchFieldType
+	separator string
+	sortable  bool
+}
+
+type searchIndex struct {
+	name     string
+	prefixes []string
+	fields   []*searchField
+	// 已索引的文档及其字段原始值
+	docs map[string]map[string]string
+	// field -> 分词/标签 -> keys
+	terms map[string]map[string]map[string]struct{}
+	// field -> 以数值为 score 的 key 集合
+	numbers map[string]SortedSet
+}
+
+func newSearchIndex(name string, prefixes []string, fields []*searchField) *searchIndex {
+	index := searchIndex{
+		name:     name,
+		prefixes: prefixes,
+		fields:   fields,
+		docs:     make(map[string]map[string]string),
+		terms:    make(map[string]map[string]map[string]struct{}),
+		numbers:  make(map[string]SortedSet),
+	}
+	for _, field := range fields {
+		if field.typ == searchFieldNumeric {
+			index.numbers[field.name] = newSkiplist(field.name)
+		} else {
+			index.terms[field.name] = make(map[string]map[string]struct{})
+		}
+	}
+	return &index
+}
+
+func (s *searchIndex) field(name string) *searchField {
+	for _, field := range s.fields {
+		if field.name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+func (s *searchIndex) match(key string) bool {
+	if len(s.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 索引覆盖的 key，以前缀加 * 表示，用于检查 ACL 的 key 模式. 未指定前缀时覆盖全部 key
+func searchKeyPatterns(prefixes []string) []string {
+	if len(prefixes) == 0 {
+		return []string{"*"}
+	}
+	patterns := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		patterns = append(patterns, prefix+"*")
+	}
+	return patterns
+}
+
+// 文本按字母和数字以外的字符分词，统一小写
+func tokenizeSearchText(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func splitSearchTags(raw, separator string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(raw, separator) {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (f *searchField) tokens(value string) []string {
+	if f.typ == searchFieldTag {
+		return splitSearchTags(value, f.separator)
+	}
+	return tokenizeSearchText(value)
+}
+
+func (s *searchIndex) add(key string, hmap HashMap) {
+	values := make(map[string]string)
+	for _, field := range s.fields {
+		value := hmap.Get(field.name)
+		if value == nil {
+			continue
+		}
+		values[field.name] = string(value)
+
+		if field.typ == searchFieldNumeric {
+			if number, err := strconv.ParseFloat(string(value), 64); err == nil {
+				s.numbers[field.name].Add(number, key)
+			}
+			continue
+		}
+		for _, token := range field.tokens(string(value)) {
+			keys, ok := s.terms[field.name][token]
+			if !ok {
+				keys = make(map[string]struct{})
+				s.terms[field.name][token] = keys
+			}
+			keys[key] = struct{}{}
+		}
+	}
+	s.docs[key] = values
+}
+
+func (s *searchIndex) remove(key string) {
+	values, ok := s.docs[key]
+	if !ok {
+		return
+	}
+
+	for _, field := range s.fields {
+		value, ok := values[field.name]
+		if !ok {
+			continue
+		}
+		if field.typ == searchFieldNumeric {
+			s.numbers[field.name].Rem(key)
+			continue
+		}
+		for _, token := range field.tokens(value) {
+			if keys, ok := s.terms[field.name][token]; ok {
+				delete(keys, key)
+				if len(keys) == 0 {
+					delete(s.terms[field.name], token)
+				}
+			}
+		}
+	}
+	delete(s.docs, key)
+}
+
+func (s *searchIndex) toCmd() [][]byte {
+	cmd := [][]byte{[]byte(database.CmdTypeFTCreate), []byte(s.name), []byte("on"), []byte("hash")}
+	if len(s.prefixes) > 0 {
+		cmd = append(cmd, []byte("prefix"), []byte(strconv.Itoa(len(s.prefixes))))
+		for _, prefix := range s.prefixes {
+			cmd = append(cmd, []byte(prefix))
+		}
+	}
+
+	cmd = append(cmd, []byte("schema"))
+	for _, field := range s.fields {
+		cmd = append(cmd, []byte(field.name), []byte(field.typ))
+		if field.typ == searchFieldTag && field.separator != searchDefaultTagSeparator {
+			cmd = append(cmd, []byte("separator"), []byte(field.separator))
+		}
+		if field.sortable {
+			cmd = append(cmd, []byte("sortable"))
+		}
+	}
+	return cmd
+}
+
+type searchKeys map[string]struct{}
+
+// 查询条件
+type searchQuery interface {
+	eval(s *searchIndex) searchKeys
+}
+
+// 匹配全部文档
+type searchQueryAll struct{}
+
+func (q *searchQueryAll) eval(s *searchIndex) searchKeys {
+	keys := make(searchKeys, len(s.docs))
+	for key := range s.docs {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+// 匹配任意一个 token. field 为空时匹配所有 TEXT 字段，token 以 * 结尾时按前缀匹配
+type searchQueryTerms struct {
+	field  string
+	tokens []string
+}
+
+func (q *searchQueryTerms) eval(s *searchIndex) searchKeys {
+	keys := make(searchKeys)
+	for _, field := range s.fields {
+		if (q.field == "" && field.typ != searchFieldText) || (q.field != "" && q.field != field.name) {
+			continue
+		}
+		for _, token := range q.tokens {
+			if prefix := strings.TrimSuffix(token, "*"); prefix != token {
+				for term, termKeys := range s.terms[field.name] {
+					if strings.HasPrefix(term, prefix) {
+						mergeSearchKeys(keys, termKeys)
+					}
+				}
+				continue
+			}
+			mergeSearchKeys(keys, s.terms[field.name][token])
+		}
+	}
+	return keys
+}
+
+func mergeSearchKeys(dst, src searchKeys) {
+	for key := range src {
+		dst[key] = struct{}{}
+	}
+}
+
+type searchQueryNumeric struct {
+	field    string
+	min, max *ScoreBorder
+}
+
+func (q *searchQueryNumeric) eval(s *searchIndex) searchKeys {
+	keys := make(searchKeys)
+	for _, member := range s.numbers[q.field].RangeByScore(q.min, q.max, 0, -1, false) {
+		keys[member.Member] = struct{}{}
+	}
+	return keys
+}
+
+// 同时满足所有条件，negate 中的条件取反
+type searchQueryAnd struct {
+	queries []searchQuery
+	negate  []bool
+}
+
+func (q *searchQueryAnd) eval(s *searchIndex) searchKeys {
+	var keys searchKeys
+	for i, query := range q.queries {
+		if q.negate[i] {
+			continue
+		}
+		sub := query.eval(s)
+		if keys == nil {
+			keys = sub
+			continue
+		}
+		for key := range keys {
+			if _, ok := sub[key]; !ok {
+				delete(keys, key)
+			}
+		}
+	}
+	if keys == nil {
+		keys = (&searchQueryAll{}).eval(s)
+	}
+
+	for i, query := range q.queries {
+		if !q.negate[i] {
+			continue
+		}
+		for key := range query.eval(s) {
+			delete(keys, key)
+		}
+	}
+	return keys
+}
+
+func errSearchSyntax(query string) error {
+	return fmt.Errorf("Syntax error at offset 0 near %s", query)
+}
+
+// 解析查询语句，空格分隔的条件之间取交集，条件前加 - 代表取反. 支持:
+// * 匹配全部文档; word 或 word* 匹配任意 TEXT 字段的分词或分词前缀;
+// @field:word 或 @field:(a|b) 匹配 TEXT 字段; @field:{a | b} 匹配 TAG 字段;
+// @field:[min max] 匹配 NUMERIC 字段区间，( 代表开区间，支持 -inf +inf
+func parseSearchQuery(s *searchIndex, raw string) (searchQuery, error) {
+	query := strings.TrimSpace(raw)
+	if query == "*" {
+		return &searchQueryAll{}, nil
+	}
+
+	and := searchQueryAnd{}
+	for query != "" {
+		negate := strings.HasPrefix(query, "-")
+		if negate {
+			query = query[1:]
+		}
+
+		var (
+			sub searchQuery
+			err error
+		)
+		if strings.HasPrefix(query, "@") {
+			sub, query, err = parseSearchFieldQuery(s, query[1:])
+		} else {
+			var word string
+			word, query = cutSearchQuery(query, " ")
+			sub = &searchQueryTerms{tokens: normalizeSearchTokens([]string{word})}
+			if len(sub.(*searchQueryTerms).tokens) == 0 {
+				err = errSearchSyntax(raw)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		and.queries = append(and.queries, sub)
+		and.negate = append(and.negate, negate)
+		query = strings.TrimSpace(query)
+	}
+
+	if len(and.queries) == 0 {
+		return nil, errSearchSyntax(raw)
+	}
+	return &and, nil
+}
+
+// 返回 sep 之前的内容和剩余部分，不存在 sep 时返回全部
+func cutSearchQuery(query, sep string) (string, string) {
+	if i := strings.Index(query, sep); i >= 0 {
+		return query[:i], query[i+len(sep):]
+	}
+	return query, ""
+}
+
+// 分词规则与建立索引时一致，保留结尾的 * 作为前缀匹配
+func normalizeSearchTokens(words []string) []string {
+	tokens := []string{}
+	for _, word := range words {
+		prefix := strings.HasSuffix(word, "*")
+		for _, token := range tokenizeSearchText(word) {
+			if prefix {
+				token += "*"
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func parseSearchFieldQuery(s *searchIndex, query string) (searchQuery, string, error) {
+	name, rest := cutSearchQuery(query, ":")
+	field := s.field(name)
+	if field == nil {
+		return nil, "", fmt.Errorf("Unknown field `%s`", name)
+	}
+
+	switch field.typ {
+	case searchFieldTag:
+		if !strings.HasPrefix(rest, "{") {
+			return nil, "", errSearchSyntax(query)
+		}
+		body, rest, ok := strings.Cut(rest[1:], "}")
+		if !ok {
+			return nil, "", errSearchSyntax(query)
+		}
+		return &searchQueryTerms{field: name, tokens: splitSearchTags(body, "|")}, rest, nil
+
+	case searchFieldNumeric:
+		if !strings.HasPrefix(rest, "[") {
+			return nil, "", errSearchSyntax(query)
+		}
+		body, rest, ok := strings.Cut(rest[1:], "]")
+		bounds := strings.Fields(body)
+		if !ok || len(bounds) != 2 {
+			return nil, "", errSearchSyntax(query)
+		}
+		min, err := parseScoreBorder(bounds[0])
+		if err != nil {
+			return nil, "", errors.New("Bad lower range: " + bounds[0])
+		}
+		max, err := parseScoreBorder(bounds[1])
+		if err != nil {
+			return nil, "", errors.New("Bad upper range: " + bounds[1])
+		}
+		return &searchQueryNumeric{field: name, min: min, max: max}, rest, nil
+
+	default:
+		var words []string
+		if strings.HasPrefix(rest, "(") {
+			body, remain, ok := strings.Cut(rest[1:], ")")
+			if !ok {
+				return nil, "", errSearchSyntax(query)
+			}
+			words, rest = strings.Split(body, "|"), remain
+		} else {
+			var word string
+			word, rest = cutSearchQuery(rest, " ")
+			words = []string{word}
+		}
+		tokens := normalizeSearchTokens(words)
+		if len(tokens) == 0 {
+			return nil, "", errSearchSyntax(query)
+		}
+		return &searchQueryTerms{field: name, tokens: tokens}, rest, nil
+	}
+}
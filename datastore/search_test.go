@@ -0,0 +1,108 @@
+package datastore
+
+import (
+	"goredis/database"
+	"goredis/lib"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSearchKVStore() (*KVStore, *recordPersister) {
+	kv, persister := newTestKVStore()
+	kv.HSet(newTestCmd(database.CmdTypeHSet, "user:1", "name", "Alice Smith", "city", "Paris,London", "age", "30"))
+	kv.HSet(newTestCmd(database.CmdTypeHSet, "user:2", "name", "Bob Smith", "city", "london", "age", "25"))
+	kv.HSet(newTestCmd(database.CmdTypeHSet, "other:1", "name", "Alice Other", "age", "40"))
+	return kv, persister
+}
+
+func Test_kv_search_cmds(t *testing.T) {
+	kv, persister := newTestSearchKVStore()
+
+	reply := kv.FTCreate(newTestCmd(database.CmdTypeFTCreate, "idx", "ON", "HASH", "PREFIX", "1", "user:", "SCHEMA",
+		"name", "TEXT", "SORTABLE", "city", "TAG", "age", "NUMERIC", "SORTABLE"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = kv.FTCreate(newTestCmd(database.CmdTypeFTCreate, "idx", "SCHEMA", "name", "TEXT"))
+	assert.Equal(t, "-Index already exists\r\n", string(reply.ToBytes()))
+	reply = kv.FTCreate(newTestCmd(database.CmdTypeFTCreate, "bad", "SCHEMA", "name", "GEO"))
+	assert.Equal(t, "-Invalid field type for field `name`\r\n", string(reply.ToBytes()))
+
+	// 建立索引后写入的数据同样被索引
+	kv.HSet(newTestCmd(database.CmdTypeHSet, "user:3", "name", "Carol", "city", "Berlin", "age", "35"))
+
+	cases := []struct {
+		query  []string
+		expect string
+	}{
+		{[]string{"*", "NOCONTENT"}, "*4\r\n:3\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n$6\r\nuser:3\r\n"},
+		{[]string{"smith", "NOCONTENT"}, "*3\r\n:2\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n"},
+		{[]string{"smith -@city:{paris}", "NOCONTENT"}, "*2\r\n:1\r\n$6\r\nuser:2\r\n"},
+		{[]string{"@city:{London | berlin}", "NOCONTENT", "SORTBY", "age", "DESC"}, "*4\r\n:3\r\n$6\r\nuser:3\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n"},
+		{[]string{"@age:[(25 +inf]", "NOCONTENT", "SORTBY", "name"}, "*3\r\n:2\r\n$6\r\nuser:1\r\n$6\r\nuser:3\r\n"},
+		{[]string{"@name:(ali*|carol)", "NOCONTENT", "LIMIT", "1", "1"}, "*2\r\n:2\r\n$6\r\nuser:3\r\n"},
+		{[]string{"@name:bob", "RETURN", "1", "age"}, "*3\r\n:1\r\n$6\r\nuser:2\r\n*2\r\n$3\r\nage\r\n$2\r\n25\r\n"},
+		{[]string{"@name:bob"}, "*3\r\n:1\r\n$6\r\nuser:2\r\n*6\r\n$4\r\nname\r\n$9\r\nBob Smith\r\n$4\r\ncity\r\n$6\r\nlondon\r\n$3\r\nage\r\n$2\r\n25\r\n"},
+		{[]string{"@none:x"}, "-Unknown field `none`\r\n"},
+		{[]string{"@age:[1]"}, "-Syntax error at offset 0 near age:[1]\r\n"},
+	}
+	for _, c := range cases {
+		reply = kv.FTSearch(newTestCmd(database.CmdTypeFTSearch, append([]string{"idx"}, c.query...)...))
+		assert.Equal(t, c.expect, string(reply.ToBytes()), c.query)
+	}
+
+	// 修改、删除字段以及覆盖为其他类型时更新索引
+	kv.HSet(newTestCmd(database.CmdTypeHSet, "user:2", "name", "Bob Jones"))
+	kv.HDel(newTestCmd(database.CmdTypeHDel, "user:1", "city"))
+	reply = kv.FTSearch(newTestCmd(database.CmdTypeFTSearch, "idx", "smith | @city:{london}", "NOCONTENT"))
+	assert.Equal(t, "-Syntax error at offset 0 near smith | @city:{london}\r\n", string(reply.ToBytes()))
+	reply = kv.FTSearch(newTestCmd(database.CmdTypeFTSearch, "idx", "@name:smith", "NOCONTENT"))
+	assert.Equal(t, "*2\r\n:1\r\n$6\r\nuser:1\r\n", string(reply.ToBytes()))
+	reply = kv.FTSearch(newTestCmd(database.CmdTypeFTSearch, "idx", "@city:{london}", "NOCONTENT"))
+	assert.Equal(t, "*2\r\n:1\r\n$6\r\nuser:2\r\n", string(reply.ToBytes()))
+	kv.put("user:2", "x", false)
+	kv.expire("user:3", lib.TimeNow().Add(-time.Second))
+	reply = kv.FTSearch(newTestCmd(database.CmdTypeFTSearch, "idx", "*", "NOCONTENT"))
+	assert.Equal(t, "*2\r\n:1\r\n$6\r\nuser:1\r\n", string(reply.ToBytes()))
+	assert.Equal(t, 1, len(kv.indexes["idx"].docs))
+
+	// 重写时只记录索引定义
+	assert.Equal(t, [][][]byte{{
+		[]byte("ft.create"), []byte("idx"), []byte("on"), []byte("hash"), []byte("prefix"), []byte("1"), []byte("user:"),
+		[]byte("schema"), []byte("name"), []byte("text"), []byte("sortable"), []byte("city"), []byte("tag"),
+		[]byte("age"), []byte("numeric"), []byte("sortable"),
+	}}, kv.MetaCmds())
+	assert.Equal(t, [][]byte{[]byte("ft.create"), []byte("idx"), []byte("ON"), []byte("HASH"), []byte("PREFIX"), []byte("1"), []byte("user:"),
+		[]byte("SCHEMA"), []byte("name"), []byte("TEXT"), []byte("SORTABLE"), []byte("city"), []byte("TAG"),
+		[]byte("age"), []byte("NUMERIC"), []byte("SORTABLE")}, persister.cmds[3])
+
+	reply = kv.FTDropIndex(newTestCmd(database.CmdTypeFTDropIndex, "idx", "DD"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	_, ok := kv.data["user:1"]
+	assert.False(t, ok)
+	reply = kv.FTSearch(newTestCmd(database.CmdTypeFTSearch, "idx", "*"))
+	assert.Equal(t, "-"+errSearchNoIndex.Error()+"\r\n", string(reply.ToBytes()))
+}
+
+func Test_search_rebuild(t *testing.T) {
+	kv, _ := newTestSearchKVStore()
+	kv.FTCreate(newTestCmd(database.CmdTypeFTCreate, "idx", "SCHEMA", "city", "TAG", "SEPARATOR", ";", "name", "TEXT"))
+	kv.HSet(newTestCmd(database.CmdTypeHSet, "x", "city", "a;b"))
+
+	// 先还原索引定义，再写入数据
+	restore, _ := newTestKVStore()
+	for _, cmd := range kv.MetaCmds() {
+		restore.FTCreate(database.NewCommand(database.CmdTypeFTCreate, cmd[1:]))
+	}
+	kv.ForEach(func(key string, adapter database.CmdAdapter, expireAt *time.Time) {
+		cmd := adapter.ToCmd()
+		restore.HSet(database.NewCommand(database.CmdType(cmd[0]), cmd[1:]))
+	})
+
+	for _, query := range []string{"*", "alice", "@city:{b}"} {
+		expect := kv.FTSearch(newTestCmd(database.CmdTypeFTSearch, "idx", query, "NOCONTENT"))
+		reply := restore.FTSearch(newTestCmd(database.CmdTypeFTSearch, "idx", query, "NOCONTENT"))
+		assert.Equal(t, string(expect.ToBytes()), string(reply.ToBytes()), query)
+	}
+	assert.Equal(t, 4, len(restore.indexes["idx"].docs))
+}
@@ -1,7 +1,13 @@
 package datastore
 
-import "goredis/handler"
-import "goredis/database"
+import (
+	"goredis/database"
+	"goredis/handler"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
 
 func (k *KVStore) getAsSet(key string) (Set, error) {
 	v, ok := k.data[key]
@@ -21,26 +27,98 @@ func (k *KVStore) putAsSet(key string, set Set) {
 	k.data[key] = set
 }
 
+// 批量获取 set，不存在的 key 对应位置为 nil
+func (k *KVStore) getAsSets(keys [][]byte) ([]Set, error) {
+	sets := make([]Set, 0, len(keys))
+	for _, key := range keys {
+		k.ExpirePreprocess(string(key))
+		set, err := k.getAsSet(string(key))
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+func newMembersReply(members []string) handler.Reply {
+	if len(members) == 0 {
+		return handler.NewEmptyMultiBulkReply()
+	}
+
+	res := make([][]byte, 0, len(members))
+	for _, member := range members {
+		res = append(res, []byte(member))
+	}
+	return handler.NewMultiBulkReply(res)
+}
+
+// 集合运算与 SMEMBERS 的结果，RESP3 下编码为集合类型
+func newSetMembersReply(members []string) handler.Reply {
+	res := make([]handler.Reply, 0, len(members))
+	for _, member := range members {
+		res = append(res, handler.NewBulkReply([]byte(member)))
+	}
+	return handler.NewSetReply(res)
+}
+
 type Set interface {
 	Add(value string) int64
 	Exist(value string) int64
 	Rem(value string) int64
+	Len() int64
+	Members() []string
+	// cnt > 0 时返回至多 cnt 个不重复的元素；cnt < 0 时返回 |cnt| 个元素，允许重复
+	RandMembers(cnt int64) []string
+	Encoding() string
 	database.CmdAdapter
 }
 
+// 元素均为整数且数量较少时，采用有序 intset 编码；否则转为 hashtable 编码
 type setEntity struct {
 	key       string
+	conf      *encodingConf
+	intset    []int64
 	container map[string]struct{}
 }
 
 func newSetEntity(key string) Set {
-	return &setEntity{
-		key:       key,
-		container: make(map[string]struct{}),
+	return newSetEntityWithConf(key, defaultEncodingConf)
+}
+
+func newSetEntityWithConf(key string, conf *encodingConf) Set {
+	s := setEntity{
+		key:  key,
+		conf: conf,
+	}
+	if conf.setMaxIntsetEntries <= 0 {
+		s.container = make(map[string]struct{})
 	}
+	return &s
+}
+
+func (s *setEntity) isIntset() bool {
+	return s.container == nil
 }
 
 func (s *setEntity) Add(value string) int64 {
+	if s.isIntset() {
+		if i, ok := parseIntsetMember(value); ok {
+			index, exist := s.intsetSearch(i)
+			if exist {
+				return 0
+			}
+			if len(s.intset) < s.conf.setMaxIntsetEntries {
+				s.intset = append(s.intset, 0)
+				copy(s.intset[index+1:], s.intset[index:])
+				s.intset[index] = i
+				return 1
+			}
+		}
+		// 非整数元素或数量超出阈值，转为 hashtable 编码
+		s.convertToHashtable()
+	}
+
 	if _, ok := s.container[value]; ok {
 		return 0
 	}
@@ -49,6 +127,17 @@ func (s *setEntity) Add(value string) int64 {
 }
 
 func (s *setEntity) Exist(value string) int64 {
+	if s.isIntset() {
+		i, ok := parseIntsetMember(value)
+		if !ok {
+			return 0
+		}
+		if _, exist := s.intsetSearch(i); exist {
+			return 1
+		}
+		return 0
+	}
+
 	if _, ok := s.container[value]; ok {
 		return 1
 	}
@@ -56,6 +145,19 @@ func (s *setEntity) Exist(value string) int64 {
 }
 
 func (s *setEntity) Rem(value string) int64 {
+	if s.isIntset() {
+		i, ok := parseIntsetMember(value)
+		if !ok {
+			return 0
+		}
+		index, exist := s.intsetSearch(i)
+		if !exist {
+			return 0
+		}
+		s.intset = append(s.intset[:index], s.intset[index+1:]...)
+		return 1
+	}
+
 	if _, ok := s.container[value]; ok {
 		delete(s.container, value)
 		return 1
@@ -63,12 +165,182 @@ func (s *setEntity) Rem(value string) int64 {
 	return 0
 }
 
+func (s *setEntity) Encoding() string {
+	if s.isIntset() {
+		return encodingIntset
+	}
+	return encodingHashtable
+}
+
+// 二分查找，返回 i 在 intset 中的位置(或应插入的位置)以及是否存在
+func (s *setEntity) intsetSearch(i int64) (int, bool) {
+	index := sort.Search(len(s.intset), func(j int) bool {
+		return s.intset[j] >= i
+	})
+	return index, index < len(s.intset) && s.intset[index] == i
+}
+
+func (s *setEntity) convertToHashtable() {
+	s.container = make(map[string]struct{}, len(s.intset))
+	for _, i := range s.intset {
+		s.container[strconv.FormatInt(i, 10)] = struct{}{}
+	}
+	s.intset = nil
+}
+
+// 只有规范格式的整数才能放入 intset，例如 "01"、"+1" 需要按字符串存储
+func parseIntsetMember(value string) (int64, bool) {
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || strconv.FormatInt(i, 10) != value {
+		return 0, false
+	}
+	return i, true
+}
+
+func (s *setEntity) Len() int64 {
+	if s.isIntset() {
+		return int64(len(s.intset))
+	}
+	return int64(len(s.container))
+}
+
+func (s *setEntity) Members() []string {
+	if s.isIntset() {
+		members := make([]string, 0, len(s.intset))
+		for _, i := range s.intset {
+			members = append(members, strconv.FormatInt(i, 10))
+		}
+		return members
+	}
+
+	members := make([]string, 0, len(s.container))
+	for member := range s.container {
+		members = append(members, member)
+	}
+	return members
+}
+
+// 随机选取时预分配的最大元素个数
+const maxRandPrealloc = 1024
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (s *setEntity) RandMembers(cnt int64) []string {
+	members := s.Members()
+	if cnt < 0 {
+		// 允许重复，每次独立随机选取. 数量由客户端指定，预分配时设置上限，按实际选取的元素扩容
+		n := -cnt
+		if n < 0 {
+			n = math.MaxInt64
+		}
+		if len(members) == 0 {
+			return []string{}
+		}
+		res := make([]string, 0, min64(n, maxRandPrealloc))
+		for i := int64(0); i < n; i++ {
+			res = append(res, members[rand.Intn(len(members))])
+		}
+		return res
+	}
+
+	if cnt >= int64(len(members)) {
+		return members
+	}
+
+	// 部分洗牌，取前 cnt 个
+	for i := int64(0); i < cnt; i++ {
+		j := i + rand.Int63n(int64(len(members))-i)
+		members[i], members[j] = members[j], members[i]
+	}
+	return members[:cnt]
+}
+
 func (s *setEntity) ToCmd() [][]byte {
-	args := make([][]byte, 0, 2+len(s.container))
+	members := s.Members()
+	args := make([][]byte, 0, 2+len(members))
 	args = append(args, []byte(database.CmdTypeSAdd), []byte(s.key))
-	for k := range s.container {
-		args = append(args, []byte(k))
+	for _, member := range members {
+		args = append(args, []byte(member))
 	}
 
 	return args
-}
\ No newline at end of file
+}
+
+// 多个 set 求交集. nil 代表不存在的 key，视为空集
+func setInter(sets []Set) []string {
+	if len(sets) == 0 {
+		return []string{}
+	}
+
+	// 以基数最小的 set 作为遍历基准
+	smallest := sets[0]
+	for _, set := range sets {
+		if set == nil {
+			return []string{}
+		}
+		if set.Len() < smallest.Len() {
+			smallest = set
+		}
+	}
+
+	res := []string{}
+	for _, member := range smallest.Members() {
+		hit := true
+		for _, set := range sets {
+			if set.Exist(member) == 0 {
+				hit = false
+				break
+			}
+		}
+		if hit {
+			res = append(res, member)
+		}
+	}
+	return res
+}
+
+// 多个 set 求并集
+func setUnion(sets []Set) []string {
+	union := make(map[string]struct{})
+	res := []string{}
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		for _, member := range set.Members() {
+			if _, ok := union[member]; ok {
+				continue
+			}
+			union[member] = struct{}{}
+			res = append(res, member)
+		}
+	}
+	return res
+}
+
+// 首个 set 与其余 set 求差集
+func setDiff(sets []Set) []string {
+	if len(sets) == 0 || sets[0] == nil {
+		return []string{}
+	}
+
+	res := []string{}
+	for _, member := range sets[0].Members() {
+		hit := false
+		for _, set := range sets[1:] {
+			if set != nil && set.Exist(member) == 1 {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			res = append(res, member)
+		}
+	}
+	return res
+}
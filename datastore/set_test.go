@@ -87,4 +87,63 @@ func Test_set_to_cmd(t *testing.T) {
 	t.Run("member", func(t *testing.T) {
 		assert.Equal(t, expect, actual)
 	})
-}
\ No newline at end of file
+}
+func Test_set_rand_members(t *testing.T) {
+	set := newSetEntity("")
+	for i := 0; i < 10; i++ {
+		set.Add(cast.ToString(i))
+	}
+
+	t.Run("positive_count", func(t *testing.T) {
+		members := set.RandMembers(5)
+		assert.Equal(t, 5, len(members))
+		uniq := make(map[string]struct{})
+		for _, member := range members {
+			uniq[member] = struct{}{}
+			assert.Equal(t, int64(1), set.Exist(member))
+		}
+		assert.Equal(t, 5, len(uniq))
+	})
+
+	t.Run("positive_count_over_len", func(t *testing.T) {
+		assert.Equal(t, 10, len(set.RandMembers(100)))
+	})
+
+	t.Run("negative_count", func(t *testing.T) {
+		members := set.RandMembers(-100)
+		assert.Equal(t, 100, len(members))
+		for _, member := range members {
+			assert.Equal(t, int64(1), set.Exist(member))
+		}
+	})
+}
+
+func Test_set_algebra(t *testing.T) {
+	newSet := func(members ...string) Set {
+		set := newSetEntity("")
+		for _, member := range members {
+			set.Add(member)
+		}
+		return set
+	}
+	sorted := func(members []string) []string {
+		sort.Strings(members)
+		return members
+	}
+
+	a, b, c := newSet("1", "2", "3", "4"), newSet("2", "3", "5"), newSet("3", "6")
+
+	t.Run("inter", func(t *testing.T) {
+		assert.Equal(t, []string{"3"}, sorted(setInter([]Set{a, b, c})))
+		assert.Equal(t, []string{}, setInter([]Set{a, nil}))
+	})
+
+	t.Run("union", func(t *testing.T) {
+		assert.Equal(t, []string{"1", "2", "3", "4", "5", "6"}, sorted(setUnion([]Set{a, b, nil, c})))
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		assert.Equal(t, []string{"1", "4"}, sorted(setDiff([]Set{a, b, c})))
+		assert.Equal(t, []string{}, setDiff([]Set{nil, a}))
+	})
+}
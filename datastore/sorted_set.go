@@ -1,12 +1,15 @@
 package datastore
 
 import (
+	"errors"
 	"goredis/database"
 	"goredis/handler"
 	"goredis/lib"
 	"math"
 	"math/rand"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 func (k *KVStore) getAsSortedSet(key string) (SortedSet, error) {
@@ -27,166 +30,822 @@ func (k *KVStore) putAsSortedSet(key string, zset SortedSet) {
 	k.data[key] = zset
 }
 
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
 type SortedSet interface {
-	Add(score int64, member string)
+	// 新增 member 返回 1，更新已有 member 的 score 返回 0
+	Add(score float64, member string) int64
 	Rem(member string) int64
-	Range(score1, score2 int64) []string
+	Score(member string) (float64, bool)
+	Len() int64
+	// member 的排名，从 0 开始. reverse 为 true 时按 score 从大到小排名
+	Rank(member string, reverse bool) (int64, bool)
+	// 排名区间 [start,stop]，从 0 开始
+	RangeByRank(start, stop int64, reverse bool) []ZMember
+	// score 区间 [score1,score2]
+	Range(score1, score2 float64) []string
+	// 按 score 区间查询，跳过 offset 个元素后至多返回 limit 个元素，limit < 0 代表不限制
+	RangeByScore(min, max *ScoreBorder, offset, limit int64, reverse bool) []ZMember
+	// 按 member 字典序区间查询，仅在所有 member 的 score 相同时有意义
+	RangeByLex(min, max *LexBorder, offset, limit int64, reverse bool) []ZMember
+	Encoding() string
 	database.CmdAdapter
 }
 
+var errNotValidFloat = errors.New("ERR value is not a valid float")
+
+// 解析 score，支持 inf、+inf、-inf
+func parseScore(raw string) (float64, error) {
+	switch strings.ToLower(raw) {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+
+	score, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(score) || math.IsInf(score, 0) {
+		return 0, errNotValidFloat
+	}
+	return score, nil
+}
+
+// 与回复中的浮点数格式一致
+func formatScore(score float64) string {
+	return handler.FormatDouble(score)
+}
+
+// score 区间边界，"(" 前缀代表开区间
+type ScoreBorder struct {
+	Value   float64
+	Exclude bool
+}
+
+func parseScoreBorder(raw string) (*ScoreBorder, error) {
+	border := ScoreBorder{}
+	if strings.HasPrefix(raw, "(") {
+		border.Exclude = true
+		raw = raw[1:]
+	}
+
+	value, err := parseScore(raw)
+	if err != nil {
+		return nil, errors.New("ERR min or max is not a float")
+	}
+	border.Value = value
+	return &border, nil
+}
+
+// score 是否满足作为下界的 border
+func (b *ScoreBorder) lowerThan(score float64) bool {
+	if b.Exclude {
+		return b.Value < score
+	}
+	return b.Value <= score
+}
+
+// score 是否满足作为上界的 border
+func (b *ScoreBorder) greaterThan(score float64) bool {
+	if b.Exclude {
+		return b.Value > score
+	}
+	return b.Value >= score
+}
+
+// 字典序区间边界. "[" 闭区间，"(" 开区间，"-" 与 "+" 分别代表负无穷与正无穷
+type LexBorder struct {
+	Value   string
+	Exclude bool
+	Inf     int
+}
+
+func parseLexBorder(raw string) (*LexBorder, error) {
+	switch {
+	case raw == "-":
+		return &LexBorder{Inf: -1}, nil
+	case raw == "+":
+		return &LexBorder{Inf: 1}, nil
+	case strings.HasPrefix(raw, "("):
+		return &LexBorder{Value: raw[1:], Exclude: true}, nil
+	case strings.HasPrefix(raw, "["):
+		return &LexBorder{Value: raw[1:]}, nil
+	}
+	return nil, errors.New("ERR min or max not valid string range item")
+}
+
+func (b *LexBorder) lowerThan(member string) bool {
+	switch {
+	case b.Inf != 0:
+		return b.Inf < 0
+	case b.Exclude:
+		return b.Value < member
+	}
+	return b.Value <= member
+}
+
+func (b *LexBorder) greaterThan(member string) bool {
+	switch {
+	case b.Inf != 0:
+		return b.Inf > 0
+	case b.Exclude:
+		return b.Value > member
+	}
+	return b.Value >= member
+}
+
+// 按 score 升序排列，score 相同时按 member 字典序排列
+func zmemberLess(score1 float64, member1 string, score2 float64, member2 string) bool {
+	return score1 < score2 || (score1 == score2 && member1 < member2)
+}
+
+// member 数量较少且 member 较短时，采用有序的 listpack 编码；否则转为 skiplist 编码
+type sortedSetEntity struct {
+	key      string
+	conf     *encodingConf
+	listpack []ZMember
+	skiplist SortedSet
+}
+
+func newSortedSetEntity(key string, conf *encodingConf) SortedSet {
+	z := sortedSetEntity{
+		key:  key,
+		conf: conf,
+	}
+	if conf.zsetMaxListpackEntries <= 0 {
+		z.skiplist = newSkiplist(key)
+	}
+	return &z
+}
+
+func (z *sortedSetEntity) isListpack() bool {
+	return z.skiplist == nil
+}
+
+func (z *sortedSetEntity) Add(score float64, member string) int64 {
+	if !z.isListpack() {
+		return z.skiplist.Add(score, member)
+	}
+
+	var added int64 = 1
+	if index := z.listpackSearch(member); index >= 0 {
+		if z.listpack[index].Score == score {
+			return 0
+		}
+		z.listpack = append(z.listpack[:index], z.listpack[index+1:]...)
+		added = 0
+	} else if len(z.listpack) >= z.conf.zsetMaxListpackEntries || len(member) > z.conf.zsetMaxListpackValue {
+		// 超出阈值，转为 skiplist 编码
+		z.convertToSkiplist()
+		return z.skiplist.Add(score, member)
+	}
+
+	// 有序插入
+	index := sort.Search(len(z.listpack), func(i int) bool {
+		return zmemberLess(score, member, z.listpack[i].Score, z.listpack[i].Member)
+	})
+	z.listpack = append(z.listpack, ZMember{})
+	copy(z.listpack[index+1:], z.listpack[index:])
+	z.listpack[index] = ZMember{Member: member, Score: score}
+	return added
+}
+
+func (z *sortedSetEntity) Rem(member string) int64 {
+	if !z.isListpack() {
+		return z.skiplist.Rem(member)
+	}
+
+	index := z.listpackSearch(member)
+	if index < 0 {
+		return 0
+	}
+	z.listpack = append(z.listpack[:index], z.listpack[index+1:]...)
+	return 1
+}
+
+func (z *sortedSetEntity) Score(member string) (float64, bool) {
+	if !z.isListpack() {
+		return z.skiplist.Score(member)
+	}
+
+	if index := z.listpackSearch(member); index >= 0 {
+		return z.listpack[index].Score, true
+	}
+	return 0, false
+}
+
+func (z *sortedSetEntity) Len() int64 {
+	if !z.isListpack() {
+		return z.skiplist.Len()
+	}
+	return int64(len(z.listpack))
+}
+
+func (z *sortedSetEntity) Rank(member string, reverse bool) (int64, bool) {
+	if !z.isListpack() {
+		return z.skiplist.Rank(member, reverse)
+	}
+
+	index := z.listpackSearch(member)
+	if index < 0 {
+		return 0, false
+	}
+	if reverse {
+		return int64(len(z.listpack) - 1 - index), true
+	}
+	return int64(index), true
+}
+
+func (z *sortedSetEntity) RangeByRank(start, stop int64, reverse bool) []ZMember {
+	if !z.isListpack() {
+		return z.skiplist.RangeByRank(start, stop, reverse)
+	}
+
+	length := int64(len(z.listpack))
+	if stop >= length {
+		stop = length - 1
+	}
+	if start < 0 || start > stop {
+		return []ZMember{}
+	}
+
+	res := make([]ZMember, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		if reverse {
+			res = append(res, z.listpack[length-1-i])
+			continue
+		}
+		res = append(res, z.listpack[i])
+	}
+	return res
+}
+
+// [score1,score2]
+func (z *sortedSetEntity) Range(score1, score2 float64) []string {
+	if !z.isListpack() {
+		return z.skiplist.Range(score1, score2)
+	}
+
+	res := []string{}
+	for _, zmember := range z.listpack {
+		if zmember.Score > score2 {
+			break
+		}
+		if zmember.Score >= score1 {
+			res = append(res, zmember.Member)
+		}
+	}
+	return res
+}
+
+func (z *sortedSetEntity) RangeByScore(min, max *ScoreBorder, offset, limit int64, reverse bool) []ZMember {
+	if !z.isListpack() {
+		return z.skiplist.RangeByScore(min, max, offset, limit, reverse)
+	}
+
+	return z.rangeBy(func(zmember ZMember) bool {
+		return min.lowerThan(zmember.Score) && max.greaterThan(zmember.Score)
+	}, offset, limit, reverse)
+}
+
+func (z *sortedSetEntity) RangeByLex(min, max *LexBorder, offset, limit int64, reverse bool) []ZMember {
+	if !z.isListpack() {
+		return z.skiplist.RangeByLex(min, max, offset, limit, reverse)
+	}
+
+	return z.rangeBy(func(zmember ZMember) bool {
+		return min.lowerThan(zmember.Member) && max.greaterThan(zmember.Member)
+	}, offset, limit, reverse)
+}
+
+func (z *sortedSetEntity) rangeBy(inRange func(ZMember) bool, offset, limit int64, reverse bool) []ZMember {
+	res := []ZMember{}
+	for i := range z.listpack {
+		if limit >= 0 && int64(len(res)) >= limit {
+			break
+		}
+
+		zmember := z.listpack[i]
+		if reverse {
+			zmember = z.listpack[len(z.listpack)-1-i]
+		}
+		if !inRange(zmember) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		res = append(res, zmember)
+	}
+	return res
+}
+
+func (z *sortedSetEntity) Encoding() string {
+	if z.isListpack() {
+		return encodingListpack
+	}
+	return encodingSkiplist
+}
+
+func (z *sortedSetEntity) listpackSearch(member string) int {
+	for i, zmember := range z.listpack {
+		if zmember.Member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+func (z *sortedSetEntity) convertToSkiplist() {
+	z.skiplist = newSkiplist(z.key)
+	for _, zmember := range z.listpack {
+		z.skiplist.Add(zmember.Score, zmember.Member)
+	}
+	z.listpack = nil
+}
+
+func (z *sortedSetEntity) ToCmd() [][]byte {
+	if !z.isListpack() {
+		return z.skiplist.ToCmd()
+	}
+
+	args := make([][]byte, 0, 2+2*len(z.listpack))
+	args = append(args, []byte(database.CmdTypeZAdd), []byte(z.key))
+	for _, zmember := range z.listpack {
+		args = append(args, []byte(formatScore(zmember.Score)), []byte(zmember.Member))
+	}
+	return args
+}
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+// 跳表. 每层记录跨度 span，用于排名查询
 type skiplist struct {
 	key           string
-	scoreToNode   map[int64]*skipnode
-	memberToScore map[string]int64
+	memberToScore map[string]float64
 	head          *skipnode
+	tail          *skipnode
+	level         int
+	length        int64
 	rander        *rand.Rand
 }
 
 func newSkiplist(key string) SortedSet {
 	return &skiplist{
 		key:           key,
-		memberToScore: make(map[string]int64),
-		scoreToNode:   make(map[int64]*skipnode),
-		head:          newSkipnode(0, 0),
+		memberToScore: make(map[string]float64),
+		head:          newSkipnode(0, "", skiplistMaxLevel),
+		level:         1,
 		rander:        rand.New((rand.NewSource(lib.TimeNow().UnixNano()))),
 	}
 }
 
-func (s *skiplist) Add(score int64, member string) {
+func (s *skiplist) Add(score float64, member string) int64 {
 	// 之前存在，需要删除
 	oldScore, ok := s.memberToScore[member]
 	if ok {
 		if oldScore == score {
-			return
+			return 0
 		}
-		s.rem(oldScore, member)
+		s.delete(oldScore, member)
 	}
 
 	s.memberToScore[member] = score
-	node, ok := s.scoreToNode[score]
+	s.insert(score, member)
 	if ok {
-		node.members[member] = struct{}{}
-		return
+		return 0
 	}
+	return 1
+}
 
-	// 新插入，roll 出高度
-	height := s.roll()
-	for int64(len(s.head.nexts)) < height+1 {
-		s.head.nexts = append(s.head.nexts, nil)
+func (s *skiplist) Rem(member string) int64 {
+	score, ok := s.memberToScore[member]
+	if !ok {
+		return 0
 	}
+	delete(s.memberToScore, member)
+	s.delete(score, member)
+	return 1
+}
+
+func (s *skiplist) Score(member string) (float64, bool) {
+	score, ok := s.memberToScore[member]
+	return score, ok
+}
 
-	inserted := newSkipnode(score, height+1)
-	inserted.members[member] = struct{}{}
-	s.scoreToNode[score] = inserted
+func (s *skiplist) Len() int64 {
+	return s.length
+}
 
+func (s *skiplist) Rank(member string, reverse bool) (int64, bool) {
+	score, ok := s.memberToScore[member]
+	if !ok {
+		return 0, false
+	}
+
+	// 累加沿途的跨度，得到从 1 开始的排名
+	var rank int64
 	move := s.head
-	for i := height; i >= 0; i-- {
-		for move.nexts[i] != nil && move.nexts[i].score < score {
-			move = move.nexts[i]
-			continue
+	for i := s.level - 1; i >= 0; i-- {
+		for next := move.levels[i].forward; next != nil && !zmemberLess(score, member, next.score, next.member); next = move.levels[i].forward {
+			rank += move.levels[i].span
+			move = next
 		}
+		if move != s.head && move.member == member {
+			break
+		}
+	}
 
-		inserted.nexts[i] = move.nexts[i]
-		move.nexts[i] = inserted
+	if reverse {
+		return s.length - rank, true
 	}
+	return rank - 1, true
 }
 
-func (s *skiplist) Rem(member string) int64 {
-	// 之前存在，需要删除
-	score, ok := s.memberToScore[member]
-	if !ok {
-		return 0
+func (s *skiplist) RangeByRank(start, stop int64, reverse bool) []ZMember {
+	if stop >= s.length {
+		stop = s.length - 1
+	}
+	if start < 0 || start > stop {
+		return []ZMember{}
 	}
-	s.rem(score, member)
-	return 1
-}
 
-// [score1,score2]
-func (s *skiplist) Range(score1, score2 int64) []string {
-	if score2 == -1 {
-		score2 = math.MaxInt64
+	res := make([]ZMember, 0, stop-start+1)
+	var move *skipnode
+	if reverse {
+		move = s.getByRank(s.length - start)
+	} else {
+		move = s.getByRank(start + 1)
 	}
+	for i := start; i <= stop && move != nil; i++ {
+		res = append(res, ZMember{Member: move.member, Score: move.score})
+		if reverse {
+			move = move.backward
+		} else {
+			move = move.levels[0].forward
+		}
+	}
+	return res
+}
 
+// [score1,score2]
+func (s *skiplist) Range(score1, score2 float64) []string {
 	if score1 > score2 {
 		return []string{}
 	}
 
 	move := s.head
-	for i := len(s.head.nexts) - 1; i >= 0; i-- {
-		for move.nexts[i] != nil && move.nexts[i].score < score1 {
-			move = move.nexts[i]
+	for i := s.level - 1; i >= 0; i-- {
+		for move.levels[i].forward != nil && move.levels[i].forward.score < score1 {
+			move = move.levels[i].forward
 		}
 	}
 
-	// 来到了 level0 层，move.nexts[i] 如果存在，就是首个 >= score1 的元素
-	if len(move.nexts) == 0 || move.nexts[0] == nil {
-		return []string{}
+	// 来到了 level0 层，move 的后继如果存在，就是首个 >= score1 的元素
+	res := []string{}
+	for move = move.levels[0].forward; move != nil && move.score <= score2; move = move.levels[0].forward {
+		res = append(res, move.member)
+	}
+	return res
+}
+
+func (s *skiplist) RangeByScore(min, max *ScoreBorder, offset, limit int64, reverse bool) []ZMember {
+	if reverse {
+		// 定位到最后一个满足上界的节点，向前遍历
+		move := s.head
+		for i := s.level - 1; i >= 0; i-- {
+			for move.levels[i].forward != nil && max.greaterThan(move.levels[i].forward.score) {
+				move = move.levels[i].forward
+			}
+		}
+		if move == s.head {
+			return []ZMember{}
+		}
+		return s.collect(move, func(node *skipnode) bool {
+			return min.lowerThan(node.score)
+		}, offset, limit, true)
 	}
 
-	res := []string{}
-	for move.nexts[0] != nil && move.nexts[0].score >= score1 && move.nexts[0].score <= score2 {
-		for member := range move.nexts[0].members {
-			res = append(res, member)
+	// 定位到首个满足下界的节点，向后遍历
+	move := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for move.levels[i].forward != nil && !min.lowerThan(move.levels[i].forward.score) {
+			move = move.levels[i].forward
+		}
+	}
+	return s.collect(move.levels[0].forward, func(node *skipnode) bool {
+		return max.greaterThan(node.score)
+	}, offset, limit, false)
+}
+
+func (s *skiplist) RangeByLex(min, max *LexBorder, offset, limit int64, reverse bool) []ZMember {
+	if reverse {
+		move := s.head
+		for i := s.level - 1; i >= 0; i-- {
+			for move.levels[i].forward != nil && max.greaterThan(move.levels[i].forward.member) {
+				move = move.levels[i].forward
+			}
+		}
+		if move == s.head {
+			return []ZMember{}
+		}
+		return s.collect(move, func(node *skipnode) bool {
+			return min.lowerThan(node.member)
+		}, offset, limit, true)
+	}
+
+	move := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for move.levels[i].forward != nil && !min.lowerThan(move.levels[i].forward.member) {
+			move = move.levels[i].forward
+		}
+	}
+	return s.collect(move.levels[0].forward, func(node *skipnode) bool {
+		return max.greaterThan(node.member)
+	}, offset, limit, false)
+}
+
+// 从 start 节点开始沿 level0 遍历，直到 inRange 不再满足
+func (s *skiplist) collect(start *skipnode, inRange func(*skipnode) bool, offset, limit int64, reverse bool) []ZMember {
+	res := []ZMember{}
+	for move := start; move != nil && inRange(move); {
+		if limit >= 0 && int64(len(res)) >= limit {
+			break
+		}
+		if offset > 0 {
+			offset--
+		} else {
+			res = append(res, ZMember{Member: move.member, Score: move.score})
+		}
+
+		if reverse {
+			move = move.backward
+		} else {
+			move = move.levels[0].forward
 		}
-		move = move.nexts[0]
 	}
 	return res
 }
 
-func (s *skiplist) roll() int64 {
-	var level int64
-	for s.rander.Intn(2) > 0 {
+// 根据从 1 开始的排名获取节点
+func (s *skiplist) getByRank(rank int64) *skipnode {
+	var traversed int64
+	move := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for move.levels[i].forward != nil && traversed+move.levels[i].span <= rank {
+			traversed += move.levels[i].span
+			move = move.levels[i].forward
+		}
+		if traversed == rank {
+			return move
+		}
+	}
+	return nil
+}
+
+func (s *skiplist) roll() int {
+	level := 1
+	for level < skiplistMaxLevel && s.rander.Float64() < skiplistP {
 		level++
 	}
 	return level
 }
 
-func (s *skiplist) rem(score int64, member string) {
-	delete(s.memberToScore, member)
-	skipnode := s.scoreToNode[score]
+func (s *skiplist) insert(score float64, member string) {
+	// update[i] 为第 i 层插入位置的前驱节点，rank[i] 为其排名
+	var (
+		update [skiplistMaxLevel]*skipnode
+		rank   [skiplistMaxLevel]int64
+	)
+	move := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		if i < s.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for next := move.levels[i].forward; next != nil && zmemberLess(next.score, next.member, score, member); next = move.levels[i].forward {
+			rank[i] += move.levels[i].span
+			move = next
+		}
+		update[i] = move
+	}
 
-	delete(skipnode.members, member)
-	if len(skipnode.members) > 0 {
-		return
+	// 新插入，roll 出高度
+	level := s.roll()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = s.head
+			update[i].levels[i].span = s.length
+		}
+		s.level = level
+	}
+
+	inserted := newSkipnode(score, member, level)
+	for i := 0; i < level; i++ {
+		inserted.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = inserted
+		inserted.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+
+	// 更高的层级跨过了新节点，跨度加一
+	for i := level; i < s.level; i++ {
+		update[i].levels[i].span++
 	}
 
-	delete(s.scoreToNode, score)
+	if update[0] != s.head {
+		inserted.backward = update[0]
+	}
+	if inserted.levels[0].forward != nil {
+		inserted.levels[0].forward.backward = inserted
+	} else {
+		s.tail = inserted
+	}
+	s.length++
+}
+
+func (s *skiplist) delete(score float64, member string) {
+	var update [skiplistMaxLevel]*skipnode
 	move := s.head
-	for i := len(s.head.nexts) - 1; i >= 0; i-- {
-		for move.nexts[i] != nil && move.nexts[i].score < score {
-			move = move.nexts[i]
+	for i := s.level - 1; i >= 0; i-- {
+		for next := move.levels[i].forward; next != nil && zmemberLess(next.score, next.member, score, member); next = move.levels[i].forward {
+			move = next
 		}
+		update[i] = move
+	}
+
+	remed := move.levels[0].forward
+	if remed == nil || remed.score != score || remed.member != member {
+		return
+	}
 
-		if move.nexts[i] == nil || move.nexts[i].score > score {
+	for i := 0; i < s.level; i++ {
+		if update[i].levels[i].forward == remed {
+			update[i].levels[i].span += remed.levels[i].span - 1
+			update[i].levels[i].forward = remed.levels[i].forward
 			continue
 		}
+		update[i].levels[i].span--
+	}
+
+	if remed.levels[0].forward != nil {
+		remed.levels[0].forward.backward = remed.backward
+	} else {
+		s.tail = remed.backward
+	}
 
-		remed := move.nexts[i]
-		move.nexts[i] = move.nexts[i].nexts[i]
-		remed.nexts[i] = nil
+	for s.level > 1 && s.head.levels[s.level-1].forward == nil {
+		s.level--
 	}
+	s.length--
+}
+
+func (s *skiplist) Encoding() string {
+	return encodingSkiplist
 }
 
 func (s *skiplist) ToCmd() [][]byte {
-	args := make([][]byte, 0, 2+2*len(s.memberToScore))
+	args := make([][]byte, 0, 2+2*s.length)
 	args = append(args, []byte(database.CmdTypeZAdd), []byte(s.key))
-	for member, score := range s.memberToScore {
-		scoreStr := strconv.FormatInt(score, 10)
-		args = append(args, []byte(scoreStr), []byte(member))
+	for move := s.head.levels[0].forward; move != nil; move = move.levels[0].forward {
+		args = append(args, []byte(formatScore(move.score)), []byte(move.member))
 	}
 	return args
 }
 
+type skiplevel struct {
+	forward *skipnode
+	// 到达 forward 节点跨过的节点数
+	span int64
+}
+
 type skipnode struct {
-	score   int64
-	members map[string]struct{}
-	nexts   []*skipnode
+	member   string
+	score    float64
+	backward *skipnode
+	levels   []skiplevel
 }
 
-func newSkipnode(score, height int64) *skipnode {
+func newSkipnode(score float64, member string, level int) *skipnode {
 	return &skipnode{
-		score:   score,
-		members: make(map[string]struct{}),
-		nexts:   make([]*skipnode, height),
+		member: member,
+		score:  score,
+		levels: make([]skiplevel, level),
+	}
+}
+
+// 多个有序集合聚合时，相同 member 的 score 合并方式
+type zaggregate func(score1, score2 float64) float64
+
+var zaggregates = map[string]zaggregate{
+	"sum": func(score1, score2 float64) float64 {
+		// 与 redis 一致，inf 与 -inf 相加的结果记为 0
+		if sum := score1 + score2; !math.IsNaN(sum) {
+			return sum
+		}
+		return 0
+	},
+	"min": math.Min,
+	"max": math.Max,
+}
+
+func weightedScore(score, weight float64) float64 {
+	if res := score * weight; !math.IsNaN(res) {
+		return res
+	}
+	return 0
+}
+
+// 多个有序集合求并集. nil 代表不存在的 key
+func zsetUnion(sources []map[string]float64, weights []float64, aggregate zaggregate) []ZMember {
+	union := make(map[string]float64)
+	for i, source := range sources {
+		for member, score := range source {
+			score = weightedScore(score, weights[i])
+			if cur, ok := union[member]; ok {
+				score = aggregate(cur, score)
+			}
+			union[member] = score
+		}
+	}
+	return sortedZMembers(union)
+}
+
+// 多个有序集合求交集
+func zsetInter(sources []map[string]float64, weights []float64, aggregate zaggregate) []ZMember {
+	if len(sources) == 0 {
+		return []ZMember{}
+	}
+
+	// 以基数最小的集合作为遍历基准
+	smallest := sources[0]
+	for _, source := range sources {
+		if source == nil {
+			return []ZMember{}
+		}
+		if len(source) < len(smallest) {
+			smallest = source
+		}
+	}
+
+	inter := make(map[string]float64)
+	for member := range smallest {
+		var score float64
+		hit := true
+		for i, source := range sources {
+			other, ok := source[member]
+			if !ok {
+				hit = false
+				break
+			}
+			if i == 0 {
+				score = weightedScore(other, weights[i])
+			} else {
+				score = aggregate(score, weightedScore(other, weights[i]))
+			}
+		}
+		if hit {
+			inter[member] = score
+		}
+	}
+	return sortedZMembers(inter)
+}
+
+// 首个有序集合与其余集合求差集，score 取首个集合中的值
+func zsetDiff(sources []map[string]float64) []ZMember {
+	if len(sources) == 0 || sources[0] == nil {
+		return []ZMember{}
+	}
+
+	diff := make(map[string]float64)
+	for member, score := range sources[0] {
+		hit := false
+		for _, source := range sources[1:] {
+			if _, ok := source[member]; ok {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			diff[member] = score
+		}
+	}
+	return sortedZMembers(diff)
+}
+
+func sortedZMembers(memberToScore map[string]float64) []ZMember {
+	zmembers := make([]ZMember, 0, len(memberToScore))
+	for member, score := range memberToScore {
+		zmembers = append(zmembers, ZMember{Member: member, Score: score})
 	}
+	sort.Slice(zmembers, func(i, j int) bool {
+		return zmemberLess(zmembers[i].Score, zmembers[i].Member, zmembers[j].Score, zmembers[j].Member)
+	})
+	return zmembers
 }
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"goredis/database"
 	"goredis/lib"
+	"math"
 	"math/rand"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/cast"
 	"github.com/stretchr/testify/assert"
@@ -17,8 +19,8 @@ func Test_skiplist_add_rem_range(t *testing.T) {
 	skiplist := newSkiplist("")
 	// 添加 1000 条指令
 	for i := 0; i < 1000; i++ {
-		skiplist.Add(int64(i), fmt.Sprintf("%d_0", i))
-		skiplist.Add(int64(i), fmt.Sprintf("%d_1", i))
+		skiplist.Add(float64(i), fmt.Sprintf("%d_0", i))
+		skiplist.Add(float64(i), fmt.Sprintf("%d_1", i))
 	}
 
 	// 随机移除 1000 个 member
@@ -35,7 +37,7 @@ func Test_skiplist_add_rem_range(t *testing.T) {
 	t.Run("single_score", func(t *testing.T) {
 		for i := 0; i < 100; i++ {
 			score := int64(rander.Intn(1000))
-			member := skiplist.Range(score, score)
+			member := skiplist.Range(float64(score), float64(score))
 			sort.Slice(member, func(i, j int) bool {
 				return member[i] < member[j]
 			})
@@ -57,7 +59,7 @@ func Test_skiplist_add_rem_range(t *testing.T) {
 		for i := 0; i < 100; i++ {
 			leftScore := int64(rander.Intn(501))
 			rightScore := leftScore + int64(rander.Intn(500))
-			member := skiplist.Range(leftScore, rightScore)
+			member := skiplist.Range(float64(leftScore), float64(rightScore))
 			sort.Slice(member, func(i, j int) bool {
 				splitted1 := strings.Split(member[i], "_")
 				splitted2 := strings.Split(member[j], "_")
@@ -85,8 +87,7 @@ func Test_skiplist_add_rem_range(t *testing.T) {
 	t.Run("with_maximum_right_range", func(t *testing.T) {
 		for i := 0; i < 100; i++ {
 			leftScore := int64(rander.Intn(1000))
-			rightScore := int64(-1)
-			member := skiplist.Range(leftScore, rightScore)
+			member := skiplist.Range(float64(leftScore), math.Inf(1))
 			sort.Slice(member, func(i, j int) bool {
 				splitted1 := strings.Split(member[i], "_")
 				splitted2 := strings.Split(member[j], "_")
@@ -124,9 +125,9 @@ func Test_skiplist_upsert_member_with_dif_score(t *testing.T) {
 			continue
 		}
 		memberSet[member] = struct{}{}
-		skiplist.Add(score1, member)
+		skiplist.Add(float64(score1), member)
 		score2 := int64(rander.Intn(1000))
-		skiplist.Add(score2, member)
+		skiplist.Add(float64(score2), member)
 		scoreToMembers[score2] = append(scoreToMembers[score2], member)
 	}
 
@@ -136,7 +137,7 @@ func Test_skiplist_upsert_member_with_dif_score(t *testing.T) {
 				return cast.ToInt(members[i]) < cast.ToInt(members[j])
 			})
 
-			actualMembers := skiplist.Range(score, score)
+			actualMembers := skiplist.Range(float64(score), float64(score))
 			sort.Slice(actualMembers, func(i, j int) bool {
 				return cast.ToInt(actualMembers[i]) < cast.ToInt(actualMembers[j])
 			})
@@ -149,7 +150,7 @@ func Test_skiplist_upsert_member_with_dif_score(t *testing.T) {
 				if oldScore == score {
 					continue
 				}
-				for _, gotMember := range skiplist.Range(oldScore, oldScore) {
+				for _, gotMember := range skiplist.Range(float64(oldScore), float64(oldScore)) {
 					if gotMember == member {
 						t.Errorf("old score: %d, members: %s", oldScore, gotMember)
 					}
@@ -168,7 +169,7 @@ func Test_skiplist_to_cmd(t *testing.T) {
 	for i := 0; i < 1000; i++ {
 		score := rander.Intn(1000)
 		member := rander.Intn(1000)
-		skiplist.Add(int64(score), cast.ToString(member))
+		skiplist.Add(float64(score), cast.ToString(member))
 		memberToScore[member] = score
 	}
 
@@ -218,4 +219,173 @@ func Test_skiplist_to_cmd(t *testing.T) {
 	t.Run("member", func(t *testing.T) {
 		assert.Equal(t, expect, actual)
 	})
-}
\ No newline at end of file
+}
+func Test_sorted_set_rank(t *testing.T) {
+	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
+	for _, zset := range []SortedSet{newSkiplist(""), newSortedSetEntity("", &encodingConf{zsetMaxListpackEntries: 2000, zsetMaxListpackValue: 64})} {
+		memberToScore := make(map[string]float64, 1000)
+		for i := 0; i < 2000; i++ {
+			member := cast.ToString(rander.Intn(1000))
+			// 制造大量相同的 score，校验 member 字典序
+			score := float64(rander.Intn(100)) / 4
+			zset.Add(score, member)
+			memberToScore[member] = score
+			if rander.Intn(4) == 0 {
+				zset.Rem(member)
+				delete(memberToScore, member)
+			}
+		}
+
+		expect := make([]ZMember, 0, len(memberToScore))
+		for member, score := range memberToScore {
+			expect = append(expect, ZMember{Member: member, Score: score})
+		}
+		sort.Slice(expect, func(i, j int) bool {
+			return zmemberLess(expect[i].Score, expect[i].Member, expect[j].Score, expect[j].Member)
+		})
+
+		t.Run(zset.Encoding()+"_len", func(t *testing.T) {
+			assert.Equal(t, int64(len(expect)), zset.Len())
+		})
+
+		t.Run(zset.Encoding()+"_rank", func(t *testing.T) {
+			for i, zmember := range expect {
+				rank, ok := zset.Rank(zmember.Member, false)
+				assert.True(t, ok)
+				assert.Equal(t, int64(i), rank)
+				revRank, _ := zset.Rank(zmember.Member, true)
+				assert.Equal(t, int64(len(expect)-1-i), revRank)
+			}
+			_, ok := zset.Rank("not_exist", false)
+			assert.False(t, ok)
+		})
+
+		t.Run(zset.Encoding()+"_range_by_rank", func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				start := int64(rander.Intn(len(expect)))
+				stop := start + int64(rander.Intn(50))
+				end := stop + 1
+				if end > int64(len(expect)) {
+					end = int64(len(expect))
+				}
+				assert.Equal(t, expect[start:end], zset.RangeByRank(start, stop, false))
+
+				reversed := zset.RangeByRank(start, stop, true)
+				for j, zmember := range reversed {
+					assert.Equal(t, expect[len(expect)-1-int(start)-j], zmember)
+				}
+			}
+		})
+	}
+}
+
+func Test_sorted_set_float_score(t *testing.T) {
+	zset := newSkiplist("")
+	zset.Add(math.Inf(1), "max")
+	zset.Add(math.Inf(-1), "min")
+	zset.Add(1.5, "b")
+	zset.Add(1.5, "a")
+	zset.Add(-0.25, "c")
+
+	assert.Equal(t, []string{"min", "c", "a", "b", "max"}, zset.Range(math.Inf(-1), math.Inf(1)))
+	assert.Equal(t, []string{"a", "b"}, zset.Range(1.5, 1.5))
+	assert.Equal(t, []byte("inf"), zset.ToCmd()[len(zset.ToCmd())-2])
+
+	for raw, expect := range map[string]float64{"1.5": 1.5, "-inf": math.Inf(-1), "+inf": math.Inf(1), "10": 10} {
+		score, err := parseScore(raw)
+		assert.Nil(t, err)
+		assert.Equal(t, expect, score)
+	}
+	for _, raw := range []string{"nan", "abc", ""} {
+		_, err := parseScore(raw)
+		assert.NotNil(t, err)
+	}
+
+	for score, expect := range map[float64]string{1.5: "1.5", 100: "100", 1234567: "1234567", -0.1: "-0.1", math.Inf(-1): "-inf"} {
+		assert.Equal(t, expect, formatScore(score))
+	}
+}
+
+func Test_sorted_set_range_by_score_lex(t *testing.T) {
+	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
+	skiplist := newSkiplist("")
+	listpack := newSortedSetEntity("", &encodingConf{zsetMaxListpackEntries: 1000, zsetMaxListpackValue: 64})
+	for i := 0; i < 500; i++ {
+		score, member := float64(rander.Intn(50)), cast.ToString(rander.Intn(500))
+		skiplist.Add(score, member)
+		listpack.Add(score, member)
+	}
+
+	for i := 0; i < 200; i++ {
+		min := &ScoreBorder{Value: float64(rander.Intn(60) - 5), Exclude: rander.Intn(2) == 0}
+		max := &ScoreBorder{Value: min.Value + float64(rander.Intn(20)), Exclude: rander.Intn(2) == 0}
+		offset, limit := int64(rander.Intn(5)), int64(rander.Intn(20)-1)
+		reverse := rander.Intn(2) == 0
+
+		expect := listpack.RangeByScore(min, max, offset, limit, reverse)
+		assert.Equal(t, expect, skiplist.RangeByScore(min, max, offset, limit, reverse))
+		for _, zmember := range expect {
+			assert.True(t, min.lowerThan(zmember.Score) && max.greaterThan(zmember.Score))
+		}
+		if limit >= 0 {
+			assert.LessOrEqual(t, int64(len(expect)), limit)
+		}
+	}
+
+	lexSkiplist := newSkiplist("")
+	lexListpack := newSortedSetEntity("", &encodingConf{zsetMaxListpackEntries: 1000, zsetMaxListpackValue: 64})
+	for i := 0; i < 500; i++ {
+		member := cast.ToString(rander.Intn(500))
+		lexSkiplist.Add(0, member)
+		lexListpack.Add(0, member)
+	}
+	for i := 0; i < 200; i++ {
+		min, _ := parseLexBorder([]string{"-", "[" + cast.ToString(rander.Intn(500)), "(" + cast.ToString(rander.Intn(500))}[rander.Intn(3)])
+		max, _ := parseLexBorder([]string{"+", "[" + cast.ToString(rander.Intn(500)), "(" + cast.ToString(rander.Intn(500))}[rander.Intn(3)])
+		reverse := rander.Intn(2) == 0
+		assert.Equal(t, lexListpack.RangeByLex(min, max, 0, -1, reverse), lexSkiplist.RangeByLex(min, max, 0, -1, reverse))
+	}
+}
+
+func Test_kv_sorted_set_blocking_pop_cmds(t *testing.T) {
+	kv, persister := newTestKVStore()
+
+	reply := kv.BZPopMin(newTestCmd(database.CmdTypeBZPopMin, "a", "b", "1.5"))
+	blocked, ok := reply.(*database.BlockedReply)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a", "b"}, blocked.Keys())
+	assert.Equal(t, 1500*time.Millisecond, blocked.Timeout())
+	assert.Equal(t, "*-1\r\n", string(blocked.ToBytes()))
+
+	reply = kv.BZPopMin(newTestCmd(database.CmdTypeBZPopMin, "a", "-1"))
+	assert.Equal(t, "-ERR timeout is negative\r\n", string(reply.ToBytes()))
+	reply = kv.BZPopMin(newTestCmd(database.CmdTypeBZPopMin, "a", "x"))
+	assert.Equal(t, "-ERR timeout is not a float or out of range\r\n", string(reply.ToBytes()))
+
+	kv.ZAdd(newTestCmd(database.CmdTypeZAdd, "b", "1", "m1", "2", "m2", "3", "m3"))
+	persister.cmds = nil
+
+	reply = kv.BZPopMax(newTestCmd(database.CmdTypeBZPopMax, "a", "b", "0"))
+	assert.Equal(t, "*3\r\n$1\r\nb\r\n$2\r\nm3\r\n$1\r\n3\r\n", string(reply.ToBytes()))
+	assert.Equal(t, [][]byte{[]byte("zpopmax"), []byte("b"), []byte("1")}, persister.cmds[0])
+
+	reply = kv.ZMPop(newTestCmd(database.CmdTypeZMPop, "2", "a", "b", "MIN", "COUNT", "5"))
+	assert.Equal(t, "*2\r\n$1\r\nb\r\n*2\r\n*2\r\n$2\r\nm1\r\n$1\r\n1\r\n*2\r\n$2\r\nm2\r\n$1\r\n2\r\n", string(reply.ToBytes()))
+	assert.Equal(t, [][]byte{[]byte("zpopmin"), []byte("b"), []byte("2")}, persister.cmds[1])
+	_, ok = kv.data["b"]
+	assert.False(t, ok)
+
+	reply = kv.ZMPop(newTestCmd(database.CmdTypeZMPop, "2", "a", "b", "MAX"))
+	assert.Equal(t, "*-1\r\n", string(reply.ToBytes()))
+	reply = kv.ZMPop(newTestCmd(database.CmdTypeZMPop, "0", "a", "MAX"))
+	assert.Equal(t, "-ERR numkeys should be greater than 0\r\n", string(reply.ToBytes()))
+	reply = kv.ZMPop(newTestCmd(database.CmdTypeZMPop, "1", "a", "MAX", "COUNT", "0"))
+	assert.Equal(t, "-ERR count should be greater than 0\r\n", string(reply.ToBytes()))
+
+	reply = kv.BZMPop(newTestCmd(database.CmdTypeBZMPop, "0", "1", "a", "MIN"))
+	blocked, ok = reply.(*database.BlockedReply)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a"}, blocked.Keys())
+	assert.Equal(t, time.Duration(0), blocked.Timeout())
+	assert.Len(t, persister.cmds, 2)
+}
@@ -0,0 +1,413 @@
+package datastore
+
+import (
+	"errors"
+	"goredis/database"
+	"goredis/handler"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (k *KVStore) getAsStream(key string) (Stream, error) {
+	v, ok := k.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	stream, ok := v.(Stream)
+	if !ok {
+		return nil, handler.NewWrongTypeErrReply()
+	}
+
+	return stream, nil
+}
+
+func (k *KVStore) putAsStream(key string, stream Stream) {
+	k.data[key] = stream
+}
+
+var errInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// 消息 id，由毫秒时间戳和同一毫秒内的序号组成
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	minStreamID = StreamID{}
+	maxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+func (s StreamID) String() string {
+	return strconv.FormatUint(s.Ms, 10) + "-" + strconv.FormatUint(s.Seq, 10)
+}
+
+func (s StreamID) Less(other StreamID) bool {
+	if s.Ms != other.Ms {
+		return s.Ms < other.Ms
+	}
+	return s.Seq < other.Seq
+}
+
+// 后继 id，已经是最大值时返回 false
+func (s StreamID) incr() (StreamID, bool) {
+	switch {
+	case s.Seq < math.MaxUint64:
+		return StreamID{Ms: s.Ms, Seq: s.Seq + 1}, true
+	case s.Ms < math.MaxUint64:
+		return StreamID{Ms: s.Ms + 1}, true
+	default:
+		return s, false
+	}
+}
+
+// 前驱 id，已经是最小值时返回 false
+func (s StreamID) decr() (StreamID, bool) {
+	switch {
+	case s.Seq > 0:
+		return StreamID{Ms: s.Ms, Seq: s.Seq - 1}, true
+	case s.Ms > 0:
+		return StreamID{Ms: s.Ms - 1, Seq: math.MaxUint64}, true
+	default:
+		return s, false
+	}
+}
+
+// 解析 ms-seq 格式的 id，省略 seq 时使用 missingSeq. - 和 + 分别代表最小和最大 id
+func parseStreamID(raw string, missingSeq uint64) (StreamID, error) {
+	switch raw {
+	case "-":
+		return minStreamID, nil
+	case "+":
+		return maxStreamID, nil
+	}
+
+	rawMs, rawSeq, hasSeq := strings.Cut(raw, "-")
+	ms, err := strconv.ParseUint(rawMs, 10, 64)
+	if err != nil {
+		return StreamID{}, errInvalidStreamID
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: missingSeq}, nil
+	}
+
+	seq, err := strconv.ParseUint(rawSeq, 10, 64)
+	if err != nil {
+		return StreamID{}, errInvalidStreamID
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+type StreamEntry struct {
+	ID StreamID
+	// field、value 交替排列
+	Fields [][]byte
+}
+
+type Stream interface {
+	// 最近一次生成的 id，不受删除和裁剪的影响
+	LastID() StreamID
+	// 调用方需保证 id 大于 LastID
+	Add(id StreamID, fields [][]byte)
+	Del(id StreamID) bool
+	Len() int64
+	// start、end 均为闭区间. count <= 0 时不限制数量
+	Range(start, end StreamID, count int64, reverse bool) []StreamEntry
+	// 近似裁剪只移除完整的 chunk. limit 为单次最多移除的消息数，0 代表不限制
+	TrimMaxLen(maxLen int64, approx bool, limit int64) int64
+	TrimMinID(minID StreamID, approx bool, limit int64) int64
+	SetID(lastID StreamID, entriesAdded int64, maxDeletedID StreamID)
+	EntriesAdded() int64
+	MaxDeletedID() StreamID
+	Get(id StreamID) (StreamEntry, bool)
+	// chunk 数量
+	Chunks() int64
+	// 消费组已存在时返回 false
+	CreateGroup(name string, lastID StreamID, entriesRead int64) (*StreamGroup, bool)
+	Group(name string) *StreamGroup
+	DestroyGroup(name string) bool
+	// 按名称排序的消费组
+	Groups() []*StreamGroup
+	Encoding() string
+	database.CmdAdapter
+	database.MultiCmdAdapter
+}
+
+// 每个 chunk 最多存放的消息数
+const streamChunkMaxEntries = 100
+
+// 消息按 id 递增追加，分块存储以便按块裁剪
+type streamChunk struct {
+	entries []StreamEntry
+}
+
+func (c *streamChunk) firstID() StreamID {
+	return c.entries[0].ID
+}
+
+func (c *streamChunk) lastID() StreamID {
+	return c.entries[len(c.entries)-1].ID
+}
+
+type streamEntity struct {
+	key          string
+	chunks       []*streamChunk
+	length       int64
+	lastID       StreamID
+	entriesAdded int64
+	maxDeletedID StreamID
+	groups       map[string]*StreamGroup
+}
+
+func newStreamEntity(key string) Stream {
+	return &streamEntity{
+		key:    key,
+		groups: make(map[string]*StreamGroup),
+	}
+}
+
+func (s *streamEntity) LastID() StreamID {
+	return s.lastID
+}
+
+func (s *streamEntity) Add(id StreamID, fields [][]byte) {
+	if len(s.chunks) == 0 || len(s.chunks[len(s.chunks)-1].entries) >= streamChunkMaxEntries {
+		s.chunks = append(s.chunks, &streamChunk{entries: make([]StreamEntry, 0, streamChunkMaxEntries)})
+	}
+
+	tail := s.chunks[len(s.chunks)-1]
+	tail.entries = append(tail.entries, StreamEntry{ID: id, Fields: fields})
+	s.length++
+	s.entriesAdded++
+	s.lastID = id
+}
+
+func (s *streamEntity) Del(id StreamID) bool {
+	i := sort.Search(len(s.chunks), func(i int) bool {
+		return !s.chunks[i].lastID().Less(id)
+	})
+	if i == len(s.chunks) {
+		return false
+	}
+
+	chunk := s.chunks[i]
+	j := sort.Search(len(chunk.entries), func(j int) bool {
+		return !chunk.entries[j].ID.Less(id)
+	})
+	if j == len(chunk.entries) || chunk.entries[j].ID != id {
+		return false
+	}
+
+	chunk.entries = append(chunk.entries[:j], chunk.entries[j+1:]...)
+	if len(chunk.entries) == 0 {
+		s.chunks = append(s.chunks[:i], s.chunks[i+1:]...)
+	}
+	s.length--
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+func (s *streamEntity) Len() int64 {
+	return s.length
+}
+
+func (s *streamEntity) Range(start, end StreamID, count int64, reverse bool) []StreamEntry {
+	res := []StreamEntry{}
+	if end.Less(start) {
+		return res
+	}
+
+	inRange := func(entry StreamEntry) bool {
+		return !entry.ID.Less(start) && !end.Less(entry.ID)
+	}
+
+	if !reverse {
+		// 第一个可能包含 start 的 chunk
+		i := sort.Search(len(s.chunks), func(i int) bool {
+			return !s.chunks[i].lastID().Less(start)
+		})
+		for ; i < len(s.chunks); i++ {
+			for _, entry := range s.chunks[i].entries {
+				if end.Less(entry.ID) || (count > 0 && int64(len(res)) >= count) {
+					return res
+				}
+				if inRange(entry) {
+					res = append(res, entry)
+				}
+			}
+		}
+		return res
+	}
+
+	// 最后一个可能包含 end 的 chunk
+	i := sort.Search(len(s.chunks), func(i int) bool {
+		return end.Less(s.chunks[i].firstID())
+	}) - 1
+	for ; i >= 0; i-- {
+		entries := s.chunks[i].entries
+		for j := len(entries) - 1; j >= 0; j-- {
+			if entries[j].ID.Less(start) || (count > 0 && int64(len(res)) >= count) {
+				return res
+			}
+			if inRange(entries[j]) {
+				res = append(res, entries[j])
+			}
+		}
+	}
+	return res
+}
+
+func (s *streamEntity) TrimMaxLen(maxLen int64, approx bool, limit int64) int64 {
+	return s.trim(func(entry StreamEntry, remain int64) bool {
+		return remain > maxLen
+	}, func(chunk *streamChunk, remain int64) bool {
+		return remain-int64(len(chunk.entries)) >= maxLen
+	}, approx, limit)
+}
+
+func (s *streamEntity) TrimMinID(minID StreamID, approx bool, limit int64) int64 {
+	return s.trim(func(entry StreamEntry, remain int64) bool {
+		return entry.ID.Less(minID)
+	}, func(chunk *streamChunk, remain int64) bool {
+		return chunk.lastID().Less(minID)
+	}, approx, limit)
+}
+
+// 从头部开始裁剪. 精确裁剪逐条判断 entry，近似裁剪逐块判断 chunk
+func (s *streamEntity) trim(entryFn func(entry StreamEntry, remain int64) bool,
+	chunkFn func(chunk *streamChunk, remain int64) bool, approx bool, limit int64) int64 {
+	var removed int64
+	for len(s.chunks) > 0 {
+		chunk := s.chunks[0]
+		if approx {
+			if !chunkFn(chunk, s.length) || (limit > 0 && removed+int64(len(chunk.entries)) > limit) {
+				break
+			}
+			removed += int64(len(chunk.entries))
+			s.length -= int64(len(chunk.entries))
+			s.chunks = s.chunks[1:]
+			continue
+		}
+
+		var cnt int
+		for cnt < len(chunk.entries) && entryFn(chunk.entries[cnt], s.length) {
+			cnt++
+			s.length--
+		}
+		removed += int64(cnt)
+		if cnt < len(chunk.entries) {
+			chunk.entries = chunk.entries[cnt:]
+			break
+		}
+		s.chunks = s.chunks[1:]
+	}
+	return removed
+}
+
+func (s *streamEntity) SetID(lastID StreamID, entriesAdded int64, maxDeletedID StreamID) {
+	s.lastID = lastID
+	s.entriesAdded = entriesAdded
+	s.maxDeletedID = maxDeletedID
+}
+
+func (s *streamEntity) EntriesAdded() int64 {
+	return s.entriesAdded
+}
+
+func (s *streamEntity) MaxDeletedID() StreamID {
+	return s.maxDeletedID
+}
+
+func (s *streamEntity) Get(id StreamID) (StreamEntry, bool) {
+	entries := s.Range(id, id, 1, false)
+	if len(entries) == 0 {
+		return StreamEntry{}, false
+	}
+	return entries[0], true
+}
+
+func (s *streamEntity) Chunks() int64 {
+	return int64(len(s.chunks))
+}
+
+func (s *streamEntity) CreateGroup(name string, lastID StreamID, entriesRead int64) (*StreamGroup, bool) {
+	if group, ok := s.groups[name]; ok {
+		return group, false
+	}
+
+	group := newStreamGroup(name, lastID, entriesRead)
+	s.groups[name] = group
+	return group, true
+}
+
+func (s *streamEntity) Group(name string) *StreamGroup {
+	return s.groups[name]
+}
+
+func (s *streamEntity) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+func (s *streamEntity) Groups() []*StreamGroup {
+	groups := make([]*StreamGroup, 0, len(s.groups))
+	for _, group := range s.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+func (s *streamEntity) Encoding() string {
+	return encodingStream
+}
+
+// 单条指令只能还原 last id 等元信息，完整内容见 ToCmds
+func (s *streamEntity) ToCmd() [][]byte {
+	return [][]byte{
+		[]byte(database.CmdTypeXSetID), []byte(s.key), []byte(s.lastID.String()),
+		[]byte("entriesadded"), []byte(strconv.FormatInt(s.entriesAdded, 10)),
+		[]byte("maxdeletedid"), []byte(s.maxDeletedID.String()),
+	}
+}
+
+// 逐条 xadd 还原消息，通过 xsetid 还原 last id，最后还原消费组. 空 stream 借助 maxlen 0 创建
+func (s *streamEntity) ToCmds() [][][]byte {
+	cmds := make([][][]byte, 0, s.length+1)
+	if s.length == 0 {
+		// xadd 不接受 0-0，实际的 last id 由最后的 xsetid 还原
+		addID := s.lastID
+		if addID == minStreamID {
+			addID = StreamID{Seq: 1}
+		}
+		cmds = append(cmds, [][]byte{
+			[]byte(database.CmdTypeXAdd), []byte(s.key), []byte("maxlen"), []byte("0"),
+			[]byte(addID.String()), []byte("field"), []byte("value"),
+		})
+	}
+
+	for _, chunk := range s.chunks {
+		for _, entry := range chunk.entries {
+			cmd := make([][]byte, 0, 3+len(entry.Fields))
+			cmd = append(cmd, []byte(database.CmdTypeXAdd), []byte(s.key), []byte(entry.ID.String()))
+			cmd = append(cmd, entry.Fields...)
+			cmds = append(cmds, cmd)
+		}
+	}
+	cmds = append(cmds, s.ToCmd())
+
+	for _, group := range s.Groups() {
+		cmds = append(cmds, group.toCmds(s.key)...)
+	}
+	return cmds
+}
@@ -0,0 +1,175 @@
+package datastore
+
+import (
+	"goredis/database"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 已投递但尚未确认的消息
+type StreamNACK struct {
+	ID            StreamID
+	Consumer      *StreamConsumer
+	DeliveryTime  time.Time
+	DeliveryCount int64
+}
+
+type StreamConsumer struct {
+	Name string
+	// 最近一次与消费者交互的时间
+	SeenTime time.Time
+	// 最近一次成功读取或认领消息的时间，零值代表从未读取
+	ActiveTime time.Time
+	pending    map[StreamID]*StreamNACK
+}
+
+func (c *StreamConsumer) Pending() int64 {
+	return int64(len(c.pending))
+}
+
+type StreamGroup struct {
+	Name   string
+	LastID StreamID
+	// 消费组已读取的消息数，-1 代表未知
+	EntriesRead int64
+	pel         map[StreamID]*StreamNACK
+	consumers   map[string]*StreamConsumer
+}
+
+func newStreamGroup(name string, lastID StreamID, entriesRead int64) *StreamGroup {
+	return &StreamGroup{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		pel:         make(map[StreamID]*StreamNACK),
+		consumers:   make(map[string]*StreamConsumer),
+	}
+}
+
+func (g *StreamGroup) Consumer(name string) *StreamConsumer {
+	return g.consumers[name]
+}
+
+// 创建消费者，已存在时返回已有的消费者
+func (g *StreamGroup) CreateConsumer(name string, now time.Time) (*StreamConsumer, bool) {
+	if consumer, ok := g.consumers[name]; ok {
+		return consumer, false
+	}
+
+	consumer := StreamConsumer{
+		Name:     name,
+		SeenTime: now,
+		pending:  make(map[StreamID]*StreamNACK),
+	}
+	g.consumers[name] = &consumer
+	return &consumer, true
+}
+
+// 删除消费者及其未确认的消息，返回未确认消息数
+func (g *StreamGroup) DelConsumer(name string) (int64, bool) {
+	consumer, ok := g.consumers[name]
+	if !ok {
+		return 0, false
+	}
+
+	for id := range consumer.pending {
+		delete(g.pel, id)
+	}
+	delete(g.consumers, name)
+	return consumer.Pending(), true
+}
+
+// 按名称排序的消费者
+func (g *StreamGroup) Consumers() []*StreamConsumer {
+	consumers := make([]*StreamConsumer, 0, len(g.consumers))
+	for _, consumer := range g.consumers {
+		consumers = append(consumers, consumer)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+func (g *StreamGroup) NACK(id StreamID) *StreamNACK {
+	return g.pel[id]
+}
+
+func (g *StreamGroup) PendingLen() int64 {
+	return int64(len(g.pel))
+}
+
+// 按 id 升序返回 [start, end] 区间内未确认的消息. consumer 不为 nil 时只返回该消费者的消息，count <= 0 时不限制数量
+func (g *StreamGroup) PendingRange(start, end StreamID, count int64, consumer *StreamConsumer) []*StreamNACK {
+	pel := g.pel
+	if consumer != nil {
+		pel = consumer.pending
+	}
+
+	nacks := []*StreamNACK{}
+	for id, nack := range pel {
+		if !id.Less(start) && !end.Less(id) {
+			nacks = append(nacks, nack)
+		}
+	}
+	sort.Slice(nacks, func(i, j int) bool {
+		return nacks[i].ID.Less(nacks[j].ID)
+	})
+	if count > 0 && int64(len(nacks)) > count {
+		nacks = nacks[:count]
+	}
+	return nacks
+}
+
+// 将消息归属到 consumer 名下，消息不在 pel 中时新建. 投递时间与次数由调用方设置
+func (g *StreamGroup) Claim(id StreamID, consumer *StreamConsumer) *StreamNACK {
+	nack, ok := g.pel[id]
+	if ok {
+		delete(nack.Consumer.pending, id)
+	} else {
+		nack = &StreamNACK{ID: id}
+		g.pel[id] = nack
+	}
+
+	nack.Consumer = consumer
+	consumer.pending[id] = nack
+	return nack
+}
+
+func (g *StreamGroup) Ack(id StreamID) bool {
+	nack, ok := g.pel[id]
+	if !ok {
+		return false
+	}
+
+	delete(nack.Consumer.pending, id)
+	delete(g.pel, id)
+	return true
+}
+
+// 依次还原消费组、消费者和未确认的消息
+func (g *StreamGroup) toCmds(key string) [][][]byte {
+	cmds := [][][]byte{{
+		[]byte(database.CmdTypeXGroup), []byte("create"), []byte(key), []byte(g.Name), []byte(g.LastID.String()),
+		[]byte("entriesread"), []byte(strconv.FormatInt(g.EntriesRead, 10)),
+	}}
+
+	for _, consumer := range g.Consumers() {
+		cmds = append(cmds, [][]byte{[]byte(database.CmdTypeXGroup), []byte("createconsumer"), []byte(key), []byte(g.Name), []byte(consumer.Name)})
+	}
+	for _, nack := range g.PendingRange(minStreamID, maxStreamID, 0, nil) {
+		cmds = append(cmds, xclaimCmd(key, g.Name, nack))
+	}
+	return cmds
+}
+
+// 以 xclaim 指令的形式还原消息的归属、投递时间和投递次数
+func xclaimCmd(key, group string, nack *StreamNACK) [][]byte {
+	return [][]byte{
+		[]byte(database.CmdTypeXClaim), []byte(key), []byte(group), []byte(nack.Consumer.Name), []byte("0"), []byte(nack.ID.String()),
+		[]byte("time"), []byte(strconv.FormatInt(nack.DeliveryTime.UnixMilli(), 10)),
+		[]byte("retrycount"), []byte(strconv.FormatInt(nack.DeliveryCount, 10)),
+		[]byte("force"), []byte("justid"),
+	}
+}
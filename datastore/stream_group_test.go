@@ -0,0 +1,158 @@
+package datastore
+
+import (
+	"goredis/database"
+	"goredis/handler"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_kv_stream_group_cmds(t *testing.T) {
+	kv, persister := newTestKVStore()
+
+	reply := kv.XGroup(newTestCmd(database.CmdTypeXGroup, "CREATE", "s", "g", "$"))
+	assert.Equal(t, "-"+errXGroupKeyNotExist.Error()+"\r\n", string(reply.ToBytes()))
+	reply = kv.XGroup(newTestCmd(database.CmdTypeXGroup, "CREATE", "s", "g0", "$", "MKSTREAM"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = kv.XGroup(newTestCmd(database.CmdTypeXGroup, "CREATE", "s", "g0", "$"))
+	assert.Equal(t, "-BUSYGROUP Consumer Group name already exists\r\n", string(reply.ToBytes()))
+	reply = kv.XGroup(newTestCmd(database.CmdTypeXGroup, "DESTROY", "s", "g0"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+
+	for _, id := range []string{"1-1", "2-1", "3-1"} {
+		kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", id, "f", id))
+	}
+	reply = kv.XGroup(newTestCmd(database.CmdTypeXGroup, "CREATE", "s", "g", "0"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+
+	persister.cmds = nil
+	reply = kv.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, "GROUP", "g", "c1", "COUNT", "2", "STREAMS", "s", ">"))
+	assert.Equal(t, "*1\r\n*2\r\n$1\r\ns\r\n*2\r\n"+
+		"*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$3\r\n1-1\r\n"+
+		"*2\r\n$3\r\n2-1\r\n*2\r\n$1\r\nf\r\n$3\r\n2-1\r\n", string(reply.ToBytes()))
+	assert.Len(t, persister.cmds, 4)
+	assert.Equal(t, [][]byte{[]byte("xgroup"), []byte("createconsumer"), []byte("s"), []byte("g"), []byte("c1")}, persister.cmds[0])
+	assert.Equal(t, "xclaim", string(persister.cmds[1][0]))
+	assert.Equal(t, [][]byte{[]byte("xgroup"), []byte("setid"), []byte("s"), []byte("g"), []byte("2-1"), []byte("entriesread"), []byte("2")}, persister.cmds[3])
+
+	reply = kv.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, "GROUP", "g", "c2", "STREAMS", "s", ">"))
+	assert.Equal(t, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n3-1\r\n*2\r\n$1\r\nf\r\n$3\r\n3-1\r\n", string(reply.ToBytes()))
+	reply = kv.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, "GROUP", "g", "c2", "STREAMS", "s", ">"))
+	assert.Equal(t, "*-1\r\n", string(reply.ToBytes()))
+	reply = kv.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, "GROUP", "g", "c2", "BLOCK", "0", "STREAMS", "s", ">"))
+	_, ok := reply.(*database.BlockedReply)
+	assert.True(t, ok)
+	reply = kv.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, "GROUP", "none", "c2", "STREAMS", "s", ">"))
+	assert.Equal(t, "-NOGROUP No such key 's' or consumer group 'none' in XREADGROUP with GROUP option\r\n", string(reply.ToBytes()))
+
+	// 读取历史消息会增加投递次数
+	reply = kv.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, "GROUP", "g", "c1", "STREAMS", "s", "0"))
+	assert.Equal(t, "*1\r\n*2\r\n$1\r\ns\r\n*2\r\n"+
+		"*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$3\r\n1-1\r\n"+
+		"*2\r\n$3\r\n2-1\r\n*2\r\n$1\r\nf\r\n$3\r\n2-1\r\n", string(reply.ToBytes()))
+
+	stream, _ := kv.getAsStream("s")
+	group := stream.Group("g")
+	assert.Equal(t, int64(2), group.NACK(StreamID{Ms: 1, Seq: 1}).DeliveryCount)
+	assert.Equal(t, int64(1), group.NACK(StreamID{Ms: 3, Seq: 1}).DeliveryCount)
+
+	reply = kv.XPending(newTestCmd(database.CmdTypeXPending, "s", "g"))
+	assert.Equal(t, "*4\r\n:3\r\n$3\r\n1-1\r\n$3\r\n3-1\r\n*2\r\n*2\r\n$2\r\nc1\r\n$1\r\n2\r\n*2\r\n$2\r\nc2\r\n$1\r\n1\r\n", string(reply.ToBytes()))
+	reply = kv.XPending(newTestCmd(database.CmdTypeXPending, "s", "g", "IDLE", "60000", "-", "+", "10"))
+	assert.Equal(t, "*0\r\n", string(reply.ToBytes()))
+	reply = kv.XPending(newTestCmd(database.CmdTypeXPending, "s", "g", "(1-1", "+", "10", "c1"))
+	replies := reply.(*handler.ArrayReply).Replies()
+	assert.Len(t, replies, 1)
+	assert.Equal(t, "$3\r\n2-1\r\n", string(replies[0].(*handler.ArrayReply).Replies()[0].ToBytes()))
+
+	reply = kv.XAck(newTestCmd(database.CmdTypeXAck, "s", "g", "1-1", "9-9"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+
+	reply = kv.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "g", "c2", "3600000", "2-1"))
+	assert.Equal(t, "*0\r\n", string(reply.ToBytes()))
+	reply = kv.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "g", "c2", "0", "2-1", "JUSTID"))
+	assert.Equal(t, "*1\r\n$3\r\n2-1\r\n", string(reply.ToBytes()))
+	assert.Equal(t, "c2", group.NACK(StreamID{Ms: 2, Seq: 1}).Consumer.Name)
+	assert.Equal(t, int64(2), group.NACK(StreamID{Ms: 2, Seq: 1}).DeliveryCount)
+	reply = kv.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "g", "c2", "0", "2-1", "BAD"))
+	assert.Equal(t, "-ERR Unrecognized XCLAIM option 'BAD'\r\n", string(reply.ToBytes()))
+
+	// 已删除的消息在认领时从 pel 中移除
+	kv.XDel(newTestCmd(database.CmdTypeXDel, "s", "3-1"))
+	reply = kv.XAutoClaim(newTestCmd(database.CmdTypeXAutoClaim, "s", "g", "c1", "0", "0", "COUNT", "1"))
+	assert.Equal(t, "*3\r\n$3\r\n3-1\r\n*1\r\n*2\r\n$3\r\n2-1\r\n*2\r\n$1\r\nf\r\n$3\r\n2-1\r\n*0\r\n", string(reply.ToBytes()))
+	reply = kv.XAutoClaim(newTestCmd(database.CmdTypeXAutoClaim, "s", "g", "c1", "0", "3-1", "JUSTID"))
+	assert.Equal(t, "*3\r\n$3\r\n0-0\r\n*0\r\n*1\r\n$3\r\n3-1\r\n", string(reply.ToBytes()))
+	assert.Equal(t, int64(1), group.PendingLen())
+
+	reply = kv.XInfo(newTestCmd(database.CmdTypeXInfo, "GROUPS", "s"))
+	assert.Equal(t, "*1\r\n*12\r\n$4\r\nname\r\n$1\r\ng\r\n$9\r\nconsumers\r\n:2\r\n$7\r\npending\r\n:1\r\n"+
+		"$17\r\nlast-delivered-id\r\n$3\r\n3-1\r\n$12\r\nentries-read\r\n:3\r\n$3\r\nlag\r\n:0\r\n", string(reply.ToBytes()))
+	reply = kv.XInfo(newTestCmd(database.CmdTypeXInfo, "CONSUMERS", "s", "g"))
+	replies = reply.(*handler.ArrayReply).Replies()
+	assert.Len(t, replies, 2)
+	assert.Equal(t, ":1\r\n", string(replies[0].(*handler.ArrayReply).Replies()[3].ToBytes()))
+	reply = kv.XInfo(newTestCmd(database.CmdTypeXInfo, "STREAM", "s"))
+	replies = reply.(*handler.ArrayReply).Replies()
+	assert.Equal(t, ":2\r\n", string(replies[1].ToBytes()))
+	assert.Equal(t, "$3\r\n3-1\r\n", string(replies[9].ToBytes()))
+	assert.Equal(t, ":1\r\n", string(replies[15].ToBytes()))
+	reply = kv.XInfo(newTestCmd(database.CmdTypeXInfo, "STREAM", "none"))
+	assert.Equal(t, "-ERR no such key\r\n", string(reply.ToBytes()))
+
+	reply = kv.XGroup(newTestCmd(database.CmdTypeXGroup, "CREATECONSUMER", "s", "g", "c3"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+	reply = kv.XGroup(newTestCmd(database.CmdTypeXGroup, "DELCONSUMER", "s", "g", "c1"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+	assert.Equal(t, int64(0), group.PendingLen())
+}
+
+func Test_stream_group_to_cmds(t *testing.T) {
+	kv, _ := newTestKVStore()
+	for _, id := range []string{"1-1", "2-1", "3-1", "4-1"} {
+		kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", id, "f", id))
+	}
+	kv.XGroup(newTestCmd(database.CmdTypeXGroup, "CREATE", "s", "g1", "0"))
+	kv.XGroup(newTestCmd(database.CmdTypeXGroup, "CREATE", "s", "g2", "$"))
+	kv.XGroup(newTestCmd(database.CmdTypeXGroup, "CREATECONSUMER", "s", "g2", "idle"))
+	kv.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, "GROUP", "g1", "c1", "COUNT", "2", "STREAMS", "s", ">"))
+	kv.XReadGroup(newTestCmd(database.CmdTypeXReadGroup, "GROUP", "g1", "c2", "COUNT", "1", "STREAMS", "s", ">"))
+	kv.XClaim(newTestCmd(database.CmdTypeXClaim, "s", "g1", "c2", "0", "1-1", "IDLE", "5000", "RETRYCOUNT", "7"))
+
+	stream, _ := kv.getAsStream("s")
+	restored, err := replayStreamCmds(t, stream.ToCmds()).getAsStream("s")
+	assert.NoError(t, err)
+	assert.Len(t, restored.Groups(), 2)
+
+	for _, group := range stream.Groups() {
+		restoredGroup := restored.Group(group.Name)
+		assert.Equal(t, group.LastID, restoredGroup.LastID)
+		assert.Equal(t, group.EntriesRead, restoredGroup.EntriesRead)
+
+		var consumers []string
+		for _, consumer := range restoredGroup.Consumers() {
+			consumers = append(consumers, consumer.Name)
+		}
+		var expectConsumers []string
+		for _, consumer := range group.Consumers() {
+			expectConsumers = append(expectConsumers, consumer.Name)
+		}
+		assert.Equal(t, expectConsumers, consumers)
+
+		nacks := group.PendingRange(minStreamID, maxStreamID, 0, nil)
+		restoredNacks := restoredGroup.PendingRange(minStreamID, maxStreamID, 0, nil)
+		assert.Len(t, restoredNacks, len(nacks))
+		for i, nack := range nacks {
+			assert.Equal(t, nack.ID, restoredNacks[i].ID)
+			assert.Equal(t, nack.Consumer.Name, restoredNacks[i].Consumer.Name)
+			assert.Equal(t, nack.DeliveryCount, restoredNacks[i].DeliveryCount)
+			assert.Equal(t, nack.DeliveryTime.UnixMilli(), restoredNacks[i].DeliveryTime.UnixMilli())
+		}
+	}
+
+	g1 := restored.Group("g1")
+	assert.Equal(t, int64(7), g1.NACK(StreamID{Ms: 1, Seq: 1}).DeliveryCount)
+	assert.Equal(t, "c2", g1.NACK(StreamID{Ms: 1, Seq: 1}).Consumer.Name)
+	assert.Equal(t, int64(4), restored.Group("g2").EntriesRead)
+}
@@ -0,0 +1,220 @@
+package datastore
+
+import (
+	"goredis/database"
+	"goredis/handler"
+	"goredis/lib"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStream(n int) (Stream, []StreamEntry) {
+	stream := newStreamEntity("")
+	entries := make([]StreamEntry, 0, n)
+	for i := 0; i < n; i++ {
+		entry := StreamEntry{
+			ID:     StreamID{Ms: uint64(i / 3), Seq: uint64(i % 3)},
+			Fields: [][]byte{[]byte("f"), []byte(cast.ToString(i))},
+		}
+		stream.Add(entry.ID, entry.Fields)
+		entries = append(entries, entry)
+	}
+	return stream, entries
+}
+
+func Test_stream_range_del(t *testing.T) {
+	stream, entries := newTestStream(1000)
+	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
+
+	t.Run("range", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			start, end := rander.Intn(1000), rander.Intn(1000)
+			count := int64(rander.Intn(20))
+			expect := []StreamEntry{}
+			for j := start; j <= end && (count == 0 || int64(len(expect)) < count); j++ {
+				expect = append(expect, entries[j])
+			}
+			assert.Equal(t, expect, stream.Range(entries[start].ID, entries[end].ID, count, false))
+
+			expect = []StreamEntry{}
+			for j := end; j >= start && (count == 0 || int64(len(expect)) < count); j-- {
+				expect = append(expect, entries[j])
+			}
+			assert.Equal(t, expect, stream.Range(entries[start].ID, entries[end].ID, count, true))
+		}
+	})
+
+	t.Run("del", func(t *testing.T) {
+		for i := 0; i < 500; i++ {
+			j := rander.Intn(len(entries))
+			assert.True(t, stream.Del(entries[j].ID))
+			assert.False(t, stream.Del(entries[j].ID))
+			entries = append(entries[:j], entries[j+1:]...)
+		}
+		assert.Equal(t, int64(len(entries)), stream.Len())
+		assert.Equal(t, entries, stream.Range(minStreamID, maxStreamID, 0, false))
+		assert.Equal(t, StreamID{Ms: 333, Seq: 0}, stream.LastID())
+		assert.Equal(t, int64(1000), stream.EntriesAdded())
+	})
+}
+
+func Test_stream_trim(t *testing.T) {
+	t.Run("exact", func(t *testing.T) {
+		stream, entries := newTestStream(1000)
+		assert.Equal(t, int64(10), stream.TrimMaxLen(990, false, 0))
+		assert.Equal(t, entries[10:], stream.Range(minStreamID, maxStreamID, 0, false))
+		assert.Equal(t, int64(90), stream.TrimMinID(entries[100].ID, false, 0))
+		assert.Equal(t, entries[100:], stream.Range(minStreamID, maxStreamID, 0, false))
+		assert.Equal(t, int64(0), stream.TrimMinID(entries[100].ID, false, 0))
+	})
+
+	t.Run("approx", func(t *testing.T) {
+		stream, entries := newTestStream(1000)
+		// 只移除完整的 chunk
+		assert.Equal(t, int64(0), stream.TrimMaxLen(950, true, 0))
+		assert.Equal(t, int64(200), stream.TrimMaxLen(750, true, 0))
+		assert.Equal(t, entries[200:], stream.Range(minStreamID, maxStreamID, 0, false))
+		assert.Equal(t, int64(100), stream.TrimMinID(entries[350].ID, true, 0))
+		assert.Equal(t, int64(0), stream.TrimMaxLen(0, true, 50))
+		assert.Equal(t, int64(700), stream.TrimMaxLen(0, true, 0))
+		assert.Equal(t, int64(0), stream.Len())
+		assert.Equal(t, entries[999].ID, stream.LastID())
+	})
+}
+
+func Test_kv_stream_cmds(t *testing.T) {
+	kv, persister := newTestKVStore()
+
+	reply := kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "1000-0", "a", "1"))
+	assert.Equal(t, "$6\r\n1000-0\r\n", string(reply.ToBytes()))
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "1000-*", "b", "2"))
+	assert.Equal(t, "$6\r\n1000-1\r\n", string(reply.ToBytes()))
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "1000-*", "c", "3"))
+	assert.Equal(t, "$6\r\n1000-2\r\n", string(reply.ToBytes()))
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "2000-5", "d", "4"))
+	assert.Equal(t, "$6\r\n2000-5\r\n", string(reply.ToBytes()))
+	assert.Equal(t, [][]byte{[]byte("xadd"), []byte("s"), []byte("1000-0"), []byte("a"), []byte("1")}, persister.cmds[0])
+
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "2000-5", "e", "5"))
+	assert.Equal(t, "-"+errXAddIDTooSmall.Error()+"\r\n", string(reply.ToBytes()))
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "t", "0-0", "e", "5"))
+	assert.Equal(t, "-"+errXAddIDZero.Error()+"\r\n", string(reply.ToBytes()))
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "t", "NOMKSTREAM", "*", "e", "5"))
+	assert.Equal(t, "$-1\r\n", string(reply.ToBytes()))
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "t", "1-1", "e", "5", "f"))
+	assert.Equal(t, "-ERR syntax error\r\n", string(reply.ToBytes()))
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "t", "maxlen", "10", "limit", "5", "*", "e", "5"))
+	assert.Equal(t, "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n", string(reply.ToBytes()))
+	_, ok := kv.data["t"]
+	assert.False(t, ok)
+
+	reply = kv.XLen(newTestCmd(database.CmdTypeXLen, "s"))
+	assert.Equal(t, ":4\r\n", string(reply.ToBytes()))
+
+	reply = kv.XRange(newTestCmd(database.CmdTypeXRange, "s", "(1000-0", "1000"))
+	assert.Equal(t, "*2\r\n*2\r\n$6\r\n1000-1\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n*2\r\n$6\r\n1000-2\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n", string(reply.ToBytes()))
+	reply = kv.XRevRange(newTestCmd(database.CmdTypeXRevRange, "s", "+", "-", "COUNT", "1"))
+	assert.Equal(t, "*1\r\n*2\r\n$6\r\n2000-5\r\n*2\r\n$1\r\nd\r\n$1\r\n4\r\n", string(reply.ToBytes()))
+	reply = kv.XRange(newTestCmd(database.CmdTypeXRange, "s", "-", "+", "COUNT", "0"))
+	assert.Equal(t, "*0\r\n", string(reply.ToBytes()))
+	reply = kv.XRange(newTestCmd(database.CmdTypeXRange, "s", "x", "+"))
+	assert.Equal(t, "-"+errInvalidStreamID.Error()+"\r\n", string(reply.ToBytes()))
+
+	reply = kv.XRead(newTestCmd(database.CmdTypeXRead, "COUNT", "1", "STREAMS", "s", "none", "1000-0", "0"))
+	assert.Equal(t, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$6\r\n1000-1\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n", string(reply.ToBytes()))
+	reply = kv.XRead(newTestCmd(database.CmdTypeXRead, "STREAMS", "s", "$"))
+	assert.Equal(t, "*-1\r\n", string(reply.ToBytes()))
+	reply = kv.XRead(newTestCmd(database.CmdTypeXRead, "STREAMS", "s"))
+	assert.Equal(t, "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n", string(reply.ToBytes()))
+
+	// 阻塞时 $ 被替换为具体 id
+	xread := newTestCmd(database.CmdTypeXRead, "BLOCK", "1500", "STREAMS", "s", "$")
+	reply = kv.XRead(xread)
+	blocked, ok := reply.(*database.BlockedReply)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"s"}, blocked.Keys())
+	assert.Equal(t, 1500*time.Millisecond, blocked.Timeout())
+	assert.Equal(t, "2000-5", string(xread.Args()[4]))
+
+	persister.cmds = nil
+	reply = kv.XDel(newTestCmd(database.CmdTypeXDel, "s", "1000-1", "1000-9"))
+	assert.Equal(t, ":1\r\n", string(reply.ToBytes()))
+	reply = kv.XTrim(newTestCmd(database.CmdTypeXTrim, "s", "MINID", "2000"))
+	assert.Equal(t, ":2\r\n", string(reply.ToBytes()))
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "MAXLEN", "=", "1", "2000-*", "e", "5"))
+	assert.Equal(t, "$6\r\n2000-6\r\n", string(reply.ToBytes()))
+	assert.Equal(t, [][][]byte{
+		{[]byte("xdel"), []byte("s"), []byte("1000-1"), []byte("1000-9")},
+		{[]byte("xtrim"), []byte("s"), []byte("maxlen"), []byte("1")},
+		{[]byte("xadd"), []byte("s"), []byte("2000-6"), []byte("e"), []byte("5")},
+		{[]byte("xtrim"), []byte("s"), []byte("maxlen"), []byte("1")},
+	}, persister.cmds)
+
+	reply = kv.XSetID(newTestCmd(database.CmdTypeXSetID, "s", "2000-5"))
+	assert.Equal(t, "-ERR The ID specified in XSETID is smaller than the target stream top item\r\n", string(reply.ToBytes()))
+	reply = kv.XSetID(newTestCmd(database.CmdTypeXSetID, "none", "2000-5"))
+	assert.Equal(t, "-ERR no such key\r\n", string(reply.ToBytes()))
+	reply = kv.XSetID(newTestCmd(database.CmdTypeXSetID, "s", "3000-0", "ENTRIESADDED", "10"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "3000-0", "f", "6"))
+	assert.Equal(t, "-"+errXAddIDTooSmall.Error()+"\r\n", string(reply.ToBytes()))
+
+	// 自动生成的 id 取当前毫秒时间戳
+	now := uint64(lib.TimeNow().UnixMilli())
+	reply = kv.XAdd(newTestCmd(database.CmdTypeXAdd, "s", "*", "g", "7"))
+	stream, _ := kv.getAsStream("s")
+	assert.Equal(t, "$"+cast.ToString(len(stream.LastID().String()))+"\r\n"+stream.LastID().String()+"\r\n", string(reply.ToBytes()))
+	assert.GreaterOrEqual(t, stream.LastID().Ms, now)
+
+	reply = kv.Object(newTestCmd(database.CmdTypeObject, "encoding", "s"))
+	assert.Equal(t, "$6\r\nstream\r\n", string(reply.ToBytes()))
+}
+
+// 重放 ToCmds 的结果，还原到新的 KVStore 中
+func replayStreamCmds(t *testing.T, cmds [][][]byte) *KVStore {
+	kv, _ := newTestKVStore()
+	handlers := map[database.CmdType]func(*database.Command) handler.Reply{
+		database.CmdTypeXAdd:   kv.XAdd,
+		database.CmdTypeXSetID: kv.XSetID,
+		database.CmdTypeXGroup: kv.XGroup,
+		database.CmdTypeXClaim: kv.XClaim,
+	}
+	for _, cmd := range cmds {
+		cmdType := database.CmdType(cmd[0])
+		reply := handlers[cmdType](database.NewCommand(cmdType, cmd[1:]))
+		assert.NotEqual(t, byte('-'), reply.ToBytes()[0], string(reply.ToBytes()))
+	}
+	return kv
+}
+
+func Test_stream_to_cmds(t *testing.T) {
+	t.Run("entries", func(t *testing.T) {
+		stream, entries := newTestStream(250)
+		stream.Del(entries[249].ID)
+		stream.TrimMaxLen(200, false, 0)
+
+		kv := replayStreamCmds(t, stream.ToCmds())
+		restored, err := kv.getAsStream("")
+		assert.NoError(t, err)
+		assert.Equal(t, entries[49:249], restored.Range(minStreamID, maxStreamID, 0, false))
+		assert.Equal(t, stream.LastID(), restored.LastID())
+		assert.Equal(t, stream.EntriesAdded(), restored.EntriesAdded())
+		assert.Equal(t, stream.MaxDeletedID(), restored.MaxDeletedID())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		stream, _ := newTestStream(10)
+		stream.TrimMaxLen(0, false, 0)
+
+		kv := replayStreamCmds(t, stream.ToCmds())
+		restored, err := kv.getAsStream("")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), restored.Len())
+		assert.Equal(t, stream.LastID(), restored.LastID())
+		assert.Equal(t, int64(10), restored.EntriesAdded())
+	})
+}
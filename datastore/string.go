@@ -3,6 +3,7 @@ package datastore
 import (
 	"goredis/database"
 	"goredis/handler"
+	"strconv"
 )
 
 func (k *KVStore) getAsString(key string) (String, error) {
@@ -25,11 +26,13 @@ func (k *KVStore) put(key, value string, insertStrategy bool) int64 {
 	}
 
 	k.data[key] = NewString(key, value)
+	k.reindex(key)
 	return 1
 }
 
 type String interface {
 	Bytes() []byte
+	Encoding() string
 	database.CmdAdapter
 }
 
@@ -45,6 +48,17 @@ func (s *stringEntity) Bytes() []byte {
 	return []byte(s.str)
 }
 
+func (s *stringEntity) Encoding() string {
+	if i, err := strconv.ParseInt(s.str, 10, 64); err == nil && strconv.FormatInt(i, 10) == s.str {
+		return encodingInt
+	}
+	// 与 redis 一致，44 字节以内的短字符串为 embstr 编码
+	if len(s.str) <= 44 {
+		return encodingEmbstr
+	}
+	return encodingRaw
+}
+
 func (s *stringEntity) ToCmd() [][]byte {
 	return [][]byte{[]byte(database.CmdTypeSet), []byte(s.key), []byte(s.str)}
 }
\ No newline at end of file
@@ -0,0 +1,379 @@
+package datastore
+
+import (
+	"errors"
+	"goredis/database"
+	"goredis/handler"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (k *KVStore) getAsTimeSeries(key string) (TimeSeries, error) {
+	v, ok := k.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	series, ok := v.(TimeSeries)
+	if !ok {
+		return nil, handler.NewWrongTypeErrReply()
+	}
+
+	return series, nil
+}
+
+// 记录所有时间序列的 key，供过期处理和 TS.MRANGE 遍历
+func (k *KVStore) putAsTimeSeries(key string, series TimeSeries) {
+	k.data[key] = series
+	k.timeSeries[key] = struct{}{}
+}
+
+// 遍历所有时间序列. key 被删除或覆盖为其他类型时顺带清理登记
+func (k *KVStore) forEachTimeSeries(f func(key string, series TimeSeries)) {
+	for key := range k.timeSeries {
+		series, err := k.getAsTimeSeries(key)
+		if err != nil || series == nil {
+			delete(k.timeSeries, key)
+			continue
+		}
+		f(key, series)
+	}
+}
+
+// 按保留时长淘汰过期的样本
+func (k *KVStore) trimTimeSeries() {
+	k.forEachTimeSeries(func(key string, series TimeSeries) {
+		series.Trim()
+	})
+}
+
+const (
+	tsDefaultChunkSize = 4096
+	tsMinChunkSize     = 48
+	tsMaxChunkSize     = 1048576
+)
+
+// 重复时间戳的处理策略
+const (
+	tsDuplicateBlock = "block"
+	tsDuplicateFirst = "first"
+	tsDuplicateLast  = "last"
+	tsDuplicateMin   = "min"
+	tsDuplicateMax   = "max"
+	tsDuplicateSum   = "sum"
+)
+
+var (
+	errTSKeyExist     = errors.New("ERR TSDB: key already exists")
+	errTSKeyNotExist  = errors.New("ERR TSDB: the key does not exist")
+	errTSTooOld       = errors.New("ERR TSDB: Timestamp is older than retention")
+	errTSBlock        = errors.New("ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode")
+	errTSUnknownDup   = errors.New("ERR TSDB: Unknown DUPLICATE_POLICY")
+	errTSInvalidLabel = errors.New("ERR TSDB: failed parsing labels")
+)
+
+func parseTSDuplicatePolicy(raw string) (string, error) {
+	policy := strings.ToLower(raw)
+	switch policy {
+	case tsDuplicateBlock, tsDuplicateFirst, tsDuplicateLast, tsDuplicateMin, tsDuplicateMax, tsDuplicateSum:
+		return policy, nil
+	default:
+		return "", errTSUnknownDup
+	}
+}
+
+// 按重复策略合并同一时间戳的旧值和新值
+func mergeTSDuplicate(policy string, old, value float64) (float64, error) {
+	switch policy {
+	case tsDuplicateFirst:
+		return old, nil
+	case tsDuplicateLast:
+		return value, nil
+	case tsDuplicateMin:
+		return math.Min(old, value), nil
+	case tsDuplicateMax:
+		return math.Max(old, value), nil
+	case tsDuplicateSum:
+		return old + value, nil
+	default:
+		return 0, errTSBlock
+	}
+}
+
+type TimeSeries interface {
+	// 写入样本，policy 为空时使用序列的重复策略
+	Add(ts int64, value float64, policy string) error
+	// 按时间戳升序返回 [from, to] 区间内保留期内的样本
+	Range(from, to int64) []tsSample
+	Len() int64
+	Labels() []string
+	// 淘汰保留期外的 chunk
+	Trim()
+	database.MultiCmdAdapter
+}
+
+type timeSeriesEntity struct {
+	key string
+	// 保留时长，单位毫秒. 0 代表永久保留
+	retention       int64
+	chunkSize       int
+	duplicatePolicy string
+	// 按 label, value 依次排列
+	labels []string
+	chunks []*tsChunk
+}
+
+func newTimeSeriesEntity(key string, retention int64, chunkSize int, duplicatePolicy string, labels []string) TimeSeries {
+	return &timeSeriesEntity{
+		key:             key,
+		retention:       retention,
+		chunkSize:       chunkSize,
+		duplicatePolicy: duplicatePolicy,
+		labels:          labels,
+	}
+}
+
+// 保留期内最小的时间戳
+func (t *timeSeriesEntity) minTimestamp() int64 {
+	if t.retention == 0 || len(t.chunks) == 0 {
+		return math.MinInt64
+	}
+	return t.chunks[len(t.chunks)-1].last - t.retention
+}
+
+func (t *timeSeriesEntity) Add(ts int64, value float64, policy string) error {
+	if ts < t.minTimestamp() {
+		return errTSTooOld
+	}
+
+	// 顺序写入时直接追加到最后一个 chunk
+	if len(t.chunks) == 0 || ts > t.chunks[len(t.chunks)-1].last {
+		if len(t.chunks) == 0 || t.chunks[len(t.chunks)-1].size() >= t.chunkSize {
+			t.chunks = append(t.chunks, newTSChunk())
+		}
+		t.chunks[len(t.chunks)-1].append(ts, value)
+		return nil
+	}
+
+	// 乱序写入时找到所属 chunk 后解码重建
+	i := sort.Search(len(t.chunks), func(i int) bool {
+		return t.chunks[i].last >= ts
+	})
+	samples := t.chunks[i].samples()
+	j := sort.Search(len(samples), func(j int) bool {
+		return samples[j].ts >= ts
+	})
+	if j < len(samples) && samples[j].ts == ts {
+		if policy == "" {
+			policy = t.duplicatePolicy
+		}
+		merged, err := mergeTSDuplicate(policy, samples[j].value, value)
+		if err != nil {
+			return err
+		}
+		samples[j].value = merged
+	} else {
+		samples = append(samples, tsSample{})
+		copy(samples[j+1:], samples[j:])
+		samples[j] = tsSample{ts: ts, value: value}
+	}
+
+	// 重建后过大时一分为二
+	chunk := newTSChunkFrom(samples)
+	if chunk.size() < 2*t.chunkSize {
+		t.chunks[i] = chunk
+		return nil
+	}
+	half := len(samples) / 2
+	t.chunks = append(t.chunks[:i], append([]*tsChunk{newTSChunkFrom(samples[:half]), newTSChunkFrom(samples[half:])}, t.chunks[i+1:]...)...)
+	return nil
+}
+
+func (t *timeSeriesEntity) Range(from, to int64) []tsSample {
+	if min := t.minTimestamp(); from < min {
+		from = min
+	}
+
+	samples := []tsSample{}
+	for _, chunk := range t.chunks {
+		if chunk.last < from {
+			continue
+		}
+		if chunk.first > to {
+			break
+		}
+		for _, sample := range chunk.samples() {
+			if sample.ts >= from && sample.ts <= to {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	return samples
+}
+
+func (t *timeSeriesEntity) Len() int64 {
+	var count int64
+	for _, chunk := range t.chunks {
+		count += int64(chunk.count)
+	}
+	return count
+}
+
+func (t *timeSeriesEntity) Labels() []string {
+	return t.labels
+}
+
+func (t *timeSeriesEntity) Trim() {
+	min := t.minTimestamp()
+	i := 0
+	for i < len(t.chunks)-1 && t.chunks[i].last < min {
+		i++
+	}
+	t.chunks = t.chunks[i:]
+}
+
+func (t *timeSeriesEntity) ToCmd() [][]byte {
+	cmd := [][]byte{
+		[]byte(database.CmdTypeTSCreate), []byte(t.key),
+		[]byte("retention"), []byte(strconv.FormatInt(t.retention, 10)),
+		[]byte("chunk_size"), []byte(strconv.Itoa(t.chunkSize)),
+		[]byte("duplicate_policy"), []byte(t.duplicatePolicy),
+	}
+	if len(t.labels) > 0 {
+		cmd = append(cmd, []byte("labels"))
+		for _, label := range t.labels {
+			cmd = append(cmd, []byte(label))
+		}
+	}
+	return cmd
+}
+
+// 先创建序列，再依次写入保留期内的样本
+func (t *timeSeriesEntity) ToCmds() [][][]byte {
+	cmds := [][][]byte{t.ToCmd()}
+	for _, sample := range t.Range(math.MinInt64, math.MaxInt64) {
+		cmds = append(cmds, [][]byte{
+			[]byte(database.CmdTypeTSAdd), []byte(t.key),
+			[]byte(strconv.FormatInt(sample.ts, 10)), []byte(formatTSValue(sample.value)),
+		})
+	}
+	return cmds
+}
+
+func formatTSValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// label 过滤条件. values 为空时代表 label 取值为空，即不存在该 label
+type tsFilter struct {
+	label  string
+	values []string
+	equal  bool
+}
+
+// 解析 label=value, label!=value, label=, label!=, label=(v1,v2), label!=(v1,v2)
+func parseTSFilter(raw string) (*tsFilter, error) {
+	i := strings.Index(raw, "=")
+	if i <= 0 {
+		return nil, errors.New("ERR TSDB: failed parsing filter " + raw)
+	}
+
+	filter := tsFilter{label: raw[:i], equal: true}
+	if strings.HasSuffix(filter.label, "!") {
+		filter.label = filter.label[:len(filter.label)-1]
+		filter.equal = false
+	}
+	if filter.label == "" {
+		return nil, errors.New("ERR TSDB: failed parsing filter " + raw)
+	}
+
+	value := raw[i+1:]
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		filter.values = strings.Split(value[1:len(value)-1], ",")
+	} else if value != "" {
+		filter.values = []string{value}
+	}
+	return &filter, nil
+}
+
+// 是否为要求 label 存在的正向匹配
+func (f *tsFilter) isMatcher() bool {
+	return f.equal && len(f.values) > 0
+}
+
+func (f *tsFilter) match(labels []string) bool {
+	var value string
+	for i := 0; i+1 < len(labels); i += 2 {
+		if labels[i] == f.label {
+			value = labels[i+1]
+			break
+		}
+	}
+
+	var hit bool
+	if len(f.values) == 0 {
+		hit = value == ""
+	} else {
+		for _, v := range f.values {
+			if v == value {
+				hit = true
+				break
+			}
+		}
+	}
+	return hit == f.equal
+}
+
+type tsAggregator func(values []float64) float64
+
+var tsAggregators = map[string]tsAggregator{
+	"avg": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"sum": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"min": func(values []float64) float64 {
+		min := values[0]
+		for _, v := range values[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+	"max": func(values []float64) float64 {
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+	"count": func(values []float64) float64 {
+		return float64(len(values))
+	},
+}
+
+// 将升序样本按 bucket 起始时间分组聚合
+func aggregateTSSamples(samples []tsSample, aggregator tsAggregator, bucket int64) []tsSample {
+	res := []tsSample{}
+	var values []float64
+	for i, sample := range samples {
+		start := sample.ts - ((sample.ts%bucket)+bucket)%bucket
+		values = append(values, sample.value)
+		if i+1 < len(samples) && samples[i+1].ts-start < bucket {
+			continue
+		}
+		res = append(res, tsSample{ts: start, value: aggregator(values)})
+		values = values[:0]
+	}
+	return res
+}
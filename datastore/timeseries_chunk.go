@@ -0,0 +1,208 @@
+package datastore
+
+import (
+	"math"
+	"math/bits"
+)
+
+type tsSample struct {
+	ts    int64
+	value float64
+}
+
+// 按 Gorilla 论文压缩的样本块. 时间戳记录 delta-of-delta，值记录与前一个值的异或结果
+type tsChunk struct {
+	data  []byte
+	nbits uint64
+	count int
+	first int64
+	last  int64
+
+	// 追加写入时的编码状态
+	prevDelta    int64
+	prevValue    uint64
+	prevLeading  uint8
+	prevTrailing uint8
+}
+
+func newTSChunk() *tsChunk {
+	return &tsChunk{}
+}
+
+// 将 samples 按顺序编码成新的 chunk
+func newTSChunkFrom(samples []tsSample) *tsChunk {
+	chunk := newTSChunk()
+	for _, sample := range samples {
+		chunk.append(sample.ts, sample.value)
+	}
+	return chunk
+}
+
+func (c *tsChunk) size() int {
+	return len(c.data)
+}
+
+func (c *tsChunk) writeBit(bit bool) {
+	if c.nbits%8 == 0 {
+		c.data = append(c.data, 0)
+	}
+	if bit {
+		c.data[c.nbits/8] |= 1 << (7 - c.nbits%8)
+	}
+	c.nbits++
+}
+
+// 写入 v 的低 n 位，高位在前
+func (c *tsChunk) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		c.writeBit(v&(1<<i) != 0)
+	}
+}
+
+// delta-of-delta 按取值范围选择编码长度
+var tsDODBuckets = []struct {
+	prefix     uint64
+	prefixBits int
+	valueBits  int
+}{
+	{prefix: 0b10, prefixBits: 2, valueBits: 7},
+	{prefix: 0b110, prefixBits: 3, valueBits: 9},
+	{prefix: 0b1110, prefixBits: 4, valueBits: 12},
+	{prefix: 0b1111, prefixBits: 4, valueBits: 64},
+}
+
+// 调用方需保证 ts 不小于 last
+func (c *tsChunk) append(ts int64, value float64) {
+	valueBits := math.Float64bits(value)
+	if c.count == 0 {
+		c.writeBits(uint64(ts), 64)
+		c.writeBits(valueBits, 64)
+		c.first, c.last, c.prevValue = ts, ts, valueBits
+		c.prevLeading = 0xff
+		c.count++
+		return
+	}
+
+	delta := ts - c.last
+	c.writeDOD(delta - c.prevDelta)
+	c.writeXOR(valueBits ^ c.prevValue)
+	c.last, c.prevDelta, c.prevValue = ts, delta, valueBits
+	c.count++
+}
+
+func (c *tsChunk) writeDOD(dod int64) {
+	if dod == 0 {
+		c.writeBit(false)
+		return
+	}
+	for _, bucket := range tsDODBuckets {
+		if bucket.valueBits == 64 || (dod >= -(1<<(bucket.valueBits-1)) && dod < 1<<(bucket.valueBits-1)) {
+			c.writeBits(bucket.prefix, bucket.prefixBits)
+			c.writeBits(uint64(dod), bucket.valueBits)
+			return
+		}
+	}
+}
+
+func (c *tsChunk) writeXOR(xor uint64) {
+	if xor == 0 {
+		c.writeBit(false)
+		return
+	}
+	c.writeBit(true)
+
+	leading, trailing := uint8(bits.LeadingZeros64(xor)), uint8(bits.TrailingZeros64(xor))
+	// 前导零个数用 5 位记录
+	if leading > 31 {
+		leading = 31
+	}
+	// 有效位落在上一个窗口内时复用窗口
+	if c.prevLeading != 0xff && leading >= c.prevLeading && trailing >= c.prevTrailing {
+		c.writeBit(false)
+		c.writeBits(xor>>c.prevTrailing, 64-int(c.prevLeading)-int(c.prevTrailing))
+		return
+	}
+
+	meaningful := 64 - int(leading) - int(trailing)
+	c.writeBit(true)
+	c.writeBits(uint64(leading), 5)
+	// 有效位长度用 6 位记录，64 记为 0
+	c.writeBits(uint64(meaningful)&0x3f, 6)
+	c.writeBits(xor>>trailing, meaningful)
+	c.prevLeading, c.prevTrailing = leading, trailing
+}
+
+type tsChunkReader struct {
+	chunk *tsChunk
+	pos   uint64
+}
+
+func (r *tsChunkReader) readBit() bool {
+	bit := r.chunk.data[r.pos/8]&(1<<(7-r.pos%8)) != 0
+	r.pos++
+	return bit
+}
+
+func (r *tsChunkReader) readBits(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if r.readBit() {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// 解码出 chunk 中的全部样本
+func (c *tsChunk) samples() []tsSample {
+	samples := make([]tsSample, 0, c.count)
+	if c.count == 0 {
+		return samples
+	}
+
+	r := tsChunkReader{chunk: c}
+	ts := int64(r.readBits(64))
+	valueBits := r.readBits(64)
+	samples = append(samples, tsSample{ts: ts, value: math.Float64frombits(valueBits)})
+
+	var (
+		delta             int64
+		leading, trailing uint8
+	)
+	for i := 1; i < c.count; i++ {
+		delta += r.readDOD()
+
+		if r.readBit() {
+			if r.readBit() {
+				leading = uint8(r.readBits(5))
+				meaningful := uint8(r.readBits(6))
+				if meaningful == 0 {
+					meaningful = 64
+				}
+				trailing = 64 - leading - meaningful
+			}
+			valueBits ^= r.readBits(64-int(leading)-int(trailing)) << trailing
+		}
+
+		ts += delta
+		samples = append(samples, tsSample{ts: ts, value: math.Float64frombits(valueBits)})
+	}
+	return samples
+}
+
+func (r *tsChunkReader) readDOD() int64 {
+	if !r.readBit() {
+		return 0
+	}
+	for _, bucket := range tsDODBuckets {
+		// 前缀的最后一位为 0 或已是最长前缀时命中
+		if bucket.valueBits == 64 || !r.readBit() {
+			v := r.readBits(bucket.valueBits)
+			// 符号扩展
+			shift := 64 - bucket.valueBits
+			return int64(v<<shift) >> shift
+		}
+	}
+	return 0
+}
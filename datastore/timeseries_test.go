@@ -0,0 +1,148 @@
+package datastore
+
+import (
+	"goredis/database"
+	"goredis/lib"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/spf13/cast"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ts_chunk_codec(t *testing.T) {
+	rander := rand.New(rand.NewSource(lib.TimeNow().UnixNano()))
+	samples := []tsSample{}
+	ts := int64(1700000000000)
+	for i := 0; i < 5000; i++ {
+		// 覆盖 delta-of-delta 的各个编码区间
+		switch rander.Intn(5) {
+		case 0:
+			ts += 1000
+		case 1:
+			ts += int64(rander.Intn(100))
+		case 2:
+			ts += int64(rander.Intn(5000))
+		default:
+			ts += int64(rander.Intn(1 << 20))
+		}
+		value := math.Round(rander.Float64()*10000) / 100
+		if rander.Intn(3) == 0 && len(samples) > 0 {
+			value = samples[len(samples)-1].value
+		}
+		samples = append(samples, tsSample{ts: ts, value: value})
+	}
+	samples = append(samples, tsSample{ts: math.MaxInt64, value: math.Inf(-1)})
+
+	chunk := newTSChunkFrom(samples)
+	assert.Equal(t, samples, chunk.samples())
+	// 压缩后小于原始的 16 字节每个样本
+	assert.Less(t, chunk.size(), 16*len(samples))
+}
+
+func Test_kv_ts_cmds(t *testing.T) {
+	kv, persister := newTestKVStore()
+
+	reply := kv.TSCreate(newTestCmd(database.CmdTypeTSCreate, "cpu", "RETENTION", "1000", "CHUNK_SIZE", "48", "LABELS", "host", "a", "type", "cpu"))
+	assert.Equal(t, "+OK\r\n", string(reply.ToBytes()))
+	reply = kv.TSCreate(newTestCmd(database.CmdTypeTSCreate, "cpu"))
+	assert.Equal(t, "-"+errTSKeyExist.Error()+"\r\n", string(reply.ToBytes()))
+	reply = kv.TSCreate(newTestCmd(database.CmdTypeTSCreate, "x", "CHUNK_SIZE", "50"))
+	assert.Equal(t, "-ERR TSDB: CHUNK_SIZE value must be a multiple of 8 in the range [48 .. 1048576]\r\n", string(reply.ToBytes()))
+	reply = kv.TSCreate(newTestCmd(database.CmdTypeTSCreate, "x", "LABELS", "host"))
+	assert.Equal(t, "-"+errTSInvalidLabel.Error()+"\r\n", string(reply.ToBytes()))
+
+	for i := 0; i < 100; i++ {
+		reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "cpu", cast.ToString(i*10), cast.ToString(i)))
+		assert.Equal(t, ":"+cast.ToString(i*10)+"\r\n", string(reply.ToBytes()))
+	}
+	series, _ := kv.getAsTimeSeries("cpu")
+	assert.Greater(t, len(series.(*timeSeriesEntity).chunks), 1)
+
+	reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "cpu", "500", "1"))
+	assert.Equal(t, "-"+errTSBlock.Error()+"\r\n", string(reply.ToBytes()))
+	reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "cpu", "500", "1", "ON_DUPLICATE", "SUM"))
+	assert.Equal(t, ":500\r\n", string(reply.ToBytes()))
+	reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "cpu", "505", "7"))
+	assert.Equal(t, ":505\r\n", string(reply.ToBytes()))
+	reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "cpu", "-1", "7"))
+	assert.Equal(t, "-ERR TSDB: invalid timestamp\r\n", string(reply.ToBytes()))
+	reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "cpu", "1", "x"))
+	assert.Equal(t, "-ERR TSDB: invalid value\r\n", string(reply.ToBytes()))
+
+	reply = kv.TSRange(newTestCmd(database.CmdTypeTSRange, "cpu", "490", "510"))
+	assert.Equal(t, "*4\r\n*2\r\n:490\r\n$2\r\n49\r\n*2\r\n:500\r\n$2\r\n51\r\n*2\r\n:505\r\n$1\r\n7\r\n*2\r\n:510\r\n$2\r\n51\r\n", string(reply.ToBytes()))
+	reply = kv.TSRevRange(newTestCmd(database.CmdTypeTSRevRange, "cpu", "-", "+", "COUNT", "2"))
+	assert.Equal(t, "*2\r\n*2\r\n:990\r\n$2\r\n99\r\n*2\r\n:980\r\n$2\r\n98\r\n", string(reply.ToBytes()))
+	reply = kv.TSRange(newTestCmd(database.CmdTypeTSRange, "cpu", "0", "99", "AGGREGATION", "avg", "50"))
+	assert.Equal(t, "*2\r\n*2\r\n:0\r\n$1\r\n2\r\n*2\r\n:50\r\n$1\r\n7\r\n", string(reply.ToBytes()))
+	reply = kv.TSRange(newTestCmd(database.CmdTypeTSRange, "cpu", "480", "519", "AGGREGATION", "MAX", "20", "COUNT", "1"))
+	assert.Equal(t, "*1\r\n*2\r\n:480\r\n$2\r\n49\r\n", string(reply.ToBytes()))
+	reply = kv.TSRevRange(newTestCmd(database.CmdTypeTSRevRange, "cpu", "480", "519", "AGGREGATION", "count", "20"))
+	assert.Equal(t, "*2\r\n*2\r\n:500\r\n$1\r\n3\r\n*2\r\n:480\r\n$1\r\n2\r\n", string(reply.ToBytes()))
+	reply = kv.TSRange(newTestCmd(database.CmdTypeTSRange, "cpu", "0", "10", "AGGREGATION", "median", "20"))
+	assert.Equal(t, "-ERR TSDB: Unknown aggregation type\r\n", string(reply.ToBytes()))
+	reply = kv.TSRange(newTestCmd(database.CmdTypeTSRange, "none", "-", "+"))
+	assert.Equal(t, "-"+errTSKeyNotExist.Error()+"\r\n", string(reply.ToBytes()))
+
+	// 超出保留期的样本不可写入也不可见，过期处理时淘汰整个 chunk
+	reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "cpu", "2000", "200"))
+	assert.Equal(t, ":2000\r\n", string(reply.ToBytes()))
+	reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "cpu", "900", "1"))
+	assert.Equal(t, "-"+errTSTooOld.Error()+"\r\n", string(reply.ToBytes()))
+	reply = kv.TSRange(newTestCmd(database.CmdTypeTSRange, "cpu", "-", "+"))
+	assert.Equal(t, "*1\r\n*2\r\n:2000\r\n$3\r\n200\r\n", string(reply.ToBytes()))
+	reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "cpu", "1000", "1"))
+	assert.Equal(t, ":1000\r\n", string(reply.ToBytes()))
+	kv.GC()
+	assert.Equal(t, 1, len(series.(*timeSeriesEntity).chunks))
+	reply = kv.TSRange(newTestCmd(database.CmdTypeTSRange, "cpu", "-", "+"))
+	assert.Equal(t, "*2\r\n*2\r\n:1000\r\n$1\r\n1\r\n*2\r\n:2000\r\n$3\r\n200\r\n", string(reply.ToBytes()))
+
+	// 以具体时间戳持久化
+	now := lib.TimeNow().UnixMilli()
+	reply = kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "auto", "*", "1.5", "LABELS", "host", "b"))
+	ts := persister.cmds[len(persister.cmds)-1][2]
+	assert.Equal(t, ":"+string(ts)+"\r\n", string(reply.ToBytes()))
+	assert.GreaterOrEqual(t, cast.ToInt64(string(ts)), now)
+
+	// 重写后还原
+	restore, _ := newTestKVStore()
+	for _, cmd := range series.ToCmds() {
+		cmdType := database.CmdType(cmd[0])
+		if cmdType == database.CmdTypeTSCreate {
+			restore.TSCreate(database.NewCommand(cmdType, cmd[1:]))
+		} else {
+			restore.TSAdd(database.NewCommand(cmdType, cmd[1:]))
+		}
+	}
+	expect := kv.TSRange(newTestCmd(database.CmdTypeTSRange, "cpu", "-", "+"))
+	assert.Equal(t, string(expect.ToBytes()), string(restore.TSRange(newTestCmd(database.CmdTypeTSRange, "cpu", "-", "+")).ToBytes()))
+	assert.Equal(t, series.Labels(), restore.data["cpu"].(TimeSeries).Labels())
+}
+
+func Test_kv_ts_mrange(t *testing.T) {
+	kv, _ := newTestKVStore()
+	kv.TSCreate(newTestCmd(database.CmdTypeTSCreate, "a", "LABELS", "host", "a", "type", "cpu"))
+	kv.TSCreate(newTestCmd(database.CmdTypeTSCreate, "b", "LABELS", "host", "b", "type", "cpu"))
+	kv.TSCreate(newTestCmd(database.CmdTypeTSCreate, "c", "LABELS", "host", "c", "type", "mem", "dc", "x"))
+	kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "a", "1", "1"))
+	kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "b", "1", "2"))
+	kv.TSAdd(newTestCmd(database.CmdTypeTSAdd, "c", "1", "3"))
+	kv.data["d"] = NewString("d", "x")
+	kv.timeSeries["d"] = struct{}{}
+
+	reply := kv.TSMRange(newTestCmd(database.CmdTypeTSMRange, "-", "+", "FILTER", "type=cpu", "host!=a"))
+	assert.Equal(t, "*1\r\n*3\r\n$1\r\nb\r\n*0\r\n*1\r\n*2\r\n:1\r\n$1\r\n2\r\n", string(reply.ToBytes()))
+	reply = kv.TSMRange(newTestCmd(database.CmdTypeTSMRange, "-", "+", "WITHLABELS", "FILTER", "host=(a,c)", "dc="))
+	assert.Equal(t, "*1\r\n*3\r\n$1\r\na\r\n*2\r\n*2\r\n$4\r\nhost\r\n$1\r\na\r\n*2\r\n$4\r\ntype\r\n$3\r\ncpu\r\n*1\r\n*2\r\n:1\r\n$1\r\n1\r\n", string(reply.ToBytes()))
+	reply = kv.TSMRange(newTestCmd(database.CmdTypeTSMRange, "-", "+", "FILTER", "dc!=", "host!=(a,b)"))
+	assert.Equal(t, "-ERR TSDB: please provide at least one matcher\r\n", string(reply.ToBytes()))
+	reply = kv.TSMRange(newTestCmd(database.CmdTypeTSMRange, "-", "+"))
+	assert.Equal(t, "-ERR TSDB: missing FILTER argument\r\n", string(reply.ToBytes()))
+
+	// 已不是时间序列的 key 从登记中移除
+	_, ok := kv.timeSeries["d"]
+	assert.False(t, ok)
+}
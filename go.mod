@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/panjf2000/ants v1.3.0
+	github.com/yuin/gopher-lua v1.1.1
 	go.uber.org/zap v1.27.0
 )
 
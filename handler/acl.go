@@ -0,0 +1,414 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"goredis/acl"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DB 的可选实现，供 ACL 检查指令权限. 未实现时 db 中的指令不做权限检查
+type CmdDescriber interface {
+	// 指令所属的 ACL 类别，未知指令返回 false
+	CmdCategories(cmd string) ([]string, bool)
+	// 指令访问的 key
+	CmdKeys(cmdLine [][]byte) []string
+	// db 中指令的全部类别
+	Categories() []string
+}
+
+// 检查脚本中执行的指令的权限，通过时返回 nil. handler 将其放入 ctx，db 在执行脚本中的每条指令前调用
+type ScriptAuthorizer func(args [][]byte) Reply
+
+var scriptAuthorizer int
+var ctxKeyScriptAuthorizer = &scriptAuthorizer
+
+func SetScriptAuthorizer(ctx context.Context, authorizer ScriptAuthorizer) context.Context {
+	return context.WithValue(ctx, ctxKeyScriptAuthorizer, authorizer)
+}
+
+func GetScriptAuthorizer(ctx context.Context) (ScriptAuthorizer, bool) {
+	authorizer, ok := ctx.Value(ctxKeyScriptAuthorizer).(ScriptAuthorizer)
+	return authorizer, ok
+}
+
+// 检查指令在执行时才确定的 key 的权限，通过时返回 nil. 用于执行前无法确定 key 的指令，
+// 如按前缀建立的索引、按标签匹配的序列，db 在匹配到 key 后调用
+type KeyAuthorizer func(cmdLine [][]byte, keys []string) Reply
+
+var keyAuthorizer int
+var ctxKeyKeyAuthorizer = &keyAuthorizer
+
+func SetKeyAuthorizer(ctx context.Context, authorizer KeyAuthorizer) context.Context {
+	return context.WithValue(ctx, ctxKeyKeyAuthorizer, authorizer)
+}
+
+func GetKeyAuthorizer(ctx context.Context) (KeyAuthorizer, bool) {
+	authorizer, ok := ctx.Value(ctxKeyKeyAuthorizer).(KeyAuthorizer)
+	return authorizer, ok
+}
+
+// 未认证的连接也可以执行的指令
+var noAuthCmds = map[string]bool{
+	"auth":  true,
+	"hello": true,
+	"quit":  true,
+	"reset": true,
+}
+
+// 连接层指令的 ACL 类别
+var connCmdCategories = map[string][]string{
+	"hello":  {"@fast", "@connection"},
+	"ping":   {"@fast", "@connection"},
+	"echo":   {"@fast", "@connection"},
+	"quit":   {"@fast", "@connection"},
+	"reset":  {"@fast", "@connection"},
+	"auth":   {"@fast", "@connection"},
+	"client": {"@slow", "@connection"},
+	"acl":    {"@slow"},
+}
+
+// 连接层的子指令，除列出的子指令外都属于 @admin 与 @dangerous
+var connSubCmds = map[string]map[string]bool{
+	"client": {"id": false, "info": false, "getname": false, "setname": false,
+		"list": true, "kill": true, "pause": true, "unpause": true, "no-evict": true},
+	"acl": {"whoami": false, "setuser": true, "getuser": true, "deluser": true,
+		"list": true, "users": true, "log": true, "load": true, "save": true},
+}
+
+// 实现 acl.Validator
+func (h *Handler) ValidCmd(name string) bool {
+	cmd, subCmd, hasSub := strings.Cut(name, "|")
+	if _, ok := connCmdCategories[cmd]; ok {
+		if !hasSub {
+			return true
+		}
+		_, ok = connSubCmds[cmd][subCmd]
+		return ok
+	}
+
+	describer, ok := h.db.(CmdDescriber)
+	if !ok {
+		return false
+	}
+	_, ok = describer.CmdCategories(cmd)
+	return ok
+}
+
+func (h *Handler) ValidCategory(category string) bool {
+	if category == "@connection" {
+		return true
+	}
+	describer, ok := h.db.(CmdDescriber)
+	if !ok {
+		return false
+	}
+	for _, c := range describer.Categories() {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// 构造权限检查的请求，未知指令返回 false，交给 db 回复错误
+func (h *Handler) aclRequest(args [][]byte) (*acl.Request, bool) {
+	req := acl.Request{Cmd: strings.ToLower(string(args[0]))}
+	if categories, ok := connCmdCategories[req.Cmd]; ok {
+		req.Categories = categories
+		if subCmds, ok := connSubCmds[req.Cmd]; ok && len(args) > 1 {
+			req.SubCmd = strings.ToLower(string(args[1]))
+			if dangerous, ok := subCmds[req.SubCmd]; !ok || dangerous {
+				req.Categories = append([]string{"@admin", "@dangerous"}, categories...)
+			}
+		}
+		return &req, true
+	}
+
+	describer, ok := h.db.(CmdDescriber)
+	if !ok {
+		return nil, false
+	}
+	if req.Categories, ok = describer.CmdCategories(req.Cmd); !ok {
+		return nil, false
+	}
+	req.Keys = describer.CmdKeys(args)
+	req.Write = h.mayWrite(args)
+	return &req, true
+}
+
+// 检查连接是否已认证以及用户是否有权限执行指令，通过时返回 nil
+func (h *Handler) authorize(client *Client, args [][]byte) Reply {
+	name := strings.ToLower(string(args[0]))
+	if noAuthCmds[name] {
+		return nil
+	}
+
+	client.mu.Lock()
+	authenticated := client.authenticated
+	client.mu.Unlock()
+	if !authenticated {
+		return NoAuthErrReply
+	}
+	return h.checkPerm(client, args, "toplevel")
+}
+
+// 检查连接的用户是否有权限执行指令. 拒绝时记录到 ACL LOG，logCtx 为 toplevel 或 lua
+func (h *Handler) checkPerm(client *Client, args [][]byte, logCtx string) Reply {
+	return h.checkKeys(client, args, nil, logCtx)
+}
+
+// 同 checkPerm，keys 不为 nil 时以 keys 代替从参数中解析出的 key
+func (h *Handler) checkKeys(client *Client, args [][]byte, keys []string, logCtx string) Reply {
+	client.mu.Lock()
+	username := client.User
+	client.mu.Unlock()
+	// 用户已被删除，连接即将被关闭
+	user, ok := h.acl.User(username)
+	if !ok {
+		return NoAuthErrReply
+	}
+
+	req, ok := h.aclRequest(args)
+	if !ok {
+		return nil
+	}
+	if keys != nil {
+		req.Keys = keys
+	}
+	if err := user.Check(req); err != nil {
+		permErr := err.(*acl.PermError)
+		h.acl.LogDenial(permErr.Reason, logCtx, permErr.Object, username, client.info(time.Now()))
+		return NewErrReply(err.Error())
+	}
+	return nil
+}
+
+// tls-auth-clients-user 为 CN 时，客户端证书的 CN 对应已启用的用户则以该用户认证.
+// server 在交给 handler 前已完成握手
+func (h *Handler) certUser(conn net.Conn) (string, bool) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !h.certAuth || !ok {
+		return "", false
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", false
+	}
+	name := certs[0].Subject.CommonName
+	user, ok := h.acl.User(name)
+	return name, ok && user.Enabled
+}
+
+var wrongPassErrReply = NewErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+
+// 以指定用户认证连接，失败时记录到 ACL LOG
+func (h *Handler) authenticate(client *Client, username, password string) bool {
+	if !h.acl.Authenticate(username, password) {
+		h.acl.LogDenial(acl.ReasonAuth, "toplevel", "AUTH", username, client.info(time.Now()))
+		return false
+	}
+	client.mu.Lock()
+	client.User, client.authenticated = username, true
+	client.mu.Unlock()
+	return true
+}
+
+// AUTH [username] password
+func (h *Handler) auth(client *Client, args [][]byte) Reply {
+	var username, password string
+	switch len(args) {
+	case 0:
+		return NewArgNumErrReply("auth")
+	case 1:
+		if h.acl.DefaultNoAuth() {
+			return NewErrReply("ERR AUTH <password> called without any password configured for the default user. " +
+				"Are you sure your configuration is correct?")
+		}
+		username, password = acl.DefaultUser, string(args[0])
+	case 2:
+		username, password = string(args[0]), string(args[1])
+	default:
+		return NewSyntaxErrReply()
+	}
+
+	if !h.authenticate(client, username, password) {
+		return wrongPassErrReply
+	}
+	return NewOKReply()
+}
+
+// ACL <subcommand> [<arg> ...]
+func (h *Handler) aclCmd(client *Client, args [][]byte) Reply {
+	if len(args) == 0 {
+		return NewArgNumErrReply("acl")
+	}
+
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	argNumErr := NewArgNumErrReply("acl|" + subCmd)
+	switch subCmd {
+	case "setuser":
+		if len(args) == 0 {
+			return argNumErr
+		}
+		rules := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			rules = append(rules, string(arg))
+		}
+		if err := h.acl.SetUser(string(args[0]), rules); err != nil {
+			return NewErrReply(err.Error())
+		}
+		return NewOKReply()
+
+	case "getuser":
+		if len(args) != 1 {
+			return argNumErr
+		}
+		user, ok := h.acl.User(string(args[0]))
+		if !ok {
+			return NewNillReply()
+		}
+		return NewMapReply([]Reply{
+			NewBulkReply([]byte("flags")), bulkArray(user.Flags()),
+			NewBulkReply([]byte("passwords")), bulkArray(user.Passwords()),
+			NewBulkReply([]byte("commands")), NewBulkReply([]byte(user.CommandsDesc())),
+			NewBulkReply([]byte("keys")), NewBulkReply([]byte(user.KeysDesc())),
+			NewBulkReply([]byte("channels")), NewBulkReply([]byte(user.ChannelsDesc())),
+			NewBulkReply([]byte("selectors")), NewArrayReply([]Reply{}),
+		})
+
+	case "deluser":
+		if len(args) == 0 {
+			return argNumErr
+		}
+		var deleted []string
+		for _, arg := range args {
+			ok, err := h.acl.DelUser(string(arg))
+			if err != nil {
+				return NewErrReply(err.Error())
+			}
+			if ok {
+				deleted = append(deleted, string(arg))
+			}
+		}
+		h.killUserClients(client, deleted)
+		return NewIntReply(int64(len(deleted)))
+
+	case "list", "users":
+		if len(args) != 0 {
+			return argNumErr
+		}
+		users := h.acl.Users()
+		lines := make([]string, 0, len(users))
+		for _, user := range users {
+			if subCmd == "list" {
+				lines = append(lines, user.String())
+			} else {
+				lines = append(lines, user.Name)
+			}
+		}
+		return bulkArray(lines)
+
+	case "whoami":
+		if len(args) != 0 {
+			return argNumErr
+		}
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return NewBulkReply([]byte(client.User))
+
+	case "log":
+		if len(args) > 1 {
+			return argNumErr
+		}
+		count := 10
+		if len(args) == 1 {
+			if strings.EqualFold(string(args[0]), "reset") {
+				h.acl.ResetLog()
+				return NewOKReply()
+			}
+			n, err := strconv.Atoi(string(args[0]))
+			if err != nil {
+				return NewErrReply("ERR value is not an integer or out of range")
+			}
+			if n < 0 {
+				return NewErrReply("ERR value is out of range, must be positive")
+			}
+			count = n
+		}
+		return aclLogReply(h.acl.LogEntries(count), time.Now())
+
+	case "load":
+		if len(args) != 0 {
+			return argNumErr
+		}
+		removed, err := h.acl.Load()
+		if err != nil {
+			return NewErrReply(err.Error())
+		}
+		h.killUserClients(client, removed)
+		return NewOKReply()
+
+	case "save":
+		if len(args) != 0 {
+			return argNumErr
+		}
+		if err := h.acl.Save(); err != nil {
+			h.logger.Errorf("[handler]acl save, err: %s", err.Error())
+			return NewErrReply("ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+		}
+		return NewOKReply()
+
+	default:
+		return NewUnknownSubCmdErrReply("acl", []byte(subCmd))
+	}
+}
+
+// 关闭以被删除的用户认证的连接
+func (h *Handler) killUserClients(self *Client, users []string) {
+	if len(users) == 0 {
+		return
+	}
+	for _, c := range h.sortedClients() {
+		c.mu.Lock()
+		username := c.User
+		c.mu.Unlock()
+		for _, user := range users {
+			if username == user {
+				h.killClient(self, c)
+				break
+			}
+		}
+	}
+}
+
+func bulkArray(strs []string) Reply {
+	replies := make([]Reply, 0, len(strs))
+	for _, str := range strs {
+		replies = append(replies, NewBulkReply([]byte(str)))
+	}
+	return NewArrayReply(replies)
+}
+
+func aclLogReply(entries []acl.LogEntry, now time.Time) Reply {
+	replies := make([]Reply, 0, len(entries))
+	for _, e := range entries {
+		replies = append(replies, NewMapReply([]Reply{
+			NewBulkReply([]byte("count")), NewIntReply(int64(e.Count)),
+			NewBulkReply([]byte("reason")), NewBulkReply([]byte(e.Reason)),
+			NewBulkReply([]byte("context")), NewBulkReply([]byte(e.Context)),
+			NewBulkReply([]byte("object")), NewBulkReply([]byte(e.Object)),
+			NewBulkReply([]byte("username")), NewBulkReply([]byte(e.Username)),
+			NewBulkReply([]byte("age-seconds")), NewDoubleReply(now.Sub(e.Created).Seconds()),
+			NewBulkReply([]byte("client-info")), NewBulkReply([]byte(e.ClientInfo)),
+			NewBulkReply([]byte("entry-id")), NewIntReply(e.EntryID),
+			NewBulkReply([]byte("timestamp-created")), NewIntReply(e.Created.UnixMilli()),
+			NewBulkReply([]byte("timestamp-last-updated")), NewIntReply(e.Updated.UnixMilli()),
+		}))
+	}
+	return NewArrayReply(replies)
+}
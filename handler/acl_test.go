@@ -0,0 +1,240 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"goredis/handler"
+	"goredis/log"
+	"goredis/protocol"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type aclThinker struct {
+	pass, file string
+	certUser   string
+}
+
+func (a aclThinker) ClientOutputBufferLimit() string {
+	return ""
+}
+
+func (a aclThinker) RequirePass() string {
+	return a.pass
+}
+
+func (a aclThinker) ACLFile() string {
+	return a.file
+}
+
+func (a aclThinker) ACLLogMaxLen() int {
+	return 0
+}
+
+func (a aclThinker) TLSAuthClientsUser() string {
+	return a.certUser
+}
+
+// 读取一个数组回复中的 bulk 字符串
+func (c *testConn) readArray() []string {
+	line := c.read()
+	if !assert.True(c.t, strings.HasPrefix(line, "*"), line) {
+		return nil
+	}
+	size, _ := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	items := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		items = append(items, c.read())
+	}
+	return items
+}
+
+func Test_auth(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	h, err := handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, aclThinker{pass: "secret"})
+	assert.NoError(t, err)
+
+	c := dial(t, h)
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", c.do("PING"))
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", c.do("get"))
+	assert.Equal(t, "-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> "+
+		"option can be used to authenticate the client and select the RESP protocol version at the same time\r\n", c.do("HELLO 2"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.\r\n", c.do("AUTH wrong"))
+	assert.Equal(t, "-ERR syntax error\r\n", c.do("AUTH a b c"))
+	assert.Equal(t, "+OK\r\n", c.do("AUTH secret"))
+	assert.Equal(t, "+PONG\r\n", c.do("PING"))
+
+	// RESET 后需要重新认证
+	assert.Equal(t, "+RESET\r\n", c.do("RESET"))
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", c.do("PING"))
+	assert.Equal(t, "*14\r\n", c.do("HELLO 2 AUTH default secret"))
+	for i := 0; i < 14; i++ {
+		_ = c.read()
+	}
+	assert.Equal(t, "default", c.do("ACL WHOAMI"))
+
+	// 认证失败记录到 ACL LOG
+	c.send("ACL LOG 1")
+	assert.Equal(t, "*1\r\n", c.read())
+	assert.Equal(t, "*20\r\n", c.read())
+	entry := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		key := c.read()
+		entry[key] = strings.TrimSuffix(c.read(), "\r\n")
+	}
+	assert.Equal(t, ":1", entry["count"])
+	assert.Equal(t, "auth", entry["reason"])
+	assert.Equal(t, "toplevel", entry["context"])
+	assert.Equal(t, "AUTH", entry["object"])
+	assert.Equal(t, "default", entry["username"])
+	assert.Contains(t, entry["client-info"], "cmd=auth user=default")
+	assert.Equal(t, "+OK\r\n", c.do("ACL LOG RESET"))
+	assert.Equal(t, "*0\r\n", c.do("ACL LOG"))
+	assert.Equal(t, "-ERR value is out of range, must be positive\r\n", c.do("ACL LOG -1"))
+}
+
+func Test_acl_cmds(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	file := filepath.Join(t.TempDir(), "users.acl")
+	h, err := handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, aclThinker{file: file})
+	assert.NoError(t, err)
+
+	admin := dial(t, h)
+	assert.Equal(t, "+OK\r\n", admin.do("ACL SETUSER alice on >p1 +@connection -client|kill"))
+	assert.Equal(t, "-ERR Error in ACL SETUSER modifier '+get': Unknown command or category name in ACL\r\n",
+		admin.do("ACL SETUSER alice +get"))
+	assert.Equal(t, "-ERR unknown subcommand 'foo'. Try ACL HELP.\r\n", admin.do("ACL FOO"))
+	assert.Equal(t, "-ERR wrong number of arguments for 'acl|getuser' command\r\n", admin.do("ACL GETUSER"))
+	assert.Equal(t, "$-1\r\n", admin.do("ACL GETUSER nobody"))
+
+	admin.send("ACL GETUSER alice")
+	assert.Equal(t, "*12\r\n", admin.read())
+	assert.Equal(t, "flags", admin.read())
+	assert.Equal(t, []string{"on"}, admin.readArray())
+	assert.Equal(t, "passwords", admin.read())
+	assert.Len(t, admin.readArray(), 1)
+	assert.Equal(t, "commands", admin.read())
+	assert.Equal(t, "-@all +@connection -client|kill", admin.read())
+	assert.Equal(t, "keys", admin.read())
+	assert.Equal(t, "", admin.read())
+	assert.Equal(t, "channels", admin.read())
+	assert.Equal(t, "", admin.read())
+	assert.Equal(t, "selectors", admin.read())
+	assert.Equal(t, "*0\r\n", admin.read())
+
+	assert.Equal(t, []string{"alice", "default"}, admin.readArrayOf("ACL USERS"))
+	list := admin.readArrayOf("ACL LIST")
+	assert.Len(t, list, 2)
+	assert.Regexp(t, "^user alice on #[0-9a-f]{64} resetchannels -@all \\+@connection -client\\|kill$", list[0])
+	assert.Equal(t, "user default on nopass ~* &* +@all", list[1])
+
+	alice := dial(t, h)
+	assert.Equal(t, "+OK\r\n", alice.do("AUTH alice p1"))
+	assert.Equal(t, "+PONG\r\n", alice.do("PING"))
+	assert.Regexp(t, "^:[0-9]+\r\n$", alice.do("CLIENT ID"))
+	assert.Equal(t, "-NOPERM User alice has no permissions to run the 'client|kill' command\r\n", alice.do("CLIENT KILL ID 1"))
+	assert.Equal(t, "-NOPERM User alice has no permissions to run the 'acl|whoami' command\r\n", alice.do("ACL WHOAMI"))
+	// 没有实现 CmdDescriber 的 db 不检查权限
+	assert.Equal(t, "*1\r\n", alice.do("get"))
+
+	// 保存后删除用户，再从文件中恢复
+	assert.Equal(t, "+OK\r\n", admin.do("ACL SAVE"))
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "user alice on #")
+	assert.Equal(t, "-ERR The 'default' user cannot be removed\r\n", admin.do("ACL DELUSER default"))
+	assert.Equal(t, ":1\r\n", admin.do("ACL DELUSER alice nobody"))
+	// 以被删除的用户认证的连接被关闭. 先读完 get 回复的剩余部分
+	_, _ = alice.reader.ReadString('\n')
+	_, _ = alice.reader.ReadString('\n')
+	_, err = alice.reader.ReadByte()
+	assert.Error(t, err)
+
+	assert.Equal(t, "+OK\r\n", admin.do("ACL LOAD"))
+	assert.Equal(t, []string{"alice", "default"}, admin.readArrayOf("ACL USERS"))
+
+	assert.NoError(t, os.WriteFile(file, []byte("user bob on nopass +@all\n"), 0644))
+	assert.Equal(t, "+OK\r\n", admin.do("ACL LOAD"))
+	assert.Equal(t, []string{"bob", "default"}, admin.readArrayOf("ACL USERS"))
+}
+
+func (c *testConn) readArrayOf(req string) []string {
+	c.send(req)
+	return c.readArray()
+}
+
+// 自签名证书
+func selfSignedCert(t *testing.T, cn string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// 与 server 一致，握手完成后再交给 handler
+func dialTLS(t *testing.T, h interface {
+	Handle(ctx context.Context, conn net.Conn)
+}, cn string) *testConn {
+	server, client := net.Pipe()
+	serverConn := tls.Server(server, &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t, "server")},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	go func() {
+		if serverConn.Handshake() == nil {
+			h.Handle(context.Background(), serverConn)
+		}
+	}()
+
+	clientConn := tls.Client(client, &tls.Config{
+		Certificates:       []tls.Certificate{selfSignedCert(t, cn)},
+		InsecureSkipVerify: true,
+	})
+	assert.NoError(t, clientConn.Handshake())
+	return &testConn{t: t, conn: clientConn, reader: bufio.NewReader(clientConn)}
+}
+
+func Test_tls_cert_user(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	_, err := handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, aclThinker{certUser: "email"})
+	assert.EqualError(t, err, "invalid tls-auth-clients-user: email")
+
+	h, err := handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, aclThinker{pass: "secret", certUser: "CN"})
+	assert.NoError(t, err)
+	admin := dial(t, h)
+	assert.Equal(t, "+OK\r\n", admin.do("AUTH secret"))
+	assert.Equal(t, "+OK\r\n", admin.do("ACL SETUSER alice on +@all"))
+	assert.Equal(t, "+OK\r\n", admin.do("ACL SETUSER bob off +@all"))
+
+	// CN 对应已启用的用户时自动认证
+	c := dialTLS(t, h, "alice")
+	assert.Equal(t, "alice", c.do("ACL WHOAMI"))
+
+	// 用户不存在或已禁用时仍需认证
+	c = dialTLS(t, h, "bob")
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", c.do("PING"))
+	c = dialTLS(t, h, "nobody")
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", c.do("PING"))
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"context"
+	"goredis/acl"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 连接级别的状态. 字段只在连接的处理协程中修改，修改时以及 CLIENT LIST 等其他协程读取时持有 mu
+type Client struct {
+	mu    sync.Mutex
+	ID    int64
+	Proto int
+	Name  string
+	// 客户端类别，决定输出缓冲区限制
+	Class int
+	// 当前认证的用户
+	User string
+	// 是否已通过认证，未认证时只能执行 AUTH、HELLO 等指令
+	authenticated bool
+	// 对端地址与本地地址
+	Addr, LAddr string
+	// CLIENT NO-EVICT
+	NoEvict bool
+
+	createdAt       time.Time
+	lastInteraction time.Time
+	// 最近执行的指令，子指令记为 client|list 的形式
+	lastCmd string
+	// 读缓冲区中尚未处理的字节数
+	qbuf   int
+	output *outputBuffer
+
+	conn   io.Closer
+	cancel context.CancelFunc
+	killed bool
+	// 回复当前指令后关闭连接，只在连接的处理协程中访问
+	closeAfterReply bool
+}
+
+func newClient(id int64) *Client {
+	now := time.Now()
+	return &Client{
+		ID:              id,
+		Proto:           Resp2,
+		Class:           ClientNormal,
+		User:            acl.DefaultUser,
+		authenticated:   true,
+		createdAt:       now,
+		lastInteraction: now,
+	}
+}
+
+// 记录正在执行的指令
+func (c *Client) beginCmd(args [][]byte, qbuf int) {
+	name := strings.ToLower(string(args[0]))
+	if (name == "client" || name == "acl") && len(args) > 1 {
+		name += "|" + strings.ToLower(string(args[1]))
+	}
+
+	c.mu.Lock()
+	c.lastCmd, c.qbuf, c.lastInteraction = name, qbuf, time.Now()
+	c.mu.Unlock()
+}
+
+// 关闭连接. 可以在任意协程中调用
+func (c *Client) kill() {
+	c.mu.Lock()
+	conn, cancel := c.conn, c.cancel
+	c.killed = true
+	c.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
+
+// 需要在连接层处理的指令，不进入 db
+type clientCmdHandler func(client *Client, args [][]byte) Reply
+
+func (h *Handler) clientCmdHandler(cmd []byte) (clientCmdHandler, bool) {
+	switch strings.ToLower(string(cmd)) {
+	case "hello":
+		return h.hello, true
+	case "ping":
+		return h.ping, true
+	case "echo":
+		return h.echo, true
+	case "quit":
+		return h.quit, true
+	case "reset":
+		return h.reset, true
+	case "client":
+		return h.client, true
+	case "auth":
+		return h.auth, true
+	case "acl":
+		return h.aclCmd, true
+	default:
+		return nil, false
+	}
+}
+
+// 连接名不能包含空格和换行等特殊字符
+func validClientName(name string) bool {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// PING [message]. 供健康检查使用，不经过 db
+func (h *Handler) ping(client *Client, args [][]byte) Reply {
+	switch len(args) {
+	case 0:
+		return NewSimpleStringReply("PONG")
+	case 1:
+		return NewBulkReply(args[0])
+	default:
+		return NewArgNumErrReply("ping")
+	}
+}
+
+// ECHO message
+func (h *Handler) echo(client *Client, args [][]byte) Reply {
+	if len(args) != 1 {
+		return NewArgNumErrReply("echo")
+	}
+	return NewBulkReply(args[0])
+}
+
+// QUIT. 回复 OK 后关闭连接
+func (h *Handler) quit(client *Client, args [][]byte) Reply {
+	client.closeAfterReply = true
+	return NewOKReply()
+}
+
+// RESET. 将连接恢复为新建时的状态
+func (h *Handler) reset(client *Client, args [][]byte) Reply {
+	if len(args) != 0 {
+		return NewArgNumErrReply("reset")
+	}
+
+	client.mu.Lock()
+	client.Proto, client.Name, client.NoEvict = Resp2, "", false
+	client.User, client.authenticated = acl.DefaultUser, h.acl.DefaultNoAuth()
+	if client.Class == ClientPubSub {
+		client.Class = ClientNormal
+	}
+	client.mu.Unlock()
+	return NewSimpleStringReply("RESET")
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (h *Handler) hello(client *Client, args [][]byte) Reply {
+	proto := client.Proto
+	if len(args) > 0 {
+		ver, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return NewErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != Resp2 && ver != Resp3 {
+			return NewErrReply("NOPROTO unsupported protocol version")
+		}
+		proto = int(ver)
+	}
+
+	name := client.Name
+	var username, password string
+	var auth bool
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "auth":
+			if i+2 >= len(args) {
+				return NewErrReply("ERR Syntax error in HELLO option 'auth'")
+			}
+			username, password, auth = string(args[i+1]), string(args[i+2]), true
+			i += 2
+		case "setname":
+			if i+1 >= len(args) {
+				return NewErrReply("ERR Syntax error in HELLO option 'setname'")
+			}
+			if name = string(args[i+1]); !validClientName(name) {
+				return NewErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+		default:
+			return NewErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+
+	// 认证失败时不修改协议版本与连接名
+	if auth {
+		if !h.authenticate(client, username, password) {
+			return wrongPassErrReply
+		}
+	} else {
+		client.mu.Lock()
+		authenticated := client.authenticated
+		client.mu.Unlock()
+		if !authenticated {
+			return NewErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+				"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate " +
+				"the client and select the RESP protocol version at the same time")
+		}
+	}
+
+	client.mu.Lock()
+	client.Proto, client.Name = proto, name
+	client.mu.Unlock()
+	return NewMapReply([]Reply{
+		NewBulkReply([]byte("server")), NewBulkReply([]byte("redis")),
+		NewBulkReply([]byte("version")), NewBulkReply([]byte("7.2.0")),
+		NewBulkReply([]byte("proto")), NewIntReply(int64(proto)),
+		NewBulkReply([]byte("id")), NewIntReply(client.ID),
+		NewBulkReply([]byte("mode")), NewBulkReply([]byte("standalone")),
+		NewBulkReply([]byte("role")), NewBulkReply([]byte("master")),
+		NewBulkReply([]byte("modules")), NewArrayReply([]Reply{}),
+	})
+}
@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 客户端信息，格式与 redis CLIENT LIST 一致
+func (c *Client) info(now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var flags string
+	switch c.Class {
+	case ClientReplica:
+		flags += "S"
+	case ClientPubSub:
+		flags += "P"
+	}
+	if c.NoEvict {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	var omem int
+	if c.output != nil {
+		omem = c.output.Pending()
+	}
+
+	var b strings.Builder
+	b.WriteString("id=" + strconv.FormatInt(c.ID, 10))
+	b.WriteString(" addr=" + c.Addr)
+	b.WriteString(" laddr=" + c.LAddr)
+	b.WriteString(" name=" + c.Name)
+	b.WriteString(" age=" + strconv.FormatInt(int64(now.Sub(c.createdAt)/time.Second), 10))
+	b.WriteString(" idle=" + strconv.FormatInt(int64(now.Sub(c.lastInteraction)/time.Second), 10))
+	b.WriteString(" flags=" + flags)
+	b.WriteString(" db=0 sub=0 psub=0 multi=-1")
+	b.WriteString(" qbuf=" + strconv.Itoa(c.qbuf))
+	b.WriteString(" omem=" + strconv.Itoa(omem))
+	b.WriteString(" tot-mem=" + strconv.Itoa(c.qbuf+omem))
+	b.WriteString(" cmd=" + c.lastCmd)
+	b.WriteString(" user=" + c.User)
+	b.WriteString(" redir=-1 resp=" + strconv.Itoa(c.Proto))
+	return b.String()
+}
+
+// 按 id 排序的客户端快照
+func (h *Handler) sortedClients() []*Client {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+	return clients
+}
+
+// CLIENT LIST 与 CLIENT KILL 中 TYPE 过滤的额外取值
+const (
+	// 不按类别过滤
+	clientClassAny = -2
+	// 本实例作为从节点时的主节点连接，当前没有这类连接
+	clientClassMaster = -1
+)
+
+func parseClientClass(name string) (int, bool) {
+	if strings.EqualFold(name, "master") {
+		return clientClassMaster, true
+	}
+	if strings.EqualFold(name, "slave") {
+		return ClientReplica, true
+	}
+	for class, className := range clientClassNames {
+		if strings.EqualFold(name, className) {
+			return class, true
+		}
+	}
+	return 0, false
+}
+
+// CLIENT <subcommand> [<arg> ...]
+func (h *Handler) client(client *Client, args [][]byte) Reply {
+	if len(args) == 0 {
+		return NewArgNumErrReply("client")
+	}
+
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	argNumErr := NewArgNumErrReply("client|" + subCmd)
+	switch subCmd {
+	case "id":
+		if len(args) != 0 {
+			return argNumErr
+		}
+		return NewIntReply(client.ID)
+
+	case "setname":
+		if len(args) != 1 {
+			return argNumErr
+		}
+		name := string(args[0])
+		if !validClientName(name) {
+			return NewErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		client.mu.Lock()
+		client.Name = name
+		client.mu.Unlock()
+		return NewOKReply()
+
+	case "getname":
+		if len(args) != 0 {
+			return argNumErr
+		}
+		if client.Name == "" {
+			return NewNillReply()
+		}
+		return NewBulkReply([]byte(client.Name))
+
+	case "info":
+		if len(args) != 0 {
+			return argNumErr
+		}
+		return NewBulkReply([]byte(client.info(time.Now()) + "\n"))
+
+	case "list":
+		return h.clientList(args)
+
+	case "kill":
+		if len(args) == 0 {
+			return argNumErr
+		}
+		return h.clientKill(client, args)
+
+	case "pause":
+		if len(args) != 1 && len(args) != 2 {
+			return argNumErr
+		}
+		timeout, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil || timeout < 0 {
+			return NewErrReply("ERR timeout is not an integer or out of range")
+		}
+		all := true
+		if len(args) == 2 {
+			switch strings.ToLower(string(args[1])) {
+			case "write":
+				all = false
+			case "all":
+			default:
+				return NewSyntaxErrReply()
+			}
+		}
+		h.pause.pause(time.Now().Add(time.Duration(timeout)*time.Millisecond), all)
+		return NewOKReply()
+
+	case "unpause":
+		if len(args) != 0 {
+			return argNumErr
+		}
+		h.pause.unpause()
+		return NewOKReply()
+
+	case "no-evict":
+		if len(args) != 1 {
+			return argNumErr
+		}
+		var noEvict bool
+		switch strings.ToLower(string(args[0])) {
+		case "on":
+			noEvict = true
+		case "off":
+		default:
+			return NewSyntaxErrReply()
+		}
+		client.mu.Lock()
+		client.NoEvict = noEvict
+		client.mu.Unlock()
+		return NewOKReply()
+
+	default:
+		return NewUnknownSubCmdErrReply("client", []byte(subCmd))
+	}
+}
+
+// CLIENT LIST [TYPE <NORMAL|MASTER|REPLICA|PUBSUB>] [ID client-id [client-id ...]]
+func (h *Handler) clientList(args [][]byte) Reply {
+	class := clientClassAny
+	var ids map[int64]struct{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(string(args[i]), "type") && i+1 < len(args):
+			var ok bool
+			if class, ok = parseClientClass(string(args[i+1])); !ok {
+				return NewErrReply("ERR Unknown client type '" + string(args[i+1]) + "'")
+			}
+			i++
+		case strings.EqualFold(string(args[i]), "id") && i+1 < len(args):
+			ids = make(map[int64]struct{})
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(string(args[i]), 10, 64)
+				if err != nil || id <= 0 {
+					return NewErrReply("ERR Invalid client ID")
+				}
+				ids[id] = struct{}{}
+			}
+		default:
+			return NewSyntaxErrReply()
+		}
+	}
+
+	var b strings.Builder
+	now := time.Now()
+	for _, c := range h.sortedClients() {
+		if class != clientClassAny && c.Class != class {
+			continue
+		}
+		if _, ok := ids[c.ID]; ids != nil && !ok {
+			continue
+		}
+		b.WriteString(c.info(now))
+		b.WriteByte('\n')
+	}
+	return NewBulkReply([]byte(b.String()))
+}
+
+// CLIENT KILL ip:port 或 CLIENT KILL <filter> <value> ...
+func (h *Handler) clientKill(client *Client, args [][]byte) Reply {
+	// 旧格式只按地址关闭一个连接
+	if len(args) == 1 {
+		for _, c := range h.sortedClients() {
+			if c.Addr == string(args[0]) {
+				h.killClient(client, c)
+				return NewOKReply()
+			}
+		}
+		return NewErrReply("ERR No such client")
+	}
+	if len(args)%2 != 0 {
+		return NewSyntaxErrReply()
+	}
+
+	var (
+		id         int64
+		class      = clientClassAny
+		user, addr string
+		laddr      string
+		maxAge     int64
+		skipMe     = true
+	)
+	for i := 0; i < len(args); i += 2 {
+		opt, value := strings.ToLower(string(args[i])), string(args[i+1])
+		switch opt {
+		case "id":
+			var err error
+			if id, err = strconv.ParseInt(value, 10, 64); err != nil || id <= 0 {
+				return NewErrReply("ERR client-id should be greater than 0")
+			}
+		case "type":
+			var ok bool
+			if class, ok = parseClientClass(value); !ok {
+				return NewErrReply("ERR Unknown client type '" + value + "'")
+			}
+		case "user":
+			user = value
+		case "addr":
+			addr = value
+		case "laddr":
+			laddr = value
+		case "maxage":
+			var err error
+			if maxAge, err = strconv.ParseInt(value, 10, 64); err != nil || maxAge <= 0 {
+				return NewSyntaxErrReply()
+			}
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return NewSyntaxErrReply()
+			}
+		default:
+			return NewSyntaxErrReply()
+		}
+	}
+
+	var killed int64
+	now := time.Now()
+	for _, c := range h.sortedClients() {
+		// 已关闭但尚未退出的连接不再计数
+		c.mu.Lock()
+		match := !c.killed &&
+			(id == 0 || c.ID == id) &&
+			(class == clientClassAny || c.Class == class) &&
+			(user == "" || c.User == user) &&
+			(addr == "" || c.Addr == addr) &&
+			(laddr == "" || c.LAddr == laddr) &&
+			(maxAge == 0 || now.Sub(c.createdAt) >= time.Duration(maxAge)*time.Second)
+		c.mu.Unlock()
+		if !match || (skipMe && c == client) {
+			continue
+		}
+		h.killClient(client, c)
+		killed++
+	}
+	return NewIntReply(killed)
+}
+
+// 关闭当前连接时需要先写出回复
+func (h *Handler) killClient(self, target *Client) {
+	if target == self {
+		self.closeAfterReply = true
+		return
+	}
+	target.kill()
+}
@@ -0,0 +1,168 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"goredis/handler"
+	"goredis/log"
+	"goredis/protocol"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, h interface {
+	Handle(ctx context.Context, conn net.Conn)
+}) *testConn {
+	server, client := net.Pipe()
+	go h.Handle(context.Background(), server)
+	return &testConn{t: t, conn: client, reader: bufio.NewReader(client)}
+}
+
+func (c *testConn) send(req string) {
+	_ = c.conn.SetDeadline(time.Now().Add(time.Second))
+	_, err := c.conn.Write([]byte(req + "\r\n"))
+	assert.NoError(c.t, err)
+}
+
+// 读取一个回复. 只支持简单类型与 bulk 字符串，bulk 字符串只返回内容
+func (c *testConn) read() string {
+	line, err := c.reader.ReadString('\n')
+	if !assert.NoError(c.t, err) {
+		return ""
+	}
+	if line[0] != '$' || line == "$-1\r\n" {
+		return line
+	}
+	n, _ := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	body := make([]byte, n+2)
+	_, err = io.ReadFull(c.reader, body)
+	assert.NoError(c.t, err)
+	return string(body[:n])
+}
+
+func (c *testConn) do(req string) string {
+	c.send(req)
+	return c.read()
+}
+
+func Test_connection_cmds(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	h, _ := handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, nil)
+
+	c := dial(t, h)
+	assert.Equal(t, "hi", c.do("ECHO hi"))
+	assert.Equal(t, "-ERR wrong number of arguments for 'echo' command\r\n", c.do("ECHO"))
+
+	assert.Equal(t, "$-1\r\n", c.do("CLIENT GETNAME"))
+	assert.Equal(t, "+OK\r\n", c.do("CLIENT SETNAME conn1"))
+	assert.Equal(t, "conn1", c.do("client getname"))
+	assert.Equal(t, "-ERR Client names cannot contain spaces, newlines or special characters.\r\n", c.do("CLIENT SETNAME 'a b'"))
+	assert.Equal(t, "+OK\r\n", c.do("CLIENT NO-EVICT on"))
+	assert.Equal(t, "-ERR unknown subcommand 'foo'. Try CLIENT HELP.\r\n", c.do("CLIENT FOO"))
+	assert.Equal(t, "-ERR wrong number of arguments for 'client|id' command\r\n", c.do("CLIENT ID 1"))
+
+	id := strings.TrimSuffix(strings.TrimPrefix(c.do("CLIENT ID"), ":"), "\r\n")
+	info := c.do("CLIENT INFO")
+	assert.Regexp(t, regexp.MustCompile(`^id=`+id+` addr=pipe laddr=pipe name=conn1 age=0 idle=0 flags=e db=0 .* cmd=client\|info user=default redir=-1 resp=2\n$`), info)
+
+	// RESET 恢复连接的初始状态
+	assert.Equal(t, "+RESET\r\n", c.do("RESET"))
+	assert.Equal(t, "$-1\r\n", c.do("CLIENT GETNAME"))
+	assert.Contains(t, c.do("CLIENT INFO"), " flags=N ")
+
+	// QUIT 回复后关闭连接
+	assert.Equal(t, "+OK\r\n", c.do("QUIT"))
+	_, err := c.reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func Test_client_list_and_kill(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	h, _ := handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, nil)
+
+	c1, c2, c3 := dial(t, h), dial(t, h), dial(t, h)
+	id1 := strings.TrimSuffix(c1.do("CLIENT ID")[1:], "\r\n")
+	id2 := strings.TrimSuffix(c2.do("CLIENT ID")[1:], "\r\n")
+	id3 := strings.TrimSuffix(c3.do("CLIENT ID")[1:], "\r\n")
+
+	list := c1.do("CLIENT LIST")
+	assert.Len(t, strings.Split(strings.TrimSuffix(list, "\n"), "\n"), 3)
+	// 按 id 排序
+	assert.Regexp(t, "^id=1 .*\nid=2 .*\nid=3 .*\n$", list)
+
+	list = c1.do("CLIENT LIST ID " + id2 + " " + id3)
+	assert.Len(t, strings.Split(strings.TrimSuffix(list, "\n"), "\n"), 2)
+	assert.Contains(t, list, "id="+id2+" ")
+	assert.Contains(t, list, "id="+id3+" ")
+	assert.NotContains(t, list, "id="+id1+" ")
+	assert.Equal(t, "", c1.do("CLIENT LIST TYPE replica"))
+	assert.Equal(t, "-ERR Unknown client type 'foo'\r\n", c1.do("CLIENT LIST TYPE foo"))
+	assert.Equal(t, "-ERR Invalid client ID\r\n", c1.do("CLIENT LIST ID x"))
+
+	assert.Equal(t, ":1\r\n", c1.do("CLIENT KILL ID "+id2))
+	_, err := c2.reader.ReadByte()
+	assert.Error(t, err)
+	assert.Equal(t, "-ERR No such client\r\n", c1.do("CLIENT KILL 127.0.0.1:1"))
+	assert.Equal(t, "-ERR client-id should be greater than 0\r\n", c1.do("CLIENT KILL ID 0"))
+
+	// 默认跳过当前连接
+	assert.Equal(t, ":1\r\n", c1.do("CLIENT KILL USER default"))
+	_, err = c3.reader.ReadByte()
+	assert.Error(t, err)
+	assert.Equal(t, ":1\r\n", c1.do("CLIENT KILL USER default SKIPME no"))
+	_, err = c1.reader.ReadByte()
+	assert.Error(t, err)
+}
+
+// 将 set 视为写指令
+type writeCheckDB struct {
+	echoDB
+}
+
+func (w *writeCheckDB) MayWrite(cmdLine [][]byte) bool {
+	return strings.EqualFold(string(cmdLine[0]), "set")
+}
+
+func Test_client_pause(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	h, _ := handler.NewHandler(&writeCheckDB{}, nil, protocol.NewParser(logger, nil), logger, nil)
+
+	c1, c2 := dial(t, h), dial(t, h)
+	assert.Equal(t, "+OK\r\n", c1.do("CLIENT PAUSE 100000 WRITE"))
+	assert.Equal(t, "-ERR timeout is not an integer or out of range\r\n", c1.do("CLIENT PAUSE -1"))
+	assert.Equal(t, "-ERR syntax error\r\n", c1.do("CLIENT PAUSE 10 FOO"))
+
+	// 读指令不受影响，写指令等待解除暂停
+	assert.Equal(t, "*1\r\n", c2.do("get"))
+	_, _ = c2.reader.ReadString('\n')
+	_, _ = c2.reader.ReadString('\n')
+	c2.send("set")
+	_ = c2.conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := c2.reader.ReadByte()
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	_ = c2.conn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.Equal(t, "+OK\r\n", c1.do("CLIENT UNPAUSE"))
+	assert.Equal(t, "*1\r\n", c2.read())
+	_, _ = c2.reader.ReadString('\n')
+	_, _ = c2.reader.ReadString('\n')
+
+	// 超时后自动解除
+	start := time.Now()
+	assert.Equal(t, "+OK\r\n", c1.do("CLIENT PAUSE 100"))
+	assert.Equal(t, "*1\r\n", c2.do("get"))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
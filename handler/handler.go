@@ -1,20 +1,36 @@
 package handler
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"goredis/acl"
+	"goredis/lib"
+	"goredis/lib/pool"
 	"goredis/log"
 	"goredis/server"
 	"io"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
 
 type Handler struct {
 	sync.Once
-	mu     sync.RWMutex
-	conns  map[net.Conn]struct{}
-	closed atomic.Bool
+	mu      sync.RWMutex
+	clients map[int64]*Client
+	closed  atomic.Bool
+	pause   pauseState
+	// 为每个连接分配递增的 id
+	clientID atomic.Int64
+	// 各类客户端的输出缓冲区限制
+	outputLimits outputLimits
+	// 用户与权限
+	acl *acl.ACL
+	// 以 TLS 客户端证书的 CN 作为用户名认证
+	certAuth bool
 
 	db        DB
 	parser    Parser
@@ -22,13 +38,47 @@ type Handler struct {
 	logger    log.Logger
 }
 
-func NewHandler(db DB, persister Persister, parser Parser, logger log.Logger) (server.Handler, error) {
+type Thinker interface {
+	acl.Thinker
+	ClientOutputBufferLimit() string
+	// CN 或 off
+	TLSAuthClientsUser() string
+}
+
+func NewHandler(db DB, persister Persister, parser Parser, logger log.Logger, thinker Thinker) (server.Handler, error) {
 	h := Handler{
-		conns:     make(map[net.Conn]struct{}),
-		persister: persister,
-		logger:    logger,
-		db:        db,
-		parser:    parser,
+		clients:      make(map[int64]*Client),
+		outputLimits: defaultOutputLimits,
+		persister:    persister,
+		logger:       logger,
+		db:           db,
+		parser:       parser,
+	}
+
+	if thinker != nil {
+		limits, err := parseOutputLimits(thinker.ClientOutputBufferLimit())
+		if err != nil {
+			return nil, err
+		}
+		h.outputLimits = limits
+
+		switch strings.ToLower(thinker.TLSAuthClientsUser()) {
+		case "cn":
+			h.certAuth = true
+		case "off", "":
+		default:
+			return nil, fmt.Errorf("invalid tls-auth-clients-user: %s", thinker.TLSAuthClientsUser())
+		}
+	}
+
+	// 未配置时 default 用户无需密码并拥有全部权限
+	var aclThinker acl.Thinker
+	if thinker != nil {
+		aclThinker = thinker
+	}
+	var err error
+	if h.acl, err = acl.New(aclThinker, &h); err != nil {
+		return nil, err
 	}
 
 	return &h, nil
@@ -43,7 +93,7 @@ func (h *Handler) Start() error {
 	defer reloader.Close()
 
 	// 读取持久化文件内容，还原内存数据库
-	h.handle(SetLoadingPattern(context.Background()), newFakeReaderWriter(reloader))
+	h.handle(SetLoadingPattern(context.Background()), newFakeReaderWriter(reloader), newClient(h.clientID.Add(1)))
 	return nil
 }
 
@@ -54,73 +104,193 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 		return
 	}
 
-	h.conns[conn] = struct{}{}
+	client := newClient(h.clientID.Add(1))
+	client.Addr, client.LAddr, client.conn = conn.RemoteAddr().String(), conn.LocalAddr().String(), conn
+	client.authenticated = h.acl.DefaultNoAuth()
+	if user, ok := h.certUser(conn); ok {
+		client.User, client.authenticated = user, true
+	}
+	h.clients[client.ID] = client
 	h.mu.Unlock()
 
-	h.handle(ctx, conn)
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, client.ID)
+		h.mu.Unlock()
+		_ = conn.Close()
+	}()
+	h.handle(ctx, conn, client)
 }
 
-func (h *Handler) handle(ctx context.Context, conn io.ReadWriter) {
-	// 借助 protocol parser 将到来的指令转而通过 stream channel 输出
-	stream := h.parser.ParseStream(conn)
+func (h *Handler) handle(ctx context.Context, conn io.ReadWriter, client *Client) {
+	// 连接读取出错或被 CLIENT KILL 时取消 connCtx，使阻塞中的指令及时返回
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// 脚本中执行的指令以及执行时才确定的 key 以连接的用户检查权限
+	if !IsLoadingPattern(ctx) {
+		connCtx = SetScriptAuthorizer(connCtx, func(args [][]byte) Reply {
+			return h.checkPerm(client, args, "lua")
+		})
+		connCtx = SetKeyAuthorizer(connCtx, func(args [][]byte, keys []string) Reply {
+			return h.checkKeys(client, args, keys, "toplevel")
+		})
+	}
+
+	// 在当前协程中同步读取请求，回复写入缓冲区，流水线中的请求处理完后统一 flush 到输出缓冲区，
+	// 再由输出缓冲区的写协程写入连接
+	reader := h.parser.NewReader(&cancelReader{Reader: conn, cancel: cancel})
+	output := newOutputBuffer(conn)
+	defer output.Close()
+	client.mu.Lock()
+	client.output, client.cancel = output, cancel
+	client.mu.Unlock()
+	pool.Submit(output.run)
+	writer := acquireWriter(output)
+	defer releaseWriter(writer)
+
+	// 执行指令期间由 watcher 协程探测连接是否断开. 探测期间当前协程不能访问 reader
+	peekCh, readyCh := make(chan struct{}), make(chan error, 1)
+	defer close(peekCh)
+	pool.Submit(func() {
+		defer reader.Release()
+		for range peekCh {
+			readyCh <- reader.Peek()
+		}
+	})
+
 	for {
+		droplet := reader.ReadDroplet()
+		if droplet.Terminated() {
+			var protocolErr *ProtocolErr
+			if errors.As(droplet.Err, &protocolErr) {
+				WriteReply(writer, droplet.Reply, client.Proto)
+			}
+			// 关闭连接前写完已有的回复
+			if writer.Flush() == nil {
+				_ = output.Drain()
+			}
+			h.logger.Errorf("[handler]conn terminated, err: %s", droplet.Err.Error())
+			return
+		}
+
+		qbuf := reader.Buffered()
+		idle := qbuf == 0
+		if idle {
+			peekCh <- struct{}{}
+		}
+
+		h.handleDroplet(connCtx, writer, client, droplet, qbuf)
+		if client.closeAfterReply {
+			if writer.Flush() == nil {
+				_ = output.Drain()
+			}
+			return
+		}
+		if output.overLimit(h.outputLimits[client.Class], writer.Buffered(), lib.TimeNow()) {
+			h.logger.Errorf("[handler]client id=%d closed for overcoming of output buffer limits, class: %s",
+				client.ID, clientClassNames[client.Class])
+			return
+		}
+		if !idle {
+			continue
+		}
+
+		if err := writer.Flush(); err != nil {
+			h.logger.Errorf("[handler]conn write, err: %s", err.Error())
+			return
+		}
 		select {
 		case <-ctx.Done():
 			h.logger.Warnf("[handler]handle ctx err: %s", ctx.Err().Error())
 			return
-		case droplet := <-stream:
-			if err := h.handleDroplet(ctx, conn, droplet); err != nil {
-				h.logger.Errorf("[handler]conn terminated, err: %s", droplet.Err.Error())
+		case err := <-readyCh:
+			if err != nil {
+				h.logger.Errorf("[handler]conn terminated, err: %s", err.Error())
 				return
 			}
 		}
 	}
 }
 
-func (h *Handler) handleDroplet(ctx context.Context, conn io.ReadWriter, droplet *Droplet) error {
-	if droplet.Terminated() {
-		return droplet.Err
-	}
-
+func (h *Handler) handleDroplet(ctx context.Context, writer *bufio.Writer, client *Client, droplet *Droplet, qbuf int) {
 	if droplet.Err != nil {
-		_, _ = conn.Write(droplet.Reply.ToBytes())
+		WriteReply(writer, droplet.Reply, client.Proto)
 		h.logger.Errorf("[handler]conn request, err: %s", droplet.Err.Error())
-		return nil
+		return
 	}
 
 	if droplet.Reply == nil {
 		h.logger.Errorf("[handler]conn empty request")
-		return nil
+		return
 	}
 
 	// 请求参数必须为 multiBulkReply 类型
 	multiReply, ok := droplet.Reply.(MultiReply)
 	if !ok {
 		h.logger.Errorf("[handler]conn invalid request: %s", droplet.Reply.ToBytes())
-		return nil
+		return
 	}
 
-	if reply := h.db.Do(ctx, multiReply.Args()); reply != nil {
-		_, _ = conn.Write(reply.ToBytes())
-		return nil
+	// 与 redis 一致，空请求不回复
+	args := multiReply.Args()
+	if len(args) == 0 {
+		return
+	}
+	client.beginCmd(args, qbuf)
+	// 加载持久化文件时不检查权限
+	if !IsLoadingPattern(ctx) {
+		if reply := h.authorize(client, args); reply != nil {
+			WriteReply(writer, reply, client.Proto)
+			return
+		}
+	}
+	if cmdHandler, ok := h.clientCmdHandler(args[0]); ok {
+		WriteReply(writer, cmdHandler(client, args[1:]), client.Proto)
+		return
 	}
 
-	_, _ = conn.Write(UnknownErrReplyBytes)
-	return nil
+	// 连接层指令不受 CLIENT PAUSE 影响，以便随时解除暂停. 复制连接不暂停
+	if client.Class != ClientReplica && !h.pause.wait(ctx, h.mayWrite(args)) {
+		return
+	}
+
+	// 回复按连接协商的协议版本编码
+	if reply := h.db.Do(ctx, args); reply != nil {
+		WriteReply(writer, reply, client.Proto)
+		return
+	}
+
+	_, _ = writer.Write(UnknownErrReplyBytes)
+}
+
+func (h *Handler) mayWrite(args [][]byte) bool {
+	checker, ok := h.db.(WriteChecker)
+	return !ok || checker.MayWrite(args)
+}
+
+type cancelReader struct {
+	io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	if err != nil {
+		c.cancel()
+	}
+	return n, err
 }
 
 func (h *Handler) Close() {
 	h.Once.Do(func() {
 		h.closed.Store(true)
-		h.mu.RLock()
-		defer h.mu.RUnlock()
-
-		for conn := range h.conns {
-			if err := conn.Close; err != nil {
+		h.mu.Lock()
+		defer h.mu.Unlock()
 
-			}
+		for _, client := range h.clients {
+			client.kill()
 		}
-		h.conns = nil
+		h.clients = nil
 		h.db.Close()
 		h.persister.Close()
 	})
@@ -0,0 +1,111 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"goredis/handler"
+	"goredis/log"
+	"goredis/protocol"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 将指令原样返回
+type echoDB struct{}
+
+func (e *echoDB) Do(ctx context.Context, cmdLine [][]byte) handler.Reply {
+	return handler.NewMultiBulkReply(cmdLine)
+}
+
+func (e *echoDB) Close() {}
+
+func Test_handle_inline(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	h, _ := handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, nil)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go h.Handle(context.Background(), server)
+
+	reader := bufio.NewReader(client)
+	readLines := func(n int) string {
+		var res string
+		for i := 0; i < n; i++ {
+			line, err := reader.ReadString('\n')
+			assert.NoError(t, err)
+			res += line
+		}
+		return res
+	}
+
+	_, _ = client.Write([]byte("PING\n"))
+	assert.Equal(t, "+PONG\r\n", readLines(1))
+	_, _ = client.Write([]byte("ping hi\r\n"))
+	assert.Equal(t, "$2\r\nhi\r\n", readLines(2))
+	_, _ = client.Write([]byte("set 'a b' c\r\n"))
+	assert.Equal(t, "*3\r\n$3\r\nset\r\n$3\r\na b\r\n$1\r\nc\r\n", readLines(7))
+	_, _ = client.Write([]byte("set \"a\r\n"))
+	assert.Equal(t, "-ERR Protocol error: unbalanced quotes in request\r\n", readLines(1))
+}
+
+func Test_handle_pipeline(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	h, _ := handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, nil)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go h.Handle(context.Background(), server)
+
+	// 流水线中的回复合并为一次写入
+	_, _ = client.Write([]byte("*1\r\n$1\r\na\r\n*1\r\n$1\r\nb\r\nPING\r\n"))
+	buf := make([]byte, 1024)
+	n, err := client.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "*1\r\n$1\r\na\r\n*1\r\n$1\r\nb\r\n+PONG\r\n", string(buf[:n]))
+}
+
+type outputLimitThinker string
+
+func (o outputLimitThinker) ClientOutputBufferLimit() string {
+	return string(o)
+}
+
+func (o outputLimitThinker) RequirePass() string {
+	return ""
+}
+
+func (o outputLimitThinker) ACLFile() string {
+	return ""
+}
+
+func (o outputLimitThinker) ACLLogMaxLen() int {
+	return 0
+}
+
+func (o outputLimitThinker) TLSAuthClientsUser() string {
+	return "off"
+}
+
+func Test_handle_output_buffer_limit(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	h, err := handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, outputLimitThinker("normal 64 0 0"))
+	assert.NoError(t, err)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go h.Handle(context.Background(), server)
+
+	// 不读取回复，积压超过 hard 限制后连接被关闭
+	_, _ = client.Write([]byte(strings.Repeat("*2\r\n$3\r\nget\r\n$8\r\nsome-key\r\n", 10)))
+	_, err = io.ReadAll(client)
+	assert.NoError(t, err)
+	_, err = client.Write([]byte("PING\r\n"))
+	assert.Error(t, err)
+
+	_, err = handler.NewHandler(&echoDB{}, nil, protocol.NewParser(logger, nil), logger, outputLimitThinker("normal 64"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 客户端类别，不同类别采用不同的输出缓冲区限制
+const (
+	ClientNormal = iota
+	ClientReplica
+	ClientPubSub
+)
+
+var clientClassNames = [...]string{
+	ClientNormal:  "normal",
+	ClientReplica: "replica",
+	ClientPubSub:  "pubsub",
+}
+
+// 输出缓冲区限制. 达到 hard 时立即断开连接，持续超过 soft 达到 softSeconds 时断开连接. 0 代表不限制
+type outputLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds time.Duration
+}
+
+type outputLimits [len(clientClassNames)]outputLimit
+
+// 与 redis 默认配置一致
+var defaultOutputLimits = outputLimits{
+	ClientNormal:  {},
+	ClientReplica: {hard: 256 << 20, soft: 64 << 20, softSeconds: 60 * time.Second},
+	ClientPubSub:  {hard: 32 << 20, soft: 8 << 20, softSeconds: 60 * time.Second},
+}
+
+var errOutputLimitFormat = errors.New("ERR Wrong number of arguments in buffer limit configuration.")
+
+// 解析 client-output-buffer-limit，格式为 <class> <hard> <soft> <soft seconds> ...，未出现的类别沿用默认值
+func parseOutputLimits(str string) (outputLimits, error) {
+	limits := defaultOutputLimits
+	fields := strings.Fields(str)
+	if len(fields)%4 != 0 {
+		return limits, errOutputLimitFormat
+	}
+
+	for i := 0; i < len(fields); i += 4 {
+		class := -1
+		for j, name := range clientClassNames {
+			if strings.EqualFold(fields[i], name) {
+				class = j
+			}
+		}
+		// slave 为 replica 的旧称
+		if strings.EqualFold(fields[i], "slave") {
+			class = ClientReplica
+		}
+		if class < 0 {
+			return limits, errors.New("ERR Invalid client class specified in buffer limit configuration.")
+		}
+
+		hard, err := parseMemory(fields[i+1])
+		if err != nil {
+			return limits, err
+		}
+		soft, err := parseMemory(fields[i+2])
+		if err != nil {
+			return limits, err
+		}
+		seconds, err := strconv.ParseInt(fields[i+3], 10, 64)
+		if err != nil || seconds < 0 {
+			return limits, errors.New("ERR Error in soft_seconds setting in buffer limit configuration.")
+		}
+		limits[class] = outputLimit{hard: hard, soft: soft, softSeconds: time.Duration(seconds) * time.Second}
+	}
+	return limits, nil
+}
+
+// 解析带单位的内存大小，如 64mb、1gb. 单位不区分大小写，k/m/g 为 1000 进制，kb/mb/gb 为 1024 进制
+func parseMemory(str string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	lower, mul := strings.ToLower(str), int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, mul = strings.TrimSuffix(lower, unit.suffix), unit.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("ERR Invalid memory value '" + str + "' in buffer limit configuration.")
+	}
+	return n * mul, nil
+}
+
+var errOutputClosed = errors.New("output buffer closed")
+
+const outputChunkSize = 16 * 1024
+
+var outputChunkPool = sync.Pool{
+	New: func() interface{} {
+		chunk := make([]byte, 0, outputChunkSize)
+		return &chunk
+	},
+}
+
+// 连接的输出缓冲区. 回复先暂存在内存中，由独立的协程写入连接，
+// 对端读取缓慢时不阻塞请求处理，积压的字节数用于检查输出缓冲区限制
+type outputBuffer struct {
+	conn io.Writer
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// 待写入连接的数据，spare 为写协程交还的空切片，交替使用
+	chunks, spare []*[]byte
+	// 已暂存但尚未写入连接的字节数
+	pending int
+	closed  bool
+	err     error
+
+	// 首次超过 soft 限制的时间，只在请求处理协程中访问
+	softSince time.Time
+}
+
+func newOutputBuffer(conn io.Writer) *outputBuffer {
+	o := &outputBuffer{conn: conn}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// 在独立的协程中运行，直到 Close 或写入连接出错
+func (o *outputBuffer) run() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for {
+		for len(o.chunks) == 0 && !o.closed {
+			o.cond.Wait()
+		}
+		if o.closed {
+			return
+		}
+
+		chunks := o.chunks
+		o.chunks, o.spare = o.spare, nil
+		o.mu.Unlock()
+
+		var (
+			written int
+			err     error
+		)
+		for _, chunk := range chunks {
+			if err == nil {
+				_, err = o.conn.Write(*chunk)
+			}
+			written += len(*chunk)
+			*chunk = (*chunk)[:0]
+			outputChunkPool.Put(chunk)
+		}
+
+		o.mu.Lock()
+		o.spare = chunks[:0]
+		o.pending -= written
+		if err != nil {
+			o.err, o.closed = err, true
+		}
+		o.cond.Broadcast()
+	}
+}
+
+func (o *outputBuffer) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return 0, o.err
+	}
+	if o.closed {
+		return 0, errOutputClosed
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		if last := len(o.chunks) - 1; last < 0 || len(*o.chunks[last]) == cap(*o.chunks[last]) {
+			o.chunks = append(o.chunks, outputChunkPool.Get().(*[]byte))
+		}
+		chunk := o.chunks[len(o.chunks)-1]
+		copied := copy((*chunk)[len(*chunk):cap(*chunk)], p)
+		*chunk = (*chunk)[:len(*chunk)+copied]
+		p = p[copied:]
+	}
+	o.pending += n
+	o.cond.Broadcast()
+	return n, nil
+}
+
+// 尚未写入连接的字节数
+func (o *outputBuffer) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.pending
+}
+
+// 等待暂存的数据全部写入连接
+func (o *outputBuffer) Drain() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for o.pending > 0 && !o.closed {
+		o.cond.Wait()
+	}
+	return o.err
+}
+
+// 停止写协程，丢弃尚未写入连接的数据
+func (o *outputBuffer) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	for _, chunk := range o.chunks {
+		*chunk = (*chunk)[:0]
+		outputChunkPool.Put(chunk)
+	}
+	o.chunks = nil
+	o.cond.Broadcast()
+}
+
+// 检查是否超出输出缓冲区限制. buffered 为尚未交给输出缓冲区的字节数
+func (o *outputBuffer) overLimit(limit outputLimit, buffered int, now time.Time) bool {
+	o.mu.Lock()
+	used := int64(o.pending + buffered)
+	o.mu.Unlock()
+
+	if limit.hard > 0 && used >= limit.hard {
+		return true
+	}
+	if limit.soft == 0 || used < limit.soft {
+		o.softSince = time.Time{}
+		return false
+	}
+	if o.softSince.IsZero() {
+		o.softSince = now
+	}
+	return now.Sub(o.softSince) > limit.softSeconds
+}
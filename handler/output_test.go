@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parse_output_limits(t *testing.T) {
+	limits, err := parseOutputLimits("normal 1mb 512kb 10 pubsub 64 32 0")
+	assert.NoError(t, err)
+	assert.Equal(t, outputLimit{hard: 1 << 20, soft: 512 << 10, softSeconds: 10 * time.Second}, limits[ClientNormal])
+	assert.Equal(t, defaultOutputLimits[ClientReplica], limits[ClientReplica])
+	assert.Equal(t, outputLimit{hard: 64, soft: 32}, limits[ClientPubSub])
+
+	limits, err = parseOutputLimits("slave 2g 1G 60")
+	assert.NoError(t, err)
+	assert.Equal(t, outputLimit{hard: 2e9, soft: 1e9, softSeconds: time.Minute}, limits[ClientReplica])
+
+	for _, str := range []string{"normal 0 0", "master 0 0 0", "normal x 0 0", "normal -1 0 0", "normal 0 0 -1"} {
+		_, err = parseOutputLimits(str)
+		assert.Error(t, err, str)
+	}
+}
+
+// 在 release 关闭前阻塞写入，模拟读取缓慢的对端
+type slowWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	release chan struct{}
+}
+
+func (s *slowWriter) Write(p []byte) (int, error) {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func Test_output_buffer(t *testing.T) {
+	conn := &slowWriter{release: make(chan struct{})}
+	output := newOutputBuffer(conn)
+	defer output.Close()
+	go output.run()
+
+	payload := bytes.Repeat([]byte("x"), outputChunkSize+10)
+	_, err := output.Write(payload)
+	assert.NoError(t, err)
+	_, err = output.Write([]byte("end"))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	limit := outputLimit{hard: int64(len(payload)) * 2, soft: 100, softSeconds: time.Second}
+	// 写入被阻塞时积压的数据计入限制
+	assert.True(t, output.overLimit(outputLimit{hard: 100}, 0, now))
+	assert.False(t, output.overLimit(limit, 0, now))
+	assert.False(t, output.overLimit(limit, 0, now.Add(time.Second)))
+	assert.True(t, output.overLimit(limit, 0, now.Add(2*time.Second)))
+	assert.True(t, output.overLimit(limit, len(payload), now))
+
+	close(conn.release)
+	assert.NoError(t, output.Drain())
+	assert.Equal(t, string(payload)+"end", conn.buf.String())
+	// 积压清空后重新计算超出 soft 限制的时间
+	assert.False(t, output.overLimit(limit, 0, now.Add(3*time.Second)))
+	assert.False(t, output.overLimit(limit, 200, now.Add(3*time.Second)))
+	assert.False(t, output.overLimit(limit, 200, now.Add(4*time.Second)))
+
+	output.Close()
+	_, err = output.Write([]byte("x"))
+	assert.Equal(t, errOutputClosed, err)
+}
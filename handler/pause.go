@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CLIENT PAUSE 的暂停状态. 暂停期间普通客户端的指令等待至超时或 CLIENT UNPAUSE，
+// WRITE 模式只暂停可能写入数据的指令
+type pauseState struct {
+	mu    sync.Mutex
+	until time.Time
+	all   bool
+	// 解除暂停时关闭，唤醒等待中的连接
+	done chan struct{}
+}
+
+// 重复暂停时取更晚的结束时间与更严格的模式
+func (p *pauseState) pause(until time.Time, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.pausedLocked(time.Now()) {
+		p.until, p.all = time.Time{}, false
+	}
+	if p.done == nil {
+		p.done = make(chan struct{})
+	}
+	if until.After(p.until) {
+		p.until = until
+	}
+	p.all = p.all || all
+}
+
+func (p *pauseState) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done != nil {
+		close(p.done)
+	}
+	p.until, p.all, p.done = time.Time{}, false, nil
+}
+
+func (p *pauseState) pausedLocked(now time.Time) bool {
+	return now.Before(p.until)
+}
+
+// 等待暂停结束. ctx 取消时返回 false
+func (p *pauseState) wait(ctx context.Context, write bool) bool {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		if !p.pausedLocked(now) || !(p.all || write) {
+			p.mu.Unlock()
+			return true
+		}
+		done, timer := p.done, time.NewTimer(p.until.Sub(now))
+		p.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+		timer.Stop()
+	}
+}
@@ -33,6 +33,7 @@ func newFakeReaderWriter(reader io.Reader) io.ReadWriter {
 	}
 }
 
+// 丢弃回复. 需要返回写入长度，否则 bufio.Writer 视为 short write
 func (f *fakeReadWriter) Write(p []byte) (n int, err error) {
-	return 0, nil
+	return len(p), nil
 }
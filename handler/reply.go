@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -51,7 +53,7 @@ func (i *IntReply) ToBytes() []byte {
 
 type SyntaxErrReply struct{}
 
-var syntaxErrBytes = []byte("-Err syntax error\r\n")
+var syntaxErrBytes = []byte("-ERR syntax error\r\n")
 var theSyntaxErrReply = new(SyntaxErrReply)
 
 func NewSyntaxErrReply() *SyntaxErrReply {
@@ -63,7 +65,7 @@ func (r *SyntaxErrReply) ToBytes() []byte {
 }
 
 func (r *SyntaxErrReply) Error() string {
-	return "Err syntax error"
+	return "ERR syntax error"
 }
 
 type WrongTypeErrReply struct{}
@@ -94,6 +96,53 @@ func NewErrReply(errStr string) *ErrReply {
 	}
 }
 
+// 与 redis 一致的通用错误
+var (
+	NoAuthErrReply    = NewErrReply("NOAUTH Authentication required.")
+	OOMErrReply       = NewErrReply("OOM command not allowed when used memory > 'maxmemory'.")
+	ReadOnlyErrReply  = NewErrReply("READONLY You can't write against a read only replica.")
+	ExecAbortErrReply = NewErrReply("EXECABORT Transaction discarded because of previous errors.")
+	BusyErrReply      = NewErrReply("BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSCRIPT.")
+	// 正在执行的是 FCALL 调用的函数
+	BusyFunctionErrReply = NewErrReply("BUSY Redis is busy running a script. You can only call FUNCTION KILL or SHUTDOWN NOSCRIPT.")
+	LoadingErrReply      = NewErrReply("LOADING Redis is loading the dataset in memory")
+)
+
+// 未知指令. 与 redis 一致，错误信息中附带前几个参数，总长度不超过 128
+func NewUnknownCmdErrReply(cmdLine [][]byte) *ErrReply {
+	var name []byte
+	if len(cmdLine) > 0 {
+		name = cmdLine[0]
+	}
+	var args strings.Builder
+	for i := 1; i < len(cmdLine) && args.Len() < 128; i++ {
+		arg := cmdLine[i]
+		if n := 128 - args.Len(); len(arg) > n {
+			arg = arg[:n]
+		}
+		args.WriteString("'" + string(arg) + "' ")
+	}
+	return NewErrReply(sanitizeErr(fmt.Sprintf("ERR unknown command '%.128s', with args beginning with: %s", name, args.String())))
+}
+
+func NewUnknownSubCmdErrReply(cmd string, subCmd []byte) *ErrReply {
+	return NewErrReply(sanitizeErr(fmt.Sprintf("ERR unknown subcommand '%.128s'. Try %s HELP.", subCmd, strings.ToUpper(cmd))))
+}
+
+func NewArgNumErrReply(cmd string) *ErrReply {
+	return NewErrReply("ERR wrong number of arguments for '" + cmd + "' command")
+}
+
+// 错误回复不能包含换行，与 redis 一致替换为空格
+func sanitizeErr(msg string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, msg)
+}
+
 func (e *ErrReply) ToBytes() []byte {
 	return []byte("-" + e.ErrStr + CRLF)
 }
@@ -146,16 +195,32 @@ func (m *MultiBulkReply) Args() [][]byte {
 }
 
 func (m *MultiBulkReply) ToBytes() []byte {
-	var strBuf strings.Builder
-	strBuf.WriteString("*" + strconv.Itoa(len(m.args)) + CRLF)
+	return appendReply(make([]byte, 0, m.size()), m, Resp2)
+}
+
+// 编码后的大致长度，用于预分配内存
+func (m *MultiBulkReply) size() int {
+	size := 16
 	for _, arg := range m.args {
-		if arg == nil {
-			strBuf.WriteString(string(nillBulkBytes))
-			continue
-		}
-		strBuf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
+		size += len(arg) + 16
 	}
-	return []byte(strBuf.String())
+	return size
+}
+
+var (
+	nullMultiBulkReply = &NullMultiBulkReply{}
+	nullMultiBulkBytes = []byte("*-1\r\n")
+)
+
+// 空值数组类型，协议固定为【*】【-1】【CRLF】
+type NullMultiBulkReply struct{}
+
+func NewNullMultiBulkReply() *NullMultiBulkReply {
+	return nullMultiBulkReply
+}
+
+func (n *NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
 }
 
 var emptyMultiBulkBytes = []byte("*0\r\n")
@@ -170,3 +235,301 @@ func NewEmptyMultiBulkReply() *EmptyMultiBulkReply {
 func (r *EmptyMultiBulkReply) ToBytes() []byte {
 	return emptyMultiBulkBytes
 }
+
+// 嵌套数组类型. 元素可以是任意 reply，例如整数数组、数组的数组
+type ArrayReply struct {
+	replies []Reply
+}
+
+func NewArrayReply(replies []Reply) *ArrayReply {
+	return &ArrayReply{
+		replies: replies,
+	}
+}
+
+func (a *ArrayReply) Replies() []Reply {
+	return a.replies
+}
+
+func (a *ArrayReply) ToBytes() []byte {
+	return appendReply(make([]byte, 0, 64), a, Resp2)
+}
+
+// 协议版本
+const (
+	Resp2 = 2
+	Resp3 = 3
+)
+
+// 在 RESP3 下编码不同的回复实现该接口，ToBytes 为 RESP2 编码
+type Resp3Reply interface {
+	Reply
+	ToResp3Bytes() []byte
+}
+
+// 按连接协商的协议版本编码回复
+func EncodeReply(reply Reply, proto int) []byte {
+	if resp3Reply, ok := reply.(Resp3Reply); ok && proto == Resp3 {
+		return resp3Reply.ToResp3Bytes()
+	}
+	return reply.ToBytes()
+}
+
+var nullBytes = []byte("_\r\n")
+
+func (n *NillReply) ToResp3Bytes() []byte {
+	return nullBytes
+}
+
+func (b *BulkReply) ToResp3Bytes() []byte {
+	if b.Arg == nil {
+		return nullBytes
+	}
+	return b.ToBytes()
+}
+
+func (m *MultiBulkReply) ToResp3Bytes() []byte {
+	return appendReply(make([]byte, 0, m.size()), m, Resp3)
+}
+
+func (n *NullMultiBulkReply) ToResp3Bytes() []byte {
+	return nullBytes
+}
+
+func (a *ArrayReply) ToResp3Bytes() []byte {
+	return appendReply(make([]byte, 0, 64), a, Resp3)
+}
+
+// 聚合类型的编码. size 为协议头中的元素个数，map 类型为键值对个数
+func encodeAggregate(prefix byte, size int, replies []Reply, proto int) []byte {
+	buf := []byte(string(prefix) + strconv.Itoa(size) + CRLF)
+	for _, reply := range replies {
+		buf = append(buf, EncodeReply(reply, proto)...)
+	}
+	return buf
+}
+
+// 键值对类型，replies 按 key, value 依次排列. RESP2 下编码为数组
+type MapReply struct {
+	replies []Reply
+}
+
+func NewMapReply(replies []Reply) *MapReply {
+	return &MapReply{
+		replies: replies,
+	}
+}
+
+func (m *MapReply) Replies() []Reply {
+	return m.replies
+}
+
+func (m *MapReply) ToBytes() []byte {
+	return encodeAggregate('*', len(m.replies), m.replies, Resp2)
+}
+
+func (m *MapReply) ToResp3Bytes() []byte {
+	return encodeAggregate('%', len(m.replies)/2, m.replies, Resp3)
+}
+
+// 集合类型. RESP2 下编码为数组
+type SetReply struct {
+	replies []Reply
+}
+
+func NewSetReply(replies []Reply) *SetReply {
+	return &SetReply{
+		replies: replies,
+	}
+}
+
+func (s *SetReply) Replies() []Reply {
+	return s.replies
+}
+
+func (s *SetReply) ToBytes() []byte {
+	return encodeAggregate('*', len(s.replies), s.replies, Resp2)
+}
+
+func (s *SetReply) ToResp3Bytes() []byte {
+	return encodeAggregate('~', len(s.replies), s.replies, Resp3)
+}
+
+// 成对出现的元素，如 member, score. RESP2 下编码为平铺的数组，RESP3 下编码为二元数组的数组
+type PairsReply struct {
+	replies []Reply
+}
+
+func NewPairsReply(replies []Reply) *PairsReply {
+	return &PairsReply{
+		replies: replies,
+	}
+}
+
+func (p *PairsReply) Replies() []Reply {
+	return p.replies
+}
+
+func (p *PairsReply) ToBytes() []byte {
+	return encodeAggregate('*', len(p.replies), p.replies, Resp2)
+}
+
+func (p *PairsReply) ToResp3Bytes() []byte {
+	buf := []byte("*" + strconv.Itoa(len(p.replies)/2) + CRLF)
+	for i := 0; i+1 < len(p.replies); i += 2 {
+		buf = append(buf, encodeAggregate('*', 2, p.replies[i:i+2], Resp3)...)
+	}
+	return buf
+}
+
+// 服务端推送类型. RESP2 下编码为数组
+type PushReply struct {
+	replies []Reply
+}
+
+func NewPushReply(replies []Reply) *PushReply {
+	return &PushReply{
+		replies: replies,
+	}
+}
+
+func (p *PushReply) Replies() []Reply {
+	return p.replies
+}
+
+func (p *PushReply) ToBytes() []byte {
+	return encodeAggregate('*', len(p.replies), p.replies, Resp2)
+}
+
+func (p *PushReply) ToResp3Bytes() []byte {
+	return encodeAggregate('>', len(p.replies), p.replies, Resp3)
+}
+
+// 为回复附加属性. RESP2 下忽略属性
+type AttributeReply struct {
+	attrs []Reply
+	reply Reply
+}
+
+func NewAttributeReply(attrs []Reply, reply Reply) *AttributeReply {
+	return &AttributeReply{
+		attrs: attrs,
+		reply: reply,
+	}
+}
+
+func (a *AttributeReply) Attrs() []Reply {
+	return a.attrs
+}
+
+func (a *AttributeReply) Reply() Reply {
+	return a.reply
+}
+
+func (a *AttributeReply) ToBytes() []byte {
+	return a.reply.ToBytes()
+}
+
+func (a *AttributeReply) ToResp3Bytes() []byte {
+	return append(encodeAggregate('|', len(a.attrs)/2, a.attrs, Resp3), EncodeReply(a.reply, Resp3)...)
+}
+
+// 浮点数类型. RESP2 下编码为字符串
+type DoubleReply struct {
+	Value float64
+}
+
+func NewDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{
+		Value: value,
+	}
+}
+
+// 整数值不使用科学计数法，无穷大为 inf, -inf
+func FormatDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	case math.IsNaN(value):
+		return "nan"
+	case value == math.Trunc(value) && math.Abs(value) < 1e17:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+func (d *DoubleReply) ToBytes() []byte {
+	return NewBulkReply([]byte(FormatDouble(d.Value))).ToBytes()
+}
+
+func (d *DoubleReply) ToResp3Bytes() []byte {
+	return []byte("," + FormatDouble(d.Value) + CRLF)
+}
+
+// 布尔类型. RESP2 下编码为整数 1 或 0
+type BoolReply struct {
+	Value bool
+}
+
+func NewBoolReply(value bool) *BoolReply {
+	return &BoolReply{
+		Value: value,
+	}
+}
+
+func (b *BoolReply) ToBytes() []byte {
+	if b.Value {
+		return []byte(":1\r\n")
+	}
+	return []byte(":0\r\n")
+}
+
+func (b *BoolReply) ToResp3Bytes() []byte {
+	if b.Value {
+		return []byte("#t\r\n")
+	}
+	return []byte("#f\r\n")
+}
+
+// 大整数类型. RESP2 下编码为字符串
+type BigNumberReply struct {
+	Str string
+}
+
+func NewBigNumberReply(str string) *BigNumberReply {
+	return &BigNumberReply{
+		Str: str,
+	}
+}
+
+func (b *BigNumberReply) ToBytes() []byte {
+	return NewBulkReply([]byte(b.Str)).ToBytes()
+}
+
+func (b *BigNumberReply) ToResp3Bytes() []byte {
+	return []byte("(" + b.Str + CRLF)
+}
+
+// 带格式的字符串，format 为 3 个字符，如 txt, mkd. RESP2 下编码为字符串
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+func NewVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{
+		Format: format,
+		Text:   text,
+	}
+}
+
+func (v *VerbatimReply) ToBytes() []byte {
+	return NewBulkReply(v.Text).ToBytes()
+}
+
+func (v *VerbatimReply) ToResp3Bytes() []byte {
+	return []byte("=" + strconv.Itoa(len(v.Text)+4) + CRLF + v.Format + ":" + string(v.Text) + CRLF)
+}
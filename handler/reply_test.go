@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"goredis/acl"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_reply_encode_by_proto(t *testing.T) {
+	cases := []struct {
+		reply Reply
+		resp2 string
+		resp3 string
+	}{
+		{NewNillReply(), "$-1\r\n", "_\r\n"},
+		{NewNullMultiBulkReply(), "*-1\r\n", "_\r\n"},
+		{NewMultiBulkReply([][]byte{[]byte("a"), nil}), "*2\r\n$1\r\na\r\n$-1\r\n", "*2\r\n$1\r\na\r\n_\r\n"},
+		{NewDoubleReply(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{NewDoubleReply(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{NewBoolReply(true), ":1\r\n", "#t\r\n"},
+		{NewBoolReply(false), ":0\r\n", "#f\r\n"},
+		{NewBigNumberReply("12345678901234567890"), "$20\r\n12345678901234567890\r\n", "(12345678901234567890\r\n"},
+		{NewVerbatimReply("txt", []byte("hi")), "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{NewMapReply([]Reply{NewBulkReply([]byte("k")), NewIntReply(1)}), "*2\r\n$1\r\nk\r\n:1\r\n", "%1\r\n$1\r\nk\r\n:1\r\n"},
+		{NewSetReply([]Reply{NewBulkReply([]byte("m"))}), "*1\r\n$1\r\nm\r\n", "~1\r\n$1\r\nm\r\n"},
+		{NewPushReply([]Reply{NewBulkReply([]byte("message"))}), "*1\r\n$7\r\nmessage\r\n", ">1\r\n$7\r\nmessage\r\n"},
+		{NewPairsReply([]Reply{NewBulkReply([]byte("a")), NewDoubleReply(1)}), "*2\r\n$1\r\na\r\n$1\r\n1\r\n", "*1\r\n*2\r\n$1\r\na\r\n,1\r\n"},
+		{NewAttributeReply([]Reply{NewBulkReply([]byte("ttl")), NewIntReply(3)}, NewIntReply(1)), ":1\r\n", "|1\r\n$3\r\nttl\r\n:3\r\n:1\r\n"},
+		// 嵌套的元素同样按协议版本编码
+		{NewArrayReply([]Reply{NewNillReply(), NewBoolReply(true)}), "*2\r\n$-1\r\n:1\r\n", "*2\r\n_\r\n#t\r\n"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.resp2, string(EncodeReply(c.reply, Resp2)))
+		assert.Equal(t, c.resp3, string(EncodeReply(c.reply, Resp3)))
+	}
+}
+
+func Test_hello(t *testing.T) {
+	users, _ := acl.New(nil, nil)
+	h := &Handler{acl: users}
+	client := newClient(7)
+
+	reply := h.hello(client, [][]byte{[]byte("3"), []byte("SETNAME"), []byte("conn1")})
+	assert.Equal(t, "%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n7.2.0\r\n$5\r\nproto\r\n:3\r\n$2\r\nid\r\n:7\r\n"+
+		"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n", string(EncodeReply(reply, client.Proto)))
+	assert.Equal(t, Resp3, client.Proto)
+	assert.Equal(t, "conn1", client.Name)
+
+	cases := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"4"}, "-NOPROTO unsupported protocol version\r\n"},
+		{[]string{"x"}, "-ERR Protocol version is not an integer or out of range\r\n"},
+		{[]string{"2", "AUTH", "default"}, "-ERR Syntax error in HELLO option 'auth'\r\n"},
+		{[]string{"2", "AUTH", "nobody", "pass"}, "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{[]string{"2", "SETNAME", "a b"}, "-ERR Client names cannot contain spaces, newlines or special characters.\r\n"},
+		{[]string{"2", "FOO"}, "-ERR Syntax error in HELLO option 'FOO'\r\n"},
+	}
+	for _, c := range cases {
+		args := make([][]byte, 0, len(c.args))
+		for _, arg := range c.args {
+			args = append(args, []byte(arg))
+		}
+		assert.Equal(t, c.expect, string(h.hello(client, args).ToBytes()), c.args)
+	}
+	// 失败的 HELLO 不改变连接状态
+	assert.Equal(t, Resp3, client.Proto)
+
+	reply = h.hello(client, [][]byte{[]byte("2"), []byte("AUTH"), []byte("default"), []byte("any")})
+	assert.Equal(t, byte('*'), EncodeReply(reply, client.Proto)[0])
+	assert.Equal(t, Resp2, client.Proto)
+}
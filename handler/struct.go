@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"io"
 	"strings"
 )
@@ -22,11 +23,47 @@ type Droplet struct {
 	Err   error
 }
 
+// 读取连接出错，连接无法继续使用
+type ConnReadErr struct {
+	Err error
+}
+
+func (c *ConnReadErr) Error() string {
+	return c.Err.Error()
+}
+
+func (c *ConnReadErr) Unwrap() error {
+	return c.Err
+}
+
+// 请求格式错误. 与 redis 一致，回复错误后关闭连接
+type ProtocolErr struct {
+	Err error
+}
+
+func (p *ProtocolErr) Error() string {
+	return p.Err.Error()
+}
+
+func (p *ProtocolErr) Unwrap() error {
+	return p.Err
+}
+
 func (d *Droplet) Terminated() bool {
 	if d.Err == io.EOF || d.Err == io.ErrUnexpectedEOF {
 		return true
 	}
 
+	var readErr *ConnReadErr
+	if errors.As(d.Err, &readErr) {
+		return true
+	}
+
+	var protocolErr *ProtocolErr
+	if errors.As(d.Err, &protocolErr) {
+		return true
+	}
+
 	return d.Err != nil && strings.Contains(d.Err.Error(), "use of closed network connection")
 }
 
@@ -35,6 +72,23 @@ type DB interface {
 	Close()
 }
 
+// DB 的可选实现. CLIENT PAUSE WRITE 据此判断指令是否需要暂停，未实现时所有指令都视为写指令
+type WriteChecker interface {
+	MayWrite(cmdLine [][]byte) bool
+}
+
 type Parser interface {
 	ParseStream(reader io.Reader) <-chan *Droplet
+	// 为连接创建同步读取请求的 reader，使用完毕后需要 Release
+	NewReader(reader io.Reader) RequestReader
+}
+
+type RequestReader interface {
+	// 阻塞读取下一个请求. 返回的 Droplet 只在下次调用前有效，其中的参数可以被长期持有
+	ReadDroplet() *Droplet
+	// 已读入缓冲区尚未解析的字节数，为 0 时代表流水线中的请求已处理完
+	Buffered() int
+	// 等待连接可读或出错，不消费数据
+	Peek() error
+	Release()
 }
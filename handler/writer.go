@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"sync"
+)
+
+const writerBufSize = 16 * 1024
+
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewWriterSize(nil, writerBufSize)
+	},
+}
+
+func acquireWriter(w io.Writer) *bufio.Writer {
+	writer := writerPool.Get().(*bufio.Writer)
+	writer.Reset(w)
+	return writer
+}
+
+func releaseWriter(writer *bufio.Writer) {
+	writer.Reset(nil)
+	writerPool.Put(writer)
+}
+
+// 将回复直接编码到 writer 的缓冲区中，bulk 内容不经过中间拷贝.
+// 写入错误由 writer 记录，在 Flush 时返回
+func WriteReply(w *bufio.Writer, reply Reply, proto int) {
+	switch r := reply.(type) {
+	case *BulkReply:
+		if r.Arg != nil {
+			writeBulk(w, r.Arg)
+			return
+		}
+	case *MultiBulkReply:
+		_, _ = w.Write(appendHeader(w.AvailableBuffer(), '*', len(r.args)))
+		for _, arg := range r.args {
+			if arg == nil {
+				_, _ = w.Write(nilBulkBytes(proto))
+				continue
+			}
+			writeBulk(w, arg)
+		}
+		return
+	case *ArrayReply:
+		_, _ = w.Write(appendHeader(w.AvailableBuffer(), '*', len(r.replies)))
+		for _, reply := range r.replies {
+			WriteReply(w, reply, proto)
+		}
+		return
+	}
+	_, _ = w.Write(appendReply(w.AvailableBuffer(), reply, proto))
+}
+
+func writeBulk(w *bufio.Writer, arg []byte) {
+	_, _ = w.Write(appendHeader(w.AvailableBuffer(), '$', len(arg)))
+	_, _ = w.Write(arg)
+	_, _ = w.WriteString(CRLF)
+}
+
+func nilBulkBytes(proto int) []byte {
+	if proto == Resp3 {
+		return nullBytes
+	}
+	return nillBulkBytes
+}
+
+// 协议头，格式为【prefix】【n】【CRLF】
+func appendHeader(buf []byte, prefix byte, n int) []byte {
+	buf = append(buf, prefix)
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	return append(buf, CRLF...)
+}
+
+// 将回复编码追加到 buf 之后. 常见类型直接追加，避免 ToBytes 产生的临时内存
+func appendReply(buf []byte, reply Reply, proto int) []byte {
+	switch r := reply.(type) {
+	case *OKReply:
+		return append(buf, okBytes...)
+	case *SimpleStringReply:
+		buf = append(buf, '+')
+		buf = append(buf, r.Str...)
+		return append(buf, CRLF...)
+	case *ErrReply:
+		buf = append(buf, '-')
+		buf = append(buf, r.ErrStr...)
+		return append(buf, CRLF...)
+	case *IntReply:
+		buf = append(buf, ':')
+		buf = strconv.AppendInt(buf, r.Code, 10)
+		return append(buf, CRLF...)
+	case *BulkReply:
+		if r.Arg == nil {
+			return append(buf, nilBulkBytes(proto)...)
+		}
+		buf = appendHeader(buf, '$', len(r.Arg))
+		buf = append(buf, r.Arg...)
+		return append(buf, CRLF...)
+	case *MultiBulkReply:
+		buf = appendHeader(buf, '*', len(r.args))
+		for _, arg := range r.args {
+			if arg == nil {
+				buf = append(buf, nilBulkBytes(proto)...)
+				continue
+			}
+			buf = appendHeader(buf, '$', len(arg))
+			buf = append(buf, arg...)
+			buf = append(buf, CRLF...)
+		}
+		return buf
+	case *ArrayReply:
+		buf = appendHeader(buf, '*', len(r.replies))
+		for _, reply := range r.replies {
+			buf = appendReply(buf, reply, proto)
+		}
+		return buf
+	default:
+		return append(buf, EncodeReply(reply, proto)...)
+	}
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_write_reply(t *testing.T) {
+	replies := []Reply{
+		NewOKReply(),
+		NewSimpleStringReply("PONG"),
+		NewErrReply("ERR unknown"),
+		NewWrongTypeErrReply(),
+		NewIntReply(-12),
+		NewNillReply(),
+		NewBulkReply([]byte("value")),
+		NewBulkReply(nil),
+		NewMultiBulkReply([][]byte{[]byte("a"), nil, []byte("")}),
+		NewArrayReply([]Reply{NewIntReply(1), NewMultiBulkReply([][]byte{nil}), NewDoubleReply(1.5)}),
+		NewMapReply([]Reply{NewBulkReply([]byte("k")), NewBoolReply(true)}),
+	}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriterSize(&buf, 16)
+	for _, proto := range []int{Resp2, Resp3} {
+		for _, reply := range replies {
+			buf.Reset()
+			WriteReply(w, reply, proto)
+			assert.NoError(t, w.Flush())
+			assert.Equal(t, string(EncodeReply(reply, proto)), buf.String())
+		}
+	}
+}
+
+func benchReplies() []Reply {
+	args := make([][]byte, 10)
+	for i := range args {
+		args[i] = bytes.Repeat([]byte("v"), 64)
+	}
+	return []Reply{
+		NewOKReply(),
+		NewIntReply(1024),
+		NewBulkReply(bytes.Repeat([]byte("v"), 128)),
+		NewMultiBulkReply(args),
+	}
+}
+
+// 原有方式: 每个回复先编码为 []byte 再写入连接
+func Benchmark_reply_to_bytes(b *testing.B) {
+	replies := benchReplies()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, reply := range replies {
+			_, _ = io.Discard.Write(EncodeReply(reply, Resp2))
+		}
+	}
+}
+
+func Benchmark_write_reply(b *testing.B) {
+	replies := benchReplies()
+	w := acquireWriter(io.Discard)
+	defer releaseWriter(w)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, reply := range replies {
+			WriteReply(w, reply, Resp2)
+		}
+		_ = w.Flush()
+	}
+}
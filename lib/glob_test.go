@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_glob_match(t *testing.T) {
+	cases := []struct {
+		pattern, str string
+		expect       bool
+	}{
+		{"*", "", true},
+		{"*", "a/b", true},
+		{"user:*", "user:1/2", true},
+		{"user:*", "item:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+		{"[abc", "a", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expect, GlobMatch(c.pattern, c.str), "%s %s", c.pattern, c.str)
+	}
+}
@@ -0,0 +1,121 @@
+// 示例模块: 基于模块 API 实现的计数器类型.
+// 使用方式: 在启动前调用 database.RegisterModule(counter.NewModule())
+package counter
+
+import (
+	"goredis/database"
+	"goredis/handler"
+	"strconv"
+)
+
+const (
+	CmdTypeIncrBy database.CmdType = "cnt.incrby"
+	CmdTypeGet    database.CmdType = "cnt.get"
+	CmdTypeReset  database.CmdType = "cnt.reset"
+)
+
+// 计数器实体. 实现 CmdAdapter，aof 重写时以 cnt.incrby 指令还原
+type counterEntity struct {
+	key   string
+	value int64
+}
+
+func (c *counterEntity) ToCmd() [][]byte {
+	return [][]byte{[]byte(CmdTypeIncrBy), []byte(c.key), []byte(strconv.FormatInt(c.value, 10))}
+}
+
+type Module struct{}
+
+func NewModule() *Module {
+	return &Module{}
+}
+
+func (m *Module) Name() string {
+	return "counter"
+}
+
+// 每个执行器各自加载一次，指令绑定到该执行器的 key 空间
+func (m *Module) OnLoad(e *database.DBExecutor) error {
+	c := counters{executor: e}
+	if err := e.RegisterCommand(CmdTypeIncrBy, database.CmdSpec{Arity: 3, Flags: []string{database.CmdFlagWrite}, FirstKey: 1, LastKey: 1, Step: 1}, c.incrBy); err != nil {
+		return err
+	}
+	if err := e.RegisterCommand(CmdTypeGet, database.CmdSpec{Arity: 2, Flags: []string{database.CmdFlagReadOnly}, FirstKey: 1, LastKey: 1, Step: 1}, c.get); err != nil {
+		return err
+	}
+	if err := e.RegisterCommand(CmdTypeReset, database.CmdSpec{Arity: -2, Flags: []string{database.CmdFlagWrite}, FirstKey: 1, LastKey: -1, Step: 1}, c.reset); err != nil {
+		return err
+	}
+	return nil
+}
+
+type counters struct {
+	executor *database.DBExecutor
+}
+
+func (c *counters) getAsCounter(key string) (*counterEntity, error) {
+	v, ok := c.executor.Keyspace().GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+
+	counter, ok := v.(*counterEntity)
+	if !ok {
+		return nil, handler.NewWrongTypeErrReply()
+	}
+	return counter, nil
+}
+
+// CNT.INCRBY key increment
+func (c *counters) incrBy(cmd *database.Command) handler.Reply {
+	args := cmd.Args()
+	key := string(args[0])
+	increment, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return handler.NewErrReply("ERR value is not an integer or out of range")
+	}
+
+	counter, err := c.getAsCounter(key)
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if counter == nil {
+		counter = &counterEntity{key: key}
+		c.executor.Keyspace().PutEntity(key, counter)
+	}
+
+	counter.value += increment
+	c.executor.Persist(cmd) // 持久化
+	return handler.NewIntReply(counter.value)
+}
+
+// CNT.GET key
+func (c *counters) get(cmd *database.Command) handler.Reply {
+	counter, err := c.getAsCounter(string(cmd.Args()[0]))
+	if err != nil {
+		return handler.NewErrReply(err.Error())
+	}
+	if counter == nil {
+		return handler.NewIntReply(0)
+	}
+	return handler.NewIntReply(counter.value)
+}
+
+// CNT.RESET key [key ...]. 返回删除的计数器个数
+func (c *counters) reset(cmd *database.Command) handler.Reply {
+	for _, arg := range cmd.Args() {
+		if _, err := c.getAsCounter(string(arg)); err != nil {
+			return handler.NewErrReply(err.Error())
+		}
+	}
+
+	var removed int64
+	for _, arg := range cmd.Args() {
+		if c.executor.Keyspace().DelEntity(string(arg)) {
+			removed++
+		}
+	}
+
+	c.executor.Persist(cmd) // 持久化
+	return handler.NewIntReply(removed)
+}
@@ -0,0 +1,115 @@
+package counter_test
+
+import (
+	"context"
+	"goredis/database"
+	"goredis/datastore"
+	"goredis/modules/counter"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordPersister struct {
+	mu   sync.Mutex
+	cmds [][][]byte
+}
+
+func (r *recordPersister) Reloader() (io.ReadCloser, error) {
+	return nil, io.EOF
+}
+
+func (r *recordPersister) PersistCmd(ctx context.Context, cmd [][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cmds = append(r.cmds, cmd)
+}
+
+func (r *recordPersister) Close() {}
+
+func cmdLine(args ...string) [][]byte {
+	res := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		res = append(res, []byte(arg))
+	}
+	return res
+}
+
+func Test_counter_module(t *testing.T) {
+	database.RegisterModule(counter.NewModule())
+
+	persister := &recordPersister{}
+	kvStore := datastore.NewKVStore(persister, nil)
+	executor := database.NewDBExecutor(kvStore, persister, nil)
+	db := database.NewDBTrigger(executor)
+	defer db.Close()
+
+	// 订阅者在执行器协程中回调，通过 channel 转交给测试协程
+	events := make(chan database.KeyspaceEvent, 16)
+	executor.(*database.DBExecutor).SubscribeKeyspace(func(event database.KeyspaceEvent) {
+		events <- event
+	})
+
+	ctx := context.Background()
+	cases := []struct {
+		cmd    []string
+		expect string
+	}{
+		{[]string{"cnt.incrby", "c", "5"}, ":5\r\n"},
+		{[]string{"cnt.incrby", "c", "-2"}, ":3\r\n"},
+		{[]string{"cnt.get", "c"}, ":3\r\n"},
+		{[]string{"cnt.get", "none"}, ":0\r\n"},
+		{[]string{"cnt.incrby", "c", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"cnt.incrby", "c"}, "-ERR wrong number of arguments for 'cnt.incrby' command\r\n"},
+		{[]string{"set", "s", "v"}, "+OK\r\n"},
+		{[]string{"cnt.get", "s"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"get", "c"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"eval", "return redis.call('cnt.incrby', KEYS[1], 1)", "1", "c"}, ":4\r\n"},
+		{[]string{"cnt.reset", "c", "none"}, ":1\r\n"},
+		{[]string{"cnt.get", "c"}, ":0\r\n"},
+	}
+	for _, c := range cases {
+		reply := db.Do(ctx, cmdLine(c.cmd...))
+		assert.Equal(t, c.expect, string(reply.ToBytes()), c.cmd)
+	}
+
+	persister.mu.Lock()
+	assert.Equal(t, [][][]byte{
+		cmdLine("cnt.incrby", "c", "5"),
+		cmdLine("cnt.incrby", "c", "-2"),
+		cmdLine("set", "s", "v"),
+		cmdLine("cnt.incrby", "c", "1"),
+		cmdLine("cnt.reset", "c", "none"),
+	}, persister.cmds)
+	persister.mu.Unlock()
+
+	// 只读指令与失败的指令不产生事件
+	expectEvents := []database.KeyspaceEvent{
+		{Cmd: counter.CmdTypeIncrBy, Key: "c"},
+		{Cmd: counter.CmdTypeIncrBy, Key: "c"},
+		{Cmd: database.CmdTypeSet, Key: "s"},
+		{Cmd: counter.CmdTypeIncrBy, Key: "c"},
+		{Cmd: counter.CmdTypeReset, Key: "c"},
+		{Cmd: counter.CmdTypeReset, Key: "none"},
+	}
+	for _, expect := range expectEvents {
+		select {
+		case event := <-events:
+			assert.Equal(t, expect, event)
+		case <-time.After(time.Second):
+			t.Fatal("keyspace event timeout")
+		}
+	}
+	assert.Empty(t, events)
+
+	// aof 重写时自定义类型通过 CmdAdapter 还原
+	db.Do(ctx, cmdLine("cnt.incrby", "d", "7"))
+	kvStore.ForEach(func(key string, adapter database.CmdAdapter, expireAt *time.Time) {
+		if key == "d" {
+			assert.Equal(t, cmdLine("cnt.incrby", "d", "7"), adapter.ToCmd())
+		}
+	})
+}
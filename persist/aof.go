@@ -27,6 +27,7 @@ type aofPersister struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	thinker                Thinker
 	buffer                 chan [][]byte
 	aofFile                *os.File
 	aofFileName            string
@@ -48,6 +49,7 @@ func newAofPersister(thinker Thinker) (handler.Persister, error) {
 	a := aofPersister{
 		ctx:         ctx,
 		cancel:      cancel,
+		thinker:     thinker,
 		buffer:      make(chan [][]byte, 1<<10),
 		aofFile:     aofFile,
 		aofFileName: aofFileName,
@@ -49,14 +49,31 @@ func (a *aofPersister) startRewrite() (*os.File, int64, error) {
 }
 
 func (a *aofPersister) doRewrite(tmpFile *os.File, fileSize int64) error {
-	forkedDB, err := a.forkDB(fileSize)
+	forkedDB, forkedExecutor, err := a.forkDB(fileSize)
 	if err != nil {
 		return err
 	}
 
+	// 全局状态先于数据写入，其中函数库最先写入
+	for _, meta := range []interface{}{forkedExecutor, forkedDB} {
+		metaAdapter, ok := meta.(database.MetaCmdAdapter)
+		if !ok {
+			continue
+		}
+		for _, cmd := range metaAdapter.MetaCmds() {
+			_, _ = tmpFile.Write(handler.NewMultiBulkReply(cmd).ToBytes())
+		}
+	}
+
 	// 将 db 数据转为 aof cmd
 	forkedDB.ForEach(func(key string, adapter database.CmdAdapter, expireAt *time.Time) {
-		_, _ = tmpFile.Write(handler.NewMultiBulkReply(adapter.ToCmd()).ToBytes())
+		if multiAdapter, ok := adapter.(database.MultiCmdAdapter); ok {
+			for _, cmd := range multiAdapter.ToCmds() {
+				_, _ = tmpFile.Write(handler.NewMultiBulkReply(cmd).ToBytes())
+			}
+		} else {
+			_, _ = tmpFile.Write(handler.NewMultiBulkReply(adapter.ToCmd()).ToBytes())
+		}
 
 		if expireAt == nil {
 			return
@@ -69,26 +86,26 @@ func (a *aofPersister) doRewrite(tmpFile *os.File, fileSize int64) error {
 	return nil
 }
 
-func (a *aofPersister) forkDB(fileSize int64) (database.DataStore, error) {
+func (a *aofPersister) forkDB(fileSize int64) (database.DataStore, database.Executor, error) {
 	file, err := os.Open(a.aofFileName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	file.Seek(0, io.SeekStart)
 	logger := log.GetDefaultLogger()
 	reloader := readCloserAdapter(io.LimitReader(file, fileSize), file.Close)
 	fakePerisister := newFakePersister(reloader)
-	tmpKVStore := datastore.NewKVStore(fakePerisister)
-	executor := database.NewDBExecutor(tmpKVStore)
+	tmpKVStore := datastore.NewKVStore(fakePerisister, a.thinker)
+	executor := database.NewDBExecutor(tmpKVStore, fakePerisister, nil)
 	trigger := database.NewDBTrigger(executor)
-	h, err := handler.NewHandler(trigger, fakePerisister, protocol.NewParser(logger), logger)
+	h, err := handler.NewHandler(trigger, fakePerisister, protocol.NewParser(logger, a.thinker), logger, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if err = h.Start(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return tmpKVStore, nil
+	return tmpKVStore, executor, nil
 }
 
 func (a *aofPersister) endRewrite(tmpFile *os.File, fileSize int64) error {
@@ -2,11 +2,17 @@ package persist
 
 import (
 	"context"
+	"goredis/datastore"
 	"goredis/handler"
+	"goredis/protocol"
 	"io"
 )
 
 type Thinker interface {
+	// aof 重写时需要以相同的编码阈值还原数据
+	datastore.Thinker
+	// 重写时以相同的协议限制解析 aof 文件
+	protocol.Thinker
 	AppendOnly() bool
 	AppendFileName() string
 	AppendFsync() string
@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"goredis/handler"
+	"strconv"
+)
+
+// 单行指令的最大长度，与 redis 的 PROTO_INLINE_MAX_SIZE 一致
+const maxInlineSize = 64 * 1024
+
+var (
+	errInlineTooBig     = errors.New("ERR Protocol error: too big inline request")
+	errUnbalancedQuotes = errors.New("ERR Protocol error: unbalanced quotes in request")
+	errClosingQuote     = errors.New("ERR Protocol error: closing quote must be followed by a space or nothing at all")
+)
+
+// 解析 telnet 等客户端发送的单行指令，如 PING、SET "a b" 1. 空行返回 nil
+func (p *Parser) parseInline(line []byte) *handler.Droplet {
+	if len(line) > maxInlineSize {
+		return errDroplet(errInlineTooBig)
+	}
+
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte{'\n'}), []byte{'\r'})
+	args, err := splitInlineArgs(line)
+	if err != nil {
+		return errDroplet(err)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return &handler.Droplet{
+		Reply: handler.NewMultiBulkReply(args),
+	}
+}
+
+func isInlineSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// 按空白字符切分参数，规则与 redis-cli 一致:
+// 双引号内支持 \n \r \t \b \a \\ \" 以及 \xHH 转义，单引号内只支持 \' 转义，闭合引号后必须为空白或行尾
+func splitInlineArgs(line []byte) ([][]byte, error) {
+	args := [][]byte{}
+	i := 0
+	for {
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var (
+			arg    = []byte{}
+			quote  byte
+			closed bool
+		)
+		for !closed {
+			if i >= len(line) {
+				if quote != 0 {
+					return nil, errUnbalancedQuotes
+				}
+				break
+			}
+
+			c := line[i]
+			switch {
+			case quote == '"' && c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+				v, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+				arg = append(arg, byte(v))
+				i += 3
+			case quote == '"' && c == '\\' && i+1 < len(line):
+				i++
+				arg = append(arg, unescapeInline(line[i]))
+			case quote == '\'' && c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+				i++
+				arg = append(arg, '\'')
+			case quote != 0 && c == quote:
+				if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+					return nil, errClosingQuote
+				}
+				closed = true
+			case quote != 0:
+				arg = append(arg, c)
+			case isInlineSpace(c):
+				closed = true
+			case c == '"' || c == '\'':
+				quote = c
+			default:
+				arg = append(arg, c)
+			}
+			i++
+		}
+		args = append(args, arg)
+	}
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unescapeInline(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return c
+	}
+}
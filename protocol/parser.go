@@ -2,23 +2,67 @@ package protocol
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
+	"fmt"
 	"goredis/handler"
 	"goredis/lib/pool"
 	"goredis/log"
 	"io"
+	"math"
 	"strconv"
+	"strings"
 )
 
 type lineParser func(header []byte, reader *bufio.Reader) *handler.Droplet
 
+type Thinker interface {
+	ProtoMaxBulkLen() int
+	ProtoMaxMultiBulkLen() int
+	ClientQueryBufferLimit() int
+}
+
+type limitConf struct {
+	// 单个 bulk 的最大长度
+	maxBulkLen int64
+	// 数组的最大元素个数
+	maxMultiBulkLen int64
+	// 单个请求的最大字节数，超过时直接关闭连接
+	queryBufLimit int
+}
+
+var defaultLimitConf = &limitConf{
+	maxBulkLen:      512 * 1024 * 1024,
+	maxMultiBulkLen: math.MaxInt32,
+	queryBufLimit:   1024 * 1024 * 1024,
+}
+
+func newLimitConf(thinker Thinker) *limitConf {
+	if thinker == nil {
+		return defaultLimitConf
+	}
+
+	conf := *defaultLimitConf
+	if v := thinker.ProtoMaxBulkLen(); v > 0 {
+		conf.maxBulkLen = int64(v)
+	}
+	if v := thinker.ProtoMaxMultiBulkLen(); v > 0 {
+		conf.maxMultiBulkLen = int64(v)
+	}
+	if v := thinker.ClientQueryBufferLimit(); v > 0 {
+		conf.queryBufLimit = v
+	}
+	return &conf
+}
+
 type Parser struct {
 	lineParsers map[byte]lineParser
+	limit       *limitConf
 	logger      log.Logger
 }
 
-func NewParser(logger log.Logger) handler.Parser {
+func NewParser(logger log.Logger, thinker Thinker) handler.Parser {
 	p := &Parser{
+		limit:  newLimitConf(thinker),
 		logger: logger,
 	}
 
@@ -28,11 +72,24 @@ func NewParser(logger log.Logger) handler.Parser {
 		':': p.parseInt,
 		'$': p.parseBulk,
 		'*': p.parseMultiBulk,
+
+		// RESP3
+		'_': p.parseNull,
+		'#': p.parseBool,
+		',': p.parseDouble,
+		'(': p.parseBigNumber,
+		'=': p.parseVerbatim,
+		'%': p.parseMap,
+		'~': p.parseSet,
+		'>': p.parsePush,
+		'|': p.parseAttribute,
 	}
 
 	return p
 }
 
+// 在独立的协程中读取并逐个投递到 channel，适用于解析服务端回复等不关注性能的场景.
+// 按回复解析，允许任意 RESP 类型. 处理客户端连接时应使用 NewReader 同步读取
 func (p *Parser) ParseStream(reader io.Reader) <-chan *handler.Droplet {
 	ch := make(chan *handler.Droplet)
 	pool.Submit(func() {
@@ -42,30 +99,15 @@ func (p *Parser) ParseStream(reader io.Reader) <-chan *handler.Droplet {
 }
 
 func (p *Parser) parse(rawReader io.Reader, ch chan<- *handler.Droplet) {
-	reader := bufio.NewReader(rawReader)
+	reader := p.newReader(rawReader, true)
+	defer reader.Release()
 	for {
-		firstLine, err := reader.ReadBytes('\n')
-		if err != nil {
-			ch <- &handler.Droplet{
-				Reply: handler.NewErrReply(err.Error()),
-				Err:   err,
-			}
+		// reader 返回的 droplet 会被复用，投递前需要拷贝
+		droplet := *reader.ReadDroplet()
+		ch <- &droplet
+		if droplet.Terminated() {
 			return
 		}
-
-		length := len(firstLine)
-		if length <= 2 || firstLine[length-1] != '\n' || firstLine[length-2] != '\r' {
-			continue
-		}
-
-		firstLine = bytes.TrimSuffix(firstLine, []byte{'\r', '\n'})
-		lineParseFunc, ok := p.lineParsers[firstLine[0]]
-		if !ok {
-			p.logger.Errorf("[parser] invalid line handler: %s", firstLine[0])
-			continue
-		}
-
-		ch <- lineParseFunc(firstLine, reader)
 	}
 }
 
@@ -113,15 +155,18 @@ func (p *Parser) parseBulk(header []byte, reader *bufio.Reader) *handler.Droplet
 
 func (p *Parser) parseBulkBody(header []byte, reader *bufio.Reader) ([]byte, error) {
 	// 获取 string 长度
-	strLen, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil {
-		return nil, err
+	strLen, ok := parseLength(header[1:])
+	if !ok || strLen > p.limit.maxBulkLen {
+		return nil, errInvalidBulkLength
+	}
+	// 长度为 -1 代表空值
+	if strLen < 0 {
+		return nil, nil
 	}
 
 	// 长度 + 2，把 CRLF 也考虑在内
-	body := make([]byte, strLen+2)
-	// 从 reader 中读取对应长度
-	if _, err = io.ReadFull(reader, body); err != nil {
+	body, err := readAppend(reader, nil, int(strLen)+2)
+	if err != nil {
 		return nil, err
 	}
 	return body[:len(body)-2], nil
@@ -139,9 +184,9 @@ func (p *Parser) parseMultiBulk(header []byte, reader *bufio.Reader) (droplet *h
 	}()
 
 	// 获取数组长度
-	length, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil {
-		_err = err
+	length, ok := parseLength(header[1:])
+	if !ok || length > p.limit.maxMultiBulkLen {
+		_err = errInvalidMultiBulkLength
 		return
 	}
 
@@ -151,7 +196,8 @@ func (p *Parser) parseMultiBulk(header []byte, reader *bufio.Reader) (droplet *h
 		}
 	}
 
-	lines := make([][]byte, 0, length)
+	lines := make([][]byte, 0, preallocSize(length))
+	var replies []handler.Reply
 	for i := int64(0); i < length; i++ {
 		// 获取每个 bulk 首行
 		firstLine, err := reader.ReadBytes('\n')
@@ -162,7 +208,24 @@ func (p *Parser) parseMultiBulk(header []byte, reader *bufio.Reader) (droplet *h
 
 		// bulk 首行格式校验
 		length := len(firstLine)
-		if length < 4 || firstLine[length-2] != '\r' || firstLine[length-1] != '\n' || firstLine[0] != '$' {
+		if length < 3 || firstLine[length-2] != '\r' || firstLine[length-1] != '\n' {
+			continue
+		}
+
+		// 非 bulk 元素时按嵌套数组解析
+		if firstLine[0] != '$' || replies != nil {
+			if replies == nil {
+				replies = make([]handler.Reply, 0, cap(lines))
+				for _, line := range lines {
+					replies = append(replies, handler.NewBulkReply(line))
+				}
+			}
+			reply, err := p.parseLine(firstLine[:length-2], reader)
+			if err != nil {
+				_err = err
+				return
+			}
+			replies = append(replies, reply)
 			continue
 		}
 
@@ -176,7 +239,179 @@ func (p *Parser) parseMultiBulk(header []byte, reader *bufio.Reader) (droplet *h
 		lines = append(lines, bulkBody)
 	}
 
+	if replies != nil {
+		return &handler.Droplet{
+			Reply: handler.NewArrayReply(replies),
+		}
+	}
 	return &handler.Droplet{
 		Reply: handler.NewMultiBulkReply(lines),
 	}
-}
\ No newline at end of file
+}
+
+// 解析首行已读取的一个元素
+func (p *Parser) parseLine(header []byte, reader *bufio.Reader) (handler.Reply, error) {
+	lineParseFunc, ok := p.lineParsers[header[0]]
+	if !ok {
+		return nil, fmt.Errorf("invalid line handler: %c", header[0])
+	}
+	droplet := lineParseFunc(header, reader)
+	if droplet.Err != nil {
+		return nil, droplet.Err
+	}
+	return droplet.Reply, nil
+}
+
+// 读取并解析一个元素，供聚合类型解析其中的元素
+func (p *Parser) readReply(reader *bufio.Reader) (handler.Reply, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("invalid line: " + strconv.Quote(string(line)))
+	}
+	return p.parseLine(line[:len(line)-2], reader)
+}
+
+// 解析聚合类型中的 n 个元素
+func (p *Parser) readReplies(n int64, reader *bufio.Reader) ([]handler.Reply, error) {
+	replies := make([]handler.Reply, 0, preallocSize(n))
+	for i := int64(0); i < n; i++ {
+		reply, err := p.readReply(reader)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// 元素个数由对端声明，预分配时设置上限，按实际读到的元素扩容
+func preallocSize(n int64) int64 {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
+
+func errDroplet(err error) *handler.Droplet {
+	return &handler.Droplet{
+		Reply: handler.NewErrReply(err.Error()),
+		Err:   err,
+	}
+}
+
+func (p *Parser) parseNull(header []byte, reader *bufio.Reader) *handler.Droplet {
+	return &handler.Droplet{
+		Reply: handler.NewNillReply(),
+	}
+}
+
+func (p *Parser) parseBool(header []byte, reader *bufio.Reader) *handler.Droplet {
+	switch string(header[1:]) {
+	case "t":
+		return &handler.Droplet{Reply: handler.NewBoolReply(true)}
+	case "f":
+		return &handler.Droplet{Reply: handler.NewBoolReply(false)}
+	default:
+		return errDroplet(errors.New("invalid boolean: " + string(header[1:])))
+	}
+}
+
+func (p *Parser) parseDouble(header []byte, reader *bufio.Reader) *handler.Droplet {
+	raw := string(header[1:])
+	var value float64
+	switch strings.ToLower(raw) {
+	case "inf", "+inf":
+		value = math.Inf(1)
+	case "-inf":
+		value = math.Inf(-1)
+	case "nan":
+		value = math.NaN()
+	default:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return errDroplet(err)
+		}
+		value = v
+	}
+	return &handler.Droplet{
+		Reply: handler.NewDoubleReply(value),
+	}
+}
+
+func (p *Parser) parseBigNumber(header []byte, reader *bufio.Reader) *handler.Droplet {
+	return &handler.Droplet{
+		Reply: handler.NewBigNumberReply(string(header[1:])),
+	}
+}
+
+// 格式为 =<长度>CRLF<3 字节格式>:<内容>CRLF
+func (p *Parser) parseVerbatim(header []byte, reader *bufio.Reader) *handler.Droplet {
+	body, err := p.parseBulkBody(header, reader)
+	if err != nil {
+		return errDroplet(err)
+	}
+	if len(body) < 4 || body[3] != ':' {
+		return errDroplet(errors.New("invalid verbatim string"))
+	}
+	return &handler.Droplet{
+		Reply: handler.NewVerbatimReply(string(body[:3]), body[4:]),
+	}
+}
+
+// 解析聚合类型，pairs 为 true 时头部长度代表键值对个数
+func (p *Parser) parseAggregate(header []byte, reader *bufio.Reader, pairs bool, build func(replies []handler.Reply) handler.Reply) *handler.Droplet {
+	length, ok := parseLength(header[1:])
+	if !ok || length < 0 || length > p.limit.maxMultiBulkLen {
+		return errDroplet(errInvalidMultiBulkLength)
+	}
+	if pairs {
+		length *= 2
+	}
+
+	replies, err := p.readReplies(length, reader)
+	if err != nil {
+		return errDroplet(err)
+	}
+	return &handler.Droplet{
+		Reply: build(replies),
+	}
+}
+
+func (p *Parser) parseMap(header []byte, reader *bufio.Reader) *handler.Droplet {
+	return p.parseAggregate(header, reader, true, func(replies []handler.Reply) handler.Reply {
+		return handler.NewMapReply(replies)
+	})
+}
+
+func (p *Parser) parseSet(header []byte, reader *bufio.Reader) *handler.Droplet {
+	return p.parseAggregate(header, reader, false, func(replies []handler.Reply) handler.Reply {
+		return handler.NewSetReply(replies)
+	})
+}
+
+func (p *Parser) parsePush(header []byte, reader *bufio.Reader) *handler.Droplet {
+	return p.parseAggregate(header, reader, false, func(replies []handler.Reply) handler.Reply {
+		return handler.NewPushReply(replies)
+	})
+}
+
+// 属性之后紧跟其所修饰的元素
+func (p *Parser) parseAttribute(header []byte, reader *bufio.Reader) *handler.Droplet {
+	droplet := p.parseAggregate(header, reader, true, func(replies []handler.Reply) handler.Reply {
+		return handler.NewMapReply(replies)
+	})
+	if droplet.Err != nil {
+		return droplet
+	}
+
+	reply, err := p.readReply(reader)
+	if err != nil {
+		return errDroplet(err)
+	}
+	return &handler.Droplet{
+		Reply: handler.NewAttributeReply(droplet.Reply.(*handler.MapReply).Replies(), reply),
+	}
+}
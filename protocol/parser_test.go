@@ -0,0 +1,177 @@
+package protocol
+
+import (
+	"bytes"
+	"goredis/handler"
+	"goredis/log"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parse_resp3(t *testing.T) {
+	p := NewParser(log.GetDefaultLogger(), nil)
+	stream := p.ParseStream(strings.NewReader(strings.Join([]string{
+		"*2\r\n$3\r\nget\r\n$1\r\na\r\n",
+		"_\r\n",
+		"#t\r\n",
+		",-inf\r\n",
+		",2.5\r\n",
+		"(12345678901234567890\r\n",
+		"=8\r\ntxt:some\r\n",
+		"%2\r\n$1\r\na\r\n:1\r\n+b\r\n*2\r\n:1\r\n_\r\n",
+		"~1\r\n$1\r\nm\r\n",
+		">2\r\n$7\r\nmessage\r\n$-1\r\n",
+		"|1\r\n$3\r\nttl\r\n:3\r\n$1\r\nv\r\n",
+	}, "")))
+
+	next := func() handler.Reply {
+		droplet := <-stream
+		assert.NoError(t, droplet.Err)
+		return droplet.Reply
+	}
+
+	assert.Equal(t, [][]byte{[]byte("get"), []byte("a")}, next().(*handler.MultiBulkReply).Args())
+	assert.Equal(t, handler.NewNillReply(), next())
+	assert.Equal(t, handler.NewBoolReply(true), next())
+	assert.True(t, math.IsInf(next().(*handler.DoubleReply).Value, -1))
+	assert.Equal(t, handler.NewDoubleReply(2.5), next())
+	assert.Equal(t, handler.NewBigNumberReply("12345678901234567890"), next())
+	assert.Equal(t, handler.NewVerbatimReply("txt", []byte("some")), next())
+
+	// 解析后以原协议编码，内容保持不变
+	assert.Equal(t, "%2\r\n$1\r\na\r\n:1\r\n+b\r\n*2\r\n:1\r\n_\r\n", string(handler.EncodeReply(next(), handler.Resp3)))
+	assert.Equal(t, "~1\r\n$1\r\nm\r\n", string(handler.EncodeReply(next(), handler.Resp3)))
+	assert.Equal(t, ">2\r\n$7\r\nmessage\r\n_\r\n", string(handler.EncodeReply(next(), handler.Resp3)))
+	attr := next().(*handler.AttributeReply)
+	assert.Equal(t, "|1\r\n$3\r\nttl\r\n:3\r\n$1\r\nv\r\n", string(handler.EncodeReply(attr, handler.Resp3)))
+}
+
+func Test_split_inline_args(t *testing.T) {
+	cases := []struct {
+		line   string
+		expect []string
+		err    error
+	}{
+		{"PING", []string{"PING"}, nil},
+		{"  set  a\tb  ", []string{"set", "a", "b"}, nil},
+		{`set "a b" 'c d'`, []string{"set", "a b", "c d"}, nil},
+		{`set k "\x41\n\"q\"\\"`, []string{"set", "k", "A\n\"q\"\\"}, nil},
+		{`set k 'it\'s'`, []string{"set", "k", "it's"}, nil},
+		{`set k ""`, []string{"set", "k", ""}, nil},
+		{"", []string{}, nil},
+		{`set "a`, nil, errUnbalancedQuotes},
+		{`set 'a`, nil, errUnbalancedQuotes},
+		{`set "a"b`, nil, errClosingQuote},
+	}
+	for _, c := range cases {
+		args, err := splitInlineArgs([]byte(c.line))
+		assert.Equal(t, c.err, err, c.line)
+		if err != nil {
+			continue
+		}
+		res := make([]string, 0, len(args))
+		for _, arg := range args {
+			res = append(res, string(arg))
+		}
+		assert.Equal(t, c.expect, res, c.line)
+	}
+}
+
+func Test_parse_inline(t *testing.T) {
+	p := NewParser(log.GetDefaultLogger(), nil)
+	stream := p.ParseStream(strings.NewReader("PING\r\n\r\nset a \"b c\"\n*1\r\n$4\r\nPING\r\nget \"a\n"))
+
+	droplet := <-stream
+	assert.Equal(t, [][]byte{[]byte("PING")}, droplet.Reply.(*handler.MultiBulkReply).Args())
+	// 空行被忽略，与 RESP 请求混用
+	droplet = <-stream
+	assert.Equal(t, [][]byte{[]byte("set"), []byte("a"), []byte("b c")}, droplet.Reply.(*handler.MultiBulkReply).Args())
+	droplet = <-stream
+	assert.Equal(t, [][]byte{[]byte("PING")}, droplet.Reply.(*handler.MultiBulkReply).Args())
+	// 协议错误回复后关闭连接
+	droplet = <-stream
+	assert.ErrorIs(t, droplet.Err, errUnbalancedQuotes)
+	assert.True(t, droplet.Terminated())
+
+	stream = p.ParseStream(strings.NewReader(strings.Repeat("x", maxInlineSize+1) + "\n"))
+	droplet = <-stream
+	assert.ErrorIs(t, droplet.Err, errInlineTooBig)
+	assert.True(t, droplet.Terminated())
+}
+
+type limitThinker struct{}
+
+func (limitThinker) ProtoMaxBulkLen() int        { return 16 }
+func (limitThinker) ProtoMaxMultiBulkLen() int   { return 4 }
+func (limitThinker) ClientQueryBufferLimit() int { return 32 }
+
+func Test_parse_limits(t *testing.T) {
+	p := NewParser(log.GetDefaultLogger(), limitThinker{}).(*Parser)
+	cases := []struct {
+		input   string
+		err     error
+		replies bool
+	}{
+		{"$9999999999\r\n", errInvalidBulkLength, true},
+		{"$-5\r\nabc\r\n", errInvalidBulkLength, true},
+		{"*5\r\n", errInvalidMultiBulkLength, false},
+		{"*-3\r\n", errInvalidMultiBulkLength, false},
+		{"*99999999999999999999\r\n", errInvalidMultiBulkLength, false},
+		{"*1\r\n$17\r\n", errInvalidBulkLength, false},
+		{"*1\r\n$-2\r\n", errInvalidBulkLength, false},
+		{"*1\r\n$1\n", errInvalidBulkLength, false},
+		{"*1\r\n%9999999999\r\n", errInvalidMultiBulkLength, true},
+		{"*3\r\n$16\r\n0123456789abcdef\r\n$16\r\n0123456789abcdef\r\n$1\r\nx\r\n", errQueryBufLimit, false},
+	}
+	for _, c := range cases {
+		reader := p.newReader(strings.NewReader(c.input), c.replies)
+		droplet := reader.ReadDroplet()
+		assert.ErrorIs(t, droplet.Err, c.err, c.input)
+		assert.True(t, droplet.Terminated(), c.input)
+		reader.Release()
+	}
+
+	// 未超出限制的请求正常解析
+	reader := p.NewReader(strings.NewReader("*2\r\n$3\r\nget\r\n$16\r\n0123456789abcdef\r\n"))
+	defer reader.Release()
+	droplet := reader.ReadDroplet()
+	assert.NoError(t, droplet.Err)
+	assert.Equal(t, [][]byte{[]byte("get"), []byte("0123456789abcdef")}, droplet.Reply.(*handler.MultiBulkReply).Args())
+}
+
+func Fuzz_parser(f *testing.F) {
+	seeds := []string{
+		"*2\r\n$3\r\nget\r\n$1\r\na\r\n",
+		"PING\r\nset \"a b\" 'c'\n",
+		"*1\r\n*2\r\n:1\r\n_\r\n",
+		"%1\r\n+k\r\n,1.5\r\n",
+		"|1\r\n$1\r\na\r\n#t\r\n=8\r\ntxt:some\r\n",
+		"$9999999999\r\n",
+		"*-1\r\n*0\r\n$-1\r\n",
+		"*3\r\n$16\r\n0123456789abcdef\r\n$16\r\n0123456789abcdef\r\n",
+		"*2\r\n:1\r\n" + strings.Repeat("*1\r\n", 4096),
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	p := NewParser(log.GetDefaultLogger(), limitThinker{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := p.NewReader(bytes.NewReader(data))
+		defer reader.Release()
+		// 每个请求至少消费一个字节，读取次数不会超过输入长度
+		for i := 0; i <= len(data); i++ {
+			droplet := reader.ReadDroplet()
+			if droplet.Reply == nil {
+				t.Fatalf("nil reply for input %q", data)
+			}
+			if droplet.Terminated() {
+				return
+			}
+		}
+		t.Fatalf("reader not terminated for input %q", data)
+	})
+}
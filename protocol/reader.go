@@ -0,0 +1,315 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"goredis/handler"
+	"io"
+	"sync"
+)
+
+const (
+	readerBufSize = 16 * 1024
+	// 暂存区超过该大小时不再复用，避免个别大请求长期占用内存
+	maxPooledBufSize = 1024 * 1024
+)
+
+var (
+	errInvalidMultiBulkLength = errors.New("ERR Protocol error: invalid multibulk length")
+	errInvalidBulkLength      = errors.New("ERR Protocol error: invalid bulk length")
+	errQueryBufLimit          = errors.New("closing client that reached max query buffer length")
+)
+
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return &Reader{br: bufio.NewReaderSize(nil, readerBufSize)}
+	},
+}
+
+// 连接级别的请求读取器. 在读缓冲区上原地解析请求头，请求参数统一拷贝到一块内存中，
+// 每个请求只需为参数分配一次内存. 参数会被指令长期持有(如写入 list)，因此不能直接引用复用的缓冲区
+type Reader struct {
+	parser *Parser
+	br     *bufio.Reader
+	// 超出读缓冲区的长行
+	line []byte
+	// 当前请求的参数内容，以及每个参数的结束位置，-1 代表空值
+	buf  []byte
+	ends []int
+	// 返回给调用方的结果，下次读取时复用
+	droplet handler.Droplet
+	// 解析服务端回复时允许任意类型的元素，以及嵌套的聚合类型
+	replies bool
+}
+
+func (p *Parser) NewReader(reader io.Reader) handler.RequestReader {
+	return p.newReader(reader, false)
+}
+
+func (p *Parser) newReader(reader io.Reader, replies bool) *Reader {
+	r := readerPool.Get().(*Reader)
+	r.parser = p
+	r.replies = replies
+	r.br.Reset(reader)
+	return r
+}
+
+func (r *Reader) Release() {
+	r.br.Reset(nil)
+	r.parser = nil
+	r.droplet = handler.Droplet{}
+	if cap(r.buf) > maxPooledBufSize {
+		r.buf = nil
+	}
+	if cap(r.line) > maxPooledBufSize {
+		r.line = nil
+	}
+	readerPool.Put(r)
+}
+
+func (r *Reader) Buffered() int {
+	return r.br.Buffered()
+}
+
+func (r *Reader) Peek() error {
+	_, err := r.br.Peek(1)
+	return err
+}
+
+func (r *Reader) reply(reply handler.Reply) *handler.Droplet {
+	r.droplet = handler.Droplet{Reply: reply}
+	return &r.droplet
+}
+
+func (r *Reader) fail(err error) *handler.Droplet {
+	r.droplet = handler.Droplet{Reply: handler.NewErrReply(err.Error()), Err: err}
+	return &r.droplet
+}
+
+// 请求格式错误，回复错误后关闭连接
+func (r *Reader) failProtocol(err error) *handler.Droplet {
+	r.droplet = handler.Droplet{Reply: handler.NewErrReply(err.Error()), Err: &handler.ProtocolErr{Err: err}}
+	return &r.droplet
+}
+
+// 读取错误是否来自连接本身，而非协议格式
+func (r *Reader) failRead(err error) *handler.Droplet {
+	if err == errInlineTooBig {
+		return r.failProtocol(err)
+	}
+	return r.fail(&handler.ConnReadErr{Err: err})
+}
+
+// 解析具体元素时的错误，io 错误来自连接本身
+func (r *Reader) failParse(err error) *handler.Droplet {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return r.fail(&handler.ConnReadErr{Err: err})
+	}
+	return r.failProtocol(err)
+}
+
+// 读取一行，包含结尾的 \n. 返回的内容只在下次读取前有效.
+// 超过 maxInlineSize 时丢弃该行并返回 errInlineTooBig
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.br.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+
+	r.line = append(r.line[:0], line...)
+	for {
+		line, err = r.br.ReadSlice('\n')
+		if len(r.line)+len(line) > maxInlineSize {
+			for err == bufio.ErrBufferFull {
+				_, err = r.br.ReadSlice('\n')
+			}
+			if err != nil {
+				return nil, err
+			}
+			return nil, errInlineTooBig
+		}
+		r.line = append(r.line, line...)
+		if err != bufio.ErrBufferFull {
+			return r.line, err
+		}
+	}
+}
+
+// 解析非负的十进制长度，允许 -1
+func parseLength(b []byte) (int64, bool) {
+	if len(b) == 2 && b[0] == '-' && b[1] == '1' {
+		return -1, true
+	}
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n, true
+}
+
+func (r *Reader) ReadDroplet() *handler.Droplet {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return r.failRead(err)
+		}
+
+		// 首字节不是 RESP 类型标识时按单行指令解析，单行指令允许只以 \n 结尾.
+		// 与 redis 一致，请求中只有 * 开头的是 RESP 数组
+		lineParseFunc, ok := r.parser.lineParsers[line[0]]
+		if !ok || (!r.replies && line[0] != '*') {
+			droplet := r.parser.parseInline(line)
+			if droplet == nil {
+				continue
+			}
+			if droplet.Err != nil {
+				return r.failProtocol(droplet.Err)
+			}
+			r.droplet = *droplet
+			return &r.droplet
+		}
+
+		length := len(line)
+		if length <= 2 || line[length-2] != '\r' {
+			continue
+		}
+
+		if line[0] == '*' {
+			return r.readMultiBulk(line[:length-2])
+		}
+
+		// 解析服务端回复，沿用逐个分配的解析方式
+		header := append([]byte(nil), line[:length-2]...)
+		droplet := lineParseFunc(header, r.br)
+		if droplet.Err != nil {
+			return r.failParse(droplet.Err)
+		}
+		r.droplet = *droplet
+		return &r.droplet
+	}
+}
+
+// 解析 RESP 数组. 请求的元素只能是 bulk，返回 MultiBulkReply.
+// 解析服务端回复时元素可以是任意类型，存在非 bulk 元素时返回 ArrayReply
+func (r *Reader) readMultiBulk(header []byte) *handler.Droplet {
+	limit := r.parser.limit
+	n, ok := parseLength(header[1:])
+	if !ok || n > limit.maxMultiBulkLen {
+		return r.failProtocol(errInvalidMultiBulkLength)
+	}
+	if n <= 0 {
+		return r.reply(handler.NewEmptyMultiBulkReply())
+	}
+
+	r.buf, r.ends = r.buf[:0], r.ends[:0]
+	var replies []handler.Reply
+	for i := int64(0); i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return r.failRead(err)
+		}
+		length := len(line)
+		if length < 3 || line[length-2] != '\r' {
+			return r.failProtocol(errInvalidBulkLength)
+		}
+
+		if line[0] != '$' || replies != nil {
+			// 请求中的嵌套元素会让解析递归到任意深度，并且绕过 client-query-buffer-limit
+			if !r.replies {
+				return r.failProtocol(fmt.Errorf("ERR Protocol error: expected '$', got '%c'", line[0]))
+			}
+			if replies == nil {
+				replies = make([]handler.Reply, 0, preallocSize(n))
+				for _, arg := range r.args() {
+					replies = append(replies, handler.NewBulkReply(arg))
+				}
+			}
+			reply, err := r.parser.parseLine(append([]byte(nil), line[:length-2]...), r.br)
+			if err != nil {
+				return r.failParse(err)
+			}
+			replies = append(replies, reply)
+			continue
+		}
+
+		size, ok := parseLength(line[1 : length-2])
+		if !ok || size > limit.maxBulkLen {
+			return r.failProtocol(errInvalidBulkLength)
+		}
+		if size < 0 {
+			r.ends = append(r.ends, -1)
+			continue
+		}
+		if len(r.buf)+int(size) > limit.queryBufLimit {
+			return r.fail(&handler.ConnReadErr{Err: errQueryBufLimit})
+		}
+
+		// 连同结尾的 CRLF 一起读入，再丢弃 CRLF
+		end := len(r.buf) + int(size)
+		if r.buf, err = readAppend(r.br, r.buf, int(size)+2); err != nil {
+			return r.failRead(err)
+		}
+		r.buf = r.buf[:end]
+		r.ends = append(r.ends, end)
+	}
+
+	if replies != nil {
+		return r.reply(handler.NewArrayReply(replies))
+	}
+	return r.reply(handler.NewMultiBulkReply(r.args()))
+}
+
+// 读取 n 字节追加到 buf 之后. 按实际到达的数据分段扩容，避免按对端声明的长度一次性分配内存
+func readAppend(reader io.Reader, buf []byte, n int) ([]byte, error) {
+	end := len(buf) + n
+	for len(buf) < end {
+		if len(buf) == cap(buf) {
+			grow := cap(buf)
+			if grow < readerBufSize {
+				grow = readerBufSize
+			}
+			if grow > end-len(buf) {
+				grow = end - len(buf)
+			}
+			newBuf := make([]byte, len(buf), cap(buf)+grow)
+			copy(newBuf, buf)
+			buf = newBuf
+		}
+
+		limit := cap(buf)
+		if limit > end {
+			limit = end
+		}
+		read, err := io.ReadFull(reader, buf[len(buf):limit])
+		buf = buf[:len(buf)+read]
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// 将暂存区中的参数拷贝到一块新分配的内存中
+func (r *Reader) args() [][]byte {
+	block := make([]byte, len(r.buf))
+	copy(block, r.buf)
+
+	args := make([][]byte, len(r.ends))
+	start := 0
+	for i, end := range r.ends {
+		if end < 0 {
+			continue
+		}
+		args[i] = block[start:end:end]
+		start = end
+	}
+	return args
+}
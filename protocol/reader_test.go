@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"bytes"
+	"goredis/handler"
+	"goredis/log"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_reader_pipeline(t *testing.T) {
+	p := NewParser(log.GetDefaultLogger(), nil).(*Parser)
+	value := strings.Repeat("v", readerBufSize)
+	reader := p.NewReader(strings.NewReader(strings.Join([]string{
+		"*3\r\n$3\r\nset\r\n$1\r\na\r\n$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n",
+		"*2\r\n$3\r\nget\r\n$-1\r\n",
+		"get a\r\n",
+		"%1\r\n",
+		"*x\r\n",
+	}, "")))
+	defer reader.Release()
+
+	droplet := reader.ReadDroplet()
+	assert.NoError(t, droplet.Err)
+	set := droplet.Reply.(*handler.MultiBulkReply).Args()
+
+	droplet = reader.ReadDroplet()
+	assert.Equal(t, [][]byte{[]byte("get"), nil}, droplet.Reply.(*handler.MultiBulkReply).Args())
+	droplet = reader.ReadDroplet()
+	assert.Equal(t, [][]byte{[]byte("get"), []byte("a")}, droplet.Reply.(*handler.MultiBulkReply).Args())
+	// 请求中只有 * 开头的是 RESP 数组，其他按单行指令解析
+	droplet = reader.ReadDroplet()
+	assert.Equal(t, [][]byte{[]byte("%1")}, droplet.Reply.(*handler.MultiBulkReply).Args())
+	droplet = reader.ReadDroplet()
+	assert.ErrorIs(t, droplet.Err, errInvalidMultiBulkLength)
+	assert.True(t, droplet.Terminated())
+
+	// 后续读取不影响已返回的参数
+	assert.Equal(t, [][]byte{[]byte("set"), []byte("a"), []byte(value)}, set)
+	assert.Equal(t, 0, reader.Buffered())
+}
+
+func Test_reader_reject_nested(t *testing.T) {
+	p := NewParser(log.GetDefaultLogger(), nil).(*Parser)
+	// 嵌套的数组曾导致解析递归过深，栈溢出
+	input := "*2\r\n:1\r\n" + strings.Repeat("*1\r\n", 5000000)
+	reader := p.NewReader(strings.NewReader(input))
+	defer reader.Release()
+	droplet := reader.ReadDroplet()
+	assert.EqualError(t, droplet.Err, "ERR Protocol error: expected '$', got ':'")
+	assert.Equal(t, "-ERR Protocol error: expected '$', got ':'\r\n", string(droplet.Reply.ToBytes()))
+	assert.True(t, droplet.Terminated())
+
+	reader = p.NewReader(strings.NewReader("*2\r\n$3\r\nget\r\n*1\r\n$1\r\na\r\n"))
+	defer reader.Release()
+	droplet = reader.ReadDroplet()
+	assert.EqualError(t, droplet.Err, "ERR Protocol error: expected '$', got '*'")
+	assert.True(t, droplet.Terminated())
+
+	// 解析回复时允许嵌套
+	replies := p.newReader(strings.NewReader("*2\r\n:1\r\n*1\r\n$1\r\na\r\n"), true)
+	defer replies.Release()
+	droplet = replies.ReadDroplet()
+	assert.NoError(t, droplet.Err)
+	assert.Equal(t, "*2\r\n:1\r\n*1\r\n$1\r\na\r\n", string(droplet.Reply.ToBytes()))
+}
+
+// 连续的流水线请求，读取完后从头重放
+type replayReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+	if r.pos == len(r.data) {
+		r.pos = 0
+	}
+	n := copy(p, r.data[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+func benchRequests() []byte {
+	return bytes.Repeat([]byte("*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$16\r\nvalue-0123456789\r\n"), 64)
+}
+
+// 原有方式: 通过协程与 channel 投递每个请求
+func Benchmark_parse_stream(b *testing.B) {
+	p := NewParser(log.GetDefaultLogger(), nil)
+	stream := p.ParseStream(&replayReader{data: benchRequests()})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		<-stream
+	}
+}
+
+func Benchmark_reader(b *testing.B) {
+	p := NewParser(log.GetDefaultLogger(), nil)
+	reader := p.NewReader(&replayReader{data: benchRequests()})
+	defer reader.Release()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.ReadDroplet()
+	}
+}
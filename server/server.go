@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"goredis/lib/pool"
 	"goredis/log"
 	"net"
@@ -26,18 +28,38 @@ type Server struct {
 	stopOnce sync.Once
 	handler  Handler
 	logger   log.Logger
+	thinker  Thinker
 	stopc    chan struct{}
+	// 未配置 tls-port 时为 nil
+	tls *tlsLoader
 }
 
-func NewServer(handler Handler, logger log.Logger) *Server {
+func NewServer(handler Handler, logger log.Logger, thinker Thinker) *Server {
 	return &Server{
 		handler: handler,
 		logger:  logger,
+		thinker: thinker,
 		stopc:   make(chan struct{}),
 	}
 }
 
+// 监听 address 上的明文连接以及配置的 TLS 端口. address 为空时只监听 TLS 端口
 func (s *Server) Serve(address string) error {
+	var tlsAddress string
+	if s.thinker != nil {
+		tlsAddress = s.thinker.TLSAddress()
+	}
+	if address == "" && tlsAddress == "" {
+		return errors.New("no port to listen, both port and tls-port are disabled")
+	}
+	if tlsAddress != "" {
+		loader, err := newTLSLoader(s.thinker, s.logger)
+		if err != nil {
+			return err
+		}
+		s.tls = loader
+	}
+
 	if err := s.handler.Start(); err != nil {
 		return err
 	}
@@ -66,21 +88,43 @@ func (s *Server) Serve(address string) error {
 			}
 		})
 
-		listener, err := net.Listen("tcp", address)
-		if err != nil {
-			_err = err
-			return
+		var listeners []net.Listener
+		if address != "" {
+			listener, err := net.Listen("tcp", address)
+			if err != nil {
+				_err = err
+				return
+			}
+			listeners = append(listeners, listener)
+		}
+		if tlsAddress != "" {
+			listener, err := net.Listen("tcp", tlsAddress)
+			if err != nil {
+				for _, l := range listeners {
+					_ = l.Close()
+				}
+				_err = err
+				return
+			}
+			listeners = append(listeners, tls.NewListener(listener, s.tls.serverConfig()))
 		}
 
-		s.listenAndServe(listener, closec)
+		s.listenAndServe(listeners, closec)
 	})
 
 	return _err
 }
 
-func (s *Server) listenAndServe(listener net.Listener, closec chan struct{}) {
-	errc := make(chan error, 1)
-	defer close(errc)
+// 立即重新加载 TLS 证书. 证书文件变化后新连接握手时也会自动重新加载
+func (s *Server) ReloadTLS() error {
+	if s.tls == nil {
+		return errors.New("tls is not enabled")
+	}
+	return s.tls.Reload()
+}
+
+func (s *Server) listenAndServe(listeners []net.Listener, closec chan struct{}) {
+	errc := make(chan error, len(listeners))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	pool.Submit(func() {
@@ -93,13 +137,30 @@ func (s *Server) listenAndServe(listener net.Listener, closec chan struct{}) {
 		cancel()
 		s.logger.Warnf("[server]server closing...")
 		s.handler.Close()
-		if err := listener.Close(); err != nil {
-			s.logger.Errorf("[server]server close listener err: %s", err.Error())
+		for _, listener := range listeners {
+			if err := listener.Close(); err != nil {
+				s.logger.Errorf("[server]server close listener err: %s", err.Error())
+			}
 		}
 	})
 
 	s.logger.Warnf("[server]server starting...")
-	var wg sync.WaitGroup
+	var wg, acceptWg sync.WaitGroup
+	for _, listener := range listeners[1:] {
+		listener := listener
+		acceptWg.Add(1)
+		pool.Submit(func() {
+			defer acceptWg.Done()
+			s.accept(ctx, listener, &wg, errc)
+		})
+	}
+	s.accept(ctx, listeners[0], &wg, errc)
+
+	acceptWg.Wait()
+	wg.Wait()
+}
+
+func (s *Server) accept(ctx context.Context, listener net.Listener, wg *sync.WaitGroup, errc chan<- error) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -109,17 +170,22 @@ func (s *Server) listenAndServe(listener net.Listener, closec chan struct{}) {
 			}
 
 			errc <- err
-			break
+			return
 		}
 
 		wg.Add(1)
 		pool.Submit(func() {
 			defer wg.Done()
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				if err := handshake(ctx, tlsConn); err != nil {
+					s.logger.Errorf("[server]tls handshake, addr: %s, err: %s", conn.RemoteAddr().String(), err.Error())
+					_ = conn.Close()
+					return
+				}
+			}
 			s.handler.Handle(ctx, conn)
 		})
 	}
-
-	wg.Wait()
 }
 
 func (s *Server) Stop() {
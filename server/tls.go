@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"goredis/log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Thinker interface {
+	// TLS 监听地址，为空时不监听
+	TLSAddress() string
+	TLSCertFile() string
+	TLSKeyFile() string
+	// 校验客户端证书的 CA
+	TLSCACertFile() string
+	// 是否要求客户端证书，yes、no 或 optional
+	TLSAuthClients() string
+}
+
+const (
+	// 握手超时后关闭连接，避免半开连接长期占用
+	tlsHandshakeTimeout = 10 * time.Second
+	// 新连接握手时检查证书文件是否变化的最小间隔
+	tlsReloadCheckInterval = time.Second
+)
+
+// 加载证书并在文件变化后自动重新加载，已建立的连接不受影响
+type tlsLoader struct {
+	certFile, keyFile, caFile string
+	clientAuth                tls.ClientAuthType
+	logger                    log.Logger
+
+	mu     sync.Mutex
+	config *tls.Config
+	// 上次加载时证书文件的修改时间与大小
+	stamps    []string
+	checkedAt time.Time
+	// 检查间隔，测试中可以调小
+	checkInterval time.Duration
+}
+
+func newTLSLoader(thinker Thinker, logger log.Logger) (*tlsLoader, error) {
+	l := tlsLoader{
+		certFile:      thinker.TLSCertFile(),
+		keyFile:       thinker.TLSKeyFile(),
+		caFile:        thinker.TLSCACertFile(),
+		logger:        logger,
+		checkInterval: tlsReloadCheckInterval,
+	}
+	switch strings.ToLower(thinker.TLSAuthClients()) {
+	case "yes", "":
+		l.clientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		l.clientAuth = tls.VerifyClientCertIfGiven
+	case "no":
+		l.clientAuth = tls.NoClientCert
+	default:
+		return nil, fmt.Errorf("invalid tls-auth-clients: %s", thinker.TLSAuthClients())
+	}
+	if l.certFile == "" || l.keyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file are required when tls-port is set")
+	}
+	if l.clientAuth != tls.NoClientCert && l.caFile == "" {
+		return nil, errors.New("tls-ca-cert-file is required to authenticate clients")
+	}
+
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (l *tlsLoader) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.load()
+}
+
+func (l *tlsLoader) load() error {
+	stamps, err := l.fileStamps()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return fmt.Errorf("load tls cert, err: %w", err)
+	}
+	config := tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   l.clientAuth,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if l.caFile != "" {
+		pem, err := os.ReadFile(l.caFile)
+		if err != nil {
+			return fmt.Errorf("load tls ca cert, err: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("load tls ca cert, no certificate found in %s", l.caFile)
+		}
+	}
+
+	l.config, l.stamps = &config, stamps
+	return nil
+}
+
+func (l *tlsLoader) fileStamps() ([]string, error) {
+	stamps := make([]string, 0, 3)
+	for _, file := range []string{l.certFile, l.keyFile, l.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()))
+	}
+	return stamps, nil
+}
+
+// 每次握手时调用. 文件变化后重新加载，加载失败时继续使用之前的证书
+func (l *tlsLoader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := time.Now(); now.Sub(l.checkedAt) >= l.checkInterval {
+		l.checkedAt = now
+		if stamps, err := l.fileStamps(); err == nil && strings.Join(stamps, ",") != strings.Join(l.stamps, ",") {
+			if err := l.load(); err != nil {
+				l.logger.Errorf("[server]tls reload, err: %s", err.Error())
+			} else {
+				l.logger.Warnf("[server]tls cert reloaded")
+			}
+		}
+	}
+	return l.config, nil
+}
+
+func (l *tlsLoader) serverConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: l.getConfigForClient}
+}
+
+// 在交给 handler 之前完成握手，handler 据此读取客户端证书
+func handshake(ctx context.Context, conn *tls.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	return conn.HandshakeContext(ctx)
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"goredis/log"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var serial atomic.Int64
+
+// 签发证书，ca 为 nil 时自签名
+func issueCert(t *testing.T, cn string, ca *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(serial.Add(1)),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  ca == nil,
+	}
+	parent, signer := &tmpl, any(key)
+	if ca != nil {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, parent, &key.PublicKey, signer)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writeCert(t *testing.T, cert tls.Certificate, certFile, keyFile string) {
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644))
+	if keyFile == "" {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+}
+
+type tlsThinker struct {
+	cert, key, ca, authClients string
+}
+
+func (t tlsThinker) TLSAddress() string     { return "127.0.0.1:0" }
+func (t tlsThinker) TLSCertFile() string    { return t.cert }
+func (t tlsThinker) TLSKeyFile() string     { return t.key }
+func (t tlsThinker) TLSCACertFile() string  { return t.ca }
+func (t tlsThinker) TLSAuthClients() string { return t.authClients }
+
+// 回复客户端证书的 CN 后关闭连接
+type cnHandler struct{}
+
+func (cnHandler) Start() error { return nil }
+func (cnHandler) Close()       {}
+func (cnHandler) Handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) > 0 {
+		_, _ = conn.Write([]byte(certs[0].Subject.CommonName))
+	}
+}
+
+func Test_new_tls_loader(t *testing.T) {
+	logger := log.GetDefaultLogger()
+	_, err := newTLSLoader(tlsThinker{cert: "a.crt", key: "a.key", authClients: "maybe"}, logger)
+	assert.EqualError(t, err, "invalid tls-auth-clients: maybe")
+	_, err = newTLSLoader(tlsThinker{cert: "a.crt"}, logger)
+	assert.EqualError(t, err, "tls-cert-file and tls-key-file are required when tls-port is set")
+	_, err = newTLSLoader(tlsThinker{cert: "a.crt", key: "a.key", authClients: "yes"}, logger)
+	assert.EqualError(t, err, "tls-ca-cert-file is required to authenticate clients")
+	_, err = newTLSLoader(tlsThinker{cert: "a.crt", key: "a.key", authClients: "no"}, logger)
+	assert.Error(t, err)
+
+	s := NewServer(cnHandler{}, logger, nil)
+	assert.EqualError(t, s.ReloadTLS(), "tls is not enabled")
+	assert.EqualError(t, s.Serve(""), "no port to listen, both port and tls-port are disabled")
+}
+
+func Test_tls_serve(t *testing.T) {
+	dir := t.TempDir()
+	thinker := tlsThinker{
+		cert:        filepath.Join(dir, "redis.crt"),
+		key:         filepath.Join(dir, "redis.key"),
+		ca:          filepath.Join(dir, "ca.crt"),
+		authClients: "yes",
+	}
+	ca := issueCert(t, "ca", nil)
+	writeCert(t, ca, thinker.ca, "")
+	writeCert(t, issueCert(t, "server-1", &ca), thinker.cert, thinker.key)
+
+	logger := log.GetDefaultLogger()
+	s := NewServer(cnHandler{}, logger, thinker)
+	loader, err := newTLSLoader(thinker, logger)
+	assert.NoError(t, err)
+	loader.checkInterval = 0
+	s.tls = loader
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closec := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		s.listenAndServe([]net.Listener{tls.NewListener(ln, loader.serverConfig())}, closec)
+		close(done)
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	dial := func(certs ...tls.Certificate) (string, string, error) {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots, Certificates: certs})
+		if err != nil {
+			return "", "", err
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		body, err := io.ReadAll(conn)
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, string(body), err
+	}
+
+	serverCN, clientCN, err := dial(issueCert(t, "alice", &ca))
+	assert.NoError(t, err)
+	assert.Equal(t, "server-1", serverCN)
+	assert.Equal(t, "alice", clientCN)
+
+	// 没有客户端证书或证书不是由 CA 签发时握手失败
+	_, _, err = dial()
+	assert.Error(t, err)
+	_, _, err = dial(issueCert(t, "mallory", nil))
+	assert.Error(t, err)
+
+	// 替换证书文件后新连接使用新证书
+	writeCert(t, issueCert(t, "server-2", &ca), thinker.cert, thinker.key)
+	serverCN, _, err = dial(issueCert(t, "alice", &ca))
+	assert.NoError(t, err)
+	assert.Equal(t, "server-2", serverCN)
+
+	// 新证书无效时继续使用之前的证书
+	assert.NoError(t, os.WriteFile(thinker.cert, []byte("broken"), 0644))
+	serverCN, _, err = dial(issueCert(t, "alice", &ca))
+	assert.NoError(t, err)
+	assert.Equal(t, "server-2", serverCN)
+	assert.Error(t, s.ReloadTLS())
+
+	closec <- struct{}{}
+	<-done
+}